@@ -65,6 +65,7 @@ func getApp(name string) (*app.App, error) {
 //   200: App removed
 //   401: Unauthorized
 //   404: Not found
+//   412: App protected against deletion
 func appDelete(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	r.ParseForm()
 	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
@@ -77,6 +78,10 @@ func appDelete(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	if !canDelete {
 		return permission.ErrUnauthorized
 	}
+	if a.DeletionProtection {
+		return &errors.HTTP{Code: http.StatusPreconditionFailed, Message: app.ErrAppDeletionProtected.Error()}
+	}
+	purge, _ := strconv.ParseBool(r.FormValue("purge"))
 	evt, err := event.New(&event.Opts{
 		Target:     appTarget(a.Name),
 		Kind:       permission.PermAppDelete,
@@ -92,6 +97,9 @@ func appDelete(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
 	w.Header().Set("Content-Type", "application/x-json-stream")
+	if a.Deletion == nil && !purge && app.SoftDeleteGracePeriod() > 0 {
+		return a.SoftDelete(t.GetUserName(), writer)
+	}
 	return app.Delete(&a, writer)
 }
 
@@ -172,6 +180,10 @@ func appList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	if status, ok := r.URL.Query()["status"]; ok {
 		filter.Statuses = status
 	}
+	deleted, _ := strconv.ParseBool(r.URL.Query().Get("deleted"))
+	if deleted {
+		filter.Deleted = true
+	}
 	contexts := permission.ContextsForPermission(t, permission.PermAppRead)
 	if len(contexts) == 0 {
 		w.WriteHeader(http.StatusNoContent)
@@ -219,6 +231,24 @@ func appInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return json.NewEncoder(w).Encode(&a)
 }
 
+// defaultTeamOwner returns the team owner of apps created by the user when
+// none is given: the only team where the user can create apps, or the only
+// team registered in tsuru.
+func defaultTeamOwner(t auth.Token) (string, error) {
+	team, err := permission.TeamForPermission(t, permission.PermAppCreate)
+	if err != permission.ErrTooManyTeams {
+		return team, err
+	}
+	teams, listErr := auth.ListTeams()
+	if listErr != nil {
+		return "", listErr
+	}
+	if len(teams) != 1 {
+		return "", err
+	}
+	return teams[0].Name, nil
+}
+
 type inputApp struct {
 	TeamOwner   string
 	Platform    string
@@ -227,6 +257,7 @@ type inputApp struct {
 	Description string
 	Pool        string
 	RouterOpts  map[string]string
+	Template    string
 }
 
 // title: app create
@@ -259,20 +290,21 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 		Pool:        ia.Pool,
 		RouterOpts:  ia.RouterOpts,
 	}
+	var tpl *app.AppTemplate
+	if ia.Template != "" {
+		tpl, err = app.GetAppTemplate(ia.Template)
+		if err == app.ErrAppTemplateNotFound {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		if err != nil {
+			return err
+		}
+		tpl.Apply(&a)
+	}
 	if a.TeamOwner == "" {
-		a.TeamOwner, err = permission.TeamForPermission(t, permission.PermAppCreate)
+		a.TeamOwner, err = defaultTeamOwner(t)
 		if err != nil {
-			if err != permission.ErrTooManyTeams {
-				return err
-			}
-			teams, listErr := auth.ListTeams()
-			if listErr != nil {
-				return listErr
-			}
-			if len(teams) != 1 {
-				return err
-			}
-			a.TeamOwner = teams[0].Name
+			return err
 		}
 	}
 	canCreate := permission.Check(t, permission.PermAppCreate,
@@ -313,12 +345,18 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 		if e, ok := err.(*errors.ValidationError); ok {
 			return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
 		}
+		if e, ok := err.(*provision.PoolConstraintError); ok {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+		}
 		if _, ok := err.(app.NoTeamsError); ok {
 			return &errors.HTTP{
 				Code:    http.StatusBadRequest,
 				Message: "In order to create an app, you should be member of at least one team",
 			}
 		}
+		if e, ok := err.(*quota.QuotaExceededError); ok {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: e.Error()}
+		}
 		if e, ok := err.(*app.AppCreationError); ok {
 			if e.Err == app.ErrAppAlreadyExists {
 				return &errors.HTTP{Code: http.StatusConflict, Message: e.Error()}
@@ -335,6 +373,12 @@ func createApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 		}
 		return err
 	}
+	if tpl != nil {
+		err = tpl.SetEnvs(&a, u.Email, nil)
+		if err != nil {
+			return err
+		}
+	}
 	repo, err := repository.Manager().GetRepository(a.Name)
 	if err != nil {
 		return err
@@ -419,6 +463,197 @@ func updateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	if err == app.ErrPlanNotFound {
 		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
 	}
+	if e, ok := err.(*provision.PoolConstraintError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+// title: app pool migration
+// path: /apps/{app}/migrate
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: App migrated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App or pool not found
+func migrateApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	pool := r.FormValue("pool")
+	if pool == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the pool."}
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdatePoolMigrate,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:        appTarget(appName),
+		Kind:          permission.PermAppUpdatePoolMigrate,
+		Owner:         t,
+		CustomData:    event.FormToCustomData(r.Form),
+		Allowed:       event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+		AllowedCancel: event.Allowed(permission.PermAppUpdateEvents, contextsForApp(&a)...),
+		Cancelable:    true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	evt.SetLogWriter(writer)
+	err = a.MigratePool(app.MigratePoolOptions{Pool: pool, Writer: evt, Event: evt})
+	if err == provision.ErrPoolNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if e, ok := err.(*provision.PoolConstraintError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return err
+}
+
+type inputClone struct {
+	Name         string
+	TeamOwner    string
+	Envs         []struct{ Name, Value string }
+	BindServices bool
+}
+
+// title: app clone
+// path: /apps/{app}/clone
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: App cloned
+//   400: Invalid data
+//   401: Unauthorized
+//   403: Quota exceeded or not allowed to read envs
+//   404: App not found
+//   409: App already exists
+func cloneApp(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	var ic inputClone
+	dec := form.NewDecoder(nil)
+	dec.IgnoreCase(true)
+	dec.IgnoreUnknownKeys(true)
+	err = dec.DecodeValues(&ic, r.Form)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if ic.Name == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the name of the new app."}
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	if ic.TeamOwner == "" {
+		ic.TeamOwner = a.TeamOwner
+	}
+	canRead := permission.Check(t, permission.PermAppRead, contextsForApp(&a)...)
+	canCreate := permission.Check(t, permission.PermAppCreate,
+		permission.Context(permission.CtxTeam, ic.TeamOwner),
+	)
+	if !canRead || !canCreate {
+		return permission.ErrUnauthorized
+	}
+	if !permission.Check(t, permission.PermAppReadEnv, contextsForApp(&a)...) {
+		return permission.ErrUnauthorized
+	}
+	if a.HasSecretEnvs() && !permission.Check(t, permission.PermAppReadSecrets, contextsForApp(&a)...) {
+		return permission.ErrUnauthorized
+	}
+	if ic.BindServices {
+		instances, err := service.GetServicesInstancesByTeamsAndNames(nil, nil, a.Name, "", nil)
+		if err != nil {
+			return err
+		}
+		for i := range instances {
+			allowed := permission.Check(t, permission.PermServiceInstanceUpdateBind,
+				contextsForServiceInstance(&instances[i], instances[i].ServiceName)...,
+			)
+			if !allowed {
+				return permission.ErrUnauthorized
+			}
+		}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	envs := make(map[string]string, len(ic.Envs))
+	redacted := url.Values{}
+	for k, v := range r.Form {
+		redacted[k] = v
+	}
+	for i, env := range ic.Envs {
+		envs[env.Name] = env.Value
+		key := fmt.Sprintf("Envs.%d.Value", i)
+		if _, ok := redacted[key]; ok {
+			redacted[key] = []string{app.SecretEnvMask}
+		}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(ic.Name),
+		Kind:       permission.PermAppCreate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(redacted),
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			permission.Context(permission.CtxApp, ic.Name),
+			permission.Context(permission.CtxTeam, ic.TeamOwner),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	_, err = a.Clone(app.CloneOptions{
+		Name:         ic.Name,
+		TeamOwner:    ic.TeamOwner,
+		Envs:         envs,
+		BindServices: ic.BindServices,
+		Writer:       writer,
+	}, u)
+	if err != nil {
+		if e, ok := err.(*errors.ValidationError); ok {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+		}
+		if e, ok := err.(*provision.PoolConstraintError); ok {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+		}
+		if e, ok := err.(*quota.QuotaExceededError); ok {
+			return &errors.HTTP{Code: http.StatusForbidden, Message: e.Error()}
+		}
+		if e, ok := err.(*app.AppCreationError); ok && e.Err == app.ErrAppAlreadyExists {
+			return &errors.HTTP{Code: http.StatusConflict, Message: e.Error()}
+		}
+	}
 	return err
 }
 
@@ -450,6 +685,7 @@ func numberOfUnits(r *http.Request) (uint, error) {
 //   400: Invalid data
 //   401: Unauthorized
 //   404: App not found
+//   409: App is deleted
 func addUnits(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	n, err := numberOfUnits(r)
 	if err != nil {
@@ -482,7 +718,11 @@ func addUnits(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	return a.AddUnits(n, processName, writer)
+	err = a.AddUnits(n, processName, writer)
+	if err != nil {
+		return appDeletedError(err)
+	}
+	return a.MarkManualScale()
 }
 
 // title: remove units
@@ -526,7 +766,11 @@ func removeUnits(w http.ResponseWriter, r *http.Request, t auth.Token) (err erro
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	return a.RemoveUnits(n, processName, writer)
+	err = a.RemoveUnits(n, processName, writer)
+	if err != nil {
+		return err
+	}
+	return a.MarkManualScale()
 }
 
 // title: set unit status
@@ -774,6 +1018,7 @@ func runCommand(w http.ResponseWriter, r *http.Request, t auth.Token) (err error
 //   401: Unauthorized
 //   404: App not found
 func getEnv(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	reveal, _ := strconv.ParseBool(r.URL.Query().Get("reveal"))
 	var variables []string
 	if envs, ok := r.URL.Query()["env"]; ok {
 		variables = envs
@@ -790,24 +1035,40 @@ func getEnv(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		if !allowed {
 			return permission.ErrUnauthorized
 		}
+		if reveal && !permission.Check(t, permission.PermAppReadSecrets, contextsForApp(&a)...) {
+			return permission.ErrUnauthorized
+		}
+	} else {
+		reveal = true
 	}
-	return writeEnvVars(w, &a, variables...)
+	return writeEnvVars(w, &a, reveal, variables...)
 }
 
-func writeEnvVars(w http.ResponseWriter, a *app.App, variables ...string) error {
+func writeEnvVars(w http.ResponseWriter, a *app.App, reveal bool, variables ...string) error {
 	var result []bind.EnvVar
+	envs := a.Env
+	if reveal {
+		envs = a.Envs()
+	}
 	w.Header().Set("Content-Type", "application/json")
 	if len(variables) > 0 {
 		for _, variable := range variables {
-			if v, ok := a.Env[variable]; ok {
+			if v, ok := envs[variable]; ok {
 				result = append(result, v)
 			}
 		}
 	} else {
-		for _, v := range a.Env {
+		for _, v := range envs {
 			result = append(result, v)
 		}
 	}
+	if !reveal {
+		for i := range result {
+			if result[i].Secret {
+				result[i].Value = app.SecretEnvMask
+			}
+		}
+	}
 	return json.NewEncoder(w).Encode(result)
 }
 
@@ -817,6 +1078,26 @@ type Envs struct {
 	Envs      []struct{ Name, Value string }
 	NoRestart bool
 	Private   bool
+	Secret    bool
+}
+
+// redactedEnvForm returns a copy of the form used to set envs with the values
+// of secret variables masked, so they are not stored in the event.
+func redactedEnvForm(form url.Values, e *Envs) url.Values {
+	if !e.Secret {
+		return form
+	}
+	redacted := url.Values{}
+	for k, v := range form {
+		redacted[k] = v
+	}
+	for i := range e.Envs {
+		key := fmt.Sprintf("Envs.%d.Value", i)
+		if _, ok := redacted[key]; ok {
+			redacted[key] = []string{app.SecretEnvMask}
+		}
+	}
+	return redacted
 }
 
 // title: set envs
@@ -829,6 +1110,7 @@ type Envs struct {
 //   400: Invalid data
 //   401: Unauthorized
 //   404: App not found
+//   409: App is deleted
 func setEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	err = r.ParseForm()
 	if err != nil {
@@ -860,7 +1142,7 @@ func setEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 		Target:     appTarget(appName),
 		Kind:       permission.PermAppUpdateEnvSet,
 		Owner:      t,
-		CustomData: event.FormToCustomData(r.Form),
+		CustomData: event.FormToCustomData(redactedEnvForm(r.Form, &e)),
 		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
 	})
 	if err != nil {
@@ -871,19 +1153,20 @@ func setEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	variables := []bind.EnvVar{}
 	for _, v := range e.Envs {
 		envs[v.Name] = v.Value
-		variables = append(variables, bind.EnvVar{Name: v.Name, Value: v.Value, Public: !e.Private})
+		variables = append(variables, bind.EnvVar{Name: v.Name, Value: v.Value, Public: !e.Private && !e.Secret, Secret: e.Secret})
 	}
 	w.Header().Set("Content-Type", "application/x-json-stream")
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	return a.SetEnvs(
+	return appDeletedError(a.SetEnvs(
 		bind.SetEnvApp{
 			Envs:          variables,
 			PublicOnly:    true,
 			ShouldRestart: !e.NoRestart,
+			Owner:         t.GetUserName(),
 		}, writer,
-	)
+	))
 }
 
 // title: unset envs
@@ -895,6 +1178,7 @@ func setEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 //   400: Invalid data
 //   401: Unauthorized
 //   404: App not found
+//   409: App is deleted
 func unsetEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	msg := "You must provide the list of environment variables."
 	if r.FormValue("env") == "" {
@@ -933,13 +1217,14 @@ func unsetEnv(w http.ResponseWriter, r *http.Request, t auth.Token) (err error)
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
 	noRestart, _ := strconv.ParseBool(r.FormValue("noRestart"))
-	return a.UnsetEnvs(
+	return appDeletedError(a.UnsetEnvs(
 		bind.UnsetEnvApp{
 			VariableNames: variables,
 			PublicOnly:    true,
 			ShouldRestart: !noRestart,
+			Owner:         t.GetUserName(),
 		}, writer,
-	)
+	))
 }
 
 // title: set cname
@@ -1150,6 +1435,7 @@ func getServiceInstance(serviceName, instanceName, appName string) (*service.Ser
 //   400: Invalid data
 //   401: Unauthorized
 //   404: App not found
+//   409: Service instance not ready
 func bindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	instanceName := r.URL.Query().Get(":instance")
 	appName := r.URL.Query().Get(":app")
@@ -1173,6 +1459,27 @@ func bindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (
 	if !allowed {
 		return permission.ErrUnauthorized
 	}
+	if !instance.IsReady() {
+		return &errors.HTTP{
+			Code:    http.StatusConflict,
+			Message: fmt.Sprintf("%s: %s", service.ErrServiceInstanceNotReady, instance.State),
+		}
+	}
+	if !instance.AllowsPool(a.GetPool()) {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: service.ErrPoolNotAllowed.Error()}
+	}
+	if pool, poolErr := provision.GetPoolByName(a.GetPool()); poolErr == nil {
+		if poolErr = pool.CheckConstraint(provision.PoolConstraintService, instance.ServiceName); poolErr != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: poolErr.Error()}
+		}
+	}
+	err = a.ValidateProcesses(r.Form["process"])
+	if err != nil {
+		if _, ok := err.(provision.InvalidProcessError); ok {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		return err
+	}
 	evt, err := event.New(&event.Opts{
 		Target:     appTarget(appName),
 		Kind:       permission.PermAppUpdateBind,
@@ -1188,7 +1495,7 @@ func bindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	err = instance.BindApp(a, !noRestart, writer)
+	err = instance.BindAppProcesses(a, r.Form["process"], !noRestart, writer)
 	if err != nil {
 		return err
 	}
@@ -1267,6 +1574,7 @@ func unbindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 //   200: Ok
 //   401: Unauthorized
 //   404: App not found
+//   409: App is deleted
 func restart(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	process := r.FormValue("process")
 	appName := r.URL.Query().Get(":app")
@@ -1274,6 +1582,22 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	if err != nil {
 		return err
 	}
+	var opts provision.RestartOptions
+	if batchSize := r.FormValue("batchSize"); batchSize != "" {
+		opts.BatchSize, err = strconv.Atoi(batchSize)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid batch size: " + err.Error()}
+		}
+	}
+	if pause := r.FormValue("pause"); pause != "" {
+		opts.Pause, err = time.ParseDuration(pause)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid pause: " + err.Error()}
+		}
+	}
+	if err = opts.Validate(); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	allowed := permission.Check(t, permission.PermAppUpdateRestart,
 		contextsForApp(&a)...,
 	)
@@ -1295,7 +1619,7 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	return a.Restart(process, writer)
+	return appDeletedError(a.RestartWithOptions(process, opts, writer))
 }
 
 // title: app sleep
@@ -1308,6 +1632,7 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 //   400: Invalid data
 //   401: Unauthorized
 //   404: App not found
+//   409: App is deleted
 func sleep(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	process := r.FormValue("process")
 	appName := r.URL.Query().Get(":app")
@@ -1315,14 +1640,19 @@ func sleep(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	if err != nil {
 		return err
 	}
+	var proxyURL *url.URL
 	proxy := r.FormValue("proxy")
 	if proxy == "" {
-		return &errors.HTTP{Code: http.StatusBadRequest, Message: "Empty proxy URL"}
-	}
-	proxyURL, err := url.Parse(proxy)
-	if err != nil {
-		log.Errorf("Invalid url for proxy param: %v", proxy)
-		return err
+		proxyURL, err = app.WakeupProxyURL()
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "Empty proxy URL"}
+		}
+	} else {
+		proxyURL, err = url.Parse(proxy)
+		if err != nil {
+			log.Errorf("Invalid url for proxy param: %v", proxy)
+			return err
+		}
 	}
 	allowed := permission.Check(t, permission.PermAppUpdateSleep,
 		contextsForApp(&a)...,
@@ -1345,7 +1675,7 @@ func sleep(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	return a.Sleep(writer, process, proxyURL)
+	return appDeletedError(a.Sleep(writer, process, proxyURL))
 }
 
 // title: app log
@@ -1398,7 +1728,7 @@ func addLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 //   400: Invalid data
 //   401: Unauthorized
 //   404: App not found
-//   409: App locked
+//   409: App locked or deleted
 //   412: Number of units or platform don't match
 func swap(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	app1Name := r.FormValue("app1")
@@ -1486,7 +1816,7 @@ func swap(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 			}
 		}
 	}
-	return app.Swap(app1, app2, cnameOnly)
+	return appDeletedError(app.Swap(app1, app2, cnameOnly))
 }
 
 // title: app start
@@ -1498,6 +1828,7 @@ func swap(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 //   200: Ok
 //   401: Unauthorized
 //   404: App not found
+//   409: App is deleted
 func start(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	process := r.FormValue("process")
 	appName := r.URL.Query().Get(":app")
@@ -1526,7 +1857,7 @@ func start(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	return a.Start(writer, process)
+	return appDeletedError(a.Start(writer, process))
 }
 
 // title: app stop
@@ -1651,7 +1982,16 @@ func registerUnit(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		}
 		return err
 	}
-	return writeEnvVars(w, a)
+	envs, err := a.UnitEnvs()
+	if err != nil {
+		return err
+	}
+	result := make([]bind.EnvVar, 0, len(envs))
+	for _, env := range envs {
+		result = append(result, env)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
 }
 
 // title: metric envs
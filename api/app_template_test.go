@@ -0,0 +1,136 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAppTemplateAdd(c *check.C) {
+	body := strings.NewReader("name=zend-web&platform=zend&pool=test1&description=vetted")
+	request, err := http.NewRequest("POST", "/app-templates", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	tpl, err := app.GetAppTemplate("zend-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(tpl.Platform, check.Equals, "zend")
+	c.Assert(tpl.Pool, check.Equals, "test1")
+	c.Assert(tpl.Description, check.Equals, "vetted")
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeAppTemplate, Value: "zend-web"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "app-template.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "zend-web"},
+			{"name": "platform", "value": "zend"},
+			{"name": "pool", "value": "test1"},
+			{"name": "description", "value": "vetted"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAppTemplateAddInvalidPlatform(c *check.C) {
+	body := strings.NewReader("name=cobol-web&platform=cobol")
+	request, err := http.NewRequest("POST", "/app-templates", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.InvalidPlatformError.Error()+"\n")
+}
+
+func (s *S) TestAppTemplateAddUnauthorized(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermAppCreate,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=zend-web&platform=zend")
+	request, err := http.NewRequest("POST", "/app-templates", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestAppTemplateList(c *check.C) {
+	tpl := app.AppTemplate{Name: "zend-web", Platform: "zend"}
+	err := tpl.Save()
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/app-templates", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var templates []app.AppTemplate
+	err = json.NewDecoder(recorder.Body).Decode(&templates)
+	c.Assert(err, check.IsNil)
+	c.Assert(templates, check.DeepEquals, []app.AppTemplate{tpl})
+}
+
+func (s *S) TestAppTemplateListEmpty(c *check.C) {
+	request, err := http.NewRequest("GET", "/app-templates", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
+}
+
+func (s *S) TestAppTemplateRemove(c *check.C) {
+	tpl := app.AppTemplate{Name: "zend-web", Platform: "zend"}
+	err := tpl.Save()
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/app-templates/zend-web", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = app.GetAppTemplate("zend-web")
+	c.Assert(err, check.Equals, app.ErrAppTemplateNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeAppTemplate, Value: "zend-web"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "app-template.delete",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": "zend-web"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestAppTemplateRemoveNotFound(c *check.C) {
+	request, err := http.NewRequest("DELETE", "/app-templates/unknown", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
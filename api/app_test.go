@@ -9,9 +9,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -1277,6 +1280,216 @@ func (s *S) TestUpdateAppPoolWhenAppDoesNotExist(c *check.C) {
 	c.Assert(recorder.Body.String(), check.Matches, "^App not found.\n$")
 }
 
+func (s *S) TestMigrateApp(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	opts := provision.AddPoolOptions{Name: "test", Public: true}
+	err = provision.AddPool(opts)
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("test")
+	token := customUserWithPermission(c, "migrator", permission.Permission{
+		Scheme:  permission.PermAppUpdatePoolMigrate,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	body := strings.NewReader("pool=test")
+	request, err := http.NewRequest("POST", "/apps/myappx/migrate", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*Migrating app \\"myappx\\" from pool.*`)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, "test")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  token.GetUserName(),
+		Kind:   "app.update.pool.migrate",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+			{"name": "pool", "value": "test"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestMigrateAppWithoutPool(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/myappx/migrate", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "You must provide the pool.\n")
+}
+
+func (s *S) TestCreateAppWithTemplate(c *check.C) {
+	tpl := app.AppTemplate{
+		Name:     "zend-web",
+		Platform: "zend",
+		Envs:     map[string]string{"WORKERS": "4"},
+	}
+	err := tpl.Save()
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=someapp&template=zend-web")
+	request, err := http.NewRequest("POST", "/apps", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	dbApp, err := app.GetByName("someapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Platform, check.Equals, "zend")
+	c.Assert(dbApp.Env["WORKERS"].Value, check.Equals, "4")
+}
+
+func (s *S) TestCreateAppWithTemplateNotFound(c *check.C) {
+	body := strings.NewReader("name=someapp&template=unknown")
+	request, err := http.NewRequest("POST", "/apps", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrAppTemplateNotFound.Error()+"\n")
+}
+
+func (s *S) TestCloneApp(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("name=myappx-staging&envs.0.name=STAGE&envs.0.value=staging")
+	request, err := http.NewRequest("POST", "/apps/myappx/clone", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*Cloning app \\"myappx\\" to \\"myappx-staging\\".*`)
+	dbApp, err := app.GetByName("myappx-staging")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Platform, check.Equals, "zend")
+	c.Assert(dbApp.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(dbApp.Env["STAGE"].Value, check.Equals, "staging")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myappx-staging"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+			{"name": "name", "value": "myappx-staging"},
+			{"name": "envs.0.name", "value": "STAGE"},
+			{"name": "envs.0.value", "value": "staging"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestCloneAppWithoutName(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/myappx/clone", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "You must provide the name of the new app.\n")
+}
+
+func (s *S) TestCloneAppWithoutCreatePermission(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "reader", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	body := strings.NewReader("name=myappx-staging")
+	request, err := http.NewRequest("POST", "/apps/myappx/clone", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = app.GetByName("myappx-staging")
+	c.Assert(err, check.Equals, app.ErrAppNotFound)
+}
+
+func (s *S) TestCloneAppWithoutReadEnvPermission(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "cloner", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	}, permission.Permission{
+		Scheme:  permission.PermAppCreate,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=myappx-staging")
+	request, err := http.NewRequest("POST", "/apps/myappx/clone", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = app.GetByName("myappx-staging")
+	c.Assert(err, check.Equals, app.ErrAppNotFound)
+}
+
+func (s *S) TestCloneAppWithSecretEnvsWithoutReadSecretsPermission(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "API_KEY", Value: "s3cr3t", Secret: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "cloner", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	}, permission.Permission{
+		Scheme:  permission.PermAppReadEnv,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	}, permission.Permission{
+		Scheme:  permission.PermAppCreate,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("name=myappx-staging")
+	request, err := http.NewRequest("POST", "/apps/myappx/clone", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	_, err = app.GetByName("myappx-staging")
+	c.Assert(err, check.Equals, app.ErrAppNotFound)
+}
+
 func (s *S) TestUpdateAppPlanOnly(c *check.C) {
 	config.Set("docker:router", "fake")
 	defer config.Unset("docker:router")
@@ -2590,6 +2803,124 @@ func (s *S) TestGetEnvWithAppToken(c *check.C) {
 	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
 }
 
+func (s *S) TestGetEnvSecretIsMasked(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "DATABASE_PASSWORD", Value: "secret", Secret: true}},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/black-dog/env", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var result []bind.EnvVar
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []bind.EnvVar{
+		{Name: "DATABASE_PASSWORD", Value: "*****", Secret: true},
+	})
+	request, err = http.NewRequest("GET", "/apps/black-dog/env?reveal=true", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	result = nil
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []bind.EnvVar{
+		{Name: "DATABASE_PASSWORD", Value: "secret", Secret: true},
+	})
+}
+
+func (s *S) TestGetEnvRevealWithoutPermission(c *check.C) {
+	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "env-reader", permission.Permission{
+		Scheme:  permission.PermAppReadEnv,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("GET", "/apps/black-dog/env?reveal=true", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	request, err = http.NewRequest("GET", "/apps/black-dog/env", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+}
+
+func (s *S) TestSetEnvSecret(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	d := Envs{
+		Envs: []struct{ Name, Value string }{
+			{"DATABASE_PASSWORD", "secret"},
+		},
+		Secret: true,
+	}
+	v, err := form.EncodeToValues(&d)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/black-dog/env", strings.NewReader(v.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName("black-dog")
+	c.Assert(err, check.IsNil)
+	env := dbApp.Env["DATABASE_PASSWORD"]
+	c.Assert(env.Secret, check.Equals, true)
+	c.Assert(env.Public, check.Equals, false)
+	c.Assert(env.Value, check.Not(check.Equals), "secret")
+	c.Assert(dbApp.Envs()["DATABASE_PASSWORD"].Value, check.Equals, "secret")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.env.set",
+		StartCustomData: []map[string]interface{}{
+			{"name": "Envs.0.Name", "value": "DATABASE_PASSWORD"},
+			{"name": "Envs.0.Value", "value": "*****"},
+			{"name": "Secret", "value": "true"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestSetEnvSecretWithoutEncryptionKey(c *check.C) {
+	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("Envs.0.Name=DATABASE_PASSWORD&Envs.0.Value=secret&Secret=true")
+	request, err := http.NewRequest("POST", "/apps/black-dog/env", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	dbApp, err := app.GetByName("black-dog")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env, check.HasLen, 0)
+}
+
 func (s *S) TestSetEnvPublicEnvironmentVariableInTheApp(c *check.C) {
 	a := app.App{Name: "black-dog", Platform: "zend", TeamOwner: s.team.Name}
 	err := app.CreateApp(&a, s.user)
@@ -3975,6 +4306,63 @@ func (s *S) TestBindHandlerReturns404IfTheInstanceDoesNotExist(c *check.C) {
 	c.Assert(e.Message, check.Equals, service.ErrServiceInstanceNotFound.Error())
 }
 
+func (s *S) TestBindHandlerReturns409IfTheInstanceIsNotReady(c *check.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}, State: service.StateProvisioning}
+	err := instance.Create()
+	c.Assert(err, check.IsNil)
+	a := app.App{Name: "serviceapp", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/services/mysql/instances/my-mysql/%s?:instance=my-mysql&:app=%s&:service=mysql&noRestart=false", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = bindServiceInstance(recorder, request, s.token)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Code, check.Equals, http.StatusConflict)
+	c.Assert(e.Message, check.Equals, "service instance is not ready: provisioning")
+}
+
+func (s *S) TestBindHandlerReturns400IfThePoolIsNotAllowed(c *check.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}, Pools: []string{"other-pool"}}
+	err := instance.Create()
+	c.Assert(err, check.IsNil)
+	a := app.App{Name: "serviceapp", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/services/mysql/instances/my-mysql/%s?:instance=my-mysql&:app=%s&:service=mysql&noRestart=false", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = bindServiceInstance(recorder, request, s.token)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, check.Equals, service.ErrPoolNotAllowed.Error())
+}
+
+func (s *S) TestBindHandlerReturns400IfTheProcessIsInvalid(c *check.C) {
+	instance := service.ServiceInstance{Name: "my-mysql", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err := instance.Create()
+	c.Assert(err, check.IsNil)
+	a := app.App{Name: "serviceapp", Platform: "zend", TeamOwner: s.team.Name}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/services/mysql/instances/my-mysql/%s?:instance=my-mysql&:app=%s&:service=mysql&noRestart=false&process=worker", a.Name, a.Name)
+	request, err := http.NewRequest("PUT", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = bindServiceInstance(recorder, request, s.token)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, check.Equals, `process "worker" not found in app "serviceapp"`)
+}
+
 func (s *S) TestBindHandlerReturns403IfTheUserDoesNotHaveAccessToTheInstance(c *check.C) {
 	token := userWithPermission(c, permission.Permission{
 		Scheme:  permission.PermServiceInstanceUpdateBind,
@@ -4552,6 +4940,55 @@ func (s *S) TestRestartHandlerSingleProcess(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestRestartHandlerWithBatchSize(c *check.C) {
+	config.Set("docker:router", "fake")
+	defer config.Unset("docker:router")
+	a := app.App{
+		Name:      "stress",
+		Platform:  "zend",
+		TeamOwner: s.team.Name,
+	}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/restart", a.Name)
+	body := strings.NewReader("batchSize=3&pause=10s")
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 1)
+	c.Assert(s.provisioner.LastRestartOptions(&a), check.DeepEquals, provision.RestartOptions{
+		BatchSize: 3,
+		Pause:     10 * time.Second,
+	})
+}
+
+func (s *S) TestRestartHandlerInvalidPause(c *check.C) {
+	a := app.App{
+		Name:      "stress",
+		Platform:  "zend",
+		TeamOwner: s.team.Name,
+	}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/restart", a.Name)
+	body := strings.NewReader("batchSize=3&pause=soon")
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, "invalid pause: .*\n")
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 0)
+}
+
 func (s *S) TestRestartHandlerReturns404IfTheAppDoesNotExist(c *check.C) {
 	request, err := http.NewRequest("GET", "/apps/unknown/restart?:app=unknown", nil)
 	c.Assert(err, check.IsNil)
@@ -5148,6 +5585,58 @@ func (s *S) TestRegisterUnitInvalidUnit(c *check.C) {
 	c.Assert(recorder.Body.String(), check.Equals, "unit \"invalid-unit-host\" not found\n")
 }
 
+func (s *S) TestRegisterUnitResolvesSecretReferences(c *check.C) {
+	dir, err := ioutil.TempDir("", "secrets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "token"), []byte("abc123"), 0600)
+	c.Assert(err, check.IsNil)
+	config.Set("secret-providers:files:type", "file")
+	config.Set("secret-providers:files:path", dir)
+	config.Set("secret-providers:files:allowed-paths", []interface{}{"token"})
+	defer config.Unset("secret-providers")
+	a := app.App{
+		Name:     "myappx",
+		Platform: "python",
+		Env: map[string]bind.EnvVar{
+			"TOKEN": {Name: "TOKEN", Value: "files://token"},
+		},
+		TeamOwner: s.team.Name,
+	}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.AddUnits(&a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("hostname=" + units[0].ID)
+	request, err := http.NewRequest("POST", "/apps/myappx/units/register", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	result := []map[string]interface{}{}
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	envMap := map[interface{}]interface{}{}
+	for _, envVar := range result {
+		envMap[envVar["name"]] = envVar["value"]
+	}
+	c.Assert(envMap["TOKEN"], check.Equals, "abc123")
+	err = os.Remove(filepath.Join(dir, "token"))
+	c.Assert(err, check.IsNil)
+	body = strings.NewReader("hostname=" + units[0].ID)
+	request, err = http.NewRequest("POST", "/apps/myappx/units/register", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusInternalServerError)
+	c.Assert(recorder.Body.String(), check.Matches, "unable to resolve env TOKEN of app myappx: .*\n")
+}
+
 func (s *S) TestRegisterUnitWithCustomData(c *check.C) {
 	a := app.App{
 		Name:     "myappx",
@@ -0,0 +1,199 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/permission"
+)
+
+func configFileError(err error) error {
+	if err == app.ErrConfigFileNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	return appDeletedError(err)
+}
+
+func appForConfigFile(r *http.Request, t auth.Token, perm *permission.PermissionScheme) (*app.App, error) {
+	a, err := getAppFromContext(r.URL.Query().Get(":app"), r)
+	if err != nil {
+		return nil, err
+	}
+	allowed := permission.Check(t, perm, contextsForApp(&a)...)
+	if !allowed {
+		return nil, permission.ErrUnauthorized
+	}
+	return &a, nil
+}
+
+// title: config file list
+// path: /apps/{app}/files
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: App not found
+func configFileList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	a, err := appForConfigFile(r, t, permission.PermAppReadConfigFile)
+	if err != nil {
+		return err
+	}
+	files, err := a.ListConfigFiles()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(files)
+}
+
+// title: config file versions
+// path: /apps/{app}/files/{name}/versions
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: App or config file not found
+func configFileVersions(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	a, err := appForConfigFile(r, t, permission.PermAppReadConfigFile)
+	if err != nil {
+		return err
+	}
+	files, err := a.ConfigFileVersions(r.URL.Query().Get(":name"))
+	if err != nil {
+		return configFileError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(files)
+}
+
+// title: config file content
+// path: /apps/{app}/files/{name}
+// method: GET
+// produce: application/octet-stream
+// responses:
+//   200: OK
+//   400: Invalid version
+//   401: Unauthorized
+//   404: App or config file not found
+func configFileContent(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var version int
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		version, err = strconv.Atoi(v)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid version: " + v}
+		}
+	}
+	a, err := appForConfigFile(r, t, permission.PermAppReadConfigFile)
+	if err != nil {
+		return err
+	}
+	file, err := a.GetConfigFile(r.URL.Query().Get(":name"), version)
+	if err != nil {
+		return configFileError(err)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Tsuru-Config-File-Version", strconv.Itoa(file.Version))
+	_, err = w.Write(file.Content)
+	return err
+}
+
+// title: config file set
+// path: /apps/{app}/files/{name}
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: Config file updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+//   409: App is deleted
+func configFileSet(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if _, ok := r.Form["content"]; !ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the content of the config file."}
+	}
+	content := r.FormValue("content")
+	noRestart, _ := strconv.ParseBool(r.FormValue("noRestart"))
+	a, err := appForConfigFile(r, t, permission.PermAppUpdateConfigFileSet)
+	if err != nil {
+		return err
+	}
+	r.Form.Del("content")
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(a.Name),
+		Kind:       permission.PermAppUpdateConfigFileSet,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	_, err = a.SetConfigFile(r.URL.Query().Get(":name"), []byte(content), t.GetUserName(), !noRestart, writer)
+	return configFileError(err)
+}
+
+// title: config file unset
+// path: /apps/{app}/files/{name}
+// method: DELETE
+// produce: application/x-json-stream
+// responses:
+//   200: Config file removed
+//   401: Unauthorized
+//   404: App or config file not found
+//   409: App is deleted
+func configFileUnset(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	noRestart, _ := strconv.ParseBool(r.FormValue("noRestart"))
+	a, err := appForConfigFile(r, t, permission.PermAppUpdateConfigFileUnset)
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(a.Name),
+		Kind:       permission.PermAppUpdateConfigFileUnset,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	return configFileError(a.RemoveConfigFile(r.URL.Query().Get(":name"), !noRestart, writer))
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) createConfigFileApp(c *check.C) *app.App {
+	a := app.App{Name: "myapp", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	return &a
+}
+
+func (s *S) TestConfigFileSet(c *check.C) {
+	a := s.createConfigFileApp(c)
+	body := strings.NewReader(url.Values{"content": {"server {}"}}.Encode())
+	request, err := http.NewRequest("PUT", "/apps/myapp/files/nginx.conf", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Equals, `{"Message":"---- Config file \"nginx.conf\" updated to version 1 ----\n"}`+"\n")
+	file, err := a.GetConfigFile("nginx.conf", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(file.Content), check.Equals, "server {}")
+	c.Assert(file.Owner, check.Equals, s.token.GetUserName())
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.config-file.set",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": "nginx.conf"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestConfigFileSetInvalid(c *check.C) {
+	s.createConfigFileApp(c)
+	tests := []struct {
+		path string
+		body string
+	}{
+		{path: "/apps/myapp/files/.hidden", body: "content=x"},
+		{path: "/apps/myapp/files/nginx.conf", body: "other=x"},
+	}
+	m := RunServer(true)
+	for _, tt := range tests {
+		request, err := http.NewRequest("PUT", tt.path, strings.NewReader(tt.body))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf("path: %s", tt.path))
+	}
+}
+
+func (s *S) TestConfigFileListAndContent(c *check.C) {
+	a := s.createConfigFileApp(c)
+	_, err := a.SetConfigFile("nginx.conf", []byte("v1"), "me", false, nil)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("nginx.conf", []byte("v2"), "me", false, nil)
+	c.Assert(err, check.IsNil)
+	m := RunServer(true)
+	request, err := http.NewRequest("GET", "/apps/myapp/files", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var files []app.ConfigFile
+	err = json.NewDecoder(recorder.Body).Decode(&files)
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 1)
+	c.Assert(files[0].Name, check.Equals, "nginx.conf")
+	c.Assert(files[0].Version, check.Equals, 2)
+	request, err = http.NewRequest("GET", "/apps/myapp/files/nginx.conf/versions", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	files = nil
+	err = json.NewDecoder(recorder.Body).Decode(&files)
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 2)
+	request, err = http.NewRequest("GET", "/apps/myapp/files/nginx.conf?version=1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "v1")
+	c.Assert(recorder.Header().Get("X-Tsuru-Config-File-Version"), check.Equals, "1")
+	request, err = http.NewRequest("GET", "/apps/myapp/files/other.conf", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestConfigFileUnset(c *check.C) {
+	a := s.createConfigFileApp(c)
+	_, err := a.SetConfigFile("nginx.conf", []byte("v1"), "me", false, nil)
+	c.Assert(err, check.IsNil)
+	m := RunServer(true)
+	request, err := http.NewRequest("DELETE", "/apps/myapp/files/nginx.conf", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = a.GetConfigFile("nginx.conf", 0)
+	c.Assert(err, check.Equals, app.ErrConfigFileNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.config-file.unset",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestConfigFileSetWithoutPermission(c *check.C) {
+	s.createConfigFileApp(c)
+	token := customUserWithPermission(c, "file-reader", permission.Permission{
+		Scheme:  permission.PermAppReadConfigFile,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("PUT", "/apps/myapp/files/nginx.conf", strings.NewReader("content=x"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
@@ -33,6 +33,7 @@ import (
 //   400: Invalid data
 //   403: Forbidden
 //   404: Not found
+//   409: App is deleted
 func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	var file multipart.File
 	var fileSize int64
@@ -153,7 +154,7 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	if err == nil {
 		fmt.Fprintln(w, "\nOK")
 	}
-	return err
+	return appDeletedError(err)
 }
 
 func permSchemeForDeploy(opts app.DeployOptions) *permission.PermissionScheme {
@@ -0,0 +1,149 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/permission"
+)
+
+func parseEnvRevision(value string) (int, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 0 {
+		return 0, &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid env revision: " + value}
+	}
+	return version, nil
+}
+
+// title: env revision list
+// path: /apps/{app}/env/revisions
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+//   404: App not found
+func envRevisionList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppReadEnv, contextsForApp(&a)...)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	revisions, err := app.ListEnvRevisions(a.Name)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(revisions)
+}
+
+// title: env revision diff
+// path: /apps/{app}/env/revisions/diff
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   400: Invalid revision
+//   401: Unauthorized
+//   404: App or revision not found
+func envRevisionDiff(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	from, err := parseEnvRevision(r.URL.Query().Get("from"))
+	if err != nil {
+		return err
+	}
+	to, err := parseEnvRevision(r.URL.Query().Get("to"))
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppReadEnv, contextsForApp(&a)...)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	diff, err := app.DiffEnvRevisions(a.Name, from, to)
+	if err == app.ErrEnvRevisionNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if diff == nil {
+		diff = []app.EnvDiff{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(diff)
+}
+
+// title: env revision rollback
+// path: /apps/{app}/env/revisions/{version}/rollback
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: Envs rolled back
+//   400: Invalid revision
+//   401: Unauthorized
+//   404: App or revision not found
+//   409: App is deleted
+func envRevisionRollback(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	version, err := parseEnvRevision(r.URL.Query().Get(":version"))
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateEnvRollback,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	if _, err = app.GetEnvRevision(a.Name, version); err == app.ErrEnvRevisionNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateEnvRollback,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	noRestart, _ := strconv.ParseBool(r.FormValue("noRestart"))
+	return appDeletedError(a.RollbackEnv(version, t.GetUserName(), !noRestart, writer))
+}
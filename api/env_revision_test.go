@@ -0,0 +1,149 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) createEnvRevisionsApp(c *check.C) *app.App {
+	a := app.App{Name: "swift", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{
+		Envs:  []bind.EnvVar{{Name: "DATABASE_HOST", Value: "localhost", Public: true}},
+		Owner: s.user.Email,
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{
+		Envs:  []bind.EnvVar{{Name: "DATABASE_HOST", Value: "remotehost", Public: true}},
+		Owner: s.user.Email,
+	}, nil)
+	c.Assert(err, check.IsNil)
+	return &a
+}
+
+func (s *S) TestEnvRevisionList(c *check.C) {
+	a := s.createEnvRevisionsApp(c)
+	request, err := http.NewRequest("GET", "/apps/swift/env/revisions", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var revisions []app.EnvRevision
+	err = json.NewDecoder(recorder.Body).Decode(&revisions)
+	c.Assert(err, check.IsNil)
+	expected, err := app.ListEnvRevisions(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(revisions, check.HasLen, len(expected))
+	c.Assert(revisions[0].Version, check.Equals, expected[0].Version)
+	c.Assert(revisions[0].Owner, check.Equals, s.user.Email)
+	c.Assert(revisions[0].Changed, check.DeepEquals, []string{"DATABASE_HOST"})
+}
+
+func (s *S) TestEnvRevisionDiff(c *check.C) {
+	a := s.createEnvRevisionsApp(c)
+	revisions, err := app.ListEnvRevisions(a.Name)
+	c.Assert(err, check.IsNil)
+	url := "/apps/swift/env/revisions/diff?from=" + strconv.Itoa(revisions[1].Version) + "&to=" + strconv.Itoa(revisions[0].Version)
+	request, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var diff []app.EnvDiff
+	err = json.NewDecoder(recorder.Body).Decode(&diff)
+	c.Assert(err, check.IsNil)
+	c.Assert(diff, check.DeepEquals, []app.EnvDiff{
+		{Name: "DATABASE_HOST", Action: app.EnvChanged, Old: "localhost", New: "remotehost"},
+	})
+}
+
+func (s *S) TestEnvRevisionDiffInvalid(c *check.C) {
+	s.createEnvRevisionsApp(c)
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{query: "from=a&to=1", code: http.StatusBadRequest},
+		{query: "from=1", code: http.StatusBadRequest},
+		{query: "from=1&to=100", code: http.StatusNotFound},
+	}
+	m := RunServer(true)
+	for _, tt := range tests {
+		request, err := http.NewRequest("GET", "/apps/swift/env/revisions/diff?"+tt.query, nil)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, tt.code, check.Commentf("query: %s", tt.query))
+	}
+}
+
+func (s *S) TestEnvRevisionRollback(c *check.C) {
+	a := s.createEnvRevisionsApp(c)
+	revisions, err := app.ListEnvRevisions(a.Name)
+	c.Assert(err, check.IsNil)
+	version := strconv.Itoa(revisions[1].Version)
+	request, err := http.NewRequest("POST", "/apps/swift/env/revisions/"+version+"/rollback", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["DATABASE_HOST"].Value, check.Equals, "localhost")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.env.rollback",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":version", "value": version},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestEnvRevisionRollbackNotFound(c *check.C) {
+	s.createEnvRevisionsApp(c)
+	request, err := http.NewRequest("POST", "/apps/swift/env/revisions/100/rollback", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestEnvRevisionRollbackWithoutPermission(c *check.C) {
+	s.createEnvRevisionsApp(c)
+	token := customUserWithPermission(c, "env-reader", permission.Permission{
+		Scheme:  permission.PermAppReadEnv,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("POST", "/apps/swift/env/revisions/1/rollback", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
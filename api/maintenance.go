@@ -0,0 +1,220 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// maintenanceHandler serves the maintenance page of apps in maintenance mode.
+// Routers send it the requests of these apps, it identifies the app by the
+// Host header and renders its page.
+type maintenanceHandler struct{}
+
+func (h *maintenanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, err := app.GetByHost(r.Host)
+	if err == app.ErrAppNotFound {
+		http.Error(w, fmt.Sprintf("no app found for host %q", r.Host), http.StatusNotFound)
+		return
+	}
+	var page bytes.Buffer
+	if err == nil {
+		err = a.RenderMaintenancePage(&page)
+	}
+	if err != nil {
+		log.Errorf("[maintenance] unable to render maintenance page for host %q: %s", r.Host, err)
+		http.Error(w, "app under maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(page.Bytes())
+}
+
+func startMaintenanceServer(listen string) {
+	fmt.Printf("tsuru maintenance page server listening at %s...\n", listen)
+	err := http.ListenAndServe(listen, &maintenanceHandler{})
+	if err != nil {
+		fmt.Printf("Maintenance page server stopped: %s\n", err)
+	}
+}
+
+// title: app enable maintenance
+// path: /apps/{app}/maintenance
+// method: POST
+// produce: application/x-json-stream
+// responses:
+//   200: Maintenance mode enabled
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+//   409: App is deleted
+func enableMaintenance(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateMaintenanceEnable,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	if a.Maintenance != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: app.ErrAppInMaintenance.Error()}
+	}
+	if _, err = app.MaintenancePageURL(); err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateMaintenanceEnable,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	evt.SetLogWriter(writer)
+	return appDeletedError(a.EnableMaintenance(t.GetUserName(), evt))
+}
+
+// title: app disable maintenance
+// path: /apps/{app}/maintenance
+// method: DELETE
+// produce: application/x-json-stream
+// responses:
+//   200: Maintenance mode disabled
+//   400: App not in maintenance mode
+//   401: Unauthorized
+//   404: App not found
+func disableMaintenance(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateMaintenanceDisable,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	if a.Maintenance == nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: app.ErrAppNotInMaintenance.Error()}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateMaintenanceDisable,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	evt.SetLogWriter(writer)
+	return a.DisableMaintenance(evt)
+}
+
+// title: app maintenance page
+// path: /apps/{app}/maintenance/page
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Page updated
+//   401: Unauthorized
+//   404: App not found
+func setAppMaintenancePage(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	page := r.FormValue("page")
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateMaintenancePage,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateMaintenancePage,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return a.SetMaintenancePage(page)
+}
+
+// title: team maintenance page
+// path: /teams/{name}/maintenance/page
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Page updated
+//   401: Unauthorized
+//   404: Team not found
+func setTeamMaintenancePage(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	page := r.FormValue("page")
+	teamName := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamUpdateMaintenancePage,
+		permission.Context(permission.CtxTeam, teamName),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	_, err = auth.GetTeam(teamName)
+	if err == auth.ErrTeamNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     teamTarget(teamName),
+		Kind:       permission.PermTeamUpdateMaintenancePage,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermTeamReadEvents, permission.Context(permission.CtxTeam, teamName)),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return auth.SetTeamMaintenancePage(teamName, page)
+}
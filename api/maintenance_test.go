@@ -0,0 +1,208 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMaintenanceHandler(c *check.C) {
+	a := app.App{Name: "busy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetMaintenancePage("<h1>Migrating, back soon</h1>")
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "http://busy.fakerouter.com/some/path", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	(&maintenanceHandler{}).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/html; charset=utf-8")
+	c.Assert(recorder.Body.String(), check.Equals, "<h1>Migrating, back soon</h1>")
+}
+
+func (s *S) TestMaintenanceHandlerAppNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "http://unknown.fakerouter.com/", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	(&maintenanceHandler{}).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestEnableMaintenance(c *check.C) {
+	config.Set("maintenance:url", "http://tsuru-maintenance:8082")
+	defer config.Unset("maintenance:url")
+	a := app.App{Name: "busy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	token := customUserWithPermission(c, "maintainer", permission.Permission{
+		Scheme:  permission.PermAppUpdateMaintenance,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	request, err := http.NewRequest("POST", "/apps/busy/maintenance", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*Enabling maintenance mode of app \\"busy\\".*`)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 1)
+	c.Assert(routes[0].String(), check.Equals, "http://tsuru-maintenance:8082")
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Maintenance, check.NotNil)
+	c.Assert(dbApp.Maintenance.Owner, check.Equals, token.GetUserName())
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  token.GetUserName(),
+		Kind:   "app.update.maintenance.enable",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestEnableMaintenanceNotConfigured(c *check.C) {
+	a := app.App{Name: "busy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/busy/maintenance", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrMaintenancePageNotConfigured.Error()+"\n")
+}
+
+func (s *S) TestDisableMaintenance(c *check.C) {
+	config.Set("maintenance:url", "http://tsuru-maintenance:8082")
+	defer config.Unset("maintenance:url")
+	a := app.App{Name: "busy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	err = a.EnableMaintenance(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/busy/maintenance", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://tsuru-maintenance:8082"), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, units[0].Address.String()), check.Equals, true)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Maintenance, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.maintenance.disable",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestDisableMaintenanceNotInMaintenance(c *check.C) {
+	a := app.App{Name: "busy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/busy/maintenance", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrAppNotInMaintenance.Error()+"\n")
+}
+
+func (s *S) TestSetAppMaintenancePage(c *check.C) {
+	a := app.App{Name: "busy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader("page=%3Ch1%3EBack+soon%3C%2Fh1%3E")
+	request, err := http.NewRequest("PUT", "/apps/busy/maintenance/page", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.MaintenancePage, check.Equals, "<h1>Back soon</h1>")
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.maintenance.page",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+			{"name": "page", "value": "<h1>Back soon</h1>"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestSetTeamMaintenancePage(c *check.C) {
+	token := customUserWithPermission(c, "teammaintainer", permission.Permission{
+		Scheme:  permission.PermTeamUpdateMaintenancePage,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	body := strings.NewReader("page=%3Ch1%3EBack+soon%3C%2Fh1%3E")
+	request, err := http.NewRequest("PUT", "/teams/"+s.team.Name+"/maintenance/page", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.MaintenancePage, check.Equals, "<h1>Back soon</h1>")
+	c.Assert(eventtest.EventDesc{
+		Target: teamTarget(s.team.Name),
+		Owner:  token.GetUserName(),
+		Kind:   "team.update.maintenance.page",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":name", "value": s.team.Name},
+			{"name": "page", "value": "<h1>Back soon</h1>"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestSetTeamMaintenancePageTeamNotFound(c *check.C) {
+	body := strings.NewReader("page=x")
+	request, err := http.NewRequest("PUT", "/teams/unknown/maintenance/page", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -0,0 +1,231 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/service"
+)
+
+var manifestChangePermissions = map[app.ManifestAction]*permission.PermissionScheme{
+	app.ManifestActionUpdateDescription: permission.PermAppUpdateDescription,
+	app.ManifestActionUpdatePool:        permission.PermAppUpdatePool,
+	app.ManifestActionUpdatePlan:        permission.PermAppUpdatePlan,
+	app.ManifestActionUpdateTeamOwner:   permission.PermAppUpdateTeamowner,
+	app.ManifestActionGrant:             permission.PermAppUpdateGrant,
+	app.ManifestActionRevoke:            permission.PermAppUpdateRevoke,
+	app.ManifestActionSetEnv:            permission.PermAppUpdateEnvSet,
+	app.ManifestActionUnsetEnv:          permission.PermAppUpdateEnvUnset,
+	app.ManifestActionAddCName:          permission.PermAppUpdateCnameAdd,
+	app.ManifestActionRemoveCName:       permission.PermAppUpdateCnameRemove,
+	app.ManifestActionBind:              permission.PermAppUpdateBind,
+	app.ManifestActionUnbind:            permission.PermAppUpdateUnbind,
+	app.ManifestActionAddUnits:          permission.PermAppUpdateUnitAdd,
+	app.ManifestActionRemoveUnits:       permission.PermAppUpdateUnitRemove,
+}
+
+// manifestFromRequest parses the manifest in the request, setting its team
+// owner to the team of the user when it's not set and the app does not exist
+// yet.
+func manifestFromRequest(r *http.Request, t auth.Token) (*app.Manifest, error) {
+	data := r.FormValue("manifest")
+	if data == "" {
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the manifest."}
+	}
+	m, err := app.ParseManifest([]byte(data))
+	if err != nil {
+		return nil, &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if m.TeamOwner != "" {
+		return m, nil
+	}
+	_, err = app.GetByName(m.Name)
+	if err != app.ErrAppNotFound {
+		return m, err
+	}
+	m.TeamOwner, err = defaultTeamOwner(t)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// planManifest computes the changes of the manifest, checking if the user is
+// allowed to execute each one of them.
+func planManifest(m *app.Manifest, t auth.Token) (*app.App, []app.ManifestChange, error) {
+	a, changes, err := app.PlanManifest(m)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return nil, nil, &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var contexts []permission.PermissionContext
+	if a == nil {
+		if !permission.Check(t, permission.PermAppCreate, permission.Context(permission.CtxTeam, m.TeamOwner)) {
+			return nil, nil, permission.ErrUnauthorized
+		}
+		contexts = []permission.PermissionContext{
+			permission.Context(permission.CtxApp, m.Name),
+			permission.Context(permission.CtxTeam, m.TeamOwner),
+		}
+	} else {
+		if !permission.Check(t, permission.PermAppRead, contextsForApp(a)...) {
+			return nil, nil, permission.ErrUnauthorized
+		}
+		contexts = contextsForApp(a)
+	}
+	for _, change := range changes {
+		if change.Action == app.ManifestActionCreate {
+			continue
+		}
+		if !permission.Check(t, manifestChangePermissions[change.Action], contexts...) {
+			return nil, nil, permission.ErrUnauthorized
+		}
+		if change.Action != app.ManifestActionBind && change.Action != app.ManifestActionUnbind {
+			continue
+		}
+		parts := strings.SplitN(change.Target, "/", 2)
+		instance, err := service.GetServiceInstance(parts[0], parts[1])
+		if err == service.ErrServiceInstanceNotFound {
+			return nil, nil, &errors.HTTP{Code: http.StatusBadRequest, Message: fmt.Sprintf("%s: %s", change.Target, err)}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		scheme := permission.PermServiceInstanceUpdateBind
+		if change.Action == app.ManifestActionUnbind {
+			scheme = permission.PermServiceInstanceUpdateUnbind
+		}
+		if !permission.Check(t, scheme, contextsForServiceInstance(instance, instance.ServiceName)...) {
+			return nil, nil, permission.ErrUnauthorized
+		}
+	}
+	return a, changes, nil
+}
+
+// title: app manifest plan
+// path: /manifests/plan
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/json
+// responses:
+//   200: OK
+//   400: Invalid manifest
+//   401: Unauthorized
+func manifestPlan(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	m, err := manifestFromRequest(r, t)
+	if err != nil {
+		return err
+	}
+	_, changes, err := planManifest(m, t)
+	if err != nil {
+		return err
+	}
+	if changes == nil {
+		changes = []app.ManifestChange{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(changes)
+}
+
+// title: app manifest apply
+// path: /manifests/apply
+// method: POST
+// consume: application/x-www-form-urlencoded
+// produce: application/x-json-stream
+// responses:
+//   200: Manifest applied
+//   400: Invalid manifest
+//   401: Unauthorized
+//   403: Quota exceeded
+//   409: App locked or already exists
+func manifestApply(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	m, err := manifestFromRequest(r, t)
+	if err != nil {
+		return err
+	}
+	a, _, err := planManifest(m, t)
+	if err != nil {
+		return err
+	}
+	kind := permission.PermAppCreate
+	contexts := []permission.PermissionContext{
+		permission.Context(permission.CtxApp, m.Name),
+		permission.Context(permission.CtxTeam, m.TeamOwner),
+	}
+	if a != nil {
+		locked, lockErr := app.AcquireApplicationLockWait(a.Name, t.GetUserName(), "/manifests/apply", lockQueueTimeout(false))
+		if lockErr != nil {
+			return lockErr
+		}
+		defer app.ReleaseApplicationLock(a.Name)
+		if !locked {
+			a, err = getApp(a.Name)
+			if err != nil {
+				return err
+			}
+			return &errors.HTTP{Code: http.StatusConflict, Message: fmt.Sprintf("%s", &a.Lock)}
+		}
+		kind = permission.PermAppUpdateManifest
+		contexts = contextsForApp(a)
+	}
+	// The app may have changed while waiting for the lock.
+	_, changes, err := planManifest(m, t)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(m.Name),
+		Kind:       kind,
+		Owner:      t,
+		CustomData: changes,
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contexts...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	evt.SetLogWriter(writer)
+	if len(changes) == 0 {
+		fmt.Fprintf(evt, "App %q is up to date.\n", m.Name)
+		return nil
+	}
+	_, err = app.ApplyManifest(m, changes, u, evt)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	if e, ok := err.(*provision.PoolConstraintError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Error()}
+	}
+	if e, ok := err.(*quota.QuotaExceededError); ok {
+		return &errors.HTTP{Code: http.StatusForbidden, Message: e.Error()}
+	}
+	if e, ok := err.(*app.AppCreationError); ok && e.Err == app.ErrAppAlreadyExists {
+		return &errors.HTTP{Code: http.StatusConflict, Message: e.Error()}
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func manifestRequest(c *check.C, path, manifest string, token string) *httptest.ResponseRecorder {
+	body := strings.NewReader(url.Values{"manifest": {manifest}}.Encode())
+	request, err := http.NewRequest("POST", path, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+token)
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (s *S) TestManifestPlan(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "DEBUG", Value: "1", Public: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	recorder := manifestRequest(c, "/manifests/plan", "name: myappx\nenv:\n  WORKERS: '4'\n", s.token.GetValue())
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var changes []app.ManifestChange
+	err = json.NewDecoder(recorder.Body).Decode(&changes)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.DeepEquals, []app.ManifestChange{
+		{Action: app.ManifestActionSetEnv, Target: "WORKERS", Value: "4"},
+		{Action: app.ManifestActionUnsetEnv, Target: "DEBUG"},
+	})
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["DEBUG"].Value, check.Equals, "1")
+}
+
+func (s *S) TestManifestPlanWithoutManifest(c *check.C) {
+	recorder := manifestRequest(c, "/manifests/plan", "", s.token.GetValue())
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "You must provide the manifest.\n")
+}
+
+func (s *S) TestManifestPlanUnauthorizedChange(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "reader", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	recorder := manifestRequest(c, "/manifests/plan", "name: myappx\nenv:\n  WORKERS: '4'\n", token.GetValue())
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestManifestApplyNewApp(c *check.C) {
+	manifest := "name: myappx\nplatform: zend\nenv:\n  WORKERS: '4'\ncnames: [myappx.example.com]\n"
+	recorder := manifestRequest(c, "/manifests/apply", manifest, s.token.GetValue())
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*Applying create myappx.*`)
+	dbApp, err := app.GetByName("myappx")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Platform, check.Equals, "zend")
+	c.Assert(dbApp.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(dbApp.Env["WORKERS"].Value, check.Equals, "4")
+	c.Assert(dbApp.CName, check.DeepEquals, []string{"myappx.example.com"})
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myappx"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.create",
+		StartCustomData: []map[string]interface{}{
+			{"action": "create", "target": "myappx"},
+			{"action": "set-env", "target": "WORKERS", "value": "4"},
+			{"action": "add-cname", "target": "myappx.example.com"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestManifestApplyExistingApp(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	recorder := manifestRequest(c, "/manifests/apply", "name: myappx\ndescription: my app\n", s.token.GetValue())
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Description, check.Equals, "my app")
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget("myappx"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "app.update.manifest",
+		StartCustomData: []map[string]interface{}{
+			{"action": "update-description", "value": "my app"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestManifestApplyPlatformChanged(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	recorder := manifestRequest(c, "/manifests/apply", "name: myappx\nplatform: python\n", s.token.GetValue())
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrManifestPlatformChanged.Message+"\n")
+}
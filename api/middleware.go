@@ -5,12 +5,15 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	stdIo "io"
 	stdLog "log"
 	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/negroni"
@@ -147,6 +150,97 @@ type appLockMiddleware struct {
 
 var lockWaitDuration time.Duration = 10 * time.Second
 
+// defaultLockQueueTimeout is how long requests not accepting streamed
+// responses wait in the operation queue of an app for its lock when
+// apps:lock-queue:timeout is not set. These requests get no data while they
+// wait, so it's kept below the usual idle timeout of proxies in front of the
+// API.
+const defaultLockQueueTimeout = time.Minute
+
+// defaultLockQueueStreamTimeout is how long requests accepting streamed
+// responses wait in the operation queue of an app for its lock when
+// apps:lock-queue:stream-timeout is not set. They receive keepalive messages
+// while they wait, so they may wait as long as a deploy usually takes.
+const defaultLockQueueStreamTimeout = 30 * time.Minute
+
+// lockQueueKeepAliveInterval is the interval of the keepalive messages sent
+// to streaming clients waiting in the operation queue of an app.
+const lockQueueKeepAliveInterval = 30 * time.Second
+
+func lockQueueTimeout(stream bool) time.Duration {
+	key, defaultTimeout := "apps:lock-queue:timeout", defaultLockQueueTimeout
+	if stream {
+		key, defaultTimeout = "apps:lock-queue:stream-timeout", defaultLockQueueStreamTimeout
+	}
+	timeout, _ := config.GetInt(key)
+	if timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultTimeout
+}
+
+// acceptsStream returns whether the client accepts a streamed response,
+// which is required to report its position in the operation queue of an app.
+func acceptsStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/x-json-stream")
+}
+
+func reportQueuePosition(w stdIo.Writer, op *app.Operation) {
+	msg := fmt.Sprintf("App %s is locked, operation %s is waiting at position %d of the queue.\n", op.App, op.ID.Hex(), op.Position)
+	data, err := json.Marshal(io.SimpleJsonMessage{Message: msg})
+	if err != nil {
+		log.Errorf("unable to report position of operation %s in queue: %s", op.ID.Hex(), err)
+		return
+	}
+	w.Write(append(data, "\n"...))
+}
+
+// queuedStreamWriter is the writer handed to handlers of requests whose
+// position in the operation queue was reported. The status of the response
+// was already sent along with the position, so error responses written by
+// handlers are turned into error messages in the stream.
+type queuedStreamWriter struct {
+	http.ResponseWriter
+	failed  bool
+	errBody bytes.Buffer
+}
+
+func (w *queuedStreamWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest {
+		w.failed = true
+	}
+}
+
+func (w *queuedStreamWriter) Write(data []byte) (int, error) {
+	if w.failed {
+		return w.errBody.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *queuedStreamWriter) CloseNotify() <-chan bool {
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// finish writes the error response of the handler, if any, as an error
+// message and restores the content type of the stream, which is used when
+// reporting errors returned by the handler.
+func (w *queuedStreamWriter) finish() {
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	if !w.failed {
+		return
+	}
+	data, err := json.Marshal(io.SimpleJsonMessage{Error: strings.TrimSpace(w.errBody.String())})
+	if err != nil {
+		log.Errorf("unable to write error of queued request: %s", err)
+		return
+	}
+	w.ResponseWriter.Write(append(data, "\n"...))
+}
+
 func (m *appLockMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	if r.Method == "GET" {
 		next(w, r)
@@ -184,7 +278,36 @@ func (m *appLockMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, ne
 		context.AddRequestError(r, &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()})
 		return
 	}
-	ok, err := app.AcquireApplicationLockWait(appName, owner, fmt.Sprintf("%s %s", r.Method, r.URL.Path), lockWaitDuration)
+	opts := app.LockWaitOpts{
+		App:     appName,
+		Owner:   owner,
+		Reason:  fmt.Sprintf("%s %s", r.Method, r.URL.Path),
+		Timeout: lockQueueTimeout(acceptsStream(r)),
+	}
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		opts.Cancel = notifier.CloseNotify()
+	}
+	var progressWriter interface {
+		stdIo.Writer
+		Stop()
+	}
+	if acceptsStream(r) {
+		opts.Progress = func(op *app.Operation) {
+			if progressWriter == nil {
+				w.Header().Set("Content-Type", "application/x-json-stream")
+				progressWriter = io.NewKeepAliveWriter(w, lockQueueKeepAliveInterval, "")
+			}
+			reportQueuePosition(progressWriter, op)
+		}
+	}
+	ok, err := app.AcquireApplicationLockQueued(opts)
+	if progressWriter != nil {
+		progressWriter.Stop()
+	}
+	if err == app.ErrOperationCancelled {
+		context.AddRequestError(r, &tsuruErrors.HTTP{Code: http.StatusConflict, Message: err.Error()})
+		return
+	}
 	if err != nil {
 		context.AddRequestError(r, errors.Wrap(err, "Error trying to acquire application lock"))
 		return
@@ -195,7 +318,13 @@ func (m *appLockMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, ne
 				app.ReleaseApplicationLock(appName)
 			}
 		}()
-		next(w, r)
+		if progressWriter == nil {
+			next(w, r)
+			return
+		}
+		qw := &queuedStreamWriter{ResponseWriter: w}
+		defer qw.finish()
+		next(qw, r)
 		return
 	}
 	a, err := app.GetByName(appName)
@@ -349,9 +349,8 @@ func (s *S) TestAppLockMiddlewareReturns404IfNotApp(c *check.C) {
 }
 
 func (s *S) TestAppLockMiddlewareOnLockedApp(c *check.C) {
-	oldDuration := lockWaitDuration
-	lockWaitDuration = time.Second
-	defer func() { lockWaitDuration = oldDuration }()
+	config.Set("apps:lock-queue:timeout", 1)
+	defer config.Unset("apps:lock-queue:timeout")
 	myApp := app.App{
 		Name: "my-app",
 		Lock: app.AppLock{
@@ -376,6 +375,17 @@ func (s *S) TestAppLockMiddlewareOnLockedApp(c *check.C) {
 	c.Assert(httpErr.Message, check.Matches, "App locked by someone, running /app/my-app/deploy. Acquired in 2048-11-10.*")
 }
 
+func (s *S) TestLockQueueTimeout(c *check.C) {
+	c.Assert(lockQueueTimeout(false), check.Equals, time.Minute)
+	c.Assert(lockQueueTimeout(true), check.Equals, 30*time.Minute)
+	config.Set("apps:lock-queue:timeout", 10)
+	defer config.Unset("apps:lock-queue:timeout")
+	config.Set("apps:lock-queue:stream-timeout", 600)
+	defer config.Unset("apps:lock-queue:stream-timeout")
+	c.Assert(lockQueueTimeout(false), check.Equals, 10*time.Second)
+	c.Assert(lockQueueTimeout(true), check.Equals, 10*time.Minute)
+}
+
 func (s *S) TestAppLockMiddlewareLocksAndUnlocks(c *check.C) {
 	myApp := app.App{
 		Name: "my-app",
@@ -477,6 +487,118 @@ func (s *S) TestAppLockMiddlewareWaitForLock(c *check.C) {
 	c.Assert(a.Lock.Locked, check.Equals, false)
 }
 
+func (s *S) TestAppLockMiddlewareReportsQueuePosition(c *check.C) {
+	myApp := app.App{
+		Name: "my-app",
+		Lock: app.AppLock{
+			Locked:      true,
+			Reason:      "/app/my-app/deploy",
+			Owner:       "someone",
+			AcquireDate: time.Date(2048, time.November, 10, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	err := s.conn.Apps().Insert(myApp)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": myApp.Name})
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/?:app=my-app", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Accept", "application/x-json-stream")
+	called := false
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	defer wg.Wait()
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Second)
+		app.ReleaseApplicationLock(myApp.Name)
+	}()
+	m := &appLockMiddleware{}
+	m.ServeHTTP(recorder, request, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	c.Assert(called, check.Equals, true)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Matches, `\{"Message":"App my-app is locked, operation [0-9a-f]+ is waiting at position 1 of the queue.\\n"\}\n`)
+	ops, err := app.ListOperations(myApp.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(ops, check.HasLen, 0)
+}
+
+func (s *S) TestAppLockMiddlewareQueuedHandlerErrorInStream(c *check.C) {
+	myApp := app.App{
+		Name: "my-app",
+		Lock: app.AppLock{
+			Locked:      true,
+			Reason:      "/app/my-app/deploy",
+			Owner:       "someone",
+			AcquireDate: time.Date(2048, time.November, 10, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	err := s.conn.Apps().Insert(myApp)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": myApp.Name})
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/?:app=my-app", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Accept", "application/x-json-stream")
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	defer wg.Wait()
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Second)
+		app.ReleaseApplicationLock(myApp.Name)
+	}()
+	m := &appLockMiddleware{}
+	m.ServeHTTP(recorder, request, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, "invalid data", http.StatusBadRequest)
+	})
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Matches, `\{"Message":"App my-app is locked, operation [0-9a-f]+ is waiting at position 1 of the queue.\\n"\}\n\{"Error":"invalid data"\}\n`)
+}
+
+func (s *S) TestAppLockMiddlewareCancelledOperation(c *check.C) {
+	myApp := app.App{
+		Name: "my-app",
+		Lock: app.AppLock{
+			Locked:      true,
+			Reason:      "/app/my-app/deploy",
+			Owner:       "someone",
+			AcquireDate: time.Date(2048, time.November, 10, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	err := s.conn.Apps().Insert(myApp)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": myApp.Name})
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/?:app=my-app", nil)
+	c.Assert(err, check.IsNil)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	defer wg.Wait()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			ops, listErr := app.ListOperations(myApp.Name)
+			if listErr == nil && len(ops) == 1 {
+				ops[0].Cancel()
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	h, log := doHandler()
+	m := &appLockMiddleware{}
+	m.ServeHTTP(recorder, request, h)
+	c.Assert(log.called, check.Equals, false)
+	httpErr := context.GetRequestError(request).(*errors.HTTP)
+	c.Assert(httpErr.Code, check.Equals, http.StatusConflict)
+	c.Assert(httpErr.Message, check.Equals, app.ErrOperationCancelled.Error())
+}
+
 func (s *S) TestLoggerMiddleware(c *check.C) {
 	recorder := httptest.NewRecorder()
 	request, err := http.NewRequest("PUT", "/my/path", nil)
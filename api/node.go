@@ -670,3 +670,93 @@ func rebalanceNodesHandler(w http.ResponseWriter, r *http.Request, t auth.Token)
 	fmt.Fprintf(writer, "Units successfully rebalanced!\n")
 	return nil
 }
+
+// title: drain node
+// path: /node/{address}/drain
+// method: POST
+// produce: application/x-json-stream
+// responses:
+//   200: Ok
+//   401: Unauthorized
+//   404: Not found
+func drainNodeHandler(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	address := r.URL.Query().Get(":address")
+	prov, node, err := provision.FindNode(address)
+	if err != nil {
+		if err == provision.ErrNodeNotFound {
+			return &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+		}
+		return err
+	}
+	poolContext := permission.Context(permission.CtxPool, node.Pool())
+	if !permission.Check(t, permission.PermNodeUpdateDrain, poolContext) {
+		return permission.ErrUnauthorized
+	}
+	drainProv, ok := prov.(provision.NodeDrainProvisioner)
+	if !ok {
+		return provision.ProvisionerNotSupported{Prov: prov, Action: "node drain operations"}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:        event.Target{Type: event.TargetTypeNode, Value: node.Address()},
+		Kind:          permission.PermNodeUpdateDrain,
+		Owner:         t,
+		CustomData:    event.FormToCustomData(r.Form),
+		Allowed:       event.Allowed(permission.PermPoolReadEvents, poolContext),
+		AllowedCancel: event.Allowed(permission.PermNodeUpdateDrain, poolContext),
+		Cancelable:    true,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 15*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	evt.SetLogWriter(writer)
+	return drainProv.DrainNode(provision.DrainNodeOptions{
+		Address: node.Address(),
+		Writer:  evt,
+		Event:   evt,
+	})
+}
+
+// title: uncordon node
+// path: /node/{address}/uncordon
+// method: POST
+// responses:
+//   200: Ok
+//   401: Unauthorized
+//   404: Not found
+func uncordonNodeHandler(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	address := r.URL.Query().Get(":address")
+	prov, node, err := provision.FindNode(address)
+	if err != nil {
+		if err == provision.ErrNodeNotFound {
+			return &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+		}
+		return err
+	}
+	poolContext := permission.Context(permission.CtxPool, node.Pool())
+	if !permission.Check(t, permission.PermNodeUpdateUncordon, poolContext) {
+		return permission.ErrUnauthorized
+	}
+	drainProv, ok := prov.(provision.NodeDrainProvisioner)
+	if !ok {
+		return provision.ProvisionerNotSupported{Prov: prov, Action: "node uncordon operations"}
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeNode, Value: node.Address()},
+		Kind:       permission.PermNodeUpdateUncordon,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermPoolReadEvents, poolContext),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return drainProv.UncordonNode(node.Address())
+}
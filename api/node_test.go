@@ -1019,3 +1019,88 @@ func (s *S) TestNodeRebalanceFilters(c *check.C) {
 	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
 	c.Assert(recorder.Body.String(), check.Matches, `(?s).*rebalancing - dry: true, force: true.*filtering apps: \[myapp\].*filtering metadata: map\[pool:pool1\].*`)
 }
+
+func (s *S) TestDrainNodeHandler(c *check.C) {
+	err := s.provisioner.AddNode(provision.AddNodeOptions{
+		Address: "host.com:2375",
+	})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/node/host.com:2375/drain", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/x-json-stream")
+	c.Assert(recorder.Body.String(), check.Equals, `{"Message":"drain done!"}`+"\n")
+	nodes, err := s.provisioner.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(nodes[0].Status(), check.Equals, "disabled")
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeNode, Value: "host.com:2375"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "node.update.drain",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":address", "value": "host.com:2375"},
+		},
+		LogMatches: "drain done!",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestDrainNodeHandlerNotFound(c *check.C) {
+	request, err := http.NewRequest("POST", "/node/host.com:2375/drain", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestDrainNodeHandlerError(c *check.C) {
+	err := s.provisioner.AddNode(provision.AddNodeOptions{
+		Address: "host.com:2375",
+	})
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareFailure("DrainNode", provision.ErrNodeDrainCanceled)
+	request, err := http.NewRequest("POST", "/node/host.com:2375/drain", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Body.String(), check.Matches, `(?s).*node drain canceled by user action.*`)
+	c.Assert(eventtest.EventDesc{
+		Target:       event.Target{Type: event.TargetTypeNode, Value: "host.com:2375"},
+		Owner:        s.token.GetUserName(),
+		Kind:         "node.update.drain",
+		ErrorMatches: "node drain canceled by user action",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestUncordonNodeHandler(c *check.C) {
+	err := s.provisioner.AddNode(provision.AddNodeOptions{
+		Address: "host.com:2375",
+	})
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.DrainNode(provision.DrainNodeOptions{Address: "host.com:2375"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/node/host.com:2375/uncordon", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	nodes, err := s.provisioner.ListNodes(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(nodes[0].Status(), check.Equals, "enabled")
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeNode, Value: "host.com:2375"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "node.update.uncordon",
+	}, eventtest.HasEvent)
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+)
+
+type appOperations struct {
+	Lock  app.AppLock     `json:"lock"`
+	Queue []app.Operation `json:"queue"`
+}
+
+// title: app operations
+// path: /apps/{app}/operations
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   401: Unauthorized
+//   404: App not found
+func listAppOperations(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppRead,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	ops, err := app.ListOperations(a.Name)
+	if err != nil {
+		return err
+	}
+	if ops == nil {
+		ops = []app.Operation{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(appOperations{Lock: a.Lock, Queue: ops})
+}
+
+// title: cancel app operation
+// path: /apps/{app}/operations/{id}
+// method: DELETE
+// responses:
+//   200: Operation cancelled
+//   401: Unauthorized
+//   404: App or operation not found
+func cancelAppOperation(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	op, err := app.GetOperation(a.Name, r.URL.Query().Get(":id"))
+	if err == app.ErrOperationNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	owner := t.GetUserName()
+	if t.IsAppToken() {
+		owner = t.GetAppName()
+	}
+	if op.Owner != owner && !permission.Check(t, permission.PermAppAdminUnlock, contextsForApp(&a)...) {
+		return permission.ErrUnauthorized
+	}
+	err = op.Cancel()
+	if err == app.ErrOperationNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
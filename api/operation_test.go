@@ -0,0 +1,139 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) insertOperation(c *check.C, appName, owner string) app.Operation {
+	now := time.Now().UTC()
+	op := app.Operation{
+		ID:         bson.NewObjectId(),
+		App:        appName,
+		Owner:      owner,
+		Reason:     "POST /apps/" + appName + "/restart",
+		EnqueuedAt: now,
+		Heartbeat:  now,
+	}
+	err := s.conn.AppOperations().Insert(op)
+	c.Assert(err, check.IsNil)
+	return op
+}
+
+func (s *S) TestListAppOperations(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	locked, err := app.AcquireApplicationLock(a.Name, "someone", "POST /apps/myappx/deploy")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	op := s.insertOperation(c, a.Name, "other")
+	request, err := http.NewRequest("GET", "/apps/myappx/operations", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result struct {
+		Lock  map[string]interface{}
+		Queue []map[string]interface{}
+	}
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Lock["Owner"], check.Equals, "someone")
+	c.Assert(result.Lock["Reason"], check.Equals, "POST /apps/myappx/deploy")
+	c.Assert(result.Queue, check.HasLen, 1)
+	c.Assert(result.Queue[0]["id"], check.Equals, op.ID.Hex())
+	c.Assert(result.Queue[0]["owner"], check.Equals, "other")
+	c.Assert(result.Queue[0]["reason"], check.Equals, "POST /apps/myappx/restart")
+	c.Assert(result.Queue[0]["position"], check.Equals, float64(1))
+}
+
+func (s *S) TestCancelAppOperation(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	locked, err := app.AcquireApplicationLock(a.Name, "someone", "POST /apps/myappx/deploy")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	token := customUserWithPermission(c, "reader", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	op := s.insertOperation(c, a.Name, token.GetUserName())
+	request, err := http.NewRequest("DELETE", "/apps/myappx/operations/"+op.ID.Hex(), nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	ops, err := app.ListOperations(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(ops, check.HasLen, 0)
+}
+
+func (s *S) TestCancelAppOperationFromOtherUser(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	op := s.insertOperation(c, a.Name, "other")
+	token := customUserWithPermission(c, "reader", permission.Permission{
+		Scheme:  permission.PermAppRead,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	request, err := http.NewRequest("DELETE", "/apps/myappx/operations/"+op.ID.Hex(), nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	ops, err := app.ListOperations(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(ops, check.HasLen, 1)
+}
+
+func (s *S) TestCancelAppOperationAsAdmin(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	op := s.insertOperation(c, a.Name, "other")
+	request, err := http.NewRequest("DELETE", "/apps/myappx/operations/"+op.ID.Hex(), nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	ops, err := app.ListOperations(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(ops, check.HasLen, 0)
+}
+
+func (s *S) TestCancelAppOperationNotFound(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myappx/operations/"+bson.NewObjectId().Hex(), nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(recorder.Body.String(), check.Equals, app.ErrOperationNotFound.Error()+"\n")
+}
@@ -7,6 +7,9 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/ajg/form"
 	"github.com/tsuru/tsuru/auth"
@@ -226,12 +229,49 @@ func removeTeamToPoolHandler(w http.ResponseWriter, r *http.Request, t auth.Toke
 	}
 }
 
+// poolConstraintsFromForm reads the pool constraints of an update from the
+// form. Each constraint.<field> key lists the values of the constraint on the
+// field, and constraint.<field>.blacklist defines whether the values are
+// denied instead of allowed. Setting constraint.<field> with an empty value
+// removes the constraint.
+func poolConstraintsFromForm(form url.Values) map[string]*provision.PoolConstraint {
+	const prefix = "constraint."
+	constraints := make(map[string]*provision.PoolConstraint)
+	get := func(field string) *provision.PoolConstraint {
+		if constraints[field] == nil {
+			constraints[field] = &provision.PoolConstraint{}
+		}
+		return constraints[field]
+	}
+	for key, values := range form {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		field := strings.TrimPrefix(key, prefix)
+		if strings.HasSuffix(field, ".blacklist") {
+			get(strings.TrimSuffix(field, ".blacklist")).Blacklist, _ = strconv.ParseBool(form.Get(key))
+			continue
+		}
+		c := get(field)
+		for _, v := range values {
+			if v != "" {
+				c.Values = append(c.Values, v)
+			}
+		}
+	}
+	if len(constraints) == 0 {
+		return nil
+	}
+	return constraints
+}
+
 // title: pool update
 // path: /pools/{name}
 // method: PUT
 // consume: application/x-www-form-urlencoded
 // responses:
 //   200: Pool updated
+//   400: Invalid data
 //   401: Unauthorized
 //   404: Pool not found
 //   409: Default pool already defined
@@ -264,10 +304,14 @@ func poolUpdateHandler(w http.ResponseWriter, r *http.Request, t auth.Token) (er
 			Message: err.Error(),
 		}
 	}
+	updateOpts.Constraints = poolConstraintsFromForm(r.Form)
 	err = provision.PoolUpdate(poolName, updateOpts)
 	if err == provision.ErrPoolNotFound {
 		return &terrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
 	}
+	if err == provision.ErrInvalidPoolConstraintField {
+		return &terrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
 	if err == provision.ErrDefaultPoolAlreadyExists {
 		return &terrors.HTTP{
 			Code:    http.StatusConflict,
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 
 	"github.com/tsuru/tsuru/auth"
@@ -398,6 +399,60 @@ func (s *S) TestPoolUpdateToPublicHandler(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *S) TestPoolUpdateConstraintsHandler(c *check.C) {
+	opts := provision.AddPoolOptions{Name: "pool1"}
+	err := provision.AddPool(opts)
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	b := bytes.NewBufferString("constraint.plan=small&constraint.plan=medium&constraint.service=mongodb&constraint.service.blacklist=true")
+	req, err := http.NewRequest("PUT", "/pools/pool1", b)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	rec := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusOK)
+	p, err := provision.GetPoolByName("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.Constraints, check.DeepEquals, map[string]*provision.PoolConstraint{
+		provision.PoolConstraintPlan:    {Values: []string{"small", "medium"}},
+		provision.PoolConstraintService: {Values: []string{"mongodb"}, Blacklist: true},
+	})
+}
+
+func (s *S) TestPoolUpdateInvalidConstraintHandler(c *check.C) {
+	opts := provision.AddPoolOptions{Name: "pool1"}
+	err := provision.AddPool(opts)
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	b := bytes.NewBufferString("constraint.platform=python")
+	req, err := http.NewRequest("PUT", "/pools/pool1", b)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	rec := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(rec, req)
+	c.Assert(rec.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(rec.Body.String(), check.Matches, "invalid pool constraint field.*\n")
+}
+
+func (s *S) TestPoolConstraintsFromForm(c *check.C) {
+	form := url.Values{
+		"public":                       {"true"},
+		"constraint.plan":              {"small", ""},
+		"constraint.router":            {""},
+		"constraint.service.blacklist": {"true"},
+	}
+	c.Assert(poolConstraintsFromForm(form), check.DeepEquals, map[string]*provision.PoolConstraint{
+		provision.PoolConstraintPlan:    {Values: []string{"small"}},
+		provision.PoolConstraintRouter:  {},
+		provision.PoolConstraintService: {Blacklist: true},
+	})
+	c.Assert(poolConstraintsFromForm(url.Values{"public": {"true"}}), check.IsNil)
+}
+
 func (s *S) TestPoolUpdateToDefaultPoolHandler(c *check.C) {
 	provision.RemovePool("test1")
 	opts := provision.AddPoolOptions{Name: "pool1"}
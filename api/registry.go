@@ -0,0 +1,145 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/auth"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/registry"
+)
+
+func registryCredentialContext(cred *registry.Credential) permission.PermissionContext {
+	if cred.Team != "" {
+		return permission.Context(permission.CtxTeam, cred.Team)
+	}
+	return permission.Context(permission.CtxPool, cred.Pool)
+}
+
+// title: registry credential create
+// path: /registry-credentials
+// method: POST
+// consume: application/x-www-form-urlencoded
+// responses:
+//   201: Credential created
+//   400: Invalid data
+//   401: Unauthorized
+//   409: Credential already exists
+func registryCredentialCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	cred := registry.Credential{
+		Name:     r.FormValue("name"),
+		Registry: r.FormValue("registry"),
+		Username: r.FormValue("username"),
+		Password: r.FormValue("password"),
+		Team:     r.FormValue("team"),
+		Pool:     r.FormValue("pool"),
+	}
+	ctx := registryCredentialContext(&cred)
+	if !permission.Check(t, permission.PermRegistryCredentialCreate, ctx) {
+		return permission.ErrUnauthorized
+	}
+	delete(r.Form, "password")
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeRegistryCredential, Value: cred.Name},
+		Kind:       permission.PermRegistryCredentialCreate,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermRegistryCredentialRead, ctx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = registry.Create(cred)
+	if err != nil {
+		if _, ok := err.(*tsuruErrors.ValidationError); ok {
+			return &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		if err == registry.ErrCredentialAlreadyExists {
+			return &tsuruErrors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+		}
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// title: registry credential list
+// path: /registry-credentials
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   401: Unauthorized
+func registryCredentialList(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	contexts := permission.ContextsForPermission(t, permission.PermRegistryCredentialRead)
+	if len(contexts) == 0 {
+		return permission.ErrUnauthorized
+	}
+	filter := &registry.Filter{}
+	for _, ctx := range contexts {
+		if ctx.CtxType == permission.CtxGlobal {
+			filter = nil
+			break
+		}
+		if ctx.CtxType == permission.CtxTeam {
+			filter.Teams = append(filter.Teams, ctx.Value)
+		} else if ctx.CtxType == permission.CtxPool {
+			filter.Pools = append(filter.Pools, ctx.Value)
+		}
+	}
+	creds, err := registry.List(filter)
+	if err != nil {
+		return err
+	}
+	if len(creds) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(creds)
+}
+
+// title: registry credential remove
+// path: /registry-credentials/{name}
+// method: DELETE
+// responses:
+//   200: Credential removed
+//   401: Unauthorized
+//   404: Not found
+func registryCredentialRemove(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	name := r.URL.Query().Get(":name")
+	cred, err := registry.Get(name)
+	if err == registry.ErrCredentialNotFound {
+		return &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	ctx := registryCredentialContext(cred)
+	if !permission.Check(t, permission.PermRegistryCredentialDelete, ctx) {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeRegistryCredential, Value: name},
+		Kind:    permission.PermRegistryCredentialDelete,
+		Owner:   t,
+		Allowed: event.Allowed(permission.PermRegistryCredentialRead, ctx),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = registry.Remove(name)
+	if err == registry.ErrCredentialNotFound {
+		return &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/registry"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) startFakeRegistry(c *check.C) (*httptest.Server, string) {
+	config.Set("encryption:key", "my-secret-key")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	u, err := url.Parse(server.URL)
+	c.Assert(err, check.IsNil)
+	return server, u.Host
+}
+
+func (s *S) TestRegistryCredentialCreate(c *check.C) {
+	server, host := s.startFakeRegistry(c)
+	defer server.Close()
+	defer config.Unset("encryption:key")
+	body := strings.NewReader(fmt.Sprintf("name=cred1&registry=%s&username=user&password=secret&team=tsuruteam", host))
+	request, err := http.NewRequest("POST", "/registry-credentials", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	cred, err := registry.Get("cred1")
+	c.Assert(err, check.IsNil)
+	c.Assert(cred.Registry, check.Equals, host)
+	c.Assert(cred.Team, check.Equals, "tsuruteam")
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRegistryCredential, Value: "cred1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "registry-credential.create",
+		StartCustomData: []map[string]interface{}{
+			{"name": "name", "value": "cred1"},
+			{"name": "username", "value": "user"},
+			{"name": "team", "value": "tsuruteam"},
+		},
+	}, eventtest.HasEvent)
+	n, err := s.conn.Events().Find(bson.M{"startcustomdata.name": "password"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestRegistryCredentialCreateInvalidLogin(c *check.C) {
+	server, host := s.startFakeRegistry(c)
+	defer server.Close()
+	defer config.Unset("encryption:key")
+	body := strings.NewReader(fmt.Sprintf("name=cred1&registry=%s&username=user&password=wrong&team=tsuruteam", host))
+	request, err := http.NewRequest("POST", "/registry-credentials", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid registry credentials: login failed\n")
+	_, err = registry.Get("cred1")
+	c.Assert(err, check.Equals, registry.ErrCredentialNotFound)
+}
+
+func (s *S) TestRegistryCredentialCreateForbidden(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermRegistryCredentialCreate,
+		Context: permission.Context(permission.CtxTeam, "otherteam"),
+	})
+	body := strings.NewReader("name=cred1&registry=r.com&username=user&password=secret&team=tsuruteam")
+	request, err := http.NewRequest("POST", "/registry-credentials", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestRegistryCredentialListAndRemove(c *check.C) {
+	server, host := s.startFakeRegistry(c)
+	defer server.Close()
+	defer config.Unset("encryption:key")
+	err := registry.Create(registry.Credential{Name: "cred1", Registry: host, Username: "user", Password: "secret", Team: "tsuruteam"})
+	c.Assert(err, check.IsNil)
+	err = registry.Create(registry.Credential{Name: "cred2", Registry: host, Username: "user", Password: "secret", Pool: "test1"})
+	c.Assert(err, check.IsNil)
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermRegistryCredential,
+		Context: permission.Context(permission.CtxPool, "test1"),
+	})
+	request, err := http.NewRequest("GET", "/registry-credentials", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Not(check.Matches), "(?s).*(secret|assword).*")
+	var creds []registry.Credential
+	err = json.Unmarshal(recorder.Body.Bytes(), &creds)
+	c.Assert(err, check.IsNil)
+	c.Assert(creds, check.DeepEquals, []registry.Credential{{Name: "cred2", Registry: host, Username: "user", Pool: "test1"}})
+	request, err = http.NewRequest("DELETE", "/registry-credentials/cred1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+	request, err = http.NewRequest("DELETE", "/registry-credentials/cred2", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder = httptest.NewRecorder()
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	_, err = registry.Get("cred2")
+	c.Assert(err, check.Equals, registry.ErrCredentialNotFound)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeRegistryCredential, Value: "cred2"},
+		Owner:  token.GetUserName(),
+		Kind:   "registry-credential.delete",
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestRegistryCredentialRemoveNotFound(c *check.C) {
+	request, err := http.NewRequest("DELETE", "/registry-credentials/cred1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
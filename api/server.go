@@ -31,6 +31,7 @@ import (
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/service"
 	"golang.org/x/net/websocket"
 	"gopkg.in/tylerb/graceful.v1"
 )
@@ -103,6 +104,11 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Put", "/services/{service}/instances/{instance}/{app}", AuthorizationRequiredHandler(bindServiceInstance))
 	m.Add("1.0", "Delete", "/services/{service}/instances/{instance}/{app}", AuthorizationRequiredHandler(unbindServiceInstance))
 	m.Add("1.0", "Get", "/services/{service}/instances/{instance}/status", AuthorizationRequiredHandler(serviceInstanceStatus))
+	m.Add("1.0", "Get", "/services/{service}/instances/{instance}/backups", AuthorizationRequiredHandler(serviceInstanceBackups))
+	m.Add("1.0", "Post", "/services/{service}/instances/{instance}/backups", AuthorizationRequiredHandler(createServiceInstanceBackup))
+	m.Add("1.0", "Put", "/services/{service}/instances/{instance}/backups/policy", AuthorizationRequiredHandler(setServiceInstanceBackupPolicy))
+	m.Add("1.0", "Post", "/services/{service}/instances/{instance}/backups/{backup}/restore", AuthorizationRequiredHandler(restoreServiceInstanceBackup))
+	m.Add("1.0", "Delete", "/services/{service}/instances/{instance}/backups/{backup}", AuthorizationRequiredHandler(deleteServiceInstanceBackup))
 	m.Add("1.0", "Put", "/services/{service}/instances/permission/{instance}/{team}", AuthorizationRequiredHandler(serviceInstanceGrantTeam))
 	m.Add("1.0", "Delete", "/services/{service}/instances/permission/{instance}/{team}", AuthorizationRequiredHandler(serviceInstanceRevokeTeam))
 
@@ -130,16 +136,31 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Post", "/apps/{app}/start", AuthorizationRequiredHandler(start))
 	m.Add("1.0", "Post", "/apps/{app}/stop", AuthorizationRequiredHandler(stop))
 	m.Add("1.0", "Post", "/apps/{app}/sleep", AuthorizationRequiredHandler(sleep))
+	m.Add("1.0", "Post", "/apps/{app}/migrate", AuthorizationRequiredHandler(migrateApp))
+	m.Add("1.0", "Post", "/apps/{app}/clone", AuthorizationRequiredHandler(cloneApp))
+	m.Add("1.0", "Put", "/apps/{app}/sleep/policy", AuthorizationRequiredHandler(setSleepPolicy))
+	m.Add("1.0", "Put", "/apps/{app}/units/schedule", AuthorizationRequiredHandler(setUnitsSchedule))
+	m.Add("1.0", "Put", "/apps/{app}/deletion-protection", AuthorizationRequiredHandler(setDeletionProtection))
+	m.Add("1.0", "Post", "/apps/{app}/restore", AuthorizationRequiredHandler(restoreApp))
+	m.Add("1.0", "Post", "/apps/{app}/maintenance", AuthorizationRequiredHandler(enableMaintenance))
+	m.Add("1.0", "Delete", "/apps/{app}/maintenance", AuthorizationRequiredHandler(disableMaintenance))
+	m.Add("1.0", "Put", "/apps/{app}/maintenance/page", AuthorizationRequiredHandler(setAppMaintenancePage))
 	m.Add("1.0", "Get", "/apps/{appname}/quota", AuthorizationRequiredHandler(getAppQuota))
 	m.Add("1.0", "Put", "/apps/{appname}/quota", AuthorizationRequiredHandler(changeAppQuota))
 	m.Add("1.0", "Put", "/apps/{appname}", AuthorizationRequiredHandler(updateApp))
 	m.Add("1.0", "Get", "/apps/{app}/env", AuthorizationRequiredHandler(getEnv))
 	m.Add("1.0", "Post", "/apps/{app}/env", AuthorizationRequiredHandler(setEnv))
 	m.Add("1.0", "Delete", "/apps/{app}/env", AuthorizationRequiredHandler(unsetEnv))
+	m.Add("1.0", "Get", "/apps/{app}/env/revisions", AuthorizationRequiredHandler(envRevisionList))
+	m.Add("1.0", "Get", "/apps/{app}/env/revisions/diff", AuthorizationRequiredHandler(envRevisionDiff))
+	m.Add("1.0", "Post", "/apps/{app}/env/revisions/{version}/rollback", AuthorizationRequiredHandler(envRevisionRollback))
 	m.Add("1.0", "Get", "/apps", AuthorizationRequiredHandler(appList))
 	m.Add("1.0", "Post", "/apps", AuthorizationRequiredHandler(createApp))
 	forceDeleteLockHandler := AuthorizationRequiredHandler(forceDeleteLock)
 	m.Add("1.0", "Delete", "/apps/{app}/lock", forceDeleteLockHandler)
+	m.Add("1.0", "Get", "/apps/{app}/operations", AuthorizationRequiredHandler(listAppOperations))
+	cancelOperationHandler := AuthorizationRequiredHandler(cancelAppOperation)
+	m.Add("1.0", "Delete", "/apps/{app}/operations/{id}", cancelOperationHandler)
 	m.Add("1.0", "Put", "/apps/{app}/units", AuthorizationRequiredHandler(addUnits))
 	m.Add("1.0", "Delete", "/apps/{app}/units", AuthorizationRequiredHandler(removeUnits))
 	registerUnitHandler := AuthorizationRequiredHandler(registerUnit)
@@ -157,6 +178,11 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.2", "Get", "/apps/{app}/certificate", AuthorizationRequiredHandler(listCertificates))
 	m.Add("1.2", "Put", "/apps/{app}/certificate", AuthorizationRequiredHandler(setCertificate))
 	m.Add("1.2", "Delete", "/apps/{app}/certificate", AuthorizationRequiredHandler(unsetCertificate))
+	m.Add("1.0", "Get", "/apps/{app}/files", AuthorizationRequiredHandler(configFileList))
+	m.Add("1.0", "Get", "/apps/{app}/files/{name}/versions", AuthorizationRequiredHandler(configFileVersions))
+	m.Add("1.0", "Get", "/apps/{app}/files/{name}", AuthorizationRequiredHandler(configFileContent))
+	m.Add("1.0", "Put", "/apps/{app}/files/{name}", AuthorizationRequiredHandler(configFileSet))
+	m.Add("1.0", "Delete", "/apps/{app}/files/{name}", AuthorizationRequiredHandler(configFileUnset))
 
 	m.Add("1.0", "Post", "/node/status", AuthorizationRequiredHandler(setNodeStatus))
 
@@ -212,6 +238,10 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Get", "/teams", AuthorizationRequiredHandler(teamList))
 	m.Add("1.0", "Post", "/teams", AuthorizationRequiredHandler(createTeam))
 	m.Add("1.0", "Delete", "/teams/{name}", AuthorizationRequiredHandler(removeTeam))
+	m.Add("1.0", "Get", "/teams/{name}/usage", AuthorizationRequiredHandler(teamUsage))
+	m.Add("1.0", "Get", "/teams/{name}/quota", AuthorizationRequiredHandler(getTeamQuota))
+	m.Add("1.0", "Put", "/teams/{name}/quota", AuthorizationRequiredHandler(changeTeamQuota))
+	m.Add("1.0", "Put", "/teams/{name}/maintenance/page", AuthorizationRequiredHandler(setTeamMaintenancePage))
 
 	m.Add("1.0", "Post", "/swap", AuthorizationRequiredHandler(swap))
 
@@ -230,6 +260,13 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.0", "Delete", "/plans/{planname}", AuthorizationRequiredHandler(removePlan))
 	m.Add("1.0", "Get", "/plans/routers", AuthorizationRequiredHandler(listRouters))
 
+	m.Add("1.0", "Get", "/app-templates", AuthorizationRequiredHandler(listAppTemplates))
+	m.Add("1.0", "Post", "/app-templates", AuthorizationRequiredHandler(addAppTemplate))
+	m.Add("1.0", "Delete", "/app-templates/{name}", AuthorizationRequiredHandler(removeAppTemplate))
+
+	m.Add("1.0", "Post", "/manifests/plan", AuthorizationRequiredHandler(manifestPlan))
+	m.Add("1.0", "Post", "/manifests/apply", AuthorizationRequiredHandler(manifestApply))
+
 	m.Add("1.0", "Get", "/pools", AuthorizationRequiredHandler(poolList))
 	m.Add("1.0", "Post", "/pools", AuthorizationRequiredHandler(addPoolHandler))
 	m.Add("1.0", "Delete", "/pools/{name}", AuthorizationRequiredHandler(removePoolHandler))
@@ -268,6 +305,8 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.2", "PUT", "/node", AuthorizationRequiredHandler(updateNodeHandler))
 	m.Add("1.2", "DELETE", "/node/{address:.*}", AuthorizationRequiredHandler(removeNodeHandler))
 	m.Add("1.3", "POST", "/node/rebalance", AuthorizationRequiredHandler(rebalanceNodesHandler))
+	m.Add("1.3", "POST", "/node/{address:.*}/drain", AuthorizationRequiredHandler(drainNodeHandler))
+	m.Add("1.3", "POST", "/node/{address:.*}/uncordon", AuthorizationRequiredHandler(uncordonNodeHandler))
 
 	m.Add("1.2", "GET", "/nodecontainers", AuthorizationRequiredHandler(nodeContainerList))
 	m.Add("1.2", "POST", "/nodecontainers", AuthorizationRequiredHandler(nodeContainerCreate))
@@ -280,6 +319,10 @@ func RunServer(dry bool) http.Handler {
 	m.Add("1.2", "GET", "/install/hosts", AuthorizationRequiredHandler(installHostList))
 	m.Add("1.2", "GET", "/install/hosts/{name}", AuthorizationRequiredHandler(installHostInfo))
 
+	m.Add("1.3", "POST", "/registry-credentials", AuthorizationRequiredHandler(registryCredentialCreate))
+	m.Add("1.3", "GET", "/registry-credentials", AuthorizationRequiredHandler(registryCredentialList))
+	m.Add("1.3", "DELETE", "/registry-credentials/{name}", AuthorizationRequiredHandler(registryCredentialRemove))
+
 	m.Add("1.2", "GET", "/healing/node", AuthorizationRequiredHandler(nodeHealingRead))
 	m.Add("1.2", "POST", "/healing/node", AuthorizationRequiredHandler(nodeHealingUpdate))
 	m.Add("1.2", "DELETE", "/healing/node", AuthorizationRequiredHandler(nodeHealingDelete))
@@ -322,6 +365,7 @@ func RunServer(dry bool) http.Handler {
 		logPostHandler,
 		runHandler,
 		forceDeleteLockHandler,
+		cancelOperationHandler,
 		registerUnitHandler,
 		setUnitStatusHandler,
 		diffDeployHandler,
@@ -428,6 +472,20 @@ func startServer(handler http.Handler) {
 	if err != nil {
 		fatal(err)
 	}
+	err = service.RegisterTasks()
+	if err != nil {
+		fatal(err)
+	}
+	shutdown.Register(service.StartBackupScheduler())
+	shutdown.Register(app.StartIdleSleeper())
+	shutdown.Register(app.StartUnitsScheduler())
+	shutdown.Register(app.StartDeletedAppsPurger())
+	if wakeupListen, _ := config.GetString("sleep:wakeup:listen"); wakeupListen != "" {
+		go startWakeupServer(wakeupListen)
+	}
+	if maintenanceListen, _ := config.GetString("maintenance:listen"); maintenanceListen != "" {
+		go startMaintenanceServer(maintenanceListen)
+	}
 	scheme, err := getAuthScheme()
 	if err != nil {
 		fmt.Printf("Warning: configuration didn't declare auth:scheme, using default scheme.\n")
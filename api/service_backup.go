@@ -0,0 +1,211 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/api/context"
+	"github.com/tsuru/tsuru/auth"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/service"
+)
+
+func serviceBackupError(err error) error {
+	switch err {
+	case service.ErrBackupNotSupported, service.ErrInvalidBackupInterval, service.ErrInvalidBackupKeep:
+		return &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case service.ErrBackupNotFound, service.ErrInstanceNotFoundInAPI:
+		return &tsuruErrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	case service.ErrServiceInstanceNotReady:
+		return &tsuruErrors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
+func serviceInstanceBackupEvent(r *http.Request, t auth.Token, si *service.ServiceInstance, serviceName string, kind *permission.PermissionScheme) (*event.Event, error) {
+	allowed := permission.Check(t, kind, contextsForServiceInstance(si, serviceName)...)
+	if !allowed {
+		return nil, permission.ErrUnauthorized
+	}
+	return event.New(&event.Opts{
+		Target:     serviceInstanceTarget(serviceName, si.Name),
+		Kind:       kind,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed: event.Allowed(permission.PermServiceInstanceReadEvents,
+			contextsForServiceInstance(si, serviceName)...),
+	})
+}
+
+// title: service instance backup list
+// path: /services/{service}/instances/{instance}/backups
+// method: GET
+// produce: application/json
+// responses:
+//   200: OK
+//   204: No content
+//   400: Service does not support backups
+//   401: Unauthorized
+//   404: Service instance not found
+func serviceInstanceBackups(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	serviceName := r.URL.Query().Get(":service")
+	instanceName := r.URL.Query().Get(":instance")
+	si, err := getServiceInstanceOrError(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermServiceInstanceReadBackups,
+		contextsForServiceInstance(si, serviceName)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	requestIDHeader, _ := config.GetString("request-id-header")
+	requestID := context.GetRequestID(r, requestIDHeader)
+	backups, err := si.ListBackups(requestID)
+	if err != nil {
+		return serviceBackupError(err)
+	}
+	if len(backups) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(backups)
+}
+
+// title: service instance backup create
+// path: /services/{service}/instances/{instance}/backups
+// method: POST
+// produce: application/json
+// responses:
+//   201: Backup created
+//   400: Service does not support backups
+//   401: Unauthorized
+//   404: Service instance not found
+//   409: Service instance not ready
+func createServiceInstanceBackup(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	serviceName := r.URL.Query().Get(":service")
+	instanceName := r.URL.Query().Get(":instance")
+	si, err := getServiceInstanceOrError(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	evt, err := serviceInstanceBackupEvent(r, t, si, serviceName, permission.PermServiceInstanceUpdateBackupCreate)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	requestIDHeader, _ := config.GetString("request-id-header")
+	requestID := context.GetRequestID(r, requestIDHeader)
+	backup, err := si.CreateBackup(requestID)
+	if err != nil {
+		return serviceBackupError(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(backup)
+}
+
+// title: service instance backup restore
+// path: /services/{service}/instances/{instance}/backups/{backup}/restore
+// method: POST
+// responses:
+//   200: Backup restored
+//   400: Service does not support backups
+//   401: Unauthorized
+//   404: Service instance or backup not found
+//   409: Service instance not ready
+func restoreServiceInstanceBackup(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	serviceName := r.URL.Query().Get(":service")
+	instanceName := r.URL.Query().Get(":instance")
+	backupID := r.URL.Query().Get(":backup")
+	si, err := getServiceInstanceOrError(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	evt, err := serviceInstanceBackupEvent(r, t, si, serviceName, permission.PermServiceInstanceUpdateBackupRestore)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	requestIDHeader, _ := config.GetString("request-id-header")
+	requestID := context.GetRequestID(r, requestIDHeader)
+	return serviceBackupError(si.RestoreBackup(backupID, requestID))
+}
+
+// title: service instance backup delete
+// path: /services/{service}/instances/{instance}/backups/{backup}
+// method: DELETE
+// responses:
+//   200: Backup removed
+//   400: Service does not support backups
+//   401: Unauthorized
+//   404: Service instance or backup not found
+func deleteServiceInstanceBackup(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	r.ParseForm()
+	serviceName := r.URL.Query().Get(":service")
+	instanceName := r.URL.Query().Get(":instance")
+	backupID := r.URL.Query().Get(":backup")
+	si, err := getServiceInstanceOrError(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	evt, err := serviceInstanceBackupEvent(r, t, si, serviceName, permission.PermServiceInstanceUpdateBackupDelete)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	requestIDHeader, _ := config.GetString("request-id-header")
+	requestID := context.GetRequestID(r, requestIDHeader)
+	return serviceBackupError(si.DeleteBackup(backupID, requestID))
+}
+
+// title: service instance backup policy
+// path: /services/{service}/instances/{instance}/backups/policy
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Policy updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: Service instance not found
+func setServiceInstanceBackupPolicy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	serviceName := r.URL.Query().Get(":service")
+	instanceName := r.URL.Query().Get(":instance")
+	var policy *service.BackupPolicy
+	if interval := r.FormValue("interval"); interval != "" && interval != "0" {
+		policy = &service.BackupPolicy{}
+		policy.Interval, err = time.ParseDuration(interval)
+		if err != nil {
+			return &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: "invalid interval: " + err.Error()}
+		}
+		if keep := r.FormValue("keep"); keep != "" {
+			policy.Keep, err = strconv.Atoi(keep)
+			if err != nil {
+				return &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: "invalid number of backups to keep: " + err.Error()}
+			}
+		}
+	}
+	si, err := getServiceInstanceOrError(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	evt, err := serviceInstanceBackupEvent(r, t, si, serviceName, permission.PermServiceInstanceUpdateBackupPolicy)
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return serviceBackupError(si.SetBackupPolicy(policy))
+}
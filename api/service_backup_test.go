@@ -0,0 +1,219 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/service"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *ConsumptionSuite) createBackupInstance(c *check.C, handler http.HandlerFunc) *httptest.Server {
+	ts := httptest.NewServer(handler)
+	err := s.conn.Services().Update(bson.M{"_id": s.service.Name}, bson.M{"$set": bson.M{
+		"endpoint": map[string]string{"production": ts.URL},
+		"backup":   true,
+	}})
+	c.Assert(err, check.IsNil)
+	si := service.ServiceInstance{Name: "my-mysql", ServiceName: s.service.Name, Teams: []string{s.team.Name}}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	return ts
+}
+
+func (s *ConsumptionSuite) TestServiceInstanceBackups(c *check.C) {
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "GET")
+		c.Assert(r.URL.Path, check.Equals, "/resources/my-mysql/backups")
+		w.Write([]byte(`[{"id": "b1", "status": "done", "created_at": "2017-01-01T10:00:00Z"}, {"id": "b2", "status": "done", "created_at": "2017-01-02T10:00:00Z"}]`))
+	})
+	defer ts.Close()
+	request, err := http.NewRequest("GET", "/services/mysql/instances/my-mysql/backups", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var backups []service.Backup
+	err = json.NewDecoder(recorder.Body).Decode(&backups)
+	c.Assert(err, check.IsNil)
+	c.Assert(backups, check.HasLen, 2)
+	c.Assert(backups[0].ID, check.Equals, "b2")
+	c.Assert(backups[1].ID, check.Equals, "b1")
+}
+
+func (s *ConsumptionSuite) TestCreateServiceInstanceBackup(c *check.C) {
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "POST")
+		c.Assert(r.URL.Path, check.Equals, "/resources/my-mysql/backups")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "b1", "status": "running"}`))
+	})
+	defer ts.Close()
+	request, err := http.NewRequest("POST", "/services/mysql/instances/my-mysql/backups", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var backup service.Backup
+	err = json.NewDecoder(recorder.Body).Decode(&backup)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.ID, check.Equals, "b1")
+	c.Assert(backup.Status, check.Equals, "running")
+	c.Assert(eventtest.EventDesc{
+		Target: serviceInstanceTarget("mysql", "my-mysql"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "service-instance.update.backup.create",
+	}, eventtest.HasEvent)
+}
+
+func (s *ConsumptionSuite) TestCreateServiceInstanceBackupNotSupported(c *check.C) {
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request to %s", r.URL.Path)
+	})
+	defer ts.Close()
+	err := s.conn.Services().Update(bson.M{"_id": s.service.Name}, bson.M{"$set": bson.M{"backup": false}})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/services/mysql/instances/my-mysql/backups", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, service.ErrBackupNotSupported.Error()+"\n")
+}
+
+func (s *ConsumptionSuite) TestCreateServiceInstanceBackupWithoutPermission(c *check.C) {
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Fatalf("unexpected request to %s", r.URL.Path)
+	})
+	defer ts.Close()
+	token := customUserWithPermission(c, "backup-reader", permission.Permission{
+		Scheme:  permission.PermServiceInstanceReadBackups,
+		Context: permission.Context(permission.CtxTeam, s.team.Name),
+	})
+	request, err := http.NewRequest("POST", "/services/mysql/instances/my-mysql/backups", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *ConsumptionSuite) TestRestoreServiceInstanceBackup(c *check.C) {
+	var restored []string
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "POST")
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/resources/my-mysql/backups/"), "/restore")
+		if id != "b1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		restored = append(restored, id)
+	})
+	defer ts.Close()
+	request, err := http.NewRequest("POST", "/services/mysql/instances/my-mysql/backups/b1/restore", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(restored, check.DeepEquals, []string{"b1"})
+	c.Assert(eventtest.EventDesc{
+		Target: serviceInstanceTarget("mysql", "my-mysql"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "service-instance.update.backup.restore",
+	}, eventtest.HasEvent)
+	request, err = http.NewRequest("POST", "/services/mysql/instances/my-mysql/backups/b2/restore", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *ConsumptionSuite) TestDeleteServiceInstanceBackup(c *check.C) {
+	var deleted []string
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, check.Equals, "DELETE")
+		deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/resources/my-mysql/backups/"))
+	})
+	defer ts.Close()
+	request, err := http.NewRequest("DELETE", "/services/mysql/instances/my-mysql/backups/b1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(deleted, check.DeepEquals, []string{"b1"})
+	c.Assert(eventtest.EventDesc{
+		Target: serviceInstanceTarget("mysql", "my-mysql"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "service-instance.update.backup.delete",
+	}, eventtest.HasEvent)
+}
+
+func (s *ConsumptionSuite) TestSetServiceInstanceBackupPolicy(c *check.C) {
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {})
+	defer ts.Close()
+	body := strings.NewReader("interval=24h&keep=7")
+	request, err := http.NewRequest("PUT", "/services/mysql/instances/my-mysql/backups/policy", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	si, err := service.GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.BackupPolicy, check.NotNil)
+	c.Assert(si.BackupPolicy.Interval, check.Equals, 24*time.Hour)
+	c.Assert(si.BackupPolicy.Keep, check.Equals, 7)
+	c.Assert(eventtest.EventDesc{
+		Target: serviceInstanceTarget("mysql", "my-mysql"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "service-instance.update.backup.policy",
+		StartCustomData: []map[string]interface{}{
+			{"name": "interval", "value": "24h"},
+			{"name": "keep", "value": "7"},
+		},
+	}, eventtest.HasEvent)
+	body = strings.NewReader("interval=0")
+	request, err = http.NewRequest("PUT", "/services/mysql/instances/my-mysql/backups/policy", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder = httptest.NewRecorder()
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	si, err = service.GetServiceInstance("mysql", "my-mysql")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.BackupPolicy, check.IsNil)
+}
+
+func (s *ConsumptionSuite) TestSetServiceInstanceBackupPolicyInvalidInterval(c *check.C) {
+	ts := s.createBackupInstance(c, func(w http.ResponseWriter, r *http.Request) {})
+	defer ts.Close()
+	for _, interval := range []string{"invalid", "10m"} {
+		body := strings.NewReader("interval=" + interval)
+		request, err := http.NewRequest("PUT", "/services/mysql/instances/my-mysql/backups/policy", body)
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		s.m.ServeHTTP(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	}
+}
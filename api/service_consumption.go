@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -22,6 +23,8 @@ import (
 	"github.com/tsuru/tsuru/event"
 	tsuruIo "github.com/tsuru/tsuru/io"
 	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/service"
 )
 
@@ -62,6 +65,9 @@ func createServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 		PlanName:    r.FormValue("plan"),
 		TeamOwner:   r.FormValue("owner"),
 		Description: r.FormValue("description"),
+		Tags:        r.Form["tag"],
+		Pools:       r.Form["pool"],
+		Parameters:  parametersFromForm(r.Form),
 	}
 	var teamOwner string
 	if instance.TeamOwner == "" {
@@ -100,6 +106,12 @@ func createServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 	requestIDHeader, _ := config.GetString("request-id-header")
 	requestID := context.GetRequestID(r, requestIDHeader)
 	err = service.CreateServiceInstance(instance, &srv, user, requestID)
+	if err == provision.ErrPoolNotFound {
+		return &tsuruErrors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: err.Error(),
+		}
+	}
 	if err == service.ErrInstanceNameAlreadyExists {
 		return &tsuruErrors.HTTP{
 			Code:    http.StatusConflict,
@@ -112,12 +124,38 @@ func createServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 			Message: err.Error(),
 		}
 	}
+	if _, ok := err.(*quota.QuotaExceededError); ok {
+		return &tsuruErrors.HTTP{
+			Code:    http.StatusForbidden,
+			Message: err.Error(),
+		}
+	}
 	if err == nil {
 		w.WriteHeader(http.StatusCreated)
 	}
 	return err
 }
 
+// parametersFromForm returns the service instance parameters sent as
+// parameters.<name> form values, or nil when no parameter was sent.
+func parametersFromForm(form url.Values) map[string]interface{} {
+	var params map[string]interface{}
+	for k, v := range form {
+		if !strings.HasPrefix(k, "parameters.") || len(v) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(k, "parameters.")
+		if name == "" {
+			continue
+		}
+		if params == nil {
+			params = map[string]interface{}{}
+		}
+		params[name] = service.ParseParameterValue(v[0])
+	}
+	return params
+}
+
 // title: service instance update
 // path: /services/{service}/instances/{instance}
 // method: PUT
@@ -127,11 +165,39 @@ func createServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 //   400: Invalid data
 //   401: Unauthorized
 //   404: Service instance not found
+//   409: Service instance not ready
 func updateServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
 	serviceName := r.URL.Query().Get(":service")
 	instanceName := r.URL.Query().Get(":instance")
-	description := r.FormValue("description")
-	if description == "" {
+	r.ParseForm()
+	updateData := service.ServiceInstance{
+		Description: r.FormValue("description"),
+		PlanName:    r.FormValue("plan"),
+		Parameters:  parametersFromForm(r.Form),
+	}
+	if _, ok := r.Form["tag"]; ok {
+		updateData.Tags = r.Form["tag"]
+	}
+	if _, ok := r.Form["pool"]; ok {
+		updateData.Pools = r.Form["pool"]
+	}
+	var perms []*permission.PermissionScheme
+	if updateData.PlanName != "" {
+		perms = append(perms, permission.PermServiceInstanceUpdatePlan)
+	}
+	if updateData.Parameters != nil {
+		perms = append(perms, permission.PermServiceInstanceUpdateParameters)
+	}
+	if updateData.Tags != nil {
+		perms = append(perms, permission.PermServiceInstanceUpdateTags)
+	}
+	if updateData.Pools != nil {
+		perms = append(perms, permission.PermServiceInstanceUpdatePools)
+	}
+	if updateData.Description != "" {
+		perms = append(perms, permission.PermServiceInstanceUpdateDescription)
+	}
+	if len(perms) == 0 {
 		return &tsuruErrors.HTTP{
 			Code:    http.StatusBadRequest,
 			Message: "Invalid value for description",
@@ -141,15 +207,15 @@ func updateServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 	if err != nil {
 		return err
 	}
-	allowed := permission.Check(t, permission.PermServiceInstanceUpdateDescription,
-		contextsForServiceInstance(si, serviceName)...,
-	)
-	if !allowed {
-		return permission.ErrUnauthorized
+	for _, perm := range perms {
+		allowed := permission.Check(t, perm, contextsForServiceInstance(si, serviceName)...)
+		if !allowed {
+			return permission.ErrUnauthorized
+		}
 	}
 	evt, err := event.New(&event.Opts{
 		Target:     serviceInstanceTarget(serviceName, instanceName),
-		Kind:       permission.PermServiceInstanceUpdateDescription,
+		Kind:       perms[0],
 		Owner:      t,
 		CustomData: event.FormToCustomData(r.Form),
 		Allowed: event.Allowed(permission.PermServiceInstanceReadEvents,
@@ -159,8 +225,16 @@ func updateServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 		return err
 	}
 	defer func() { evt.Done(err) }()
-	si.Description = description
-	return service.UpdateService(si)
+	requestIDHeader, _ := config.GetString("request-id-header")
+	requestID := context.GetRequestID(r, requestIDHeader)
+	err = si.Update(updateData, requestID)
+	switch err {
+	case service.ErrInvalidPlan, service.ErrPoolNotAllowed, provision.ErrPoolNotFound:
+		return &tsuruErrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case service.ErrServiceInstanceNotReady, service.ErrInstanceNotReady:
+		return &tsuruErrors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
 }
 
 // title: remove service instance
@@ -236,7 +310,7 @@ func removeServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 	return nil
 }
 
-func readableInstances(t auth.Token, contexts []permission.PermissionContext, appName, serviceName string) ([]service.ServiceInstance, error) {
+func readableInstances(t auth.Token, contexts []permission.PermissionContext, appName, serviceName string, tags []string) ([]service.ServiceInstance, error) {
 	teams := []string{}
 	instanceNames := []string{}
 	for _, c := range contexts {
@@ -255,7 +329,7 @@ func readableInstances(t auth.Token, contexts []permission.PermissionContext, ap
 			teams = append(teams, c.Value)
 		}
 	}
-	return service.GetServicesInstancesByTeamsAndNames(teams, instanceNames, appName, serviceName)
+	return service.GetServicesInstancesByTeamsAndNames(teams, instanceNames, appName, serviceName, tags)
 }
 
 func filtersForServiceList(t auth.Token, contexts []permission.PermissionContext) ([]string, []string) {
@@ -292,8 +366,9 @@ func readableServices(t auth.Token, contexts []permission.PermissionContext) ([]
 //   401: Unauthorized
 func serviceInstances(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	appName := r.URL.Query().Get("app")
+	tags := r.URL.Query()["tag"]
 	contexts := permission.ContextsForPermission(t, permission.PermServiceInstanceRead)
-	instances, err := readableInstances(t, contexts, appName, "")
+	instances, err := readableInstances(t, contexts, appName, "", tags)
 	if err != nil {
 		return err
 	}
@@ -369,6 +444,13 @@ type serviceInstanceInfo struct {
 	PlanName        string
 	PlanDescription string
 	CustomInfo      map[string]string
+	Tags            []string
+	Parameters      map[string]interface{}
+	Pools           []string
+	AppProcesses    map[string][]string
+	BackupPolicy    *service.BackupPolicy
+	State           string
+	StateMessage    string
 }
 
 // title: service instance info
@@ -410,6 +492,13 @@ func serviceInstance(w http.ResponseWriter, r *http.Request, t auth.Token) error
 		PlanName:        plan.Name,
 		PlanDescription: plan.Description,
 		CustomInfo:      info,
+		Tags:            serviceInstance.Tags,
+		Parameters:      serviceInstance.Parameters,
+		Pools:           serviceInstance.Pools,
+		AppProcesses:    serviceInstance.AppProcesses,
+		BackupPolicy:    serviceInstance.BackupPolicy,
+		State:           serviceInstance.State,
+		StateMessage:    serviceInstance.StateMessage,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(sInfo)
@@ -428,7 +517,7 @@ func serviceInfo(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		return err
 	}
 	contexts := permission.ContextsForPermission(t, permission.PermServiceInstanceRead)
-	instances, err := readableInstances(t, contexts, "", serviceName)
+	instances, err := readableInstances(t, contexts, "", serviceName, nil)
 	if err != nil {
 		return err
 	}
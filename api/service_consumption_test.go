@@ -155,6 +155,41 @@ func (s *ConsumptionSuite) TestCreateInstanceWithPlan(c *check.C) {
 	c.Assert(si.Teams, check.DeepEquals, []string{s.team.Name})
 }
 
+func (s *ConsumptionSuite) TestCreateInstanceWithTagsAndParameters(c *check.C) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	se := service.Service{
+		Name:     "mysql",
+		Teams:    []string{s.team.Name},
+		Endpoint: map[string]string{"production": ts.URL},
+	}
+	se.Create()
+	defer s.conn.Services().Remove(bson.M{"_id": se.Name})
+	params := map[string]string{
+		"name":                "brainSQL",
+		"owner":               s.team.Name,
+		"tag":                 "production",
+		"parameters.replicas": "2",
+		"parameters.engine":   "innodb",
+	}
+	recorder, request := makeRequestToCreateInstanceHandler(params, c)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	si, err := service.GetServiceInstance("mysql", "brainSQL")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.Tags, check.DeepEquals, []string{"production"})
+	c.Assert(si.Parameters, check.DeepEquals, map[string]interface{}{"replicas": float64(2), "engine": "innodb"})
+	c.Assert(form.Get("parameters.replicas"), check.Equals, "2")
+	c.Assert(form.Get("tag"), check.Equals, "production")
+}
+
 func (s *ConsumptionSuite) TestCreateInstanceWithPlanImplicitTeam(c *check.C) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"DATABASE_HOST":"localhost"}`))
@@ -595,6 +630,78 @@ func (s *ConsumptionSuite) TestUpdateServiceHandlerWithoutPermissions(c *check.C
 	c.Assert(recorder.Body.String(), check.Equals, permission.ErrUnauthorized.Error()+"\n")
 }
 
+func (s *ConsumptionSuite) TestUpdateServiceHandlerServiceInstancePlan(c *check.C) {
+	var updateForm url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/plans" {
+			w.Write([]byte(`[{"name": "small"}, {"name": "large"}]`))
+			return
+		}
+		r.ParseForm()
+		updateForm = r.Form
+	}))
+	defer ts.Close()
+	se := service.Service{
+		Name:     "mysql",
+		Teams:    []string{s.team.Name},
+		Endpoint: map[string]string{"production": ts.URL},
+	}
+	se.Create()
+	defer s.conn.Services().Remove(bson.M{"_id": se.Name})
+	si := service.ServiceInstance{
+		Name:        "brainSQL",
+		ServiceName: "mysql",
+		PlanName:    "small",
+		Teams:       []string{s.team.Name},
+	}
+	si.Create()
+	defer s.conn.ServiceInstances().Remove(bson.M{"_id": si.Name})
+	params := map[string]string{
+		"plan":                "large",
+		"parameters.replicas": "3",
+	}
+	recorder, request := makeRequestToUpdateInstanceHandler(params, "mysql", "brainSQL", s.token.GetValue(), c)
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(updateForm.Get("plan"), check.Equals, "large")
+	c.Assert(updateForm.Get("parameters.replicas"), check.Equals, "3")
+	instance, err := service.GetServiceInstance("mysql", "brainSQL")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.PlanName, check.Equals, "large")
+	c.Assert(instance.Parameters, check.DeepEquals, map[string]interface{}{"replicas": float64(3)})
+	c.Assert(eventtest.EventDesc{
+		Target: serviceInstanceTarget("mysql", "brainSQL"),
+		Owner:  s.token.GetUserName(),
+		Kind:   "service-instance.update.plan",
+	}, eventtest.HasEvent)
+	params = map[string]string{"plan": "huge"}
+	recorder, request = makeRequestToUpdateInstanceHandler(params, "mysql", "brainSQL", s.token.GetValue(), c)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, service.ErrInvalidPlan.Error()+"\n")
+}
+
+func (s *ConsumptionSuite) TestUpdateServiceHandlerPlanWithoutPermission(c *check.C) {
+	si := service.ServiceInstance{
+		Name:        "brainSQL",
+		ServiceName: "mysql",
+		PlanName:    "small",
+		Teams:       []string{s.team.Name},
+	}
+	si.Create()
+	defer s.conn.ServiceInstances().Remove(bson.M{"_id": si.Name})
+	token := customUserWithPermission(c, "myuser", permission.Permission{
+		Scheme:  permission.PermServiceInstanceUpdateDescription,
+		Context: permission.Context(permission.CtxServiceInstance, serviceIntancePermName("mysql", si.Name)),
+	})
+	params := map[string]string{"description": "changed", "plan": "large"}
+	recorder, request := makeRequestToUpdateInstanceHandler(params, "mysql", "brainSQL", token.GetValue(), c)
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
 func (s *ConsumptionSuite) TestUpdateServiceHandlerInvalidDescription(c *check.C) {
 	params := map[string]string{
 		"description": "",
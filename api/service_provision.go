@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/errors"
@@ -88,7 +89,9 @@ func serviceCreate(w http.ResponseWriter, r *http.Request, t auth.Token) (err er
 		Username: r.FormValue("username"),
 		Endpoint: map[string]string{"production": r.FormValue("endpoint")},
 		Password: r.FormValue("password"),
+		Broker:   r.FormValue("broker"),
 	}
+	s.Backup, _ = strconv.ParseBool(r.FormValue("backup"))
 	team := r.FormValue("team")
 	if team == "" {
 		team, err = permission.TeamForPermission(t, permission.PermServiceCreate)
@@ -184,6 +187,12 @@ func serviceUpdate(w http.ResponseWriter, r *http.Request, t auth.Token) (err er
 	s.Endpoint = d.Endpoint
 	s.Password = d.Password
 	s.Username = d.Username
+	if _, ok := r.Form["broker"]; ok {
+		s.Broker = r.FormValue("broker")
+	}
+	if _, ok := r.Form["backup"]; ok {
+		s.Backup, _ = strconv.ParseBool(r.FormValue("backup"))
+	}
 	return s.Update()
 }
 
@@ -154,6 +154,24 @@ func (s *ProvisionSuite) TestServiceCreate(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *ProvisionSuite) TestServiceCreateWithBroker(c *check.C) {
+	v := url.Values{}
+	v.Set("id", "some_service")
+	v.Set("password", "xxxx")
+	v.Set("team", "tsuruteam")
+	v.Set("endpoint", "broker.com")
+	v.Set("broker", "mysql")
+	recorder, request := s.makeRequest("POST", "/services", v.Encode(), c)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	var rService service.Service
+	err := s.conn.Services().Find(bson.M{"_id": "some_service"}).One(&rService)
+	c.Assert(err, check.IsNil)
+	c.Assert(rService.Endpoint["production"], check.Equals, "broker.com")
+	c.Assert(rService.Broker, check.Equals, "mysql")
+}
+
 func (s *ProvisionSuite) TestServiceCreateNameExists(c *check.C) {
 	recorder, request := s.makeRequestToCreateHandler(c)
 	s.m.ServeHTTP(recorder, request)
@@ -275,6 +293,39 @@ func (s *ProvisionSuite) TestServiceUpdate(c *check.C) {
 	}, eventtest.HasEvent)
 }
 
+func (s *ProvisionSuite) TestServiceUpdateBroker(c *check.C) {
+	srv := service.Service{
+		Name:       "mysqlapi",
+		Endpoint:   map[string]string{"production": "sqlapi.com"},
+		OwnerTeams: []string{s.team.Name},
+		Password:   "oldold",
+		Broker:     "mysql",
+	}
+	err := srv.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": srv.Name})
+	v := url.Values{}
+	v.Set("password", "yyyy")
+	v.Set("endpoint", "mysqlapi.com")
+	recorder, request := s.makeRequest("PUT", "/services/mysqlapi", v.Encode(), c)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var updated service.Service
+	err = s.conn.Services().Find(bson.M{"_id": srv.Name}).One(&updated)
+	c.Assert(err, check.IsNil)
+	c.Assert(updated.Broker, check.Equals, "mysql")
+	v.Set("broker", "")
+	recorder, request = s.makeRequest("PUT", "/services/mysqlapi", v.Encode(), c)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	updated = service.Service{}
+	err = s.conn.Services().Find(bson.M{"_id": srv.Name}).One(&updated)
+	c.Assert(err, check.IsNil)
+	c.Assert(updated.Broker, check.Equals, "")
+}
+
 func (s *ProvisionSuite) TestUpdateHandlerReturnsBadRequestWithoutPassword(c *check.C) {
 	v := url.Values{}
 	v.Set("id", "some_service")
@@ -0,0 +1,48 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/usage"
+)
+
+// title: team usage
+// path: /teams/{name}/usage
+// method: GET
+// produce: application/json, text/csv
+// responses:
+//   200: OK
+//   400: Invalid month
+//   401: Unauthorized
+func teamUsage(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	team := r.URL.Query().Get(":name")
+	allowed := permission.Check(t, permission.PermTeamReadUsage,
+		permission.Context(permission.CtxTeam, team),
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	month, err := usage.ParseMonth(r.URL.Query().Get("month"))
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	report, err := usage.TeamReport(team, month)
+	if err != nil {
+		return err
+	}
+	if r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		w.Header().Set("Content-Type", "text/csv")
+		return report.WriteCSV(w)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/usage"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) insertUsage(c *check.C) {
+	start := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	err := s.conn.Usage().Insert(usage.Interval{
+		ID:       bson.NewObjectId(),
+		Kind:     usage.KindApp,
+		Resource: "myapp",
+		Team:     s.team.Name,
+		Plan:     "small",
+		Units:    2,
+		Start:    start,
+		End:      start.Add(10 * time.Hour),
+	}, usage.Interval{
+		ID:       bson.NewObjectId(),
+		Kind:     usage.KindServiceInstance,
+		Resource: "mysql/db",
+		Team:     s.team.Name,
+		Plan:     "large",
+		Start:    start,
+		End:      start.Add(5 * time.Hour),
+	})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestTeamUsage(c *check.C) {
+	s.insertUsage(c)
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name+"/usage?month=2017-03", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var report usage.Report
+	err = json.NewDecoder(recorder.Body).Decode(&report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report, check.DeepEquals, usage.Report{
+		Team:  s.team.Name,
+		Month: "2017-03",
+		Items: []usage.ReportItem{
+			{Kind: usage.KindApp, Resource: "myapp", Plan: "small", Hours: 10, UnitHours: 20},
+			{Kind: usage.KindServiceInstance, Resource: "mysql/db", Plan: "large", Hours: 5},
+		},
+	})
+}
+
+func (s *S) TestTeamUsageCSV(c *check.C) {
+	s.insertUsage(c)
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name+"/usage?month=2017-03", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Accept", "text/csv")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "text/csv")
+	c.Assert(recorder.Body.String(), check.Equals, `team,month,kind,resource,plan,hours,unit_hours
+tsuruteam,2017-03,app,myapp,small,10.00,20.00
+tsuruteam,2017-03,service-instance,mysql/db,large,5.00,0.00
+`)
+}
+
+func (s *S) TestTeamUsageInvalidMonth(c *check.C) {
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name+"/usage?month=march", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "invalid month \"march\", expected format YYYY-MM\n")
+}
+
+func (s *S) TestTeamUsageWithoutPermission(c *check.C) {
+	token := userWithPermission(c, permission.Permission{
+		Scheme:  permission.PermTeamReadUsage,
+		Context: permission.Context(permission.CtxTeam, "other-team"),
+	})
+	request, err := http.NewRequest("GET", "/teams/"+s.team.Name+"/usage", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+)
+
+const defaultWakeupTimeout = 2 * time.Minute
+
+// wakeupHandler is the wake-up proxy of sleeping apps. Routers send it the
+// requests of apps put to sleep, it identifies the app by the Host header,
+// starts its units and restores its routes. Requests of apps that are not
+// asleep are refused, so the proxy can't be used to bypass the router. The waiting request is then
+// replayed to one of the units, or the client is redirected back to the app
+// when sleep:wakeup:mode is "redirect".
+type wakeupHandler struct {
+	mu       sync.Mutex
+	appLocks map[string]*sync.Mutex
+	timeout  time.Duration
+	redirect bool
+}
+
+func newWakeupHandler() *wakeupHandler {
+	timeout, _ := config.GetInt("sleep:wakeup:timeout")
+	mode, _ := config.GetString("sleep:wakeup:mode")
+	h := &wakeupHandler{
+		appLocks: make(map[string]*sync.Mutex),
+		timeout:  time.Duration(timeout) * time.Second,
+		redirect: mode == "redirect",
+	}
+	if h.timeout == 0 {
+		h.timeout = defaultWakeupTimeout
+	}
+	return h
+}
+
+func (h *wakeupHandler) appLock(appName string) *sync.Mutex {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	l, ok := h.appLocks[appName]
+	if !ok {
+		l = &sync.Mutex{}
+		h.appLocks[appName] = l
+	}
+	return l
+}
+
+// wake wakes the app up, unless another request already did it. Concurrent
+// requests of the same app wait for a single wake up, and wake ups running
+// in other tsuru instances are waited for until the timeout.
+func (h *wakeupHandler) wake(appName string) (*app.App, error) {
+	l := h.appLock(appName)
+	l.Lock()
+	defer l.Unlock()
+	deadline := time.Now().Add(h.timeout)
+	for {
+		a, err := app.GetByName(appName)
+		if err != nil || !a.Sleeping {
+			return a, err
+		}
+		err = a.Wake()
+		if _, locked := err.(event.ErrEventLocked); locked && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+			continue
+		}
+		return a, err
+	}
+}
+
+func (h *wakeupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, err := app.GetByHost(r.Host)
+	if err == app.ErrAppNotFound {
+		http.Error(w, fmt.Sprintf("no app found for host %q", r.Host), http.StatusNotFound)
+		return
+	}
+	if err == nil && !a.Sleeping {
+		http.Error(w, fmt.Sprintf("app %q is not asleep", a.Name), http.StatusNotFound)
+		return
+	}
+	if err == nil {
+		a, err = h.wake(a.Name)
+	}
+	if err != nil {
+		log.Errorf("[wakeup] unable to wake up app for host %q: %s", r.Host, err)
+		http.Error(w, "unable to wake up the app", http.StatusServiceUnavailable)
+		return
+	}
+	if h.redirect {
+		scheme := r.Header.Get("X-Forwarded-Proto")
+		if scheme == "" {
+			scheme = "http"
+		}
+		http.Redirect(w, r, fmt.Sprintf("%s://%s%s", scheme, r.Host, r.URL.RequestURI()), http.StatusTemporaryRedirect)
+		return
+	}
+	addresses, err := a.RoutableAddresses()
+	if err != nil || len(addresses) == 0 {
+		log.Errorf("[wakeup] no routable addresses for app %s: %v", a.Name, err)
+		http.Error(w, "unable to wake up the app", http.StatusServiceUnavailable)
+		return
+	}
+	target := addresses[rand.Intn(len(addresses))]
+	httputil.NewSingleHostReverseProxy(&target).ServeHTTP(w, r)
+}
+
+func startWakeupServer(listen string) {
+	fmt.Printf("tsuru wake-up proxy listening at %s...\n", listen)
+	err := http.ListenAndServe(listen, newWakeupHandler())
+	if err != nil {
+		fmt.Printf("Wake-up proxy stopped: %s\n", err)
+	}
+}
+
+// title: app sleep policy
+// path: /apps/{app}/sleep/policy
+// method: PUT
+// consume: application/x-www-form-urlencoded
+// responses:
+//   200: Policy updated
+//   400: Invalid data
+//   401: Unauthorized
+//   404: App not found
+func setSleepPolicy(w http.ResponseWriter, r *http.Request, t auth.Token) (err error) {
+	var policy *app.SleepPolicy
+	if idle := r.FormValue("idle"); idle != "" && idle != "0" {
+		policy = &app.SleepPolicy{}
+		policy.IdleTimeout, err = time.ParseDuration(idle)
+		if err != nil {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "invalid idle timeout: " + err.Error()}
+		}
+	}
+	appName := r.URL.Query().Get(":app")
+	a, err := getAppFromContext(appName, r)
+	if err != nil {
+		return err
+	}
+	allowed := permission.Check(t, permission.PermAppUpdateSleepPolicy,
+		contextsForApp(&a)...,
+	)
+	if !allowed {
+		return permission.ErrUnauthorized
+	}
+	evt, err := event.New(&event.Opts{
+		Target:     appTarget(appName),
+		Kind:       permission.PermAppUpdateSleepPolicy,
+		Owner:      t,
+		CustomData: event.FormToCustomData(r.Form),
+		Allowed:    event.Allowed(permission.PermAppReadEvents, contextsForApp(&a)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	err = a.SetSleepPolicy(policy)
+	if e, ok := err.(*errors.ValidationError); ok {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: e.Message}
+	}
+	if err == app.ErrWakeupProxyNotConfigured {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
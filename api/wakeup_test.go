@@ -0,0 +1,134 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestWakeupHandlerRedirect(c *check.C) {
+	config.Set("sleep:wakeup:mode", "redirect")
+	defer config.Unset("sleep:wakeup:mode")
+	a := app.App{Name: "sleepy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	proxyURL := &url.URL{Scheme: "http", Host: "tsuru-wakeup:8081"}
+	err = a.Sleep(&bytes.Buffer{}, "", proxyURL)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "http://sleepy.fakerouter.com/some/path?x=1", strings.NewReader("data"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("X-Forwarded-Proto", "https")
+	recorder := httptest.NewRecorder()
+	newWakeupHandler().ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusTemporaryRedirect)
+	c.Assert(recorder.Header().Get("Location"), check.Equals, "https://sleepy.fakerouter.com/some/path?x=1")
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, false)
+	c.Assert(s.provisioner.Starts(dbApp, ""), check.Equals, 1)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, proxyURL.String()), check.Equals, false)
+}
+
+func (s *S) TestWakeupHandlerAppNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "http://unknown.fakerouter.com/", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	newWakeupHandler().ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestWakeupHandlerAppNotAsleep(c *check.C) {
+	a := app.App{Name: "awake", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	request, err := http.NewRequest("GET", "http://awake.fakerouter.com/", nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	newWakeupHandler().ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+	c.Assert(s.provisioner.Starts(&a, ""), check.Equals, 0)
+}
+
+func (s *S) TestSleepHandlerUsesWakeupProxy(c *check.C) {
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	a := app.App{Name: "sleepy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("POST", "/apps/sleepy/sleep", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://tsuru-wakeup:8081"), check.Equals, true)
+}
+
+func (s *S) TestSetSleepPolicyHandler(c *check.C) {
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	a := app.App{Name: "sleepy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	token := customUserWithPermission(c, "sleeper", permission.Permission{
+		Scheme:  permission.PermAppUpdateSleepPolicy,
+		Context: permission.Context(permission.CtxApp, a.Name),
+	})
+	request, err := http.NewRequest("PUT", "/apps/sleepy/sleep/policy", strings.NewReader("idle=30m"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "b "+token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.SleepPolicy, check.NotNil)
+	c.Assert(dbApp.SleepPolicy.IdleTimeout, check.Equals, 30*time.Minute)
+	c.Assert(eventtest.EventDesc{
+		Target: appTarget(a.Name),
+		Owner:  token.GetUserName(),
+		Kind:   "app.update.sleep.policy",
+		StartCustomData: []map[string]interface{}{
+			{"name": ":app", "value": a.Name},
+			{"name": "idle", "value": "30m"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestSetSleepPolicyHandlerInvalidIdle(c *check.C) {
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	a := app.App{Name: "sleepy", Platform: "zend", TeamOwner: s.team.Name}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	for _, idle := range []string{"abc", "10s"} {
+		request, err := http.NewRequest("PUT", "/apps/sleepy/sleep/policy", strings.NewReader("idle="+idle))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "b "+s.token.GetValue())
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		m := RunServer(true)
+		m.ServeHTTP(recorder, request)
+		c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	}
+}
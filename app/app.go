@@ -19,8 +19,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/encryption"
 	tsuruErrors "github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/healer"
@@ -31,7 +33,9 @@ import (
 	"github.com/tsuru/tsuru/repository"
 	"github.com/tsuru/tsuru/router"
 	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/secret"
 	"github.com/tsuru/tsuru/service"
+	"github.com/tsuru/tsuru/usage"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -53,7 +57,7 @@ const (
 	// "tsr" when the name of the daemon changed to "tsurud".
 	InternalAppName = "tsr"
 
-	TsuruServicesEnvVar = "TSURU_SERVICES"
+	TsuruServicesEnvVar = bind.TsuruServicesEnvVar
 	defaultAppDir       = "/home/application/current"
 )
 
@@ -125,6 +129,14 @@ type App struct {
 	Pool           string
 	Description    string
 	RouterOpts     map[string]string
+	Sleeping       bool
+	SleepPolicy    *SleepPolicy
+	UnitsSchedule  *UnitsSchedule
+
+	DeletionProtection bool
+	Deletion           *Deletion
+	Maintenance        *Maintenance
+	MaintenancePage    string
 
 	quota.Quota
 	provisioner provision.Provisioner
@@ -182,6 +194,24 @@ func (app *App) MarshalJSON() ([]byte, error) {
 	result["teamowner"] = app.TeamOwner
 	result["plan"] = app.Plan
 	result["lock"] = app.Lock
+	if app.Sleeping {
+		result["sleeping"] = true
+	}
+	if app.SleepPolicy != nil {
+		result["sleepPolicy"] = app.SleepPolicy
+	}
+	if app.UnitsSchedule != nil {
+		result["unitsSchedule"] = app.UnitsSchedule
+	}
+	if app.DeletionProtection {
+		result["deletionProtection"] = true
+	}
+	if app.Deletion != nil {
+		result["deletion"] = app.Deletion
+	}
+	if app.Maintenance != nil {
+		result["maintenance"] = app.Maintenance
+	}
 	return json.Marshal(&result)
 }
 
@@ -201,35 +231,15 @@ func AcquireApplicationLock(appName string, owner string, reason string) (bool,
 	return AcquireApplicationLockWait(appName, owner, reason, 0)
 }
 
-// Same as AcquireApplicationLock but it keeps trying to acquire the lock
-// until timeout is reached.
+// Same as AcquireApplicationLock but it waits in the operation queue of the
+// app until the lock is acquired or timeout is reached.
 func AcquireApplicationLockWait(appName string, owner string, reason string, timeout time.Duration) (bool, error) {
-	timeoutChan := time.After(timeout)
-	conn, err := db.Conn()
-	if err != nil {
-		return false, err
-	}
-	defer conn.Close()
-	for {
-		appLock := AppLock{
-			Locked:      true,
-			Reason:      reason,
-			Owner:       owner,
-			AcquireDate: time.Now().In(time.UTC),
-		}
-		err = conn.Apps().Update(bson.M{"name": appName, "lock.locked": bson.M{"$in": []interface{}{false, nil}}}, bson.M{"$set": bson.M{"lock": appLock}})
-		if err == nil {
-			return true, nil
-		}
-		if err != mgo.ErrNotFound {
-			return false, err
-		}
-		select {
-		case <-timeoutChan:
-			return false, nil
-		case <-time.After(300 * time.Millisecond):
-		}
-	}
+	return AcquireApplicationLockQueued(LockWaitOpts{
+		App:     appName,
+		Owner:   owner,
+		Reason:  reason,
+		Timeout: timeout,
+	})
 }
 
 // ReleaseApplicationLock releases a lock hold on an app, currently it's called
@@ -286,6 +296,11 @@ func CreateApp(app *App, user *auth.User) error {
 		return err
 	}
 	app.Plan = *plan
+	reservation, err := auth.ReserveTeamQuota(app.TeamOwner, auth.TeamResources{Apps: 1})
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
 	err = app.SetPool()
 	if err != nil {
 		return err
@@ -310,6 +325,7 @@ func CreateApp(app *App, user *auth.User) error {
 	if err != nil {
 		return &AppCreationError{app: app.Name, Err: err}
 	}
+	app.recordUsage(false)
 	return nil
 }
 
@@ -323,8 +339,26 @@ func (app *App) Update(updateData App, w io.Writer) error {
 		app.Description = description
 	}
 	if poolName != "" {
+		oldProv, err := app.getProvisioner()
+		if err != nil {
+			return err
+		}
 		app.Pool = poolName
-		_, err := app.getPoolForApp(app.Pool)
+		app.provisioner = nil
+		_, err = app.getPoolForApp(app.Pool)
+		if err != nil {
+			return err
+		}
+		newProv, err := app.getProvisioner()
+		if err != nil {
+			return err
+		}
+		if newProv.GetName() != oldProv.GetName() {
+			return ErrPoolProvisionerChanged
+		}
+	}
+	if poolName != "" || planName != "" || teamOwner != "" {
+		err := app.validateUpdateConstraints(planName, teamOwner, poolName != "")
 		if err != nil {
 			return err
 		}
@@ -339,6 +373,15 @@ func (app *App) Update(updateData App, w io.Writer) error {
 		if err != nil {
 			return err
 		}
+		if plan.Memory > app.Plan.Memory {
+			reservation, err := auth.ReserveTeamQuota(app.TeamOwner, auth.TeamResources{
+				Memory: int64(app.Quota.InUse) * (plan.Memory - app.Plan.Memory),
+			})
+			if err != nil {
+				return err
+			}
+			defer reservation.Release()
+		}
 		var oldPlan Plan
 		oldPlan, app.Plan = app.Plan, *plan
 		actions := []*action.Action{
@@ -357,6 +400,17 @@ func (app *App) Update(updateData App, w io.Writer) error {
 		if err != nil {
 			return err
 		}
+		if team.Name != app.TeamOwner {
+			reservation, err := auth.ReserveTeamQuota(team.Name, auth.TeamResources{
+				Apps:   1,
+				Units:  app.Quota.InUse,
+				Memory: int64(app.Quota.InUse) * app.Plan.Memory,
+			})
+			if err != nil {
+				return err
+			}
+			defer reservation.Release()
+		}
 		app.TeamOwner = team.Name
 		err = app.validateTeamOwner()
 		if err != nil {
@@ -364,7 +418,29 @@ func (app *App) Update(updateData App, w io.Writer) error {
 		}
 		app.Grant(team)
 	}
-	return conn.Apps().Update(bson.M{"name": app.Name}, app)
+	err = conn.Apps().Update(bson.M{"name": app.Name}, app)
+	if err != nil {
+		return err
+	}
+	if planName != "" || teamOwner != "" {
+		app.recordUsage(false)
+	}
+	return nil
+}
+
+// recordUsage records the usage of the app with its current team, plan and
+// number of units, or the end of it when stop is true.
+func (app *App) recordUsage(stop bool) {
+	var units int
+	if !stop {
+		appUnits, err := app.Units()
+		if err != nil {
+			log.Errorf("[usage] unable to get units of app %q, not recording usage: %s", app.Name, err)
+			return
+		}
+		units = len(appUnits)
+	}
+	usage.Record(usage.KindApp, app.Name, app.TeamOwner, app.Plan.Name, units, stop)
 }
 
 // unbind takes all service instances that are bound to the app, and unbind
@@ -477,10 +553,15 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to remove app from db", err)
 	}
+	err = removeConfigFiles(appName)
+	if err != nil {
+		logErr("Unable to remove config files", err)
+	}
 	err = event.MarkAsRemoved(event.Target{Type: event.TargetTypeApp, Value: appName})
 	if err != nil {
 		logErr("Unable to mark old events as removed", err)
 	}
+	app.recordUsage(true)
 	return nil
 }
 
@@ -545,12 +626,27 @@ func (app *App) AddUnits(n uint, process string, writer io.Writer) error {
 	if n == 0 {
 		return errors.New("Cannot add zero units.")
 	}
-	err := action.NewPipeline(
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	reservation, err := auth.ReserveTeamQuota(app.TeamOwner, auth.TeamResources{
+		Units:  int(n),
+		Memory: int64(n) * app.Plan.Memory,
+	})
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
+	err = action.NewPipeline(
 		&reserveUnitsToAdd,
 		&provisionAddUnits,
 	).Execute(app, n, writer, process)
 	rebuild.RoutesRebuildOrEnqueue(app.Name)
-	return err
+	if err != nil {
+		return err
+	}
+	app.recordUsage(false)
+	return nil
 }
 
 // RemoveUnits removes n units from the app. It's a process composed of
@@ -577,7 +673,7 @@ func (app *App) RemoveUnits(n uint, process string, writer io.Writer) error {
 	if err != nil {
 		return err
 	}
-	return conn.Apps().Update(
+	err = conn.Apps().Update(
 		bson.M{"name": app.Name},
 		bson.M{
 			"$set": bson.M{
@@ -585,6 +681,11 @@ func (app *App) RemoveUnits(n uint, process string, writer io.Writer) error {
 			},
 		},
 	)
+	if err != nil {
+		return err
+	}
+	usage.Record(usage.KindApp, app.Name, app.TeamOwner, app.Plan.Name, len(units), false)
+	return nil
 }
 
 // SetUnitStatus changes the status of the given unit.
@@ -642,6 +743,12 @@ func UpdateNodeStatus(nodeData provision.NodeStatusData) ([]UpdateUnitsResult, e
 			log.Errorf("unable to set node status in healer: %s", err)
 		}
 	}
+	if resProv, ok := node.Provisioner().(provision.NodeResourcesProvisioner); ok && nodeData.Resources != (provision.NodeResources{}) {
+		err = resProv.UpdateNodeResources(node, nodeData.Resources)
+		if err != nil {
+			log.Errorf("unable to set node resources: %s", err)
+		}
+	}
 	unitProv, ok := node.Provisioner().(provision.UnitStatusProvisioner)
 	if !ok {
 		return []UpdateUnitsResult{}, nil
@@ -802,6 +909,67 @@ func (app *App) SetPool() error {
 		poolName = pool.Name
 	}
 	app.Pool = poolName
+	_, err = app.validatePoolConstraints()
+	return err
+}
+
+// validatePoolConstraints checks the plan, router and team owner of the app
+// against the constraints of its pool, returning the pool.
+func (app *App) validatePoolConstraints() (*provision.Pool, error) {
+	if app.Pool == "" {
+		return nil, nil
+	}
+	pool, err := provision.GetPoolByName(app.Pool)
+	if err != nil {
+		return nil, err
+	}
+	routerName, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	checks := []struct{ field, value string }{
+		{provision.PoolConstraintPlan, app.Plan.Name},
+		{provision.PoolConstraintRouter, routerName},
+		{provision.PoolConstraintTeam, app.TeamOwner},
+	}
+	for _, check := range checks {
+		err = pool.CheckConstraint(check.field, check.value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pool, nil
+}
+
+// validateUpdateConstraints checks the app, with the given plan and team
+// owner, against the constraints of its pool. When the pool changed, the
+// services of the instances bound to the app are checked too.
+func (app *App) validateUpdateConstraints(planName, teamOwner string, poolChanged bool) error {
+	updated := *app
+	if planName != "" {
+		plan, err := findPlanByName(planName)
+		if err != nil {
+			return err
+		}
+		updated.Plan = *plan
+	}
+	if teamOwner != "" {
+		updated.TeamOwner = teamOwner
+	}
+	pool, err := updated.validatePoolConstraints()
+	if err != nil || pool == nil || !poolChanged {
+		return err
+	}
+	instances, err := app.serviceInstances()
+	if err != nil {
+		return err
+	}
+	for _, si := range instances {
+		err = pool.CheckConstraint(provision.PoolConstraintService, si.ServiceName)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -876,6 +1044,32 @@ func (app *App) validate() error {
 	return nil
 }
 
+// ValidateProcesses checks that every given process is declared in the
+// current image of the app.
+func (app *App) ValidateProcesses(processes []string) error {
+	if len(processes) == 0 {
+		return nil
+	}
+	imgName, err := image.AppCurrentImageName(app.Name)
+	if err != nil && err != image.ErrNoImagesAvailable {
+		return err
+	}
+	var declared map[string][]string
+	if err == nil {
+		data, err := image.GetImageCustomData(imgName)
+		if err != nil {
+			return err
+		}
+		declared = data.Processes
+	}
+	for _, process := range processes {
+		if _, ok := declared[process]; !ok {
+			return provision.InvalidProcessError{Msg: fmt.Sprintf("process %q not found in app %q", process, app.Name)}
+		}
+	}
+	return nil
+}
+
 // InstanceEnv returns a map of environment variables that belongs to the given
 // service instance (identified by the name only).
 func (app *App) InstanceEnv(name string) map[string]bind.EnvVar {
@@ -928,20 +1122,42 @@ func (app *App) run(cmd string, w io.Writer, args provision.RunArgs) error {
 
 // Restart runs the restart hook for the app, writing its output to w.
 func (app *App) Restart(process string, w io.Writer) error {
+	return app.RestartWithOptions(process, provision.RestartOptions{}, w)
+}
+
+// RestartWithOptions restarts the app units, when a batch size is set in
+// opts the units are replaced in batches and the provisioner must support
+// rolling restarts.
+func (app *App) RestartWithOptions(process string, opts provision.RestartOptions, w io.Writer) error {
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+	prov, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	rollingProv, isRolling := prov.(provision.RollingRestartProvisioner)
+	if opts.BatchSize > 0 && !isRolling {
+		return provision.ProvisionerNotSupported{Prov: prov, Action: "rolling restarts"}
+	}
 	msg := fmt.Sprintf("---- Restarting process %q ----\n", process)
 	if process == "" {
 		msg = fmt.Sprintf("---- Restarting the app %q ----\n", app.Name)
 	}
-	err := log.Write(w, []byte(msg))
+	err = log.Write(w, []byte(msg))
 	if err != nil {
 		log.Errorf("[restart] error on write app log for the app %s - %s", app.Name, err)
 		return err
 	}
-	prov, err := app.getProvisioner()
-	if err != nil {
-		return err
+	if opts.BatchSize > 0 {
+		err = rollingProv.RollingRestart(app, process, opts, w)
+	} else {
+		err = prov.Restart(app, process, w)
 	}
-	err = prov.Restart(app, process, w)
 	if err != nil {
 		log.Errorf("[restart] error on restart the app %s - %s", app.Name, err)
 		return err
@@ -969,6 +1185,9 @@ func (app *App) Stop(w io.Writer, process string) error {
 }
 
 func (app *App) Sleep(w io.Writer, process string, proxyURL *url.URL) error {
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
 	prov, err := app.getProvisioner()
 	if err != nil {
 		return err
@@ -1010,6 +1229,9 @@ func (app *App) Sleep(w io.Writer, process string, proxyURL *url.URL) error {
 		log.Errorf("[sleep] rolling back the sleep %s", app.Name)
 		return err
 	}
+	if process == "" {
+		return app.setSleeping(true)
+	}
 	return nil
 }
 
@@ -1112,9 +1334,53 @@ func (app *App) GetDeploys() uint {
 	return app.Deploys
 }
 
-// Envs returns a map representing the apps environment variables.
+// UnitEnvs returns the environment variables units of the app are started
+// with, resolving references to external secret stores. Unlike Envs, it fails
+// when a secret variable can't be decrypted or resolved, instead of starting
+// units with an empty value.
+func (app *App) UnitEnvs() (map[string]bind.EnvVar, error) {
+	envs := make(map[string]bind.EnvVar, len(app.Env))
+	for name, env := range app.Env {
+		if env.Secret {
+			value, err := encryption.Decrypt(env.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decrypt secret env %s of app %s", name, app.Name)
+			}
+			env.Value = value
+		}
+		if secret.IsReference(env.Value) {
+			value, err := secret.Resolve(env.Value, app.secretScope())
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to resolve env %s of app %s", name, app.Name)
+			}
+			env.Value = value
+		}
+		envs[name] = env
+	}
+	return envs, nil
+}
+
+func (app *App) secretScope() secret.Scope {
+	return secret.Scope{Team: app.TeamOwner, Pool: app.Pool}
+}
+
+// Envs returns a map representing the apps environment variables. Secret
+// variables are decrypted, so the returned values must not be exposed to users
+// without permission to reveal them. References to external secret stores are
+// returned as they are, they're only resolved by UnitEnvs.
 func (app *App) Envs() map[string]bind.EnvVar {
-	return app.Env
+	envs := make(map[string]bind.EnvVar, len(app.Env))
+	for name, env := range app.Env {
+		if env.Secret {
+			value, err := encryption.Decrypt(env.Value)
+			if err != nil {
+				log.Errorf("[envs] unable to decrypt secret env %s of app %s: %s", name, app.Name, err)
+			}
+			env.Value = value
+		}
+		envs[name] = env
+	}
+	return envs
 }
 
 // SetEnvs saves a list of environment variables in the app. The publicOnly
@@ -1141,13 +1407,36 @@ func (app *App) SetEnvs(setEnvs bind.SetEnvApp, w io.Writer) error {
 // overridden (if set to false, setEnvsToApp may override a private variable).
 //
 // shouldRestart defines if the server should be restarted after saving vars.
+//
+// Secret variables are never public and are stored encrypted. Values
+// referencing external secret stores must be resolvable when set.
 func (app *App) setEnvsToApp(setEnvs bind.SetEnvApp, w io.Writer) error {
 	if len(setEnvs.Envs) == 0 {
 		return nil
 	}
+	if setEnvs.ShouldRestart && app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	for i, env := range setEnvs.Envs {
+		if secret.IsReference(env.Value) {
+			if _, err := secret.Resolve(env.Value, app.secretScope()); err != nil {
+				return &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid value for env %s: %s", env.Name, err)}
+			}
+		}
+		if !env.Secret {
+			continue
+		}
+		value, err := encryption.EncryptEnvelope(env.Value)
+		if err != nil {
+			return err
+		}
+		setEnvs.Envs[i].Value = value
+		setEnvs.Envs[i].Public = false
+	}
 	if w != nil {
 		fmt.Fprintf(w, "---- Setting %d new environment variables ----\n", len(setEnvs.Envs))
 	}
+	previous := copyEnv(app.Env)
 	for _, env := range setEnvs.Envs {
 		set := true
 		if setEnvs.PublicOnly {
@@ -1169,6 +1458,7 @@ func (app *App) setEnvsToApp(setEnvs bind.SetEnvApp, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	app.saveEnvRevision(previous, setEnvs.Owner, 0)
 	if !setEnvs.ShouldRestart {
 		return nil
 	}
@@ -1201,9 +1491,13 @@ func (app *App) unsetEnvsToApp(unsetEnvs bind.UnsetEnvApp, w io.Writer) error {
 	if len(unsetEnvs.VariableNames) == 0 {
 		return nil
 	}
+	if unsetEnvs.ShouldRestart && app.Deletion != nil {
+		return ErrAppDeleted
+	}
 	if w != nil {
 		fmt.Fprintf(w, "---- Unsetting %d environment variables ----\n", len(unsetEnvs.VariableNames))
 	}
+	previous := copyEnv(app.Env)
 	for _, name := range unsetEnvs.VariableNames {
 		var unset bool
 		e, err := app.getEnv(name)
@@ -1223,6 +1517,7 @@ func (app *App) unsetEnvsToApp(unsetEnvs bind.UnsetEnvApp, w io.Writer) error {
 	if err != nil {
 		return err
 	}
+	app.saveEnvRevision(previous, unsetEnvs.Owner, 0)
 	if !unsetEnvs.ShouldRestart {
 		return nil
 	}
@@ -1290,6 +1585,7 @@ func (app *App) AddInstance(instanceApp bind.InstanceApp, writer io.Writer) erro
 			Value:        v,
 			Public:       false,
 			InstanceName: instanceApp.Instance.Name,
+			Processes:    instanceApp.Instance.Processes,
 		})
 	}
 	envVars = append(envVars, bind.EnvVar{
@@ -1320,15 +1616,15 @@ func appendOrUpdateServiceInstance(services []bind.ServiceInstance, service bind
 	return services
 }
 
-func findServiceEnv(tsuruServices map[string][]bind.ServiceInstance, name string) (string, string) {
+func findServiceEnv(tsuruServices map[string][]bind.ServiceInstance, name string) (bind.ServiceInstance, string) {
 	for _, serviceInstances := range tsuruServices {
 		for _, instance := range serviceInstances {
 			if instance.Envs[name] != "" {
-				return instance.Name, instance.Envs[name]
+				return instance, instance.Envs[name]
 			}
 		}
 	}
-	return "", ""
+	return bind.ServiceInstance{}, ""
 }
 
 //func (app *App) RemoveInstance(serviceName string, instance bind.ServiceInstance, shouldRestart bool, writer io.Writer) error {
@@ -1360,15 +1656,16 @@ func (app *App) RemoveInstance(instanceApp bind.InstanceApp, writer io.Writer) e
 	}
 	var envsToSet []bind.EnvVar
 	for _, varName := range toUnsetEnvs {
-		instanceName, envValue := findServiceEnv(tsuruServices, varName)
-		if envValue == "" || instanceName == "" {
+		instance, envValue := findServiceEnv(tsuruServices, varName)
+		if envValue == "" || instance.Name == "" {
 			break
 		}
 		envsToSet = append(envsToSet, bind.EnvVar{
 			Name:         varName,
 			Value:        envValue,
 			Public:       false,
-			InstanceName: instanceName,
+			InstanceName: instance.Name,
+			Processes:    instance.Processes,
 		})
 	}
 	if servicesJson != nil {
@@ -1487,6 +1784,7 @@ type Filter struct {
 	Pools       []string
 	Statuses    []string
 	Locked      bool
+	Deleted     bool
 	Extra       map[string][]string
 }
 
@@ -1532,6 +1830,9 @@ func (f *Filter) Query() bson.M {
 	if f.Locked {
 		query["lock.locked"] = true
 	}
+	if f.Deleted {
+		query["deletion"] = bson.M{"$ne": nil}
+	}
 	if len(f.Pools) > 0 {
 		query["pool"] = bson.M{"$in": f.Pools}
 	}
@@ -1584,6 +1885,9 @@ func List(filter *Filter) ([]App, error) {
 
 // Swap calls the Router.Swap and updates the app.CName in the database.
 func Swap(app1, app2 *App, cnameOnly bool) error {
+	if app1.Deletion != nil || app2.Deletion != nil {
+		return ErrAppDeleted
+	}
 	r1, err := app1.Router()
 	if err != nil {
 		return err
@@ -1624,6 +1928,9 @@ func Swap(app1, app2 *App, cnameOnly bool) error {
 // Start starts the app calling the provisioner.Start method and
 // changing the units state to StatusStarted.
 func (app *App) Start(w io.Writer, process string) error {
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
 	msg := fmt.Sprintf("\n ---> Starting the process %q\n", process)
 	if process == "" {
 		msg = fmt.Sprintf("\n ---> Starting the app %q\n", app.Name)
@@ -1638,8 +1945,14 @@ func (app *App) Start(w io.Writer, process string) error {
 		log.Errorf("[start] error on start the app %s - %s", app.Name, err)
 		return err
 	}
+	if app.Sleeping {
+		err = app.setSleeping(false)
+		if err != nil {
+			log.Errorf("[start] error on start the app %s - %s", app.Name, err)
+		}
+	}
 	rebuild.RoutesRebuildOrEnqueue(app.Name)
-	return err
+	return nil
 }
 
 func (app *App) SetUpdatePlatform(check bool) error {
@@ -1811,7 +2124,27 @@ func (app *App) UpdateAddr() error {
 	return nil
 }
 
+// UnitRoutesDisabled returns whether the routes of the app must not point to
+// its units, which happens while it's soft deleted or in maintenance mode.
+func (app *App) UnitRoutesDisabled() bool {
+	return app.Deletion != nil || app.Maintenance != nil
+}
+
+// RoutableAddresses returns the addresses of the units of the app that must
+// be routed. Soft deleted apps have none, so their routes are kept removed
+// until they're restored, and apps in maintenance mode are routed to the
+// maintenance page only.
 func (app *App) RoutableAddresses() ([]url.URL, error) {
+	if app.Deletion != nil {
+		return nil, nil
+	}
+	if app.Maintenance != nil {
+		pageURL, err := MaintenancePageURL()
+		if err != nil {
+			return nil, err
+		}
+		return []url.URL{*pageURL}, nil
+	}
 	prov, err := app.getProvisioner()
 	if err != nil {
 		return nil, err
@@ -1826,3 +2159,73 @@ func (app *App) InternalLock(reason string) (bool, error) {
 func (app *App) Unlock() {
 	ReleaseApplicationLock(app.Name)
 }
+
+// RotateSecrets encrypts the secret environment variables of all apps, and
+// the ones kept in their env revisions, with the current encryption key,
+// returning the number of variables changed. It must be called after changing
+// encryption:key, with the old key listed in encryption:previous-keys.
+func RotateSecrets() (int, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{}).Select(bson.M{"name": 1, "env": 1}).All(&apps)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, a := range apps {
+		update, err := rewrapSecretEnvs(a.Env)
+		if err != nil {
+			return count, errors.Wrapf(err, "unable to rotate envs of app %s", a.Name)
+		}
+		if len(update) == 0 {
+			continue
+		}
+		err = conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": update})
+		if err != nil {
+			return count, err
+		}
+		count += len(update)
+	}
+	iter := conn.EnvRevisions().Find(bson.M{}).Select(bson.M{"app": 1, "version": 1, "env": 1}).Iter()
+	var rev EnvRevision
+	for iter.Next(&rev) {
+		update, err := rewrapSecretEnvs(rev.Env)
+		if err != nil {
+			iter.Close()
+			return count, errors.Wrapf(err, "unable to rotate envs of revision %d of app %s", rev.Version, rev.App)
+		}
+		if len(update) > 0 {
+			err = conn.EnvRevisions().Update(bson.M{"app": rev.App, "version": rev.Version}, bson.M{"$set": update})
+			if err != nil {
+				iter.Close()
+				return count, err
+			}
+			count += len(update)
+		}
+		rev = EnvRevision{}
+	}
+	return count, iter.Close()
+}
+
+// rewrapSecretEnvs returns the update setting the secret variables not
+// encrypted with the current key to their values encrypted with it.
+func rewrapSecretEnvs(env map[string]bind.EnvVar) (bson.M, error) {
+	update := bson.M{}
+	for name, envVar := range env {
+		if !envVar.Secret {
+			continue
+		}
+		value, changed, err := encryption.Rewrap(envVar.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to rotate env %s", name)
+		}
+		if changed {
+			update["env."+name+".value"] = value
+		}
+	}
+	return update, nil
+}
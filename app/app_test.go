@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"sync"
@@ -20,7 +22,9 @@ import (
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/encryption"
 	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/event/eventtest"
@@ -35,6 +39,7 @@ import (
 	"github.com/tsuru/tsuru/safe"
 	"github.com/tsuru/tsuru/service"
 	"github.com/tsuru/tsuru/tsurutest"
+	"github.com/tsuru/tsuru/usage"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -88,6 +93,31 @@ func (s *S) TestDelete(c *check.C) {
 	c.Assert(err, check.Equals, router.ErrBackendNotFound)
 }
 
+func (s *S) TestAppRecordsUsage(c *check.C) {
+	a := App{
+		Name:      "ritual",
+		Platform:  "ruby",
+		Owner:     s.user.Email,
+		TeamOwner: s.team.Name,
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "", nil)
+	c.Assert(err, check.IsNil)
+	err = Delete(&a, nil)
+	c.Assert(err, check.IsNil)
+	var intervals []usage.Interval
+	err = s.conn.Usage().Find(bson.M{"kind": usage.KindApp, "resource": a.Name}).Sort("start").All(&intervals)
+	c.Assert(err, check.IsNil)
+	c.Assert(intervals, check.HasLen, 2)
+	c.Assert(intervals[0].Team, check.Equals, s.team.Name)
+	c.Assert(intervals[0].Plan, check.Equals, a.Plan.Name)
+	c.Assert(intervals[0].Units, check.Equals, 0)
+	c.Assert(intervals[0].End.IsZero(), check.Equals, false)
+	c.Assert(intervals[1].Units, check.Equals, 2)
+	c.Assert(intervals[1].End.IsZero(), check.Equals, false)
+}
+
 func (s *S) TestDeleteWithEvents(c *check.C) {
 	a := App{
 		Name:      "ritual",
@@ -774,6 +804,19 @@ func (s *S) TestUpdateNodeStatus(c *check.C) {
 	c.Assert(result, check.DeepEquals, expected)
 }
 
+func (s *S) TestUpdateNodeStatusWithResources(c *check.C) {
+	err := s.provisioner.AddNode(provision.AddNodeOptions{
+		Address: "addr1",
+	})
+	c.Assert(err, check.IsNil)
+	resources := provision.NodeResources{CPUs: 4, Memory: 8589934592, Disk: 107374182400, DiskAvailable: 53687091200}
+	_, err = UpdateNodeStatus(provision.NodeStatusData{Addrs: []string{"addr1"}, Resources: resources})
+	c.Assert(err, check.IsNil)
+	node, err := s.provisioner.GetNode("addr1")
+	c.Assert(err, check.IsNil)
+	c.Assert(node.(*provisiontest.FakeNode).Resources(), check.DeepEquals, resources)
+}
+
 func (s *S) TestUpdateNodeStatusNotFound(c *check.C) {
 	a := App{Name: "lapname", Platform: "python", TeamOwner: s.team.Name}
 	err := CreateApp(&a, s.user)
@@ -1333,6 +1376,29 @@ func (s *S) TestGetEnvReturnsErrorIfTheEnvironmentMapIsNil(c *check.C) {
 	c.Assert(err, check.NotNil)
 }
 
+func (s *S) TestValidateProcesses(c *check.C) {
+	a := &App{Name: "dark", TeamOwner: s.team.Name}
+	err := CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.ValidateProcesses([]string{"web"})
+	c.Assert(err, check.DeepEquals, provision.InvalidProcessError{Msg: `process "web" not found in app "dark"`})
+	err = image.SaveImageCustomData("tsuru/app-dark:v1", map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web":    "python web.py",
+			"worker": "python worker.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.Name, "tsuru/app-dark:v1")
+	c.Assert(err, check.IsNil)
+	err = a.ValidateProcesses(nil)
+	c.Assert(err, check.IsNil)
+	err = a.ValidateProcesses([]string{"web", "worker"})
+	c.Assert(err, check.IsNil)
+	err = a.ValidateProcesses([]string{"web", "cron"})
+	c.Assert(err, check.DeepEquals, provision.InvalidProcessError{Msg: `process "cron" not found in app "dark"`})
+}
+
 func (s *S) TestInstanceEnvironmentReturnEnvironmentVariablesForTheServer(c *check.C) {
 	envs := map[string]bind.EnvVar{
 		"DATABASE_HOST": {Name: "DATABASE_HOST", Value: "localhost", Public: false, InstanceName: "mysql"},
@@ -1654,6 +1720,36 @@ func (s *S) TestAddInstanceFirst(c *check.C) {
 	c.Assert(s.provisioner.Restarts(a, ""), check.Equals, 0)
 }
 
+func (s *S) TestAddInstanceWithProcesses(c *check.C) {
+	a := &App{Name: "dark", TeamOwner: s.team.Name}
+	err := CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	instance := bind.ServiceInstance{
+		Name:      "myinstance",
+		Envs:      map[string]string{"DATABASE_HOST": "localhost"},
+		Processes: []string{"worker"},
+	}
+	err = a.AddInstance(
+		bind.InstanceApp{
+			ServiceName:   "myservice",
+			Instance:      instance,
+			ShouldRestart: true,
+		}, nil)
+	c.Assert(err, check.IsNil)
+	a, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Env["DATABASE_HOST"], check.DeepEquals, bind.EnvVar{
+		Name:         "DATABASE_HOST",
+		Value:        "localhost",
+		Public:       false,
+		InstanceName: "myinstance",
+		Processes:    []string{"worker"},
+	})
+	envVar := a.Env["DATABASE_HOST"]
+	c.Assert(envVar.AppliesTo("worker"), check.Equals, true)
+	c.Assert(envVar.AppliesTo("web"), check.Equals, false)
+}
+
 func (s *S) TestAddInstanceDuplicated(c *check.C) {
 	a := &App{Name: "sith", TeamOwner: s.team.Name}
 	err := CreateApp(a, s.user)
@@ -2187,6 +2283,35 @@ func (s *S) TestRestart(c *check.C) {
 	c.Assert(restarts, check.Equals, 1)
 }
 
+func (s *S) TestRestartWithOptions(c *check.C) {
+	s.provisioner.PrepareOutput([]byte("not yaml")) // loadConf
+	a := App{
+		Name:      "someapp",
+		Platform:  "django",
+		Teams:     []string{s.team.Name},
+		Plan:      Plan{Router: "fake"},
+		TeamOwner: s.team.Name,
+	}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	var b bytes.Buffer
+	opts := provision.RestartOptions{BatchSize: 2, Pause: time.Second}
+	err = a.RestartWithOptions("web", opts, &b)
+	c.Assert(err, check.IsNil)
+	c.Assert(b.String(), check.Matches, `(?s).*---- Restarting process "web" ----.*`)
+	c.Assert(s.provisioner.Restarts(&a, "web"), check.Equals, 1)
+	c.Assert(s.provisioner.LastRestartOptions(&a), check.DeepEquals, opts)
+}
+
+func (s *S) TestRestartWithOptionsInvalid(c *check.C) {
+	a := App{Name: "someapp", Platform: "django", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.RestartWithOptions("", provision.RestartOptions{BatchSize: -1}, nil)
+	c.Assert(err, check.ErrorMatches, "batch size must be greater than or equal to zero")
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 0)
+}
+
 func (s *S) TestStop(c *check.C) {
 	a := App{Name: "app", TeamOwner: s.team.Name}
 	err := CreateApp(&a, s.user)
@@ -2644,6 +2769,191 @@ func (s *S) TestEnvs(c *check.C) {
 	c.Assert(env, check.DeepEquals, app.Env)
 }
 
+func (s *S) TestEnvsDecryptsSecrets(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	value, err := encryption.EncryptEnvelope("my-password")
+	c.Assert(err, check.IsNil)
+	app := App{
+		Name: "time",
+		Env: map[string]bind.EnvVar{
+			"PASSWORD": {Name: "PASSWORD", Value: value, Secret: true},
+		},
+	}
+	env := app.Envs()
+	c.Assert(env, check.DeepEquals, map[string]bind.EnvVar{
+		"PASSWORD": {Name: "PASSWORD", Value: "my-password", Secret: true},
+	})
+	c.Assert(app.Env["PASSWORD"].Value, check.Equals, value)
+}
+
+func (s *S) TestEnvsResolvesReferences(c *check.C) {
+	dir, err := ioutil.TempDir("", "secrets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "token"), []byte("abc123"), 0600)
+	c.Assert(err, check.IsNil)
+	config.Set("secret-providers:files:type", "file")
+	config.Set("secret-providers:files:path", dir)
+	config.Set("secret-providers:files:allowed-paths", []interface{}{"token"})
+	defer config.Unset("secret-providers")
+	app := App{
+		Name: "time",
+		Env: map[string]bind.EnvVar{
+			"TOKEN": {Name: "TOKEN", Value: "files://token"},
+			"URL":   {Name: "URL", Value: "http://example.com#top", Public: true},
+		},
+	}
+	c.Assert(app.Envs(), check.DeepEquals, map[string]bind.EnvVar{
+		"TOKEN": {Name: "TOKEN", Value: "files://token"},
+		"URL":   {Name: "URL", Value: "http://example.com#top", Public: true},
+	})
+	envs, err := app.UnitEnvs()
+	c.Assert(err, check.IsNil)
+	c.Assert(envs, check.DeepEquals, map[string]bind.EnvVar{
+		"TOKEN": {Name: "TOKEN", Value: "abc123"},
+		"URL":   {Name: "URL", Value: "http://example.com#top", Public: true},
+	})
+}
+
+func (s *S) TestUnitEnvsReferenceNotAllowed(c *check.C) {
+	dir, err := ioutil.TempDir("", "secrets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "other-team"), 0700)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "other-team", "token"), []byte("abc123"), 0600)
+	c.Assert(err, check.IsNil)
+	config.Set("secret-providers:files:type", "file")
+	config.Set("secret-providers:files:path", dir)
+	config.Set("secret-providers:files:allowed-paths", []interface{}{"{team}"})
+	defer config.Unset("secret-providers")
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "TOKEN", Value: "files://other-team/token"}},
+	}, nil)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	c.Assert(err, check.ErrorMatches, ".*secret path not allowed")
+	a.Env = map[string]bind.EnvVar{"TOKEN": {Name: "TOKEN", Value: "files://other-team/token"}}
+	_, err = a.UnitEnvs()
+	c.Assert(err, check.ErrorMatches, "unable to resolve env TOKEN of app myapp: .*secret path not allowed")
+}
+
+func (s *S) TestSetEnvsSecret(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "PASSWORD", Value: "123", Public: true, Secret: true}},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	env := newApp.Env["PASSWORD"]
+	c.Assert(env.Public, check.Equals, false)
+	c.Assert(env.Secret, check.Equals, true)
+	c.Assert(encryption.IsEncrypted(env.Value), check.Equals, true)
+	c.Assert(newApp.Envs()["PASSWORD"].Value, check.Equals, "123")
+}
+
+func (s *S) TestSetEnvsSecretWithoutKey(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "PASSWORD", Value: "123", Secret: true}},
+	}, nil)
+	c.Assert(err, check.Equals, encryption.ErrKeyNotConfigured)
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(newApp.Env, check.HasLen, 0)
+}
+
+func (s *S) TestSetEnvsSecretReference(c *check.C) {
+	dir, err := ioutil.TempDir("", "secrets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "db"), []byte(`{"password": "s3cr3t"}`), 0600)
+	c.Assert(err, check.IsNil)
+	config.Set("secret-providers:files:type", "file")
+	config.Set("secret-providers:files:path", dir)
+	config.Set("secret-providers:files:allowed-paths", []interface{}{"db"})
+	defer config.Unset("secret-providers")
+	a := App{Name: "myapp"}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "PASSWORD", Value: "files://db#password"}},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "USER", Value: "files://db#user"}},
+	}, nil)
+	c.Assert(err, check.FitsTypeOf, &errors.ValidationError{})
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(newApp.Env, check.DeepEquals, map[string]bind.EnvVar{
+		"PASSWORD": {Name: "PASSWORD", Value: "files://db#password"},
+	})
+	c.Assert(newApp.Envs()["PASSWORD"].Value, check.Equals, "files://db#password")
+	envs, err := newApp.UnitEnvs()
+	c.Assert(err, check.IsNil)
+	c.Assert(envs["PASSWORD"].Value, check.Equals, "s3cr3t")
+	err = ioutil.WriteFile(filepath.Join(dir, "db"), []byte(`{"user": "root"}`), 0600)
+	c.Assert(err, check.IsNil)
+	_, err = newApp.UnitEnvs()
+	c.Assert(err, check.ErrorMatches, "unable to resolve env PASSWORD of app myapp: .*")
+}
+
+func (s *S) TestRotateSecrets(c *check.C) {
+	config.Set("encryption:key", "old-key")
+	defer config.Unset("encryption:key")
+	defer config.Unset("encryption:previous-keys")
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{
+			{Name: "PASSWORD", Value: "123", Secret: true},
+			{Name: "HOST", Value: "localhost", Public: true},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	n, err := RotateSecrets()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	config.Set("encryption:key", "new-key")
+	config.Set("encryption:previous-keys", []interface{}{"old-key"})
+	n, err = RotateSecrets()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+	config.Unset("encryption:previous-keys")
+	newApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	envs, err := newApp.UnitEnvs()
+	c.Assert(err, check.IsNil)
+	c.Assert(envs["PASSWORD"].Value, check.Equals, "123")
+	c.Assert(envs["HOST"].Value, check.Equals, "localhost")
+	rev, err := GetEnvRevision(a.Name, 1)
+	c.Assert(err, check.IsNil)
+	password, err := encryption.Decrypt(rev.Env["PASSWORD"].Value)
+	c.Assert(err, check.IsNil)
+	c.Assert(password, check.Equals, "123")
+}
+
+func (s *S) TestUnitEnvsDecryptError(c *check.C) {
+	a := App{Name: "myapp", Env: map[string]bind.EnvVar{
+		"PASSWORD": {Name: "PASSWORD", Value: "invalid", Secret: true},
+		"HOST":     {Name: "HOST", Value: "localhost", Public: true},
+	}}
+	_, err := a.UnitEnvs()
+	c.Assert(err, check.ErrorMatches, "unable to decrypt secret env PASSWORD of app myapp: .*")
+}
+
 func (s *S) TestListReturnsAppsForAGivenUser(c *check.C) {
 	a := App{
 		Name:  "testapp",
@@ -3945,6 +4255,45 @@ func (s *S) TestUpdatePoolNotExists(c *check.C) {
 	c.Assert(dbApp.Pool, check.Equals, "test")
 }
 
+func (s *S) TestCreateAppPoolConstraint(c *check.C) {
+	err := provision.PoolUpdate(s.Pool, provision.UpdatePoolOptions{Constraints: map[string]*provision.PoolConstraint{
+		provision.PoolConstraintPlan: {Values: []string{s.defaultPlan.Name}, Blacklist: true},
+	}})
+	c.Assert(err, check.IsNil)
+	app := App{Name: "test", TeamOwner: s.team.Name}
+	err = CreateApp(&app, s.user)
+	c.Assert(err, check.DeepEquals, &provision.PoolConstraintError{
+		Pool:  s.Pool,
+		Field: provision.PoolConstraintPlan,
+		Value: s.defaultPlan.Name,
+	})
+	_, err = GetByName(app.Name)
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestUpdatePoolConstraint(c *check.C) {
+	opts := provision.AddPoolOptions{Name: "test", Public: true}
+	err := provision.AddPool(opts)
+	c.Assert(err, check.IsNil)
+	err = provision.PoolUpdate("test", provision.UpdatePoolOptions{Constraints: map[string]*provision.PoolConstraint{
+		provision.PoolConstraintRouter: {Values: []string{"internal"}},
+	}})
+	c.Assert(err, check.IsNil)
+	app := App{Name: "test", TeamOwner: s.team.Name}
+	err = CreateApp(&app, s.user)
+	c.Assert(err, check.IsNil)
+	updateData := App{Name: "test", Pool: "test"}
+	err = app.Update(updateData, new(bytes.Buffer))
+	c.Assert(err, check.DeepEquals, &provision.PoolConstraintError{
+		Pool:  "test",
+		Field: provision.PoolConstraintRouter,
+		Value: "fake",
+	})
+	dbApp, err := GetByName(app.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, s.Pool)
+}
+
 func (s *S) TestUpdatePlan(c *check.C) {
 	plan := Plan{Name: "something", Router: "fake-hc", CpuShare: 100, Memory: 268435456}
 	err := s.conn.Plans().Insert(plan)
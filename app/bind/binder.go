@@ -6,14 +6,80 @@
 // service.
 package bind
 
-import "io"
+import (
+	"encoding/json"
+	"io"
+)
+
+// TsuruServicesEnvVar is the name of the variable describing every service
+// instance bound to the app.
+const TsuruServicesEnvVar = "TSURU_SERVICES"
 
 // EnvVar represents a environment variable for an app.
 type EnvVar struct {
-	Name         string `json:"name"`
-	Value        string `json:"value"`
-	Public       bool   `json:"public"`
-	InstanceName string `json:"-"`
+	Name         string   `json:"name"`
+	Value        string   `json:"value"`
+	Public       bool     `json:"public"`
+	InstanceName string   `json:"-"`
+	Processes    []string `json:"processes,omitempty"`
+	Secret       bool     `json:"secret,omitempty"`
+}
+
+// AppliesTo returns whether the variable must be set in units of the given
+// process. Variables not restricted to any process apply to all of them.
+func (e *EnvVar) AppliesTo(process string) bool {
+	return appliesTo(e.Processes, process)
+}
+
+func appliesTo(processes []string, process string) bool {
+	if len(processes) == 0 {
+		return true
+	}
+	for _, p := range processes {
+		if p == process {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessEnvs returns the variables units of the given process must be
+// started with. Instances bound only to other processes are left out of the
+// TSURU_SERVICES variable, so their credentials don't reach the process.
+func ProcessEnvs(envs map[string]EnvVar, process string) []EnvVar {
+	result := make([]EnvVar, 0, len(envs))
+	for _, env := range envs {
+		if !env.AppliesTo(process) {
+			continue
+		}
+		if env.Name == TsuruServicesEnvVar {
+			env.Value = processServicesValue(env.Value, process)
+		}
+		result = append(result, env)
+	}
+	return result
+}
+
+func processServicesValue(value, process string) string {
+	var services map[string][]ServiceInstance
+	err := json.Unmarshal([]byte(value), &services)
+	if err != nil {
+		return value
+	}
+	for name, instances := range services {
+		filtered := make([]ServiceInstance, 0, len(instances))
+		for _, instance := range instances {
+			if appliesTo(instance.Processes, process) {
+				filtered = append(filtered, instance)
+			}
+		}
+		services[name] = filtered
+	}
+	data, err := json.Marshal(services)
+	if err != nil {
+		return value
+	}
+	return string(data)
 }
 
 // Unit represents an application unit to be used in binds.
@@ -29,6 +95,9 @@ type App interface {
 	// GetName returns the app name.
 	GetName() string
 
+	// GetPool returns the name of the pool of the app.
+	GetPool() string
+
 	// GetUnits returns the app units.
 	GetUnits() ([]Unit, error)
 
@@ -49,20 +118,23 @@ type App interface {
 }
 
 type ServiceInstance struct {
-	Name string            `json:"instance_name"`
-	Envs map[string]string `json:"envs"`
+	Name      string            `json:"instance_name"`
+	Envs      map[string]string `json:"envs"`
+	Processes []string          `json:"processes,omitempty"`
 }
 
 type SetEnvApp struct {
 	Envs          []EnvVar
 	PublicOnly    bool
 	ShouldRestart bool
+	Owner         string
 }
 
 type UnsetEnvApp struct {
 	VariableNames []string
 	PublicOnly    bool
 	ShouldRestart bool
+	Owner         string
 }
 
 type InstanceApp struct {
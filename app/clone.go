@@ -0,0 +1,184 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/encryption"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+)
+
+// CloneOptions are the options used to clone an app. TeamOwner defaults to
+// the team owner of the source app, Envs override or add environment
+// variables and BindServices binds the clone to the service instances bound
+// to the source app.
+type CloneOptions struct {
+	Name         string
+	TeamOwner    string
+	Envs         map[string]string
+	BindServices bool
+	Writer       io.Writer
+}
+
+// Clone creates a new app with the platform, plan, pool, team access and
+// environment variables of the app, deploying its current image on a deploy
+// event of the clone. Secret environment variables are copied decrypted, so
+// callers must ensure the user is allowed to read them, see HasSecretEnvs.
+// The clone is kept when a step after its creation fails, so it's returned
+// along with the error.
+func (app *App) Clone(opts CloneOptions, user *auth.User) (*App, error) {
+	if opts.Writer == nil {
+		opts.Writer = ioutil.Discard
+	}
+	envs, err := app.cloneEnvs(opts.Envs)
+	if err != nil {
+		return nil, err
+	}
+	cloned := &App{
+		Name:        opts.Name,
+		Platform:    app.Platform,
+		Plan:        Plan{Name: app.Plan.Name},
+		Pool:        app.Pool,
+		TeamOwner:   opts.TeamOwner,
+		Description: app.Description,
+		RouterOpts:  app.RouterOpts,
+	}
+	if cloned.TeamOwner == "" {
+		cloned.TeamOwner = app.TeamOwner
+	}
+	err = CreateApp(cloned, user)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(opts.Writer, "---- Cloning app %q to %q ----\n", app.Name, cloned.Name)
+	for _, teamName := range app.Teams {
+		team, err := auth.GetTeam(teamName)
+		if err == auth.ErrTeamNotFound {
+			continue
+		}
+		if err != nil {
+			return cloned, err
+		}
+		err = cloned.Grant(team)
+		if err != nil && err != ErrAlreadyHaveAccess {
+			return cloned, err
+		}
+	}
+	err = cloned.SetEnvs(bind.SetEnvApp{Envs: envs, Owner: user.Email}, opts.Writer)
+	if err != nil {
+		return cloned, err
+	}
+	if opts.BindServices {
+		instances, err := app.serviceInstances()
+		if err != nil {
+			return cloned, err
+		}
+		for _, si := range instances {
+			err = si.BindAppProcesses(cloned, si.AppProcesses[app.Name], false, opts.Writer)
+			if err != nil {
+				return cloned, err
+			}
+		}
+	}
+	if app.Deploys == 0 {
+		return cloned, nil
+	}
+	img, err := image.AppCurrentImageName(app.Name)
+	if err != nil {
+		return cloned, err
+	}
+	return cloned, cloned.deployClonedImage(img, user, opts.Writer)
+}
+
+func (app *App) deployClonedImage(img string, user *auth.User, w io.Writer) (err error) {
+	opts := DeployOptions{
+		App:          app,
+		Image:        img,
+		OutputStream: w,
+		User:         user.Email,
+		Origin:       "image",
+	}
+	opts.GetKind()
+	var imageID string
+	evt, err := event.New(&event.Opts{
+		Target:     event.Target{Type: event.TargetTypeApp, Value: app.Name},
+		Kind:       permission.PermAppDeploy,
+		RawOwner:   event.Owner{Type: event.OwnerTypeUser, Name: user.Email},
+		CustomData: opts,
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			append(permission.Contexts(permission.CtxTeam, app.Teams),
+				permission.Context(permission.CtxApp, app.Name),
+				permission.Context(permission.CtxPool, app.Pool),
+			)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.DoneCustomData(err, map[string]string{"image": imageID}) }()
+	opts.Event = evt
+	imageID, err = Deploy(opts)
+	return err
+}
+
+// HasSecretEnvs returns whether the app has secret environment variables
+// that would be copied by Clone.
+func (app *App) HasSecretEnvs() bool {
+	for _, env := range app.Env {
+		if env.Secret && !isManagedEnv(env) {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneEnvs returns the environment variables of the app that are not managed
+// by tsuru, with secret values decrypted, merged with the given overrides.
+// Overrides of variables managed by tsuru are ignored.
+func (app *App) cloneEnvs(overrides map[string]string) ([]bind.EnvVar, error) {
+	envs := make(map[string]bind.EnvVar)
+	for name, env := range app.Env {
+		if isManagedEnv(env) {
+			continue
+		}
+		if env.Secret {
+			value, err := encryption.Decrypt(env.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to decrypt secret env %s of app %s", name, app.Name)
+			}
+			env.Value = value
+		}
+		envs[name] = env
+	}
+	for name, value := range overrides {
+		if isManagedEnv(bind.EnvVar{Name: name}) {
+			continue
+		}
+		env, ok := envs[name]
+		if !ok {
+			env = bind.EnvVar{Name: name, Public: true}
+		}
+		env.Value = value
+		envs[name] = env
+	}
+	names := make([]string, 0, len(envs))
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]bind.EnvVar, len(names))
+	for i, name := range names {
+		result[i] = envs[name]
+	}
+	return result, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/service"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestClone(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	otherTeam := auth.Team{Name: "staging"}
+	err := s.conn.Teams().Insert(otherTeam)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Description: "my app"}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.Grant(&otherTeam)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{
+		{Name: "DATABASE_NAME", Value: "prod", Public: true},
+		{Name: "WORKERS", Value: "4", Public: true},
+		{Name: "API_KEY", Value: "s3cr3t", Secret: true},
+	}}, nil)
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.Name, "tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	a.Deploys = 1
+	var buf bytes.Buffer
+	cloned, err := a.Clone(CloneOptions{
+		Name:   "myapp-staging",
+		Envs:   map[string]string{"DATABASE_NAME": "staging", "TSURU_APPNAME": "other"},
+		Writer: &buf,
+	}, s.user)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(cloned.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Platform, check.Equals, a.Platform)
+	c.Assert(dbApp.Plan, check.DeepEquals, a.Plan)
+	c.Assert(dbApp.Pool, check.Equals, a.Pool)
+	c.Assert(dbApp.TeamOwner, check.Equals, s.team.Name)
+	c.Assert(dbApp.Description, check.Equals, "my app")
+	c.Assert(dbApp.Teams, check.DeepEquals, []string{s.team.Name, otherTeam.Name})
+	envs := dbApp.Envs()
+	c.Assert(envs["DATABASE_NAME"].Value, check.Equals, "staging")
+	c.Assert(envs["WORKERS"].Value, check.Equals, "4")
+	c.Assert(envs["API_KEY"].Value, check.Equals, "s3cr3t")
+	c.Assert(envs["API_KEY"].Secret, check.Equals, true)
+	c.Assert(envs["TSURU_APPNAME"].Value, check.Equals, "myapp-staging")
+	c.Assert(dbApp.Deploys, check.Equals, uint(1))
+	c.Assert(buf.String(), check.Matches, `(?s).*Cloning app "myapp" to "myapp-staging".*Image deploy called.*`)
+}
+
+func (s *S) TestCloneBindServices(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"DATABASE_HOST":"localhost"}`))
+	}))
+	defer ts.Close()
+	srvc := service.Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := srvc.Create()
+	c.Assert(err, check.IsNil)
+	defer s.conn.Services().Remove(bson.M{"_id": "mysql"})
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	instance := service.ServiceInstance{
+		Name:        "my-inst",
+		ServiceName: "mysql",
+		Teams:       []string{s.team.Name},
+		Apps:        []string{a.Name},
+	}
+	err = instance.Create()
+	c.Assert(err, check.IsNil)
+	cloned, err := a.Clone(CloneOptions{Name: "myapp-staging", BindServices: true}, s.user)
+	c.Assert(err, check.IsNil)
+	si, err := service.GetServiceInstance("mysql", "my-inst")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.Apps, check.DeepEquals, []string{a.Name, cloned.Name})
+	dbApp, err := GetByName(cloned.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["DATABASE_HOST"].Value, check.Equals, "localhost")
+}
+
+func (s *S) TestCloneAlreadyExists(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = a.Clone(CloneOptions{Name: "myapp"}, s.user)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*AppCreationError)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Err, check.Equals, ErrAppAlreadyExists)
+}
+
+func (s *S) TestCloneSecretEnvDecryptError(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "API_KEY", Value: "s3cr3t", Secret: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.HasSecretEnvs(), check.Equals, true)
+	config.Set("encryption:key", "other-key")
+	_, err = a.Clone(CloneOptions{Name: "myapp-staging"}, s.user)
+	c.Assert(err, check.ErrorMatches, "unable to decrypt secret env API_KEY of app myapp: .*")
+	_, err = GetByName("myapp-staging")
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
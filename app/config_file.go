@@ -0,0 +1,264 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	defaultConfigFileMaxSize       = 64 * 1024
+	defaultConfigFilesMaxTotalSize = 256 * 1024
+
+	// configFileSizeLimit caps config-files:max-size, each version of a file
+	// is stored in a single MongoDB document, which can't exceed 16MiB.
+	configFileSizeLimit = 15 * 1024 * 1024
+)
+
+var (
+	configFileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,99}$`)
+
+	ErrConfigFileNotFound    = errors.New("config file not found")
+	ErrInvalidConfigFileName = &tsuruErrors.ValidationError{Message: "Invalid config file name, it must start with a letter or number and contain only letters, numbers, dots, underscores and dashes."}
+)
+
+// ConfigFile is a version of a named configuration file of an app. Every
+// update creates a new version, and provisioners place the latest version of
+// each file in the units of the app.
+type ConfigFile struct {
+	App       string    `json:"-"`
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Content   []byte    `json:"-"`
+	Size      int       `json:"size"`
+	Owner     string    `json:"owner"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func configFileSizeLimits() (int, int) {
+	maxSize, err := config.GetInt("config-files:max-size")
+	if err != nil || maxSize <= 0 {
+		maxSize = defaultConfigFileMaxSize
+	}
+	if maxSize > configFileSizeLimit {
+		maxSize = configFileSizeLimit
+	}
+	maxTotal, err := config.GetInt("config-files:max-total-size")
+	if err != nil || maxTotal <= 0 {
+		maxTotal = defaultConfigFilesMaxTotalSize
+	}
+	return maxSize, maxTotal
+}
+
+// SetConfigFile stores a new version of the named config file, restarting the
+// app when shouldRestart is true.
+func (app *App) SetConfigFile(name string, content []byte, owner string, shouldRestart bool, w io.Writer) (*ConfigFile, error) {
+	if shouldRestart && app.Deletion != nil {
+		return nil, ErrAppDeleted
+	}
+	if !configFileNameRegexp.MatchString(name) {
+		return nil, ErrInvalidConfigFileName
+	}
+	maxSize, maxTotal := configFileSizeLimits()
+	if len(content) > maxSize {
+		return nil, &tsuruErrors.ValidationError{
+			Message: fmt.Sprintf("config file %q is too large: %d bytes, the limit is %d bytes", name, len(content), maxSize),
+		}
+	}
+	current, err := app.ListConfigFiles()
+	if err != nil {
+		return nil, err
+	}
+	total := len(content)
+	for _, f := range current {
+		if f.Name != name {
+			total += f.Size
+		}
+	}
+	if total > maxTotal {
+		return nil, &tsuruErrors.ValidationError{
+			Message: fmt.Sprintf("config files of app %q would use %d bytes, the limit is %d bytes", app.Name, total, maxTotal),
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	coll := conn.ConfigFiles()
+	file := ConfigFile{
+		App:       app.Name,
+		Name:      name,
+		Content:   content,
+		Size:      len(content),
+		Owner:     owner,
+		Timestamp: time.Now().UTC(),
+	}
+	for retries := 0; ; retries++ {
+		var last ConfigFile
+		err = coll.Find(bson.M{"app": app.Name, "name": name}).Sort("-version").Select(bson.M{"version": 1}).One(&last)
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+		file.Version = last.Version + 1
+		err = coll.Insert(file)
+		if !mgo.IsDup(err) || retries == 3 {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w != nil {
+		fmt.Fprintf(w, "---- Config file %q updated to version %d ----\n", name, file.Version)
+	}
+	return &file, app.restartForConfigFiles(shouldRestart, w)
+}
+
+// RemoveConfigFile removes all versions of the named config file, restarting
+// the app when shouldRestart is true.
+func (app *App) RemoveConfigFile(name string, shouldRestart bool, w io.Writer) error {
+	if shouldRestart && app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	info, err := conn.ConfigFiles().RemoveAll(bson.M{"app": app.Name, "name": name})
+	if err != nil {
+		return err
+	}
+	if info.Removed == 0 {
+		return ErrConfigFileNotFound
+	}
+	if w != nil {
+		fmt.Fprintf(w, "---- Config file %q removed ----\n", name)
+	}
+	return app.restartForConfigFiles(shouldRestart, w)
+}
+
+func (app *App) restartForConfigFiles(shouldRestart bool, w io.Writer) error {
+	if !shouldRestart {
+		return nil
+	}
+	units, err := app.GetUnits()
+	if err != nil || len(units) == 0 {
+		return err
+	}
+	prov, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	return prov.Restart(app, "", w)
+}
+
+func (app *App) latestConfigFiles(withContent bool) ([]ConfigFile, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := conn.ConfigFiles().Find(bson.M{"app": app.Name}).Sort("name", "-version")
+	if !withContent {
+		query = query.Select(bson.M{"content": 0})
+	}
+	var files []ConfigFile
+	err = query.All(&files)
+	if err != nil {
+		return nil, err
+	}
+	var latest []ConfigFile
+	for _, f := range files {
+		if len(latest) == 0 || latest[len(latest)-1].Name != f.Name {
+			latest = append(latest, f)
+		}
+	}
+	return latest, nil
+}
+
+// ListConfigFiles returns the latest version of each config file of the app,
+// sorted by name, without their content.
+func (app *App) ListConfigFiles() ([]ConfigFile, error) {
+	return app.latestConfigFiles(false)
+}
+
+// ConfigFileVersions returns all versions of the named config file, newest
+// first, without their content.
+func (app *App) ConfigFileVersions(name string) ([]ConfigFile, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var files []ConfigFile
+	err = conn.ConfigFiles().Find(bson.M{"app": app.Name, "name": name}).Sort("-version").Select(bson.M{"content": 0}).All(&files)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, ErrConfigFileNotFound
+	}
+	return files, nil
+}
+
+// GetConfigFile returns the given version of the named config file, or the
+// latest one when version is 0.
+func (app *App) GetConfigFile(name string, version int) (*ConfigFile, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := bson.M{"app": app.Name, "name": name}
+	if version > 0 {
+		query["version"] = version
+	}
+	var file ConfigFile
+	err = conn.ConfigFiles().Find(query).Sort("-version").One(&file)
+	if err == mgo.ErrNotFound {
+		return nil, ErrConfigFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// ConfigFiles returns the latest version of each config file of the app, to
+// be placed in its units by the provisioner.
+func (app *App) ConfigFiles() ([]provision.ConfigFile, error) {
+	files, err := app.latestConfigFiles(true)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]provision.ConfigFile, len(files))
+	for i, f := range files {
+		result[i] = provision.ConfigFile{Name: f.Name, Content: f.Content}
+	}
+	return result, nil
+}
+
+func removeConfigFiles(appName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.ConfigFiles().RemoveAll(bson.M{"app": appName})
+	return err
+}
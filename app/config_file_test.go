@@ -0,0 +1,134 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tsuru/config"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSetConfigFile(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	file, err := a.SetConfigFile("nginx.conf", []byte("v1"), "me@example.com", true, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(file.Version, check.Equals, 1)
+	var buf bytes.Buffer
+	file, err = a.SetConfigFile("nginx.conf", []byte("v2"), "me@example.com", true, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(file.Version, check.Equals, 2)
+	c.Assert(buf.String(), check.Equals, "---- Config file \"nginx.conf\" updated to version 2 ----\n")
+	_, err = a.SetConfigFile("settings.json", []byte("{}"), "other@example.com", false, nil)
+	c.Assert(err, check.IsNil)
+	files, err := a.ListConfigFiles()
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 2)
+	c.Assert(files[0].Name, check.Equals, "nginx.conf")
+	c.Assert(files[0].Version, check.Equals, 2)
+	c.Assert(files[0].Size, check.Equals, 2)
+	c.Assert(files[0].Content, check.IsNil)
+	c.Assert(files[1].Name, check.Equals, "settings.json")
+	c.Assert(files[1].Owner, check.Equals, "other@example.com")
+	versions, err := a.ConfigFileVersions("nginx.conf")
+	c.Assert(err, check.IsNil)
+	c.Assert(versions, check.HasLen, 2)
+	c.Assert(versions[0].Version, check.Equals, 2)
+	old, err := a.GetConfigFile("nginx.conf", 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(old.Content), check.Equals, "v1")
+	latest, err := a.GetConfigFile("nginx.conf", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(latest.Content), check.Equals, "v2")
+	provFiles, err := a.ConfigFiles()
+	c.Assert(err, check.IsNil)
+	c.Assert(provFiles, check.DeepEquals, []provision.ConfigFile{
+		{Name: "nginx.conf", Content: []byte("v2")},
+		{Name: "settings.json", Content: []byte("{}")},
+	})
+}
+
+func (s *S) TestSetConfigFileRestartsApp(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(1, "", nil)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("nginx.conf", []byte("v1"), "me@example.com", true, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 1)
+	_, err = a.SetConfigFile("nginx.conf", []byte("v2"), "me@example.com", false, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 1)
+	err = a.RemoveConfigFile("nginx.conf", true, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 2)
+	_, err = a.GetConfigFile("nginx.conf", 0)
+	c.Assert(err, check.Equals, ErrConfigFileNotFound)
+	err = a.RemoveConfigFile("nginx.conf", true, nil)
+	c.Assert(err, check.Equals, ErrConfigFileNotFound)
+}
+
+func (s *S) TestSetConfigFileInvalidName(c *check.C) {
+	a := App{Name: "myapp"}
+	for _, name := range []string{"", ".hidden", "../etc/passwd", "a/b", "a b", "it's"} {
+		_, err := a.SetConfigFile(name, []byte("x"), "", false, nil)
+		c.Check(err, check.Equals, ErrInvalidConfigFileName, check.Commentf("name: %q", name))
+	}
+}
+
+func (s *S) TestSetConfigFileSizeLimits(c *check.C) {
+	config.Set("config-files:max-size", 10)
+	config.Set("config-files:max-total-size", 15)
+	defer config.Unset("config-files")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("big.conf", []byte(strings.Repeat("a", 11)), "", false, nil)
+	c.Assert(err, check.FitsTypeOf, &tsuruErrors.ValidationError{})
+	c.Assert(err, check.ErrorMatches, `config file "big.conf" is too large: 11 bytes, the limit is 10 bytes`)
+	_, err = a.SetConfigFile("a.conf", []byte(strings.Repeat("a", 10)), "", false, nil)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("a.conf", []byte(strings.Repeat("b", 10)), "", false, nil)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("b.conf", []byte(strings.Repeat("a", 6)), "", false, nil)
+	c.Assert(err, check.ErrorMatches, `config files of app "myapp" would use 16 bytes, the limit is 15 bytes`)
+}
+
+func (s *S) TestSetConfigFileNearSizeLimit(c *check.C) {
+	config.Set("config-files:max-size", 64*1024*1024)
+	config.Set("config-files:max-total-size", 64*1024*1024)
+	defer config.Unset("config-files")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	content := []byte(strings.Repeat("a", configFileSizeLimit))
+	_, err = a.SetConfigFile("big.conf", content, "", false, nil)
+	c.Assert(err, check.IsNil)
+	files, err := a.ConfigFiles()
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 1)
+	c.Assert(files[0].Content, check.DeepEquals, content)
+	_, err = a.SetConfigFile("big.conf", append(content, 'a'), "", false, nil)
+	c.Assert(err, check.ErrorMatches, `config file "big.conf" is too large: 15728641 bytes, the limit is 15728640 bytes`)
+}
+
+func (s *S) TestDeleteRemovesConfigFiles(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("nginx.conf", []byte("v1"), "", false, nil)
+	c.Assert(err, check.IsNil)
+	err = Delete(&a, nil)
+	c.Assert(err, check.IsNil)
+	n, err := s.conn.ConfigFiles().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseCronMatches(c *check.C) {
+	tests := []struct {
+		expr    string
+		time    string
+		matches bool
+	}{
+		{"* * * * *", "2017-03-01T10:15:00Z", true},
+		{"0 8 * * *", "2017-03-01T08:00:00Z", true},
+		{"0 8 * * *", "2017-03-01T08:01:00Z", false},
+		{"*/15 * * * *", "2017-03-01T10:45:00Z", true},
+		{"*/15 * * * *", "2017-03-01T10:46:00Z", false},
+		{"0 8-20/4 * * *", "2017-03-01T16:00:00Z", true},
+		{"0 8-20/4 * * *", "2017-03-01T22:00:00Z", false},
+		{"30 6 * * 1-5", "2017-03-03T06:30:00Z", true},
+		{"30 6 * * 1-5", "2017-03-04T06:30:00Z", false},
+		{"0 0 * * 7", "2017-03-05T00:00:00Z", true},
+		{"0 0 1,15 * *", "2017-03-15T00:00:00Z", true},
+		{"0 0 1 * 1", "2017-03-06T00:00:00Z", true},
+		{"0 0 1 * 1", "2017-03-07T00:00:00Z", false},
+		{"0 0 * 2 *", "2017-03-01T00:00:00Z", false},
+	}
+	for _, tt := range tests {
+		sched, err := parseCron(tt.expr)
+		c.Assert(err, check.IsNil)
+		t, err := time.Parse(time.RFC3339, tt.time)
+		c.Assert(err, check.IsNil)
+		c.Check(sched.matches(t), check.Equals, tt.matches, check.Commentf("%s at %s", tt.expr, tt.time))
+	}
+}
+
+func (s *S) TestParseCronInvalid(c *check.C) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"a * * * *",
+		"5-1 * * * *",
+	}
+	for _, expr := range tests {
+		_, err := parseCron(expr)
+		c.Check(err, check.NotNil, check.Commentf(expr))
+	}
+}
+
+func (s *S) TestCronLastFire(c *check.C) {
+	sched, err := parseCron("0 8,20 * * *")
+	c.Assert(err, check.IsNil)
+	from := time.Date(2017, 3, 1, 7, 0, 0, 0, time.UTC)
+	fire, ok := sched.lastFire(from, from.Add(14*time.Hour), time.UTC)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(fire.Equal(time.Date(2017, 3, 1, 20, 0, 0, 0, time.UTC)), check.Equals, true)
+	_, ok = sched.lastFire(from, from.Add(time.Hour-time.Second), time.UTC)
+	c.Assert(ok, check.Equals, false)
+	loc := time.FixedZone("BRT", -3*3600)
+	fire, ok = sched.lastFire(from, from.Add(5*time.Hour), loc)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(fire.Equal(time.Date(2017, 3, 1, 11, 0, 0, 0, time.UTC)), check.Equals, true)
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrAppDeletionProtected = &tsuruErrors.ValidationError{Message: "app is protected against deletion, the protection must be disabled first"}
+	ErrAppNotDeleted        = &tsuruErrors.ValidationError{Message: "app is not deleted"}
+	ErrAppDeleted           = errors.New("app is deleted, it must be restored first")
+)
+
+// Deletion holds the soft deletion of an app: who deleted it, when, and when
+// it's going to be purged.
+type Deletion struct {
+	Owner   string    `json:"owner"`
+	Date    time.Time `json:"date"`
+	PurgeAt time.Time `json:"purgeAt"`
+}
+
+// SoftDeleteGracePeriod returns the time soft deleted apps are kept before
+// being purged, read from the apps:soft-delete:grace-period config, in
+// seconds. Soft deletion is disabled when it's zero, the default.
+func SoftDeleteGracePeriod() time.Duration {
+	seconds, _ := config.GetInt("apps:soft-delete:grace-period")
+	return time.Duration(seconds) * time.Second
+}
+
+// SetDeletionProtection enables or disables the deletion protection of the
+// app. Protected apps can't be deleted.
+func (app *App) SetDeletionProtection(enabled bool) error {
+	if enabled && app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"deletionprotection": enabled}})
+	if err != nil {
+		return err
+	}
+	app.DeletionProtection = enabled
+	return nil
+}
+
+// SoftDelete stops the units of the app and removes its routes, keeping
+// everything else until the app is purged after the soft delete grace
+// period. The app may be restored until then.
+func (app *App) SoftDelete(owner string, w io.Writer) error {
+	if app.DeletionProtection {
+		return ErrAppDeletionProtected
+	}
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	now := time.Now().UTC()
+	deletion := Deletion{Owner: owner, Date: now, PurgeAt: now.Add(SoftDeleteGracePeriod())}
+	fmt.Fprintf(w, "---- Deleting app %q, it may be restored until %s ----\n", app.Name, deletion.PurgeAt.Format(time.RFC3339))
+	err := app.Stop(w, "")
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"deletion": deletion}})
+	if err != nil {
+		return err
+	}
+	app.Deletion = &deletion
+	result, err := rebuild.RebuildRoutes(app)
+	if err != nil {
+		log.Errorf("[soft-delete] unable to remove routes of app %s: %s", app.Name, err)
+		fmt.Fprintf(w, "Unable to remove routes: %s\n", err)
+	} else {
+		fmt.Fprintf(w, "Routes removed: %v\n", result.Removed)
+	}
+	app.recordUsage(true)
+	return nil
+}
+
+// Restore undoes the soft deletion of the app, starting its units and
+// restoring its routes.
+func (app *App) Restore(w io.Writer) error {
+	if app.Deletion == nil {
+		return ErrAppNotDeleted
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "---- Restoring app %q ----\n", app.Name)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$unset": bson.M{"deletion": ""}})
+	if err != nil {
+		return err
+	}
+	app.Deletion = nil
+	err = app.Start(w, "")
+	if err != nil {
+		return err
+	}
+	result, err := rebuild.RebuildRoutes(app)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Routes added: %v\n", result.Added)
+	app.recordUsage(false)
+	return nil
+}
+
+func (app *App) purge() (err error) {
+	locked, err := app.InternalLock("purge")
+	if err != nil {
+		return err
+	}
+	if !locked {
+		log.Debugf("[purge] app %s is locked, skipping", app.Name)
+		return nil
+	}
+	defer app.Unlock()
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: app.Name},
+		InternalKind: "app-purge",
+		CustomData:   app.Deletion,
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			append(permission.Contexts(permission.CtxTeam, app.Teams),
+				permission.Context(permission.CtxApp, app.Name),
+				permission.Context(permission.CtxPool, app.Pool),
+			)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	return Delete(app, evt)
+}
+
+// purgeDeletedApps deletes the soft deleted apps whose grace period is over.
+func purgeDeletedApps() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"deletion.purgeat": bson.M{"$lte": time.Now().UTC()}}).All(&apps)
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		err = apps[i].purge()
+		if err != nil {
+			log.Errorf("[purge] unable to purge app %s: %s", apps[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// DeletedAppsPurger periodically purges soft deleted apps.
+type DeletedAppsPurger struct {
+	interval time.Duration
+	done     chan bool
+}
+
+// StartDeletedAppsPurger starts the purger of soft deleted apps. The check
+// interval is read from the apps:soft-delete:purge-interval config, in
+// seconds, and defaults to ten minutes.
+func StartDeletedAppsPurger() *DeletedAppsPurger {
+	interval, _ := config.GetInt("apps:soft-delete:purge-interval")
+	p := &DeletedAppsPurger{
+		interval: time.Duration(interval) * time.Second,
+		done:     make(chan bool),
+	}
+	if p.interval == 0 {
+		p.interval = 10 * time.Minute
+	}
+	go p.run()
+	return p
+}
+
+func (p *DeletedAppsPurger) run() {
+	for {
+		err := purgeDeletedApps()
+		if err != nil {
+			log.Errorf("[purge] %s", err)
+		}
+		select {
+		case <-p.done:
+			return
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+func (p *DeletedAppsPurger) Shutdown() {
+	p.done <- true
+}
+
+func (p *DeletedAppsPurger) String() string {
+	return "deleted apps purger"
+}
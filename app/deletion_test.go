@@ -0,0 +1,181 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"net/url"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestSetDeletionProtection(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetDeletionProtection(true)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.DeletionProtection, check.Equals, true)
+	err = dbApp.SoftDelete(s.user.Email, nil)
+	c.Assert(err, check.Equals, ErrAppDeletionProtected)
+	err = dbApp.SetDeletionProtection(false)
+	c.Assert(err, check.IsNil)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.DeletionProtection, check.Equals, false)
+}
+
+func (s *S) TestSoftDelete(c *check.C) {
+	config.Set("apps:soft-delete:grace-period", 3600)
+	defer config.Unset("apps:soft-delete:grace-period")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Plan: Plan{Router: "fake"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	_, err = rebuild.RebuildRoutes(&a)
+	c.Assert(err, check.IsNil)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 2)
+	var buf bytes.Buffer
+	err = a.SoftDelete(s.user.Email, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s)---- Deleting app "myapp", it may be restored until .*`)
+	routes, err = routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 0)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	for _, u := range units {
+		c.Assert(u.Status, check.Equals, provision.StatusStopped)
+	}
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Deletion, check.NotNil)
+	c.Assert(dbApp.Deletion.Owner, check.Equals, s.user.Email)
+	c.Assert(dbApp.Deletion.PurgeAt.Sub(dbApp.Deletion.Date), check.Equals, time.Hour)
+	err = dbApp.SoftDelete(s.user.Email, nil)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = dbApp.Start(nil, "")
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = dbApp.SetDeletionProtection(true)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	apps, err := List(&Filter{Deleted: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(apps, check.HasLen, 1)
+	c.Assert(apps[0].Name, check.Equals, a.Name)
+}
+
+func (s *S) TestSoftDeletedAppRejectsRestarts(c *check.C) {
+	config.Set("apps:soft-delete:grace-period", 3600)
+	defer config.Unset("apps:soft-delete:grace-period")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	other := App{Name: "otherapp", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&other, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "WORKERS", Value: "2", Public: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.SoftDelete(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	err = a.Restart("", nil)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "WORKERS", Value: "4", Public: true}}, ShouldRestart: true}, nil)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = a.UnsetEnvs(bind.UnsetEnvApp{VariableNames: []string{"WORKERS"}, ShouldRestart: true}, nil)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = a.RollbackEnv(1, s.user.Email, true, nil)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	_, err = a.SetConfigFile("app.conf", []byte("x"), s.user.Email, true, nil)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = Swap(&a, &other, false)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = Swap(&other, &a, true)
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = a.Sleep(nil, "", &url.URL{Scheme: "http", Host: "proxy:1234"})
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	err = a.Wake()
+	c.Assert(err, check.Equals, ErrAppDeleted)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 0)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{{Name: "WORKERS", Value: "4", Public: true}}}, nil)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["WORKERS"].Value, check.Equals, "4")
+}
+
+func (s *S) TestRestore(c *check.C) {
+	config.Set("apps:soft-delete:grace-period", 3600)
+	defer config.Unset("apps:soft-delete:grace-period")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Plan: Plan{Router: "fake"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	err = a.SoftDelete(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = a.Restore(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s)---- Restoring app "myapp" ----.*`)
+	c.Assert(a.Deletion, check.IsNil)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 2)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	for _, u := range units {
+		c.Assert(u.Status, check.Equals, provision.StatusStarted)
+	}
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Deletion, check.IsNil)
+	err = dbApp.Restore(nil)
+	c.Assert(err, check.Equals, ErrAppNotDeleted)
+}
+
+func (s *S) TestPurgeDeletedApps(c *check.C) {
+	config.Set("apps:soft-delete:grace-period", 3600)
+	defer config.Unset("apps:soft-delete:grace-period")
+	expired := App{Name: "expired", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&expired, s.user)
+	c.Assert(err, check.IsNil)
+	err = expired.SoftDelete(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	err = s.conn.Apps().Update(bson.M{"name": expired.Name}, bson.M{"$set": bson.M{"deletion.purgeat": time.Now().UTC().Add(-time.Minute)}})
+	c.Assert(err, check.IsNil)
+	deleted := App{Name: "deleted", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&deleted, s.user)
+	c.Assert(err, check.IsNil)
+	err = deleted.SoftDelete(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	running := App{Name: "running", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&running, s.user)
+	c.Assert(err, check.IsNil)
+	err = purgeDeletedApps()
+	c.Assert(err, check.IsNil)
+	_, err = GetByName(expired.Name)
+	c.Assert(err, check.Equals, ErrAppNotFound)
+	_, err = GetByName(deleted.Name)
+	c.Assert(err, check.IsNil)
+	_, err = GetByName(running.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeApp, Value: expired.Name},
+		Kind:   "app-purge",
+	}, eventtest.HasEvent)
+}
@@ -209,6 +209,9 @@ func Deploy(opts DeployOptions) (string, error) {
 	if opts.Event == nil {
 		return "", errors.Errorf("missing event in deploy opts")
 	}
+	if opts.App.Deletion != nil {
+		return "", ErrAppDeleted
+	}
 	if opts.Rollback && !regexp.MustCompile(":v[0-9]+$").MatchString(opts.Image) {
 		validImages, err := findValidImages(*opts.App)
 		if err == nil {
@@ -240,6 +243,7 @@ func Deploy(opts DeployOptions) (string, error) {
 	if opts.App.UpdatePlatform {
 		opts.App.SetUpdatePlatform(false)
 	}
+	opts.App.recordUsage(false)
 	return imageId, nil
 }
 
@@ -0,0 +1,262 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SecretEnvMask replaces the value of environment variables users are not
+// allowed to see, like secret ones.
+const SecretEnvMask = "*****"
+
+var ErrEnvRevisionNotFound = errors.New("env revision not found")
+
+// EnvRevision is an immutable snapshot of the environment variables of an app,
+// created on every change to them.
+type EnvRevision struct {
+	App       string                 `json:"app"`
+	Version   int                    `json:"version"`
+	Owner     string                 `json:"owner"`
+	Timestamp time.Time              `json:"timestamp"`
+	Changed   []string               `json:"changed"`
+	Rollback  int                    `json:"rollback,omitempty" bson:",omitempty"`
+	Env       map[string]bind.EnvVar `json:"-"`
+}
+
+// EnvDiff is the change of an environment variable between two revisions.
+// Values of private and secret variables are masked.
+type EnvDiff struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// Actions in env diffs.
+const (
+	EnvAdded   = "added"
+	EnvRemoved = "removed"
+	EnvChanged = "changed"
+)
+
+func copyEnv(env map[string]bind.EnvVar) map[string]bind.EnvVar {
+	result := make(map[string]bind.EnvVar, len(env))
+	for k, v := range env {
+		result[k] = v
+	}
+	return result
+}
+
+func changedEnvNames(before, after map[string]bind.EnvVar) []string {
+	var names []string
+	for name, env := range after {
+		if oldEnv, ok := before[name]; !ok || !reflect.DeepEqual(oldEnv, env) {
+			names = append(names, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// saveEnvRevision records the current environment variables of the app as a
+// new revision, if they differ from previous. Failures are logged, as the
+// variables were already changed.
+func (app *App) saveEnvRevision(previous map[string]bind.EnvVar, owner string, rollback int) {
+	changed := changedEnvNames(previous, app.Env)
+	if len(changed) == 0 && rollback == 0 {
+		return
+	}
+	err := insertEnvRevision(&EnvRevision{
+		App:       app.Name,
+		Owner:     owner,
+		Timestamp: time.Now().UTC(),
+		Changed:   changed,
+		Rollback:  rollback,
+		Env:       copyEnv(app.Env),
+	})
+	if err != nil {
+		log.Errorf("[env-revision] unable to save env revision of app %s: %s", app.Name, err)
+	}
+}
+
+func insertEnvRevision(rev *EnvRevision) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	coll := conn.EnvRevisions()
+	for retries := 0; ; retries++ {
+		var last EnvRevision
+		err = coll.Find(bson.M{"app": rev.App}).Sort("-version").Select(bson.M{"version": 1}).One(&last)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+		rev.Version = last.Version + 1
+		err = coll.Insert(rev)
+		if !mgo.IsDup(err) || retries == 3 {
+			return err
+		}
+	}
+}
+
+// ListEnvRevisions returns the env revisions of the app, newest first.
+func ListEnvRevisions(appName string) ([]EnvRevision, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var revisions []EnvRevision
+	err = conn.EnvRevisions().Find(bson.M{"app": appName}).Sort("-version").Select(bson.M{"env": 0}).All(&revisions)
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// GetEnvRevision returns the given version of the app env.
+func GetEnvRevision(appName string, version int) (*EnvRevision, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var rev EnvRevision
+	err = conn.EnvRevisions().Find(bson.M{"app": appName, "version": version}).One(&rev)
+	if err == mgo.ErrNotFound {
+		return nil, ErrEnvRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rev, nil
+}
+
+// DiffEnvRevisions returns the changes in the app env from one revision to
+// another. Version 0 stands for the empty env, before the first revision.
+func DiffEnvRevisions(appName string, from, to int) ([]EnvDiff, error) {
+	envs := make([]map[string]bind.EnvVar, 2)
+	for i, version := range []int{from, to} {
+		if version == 0 {
+			continue
+		}
+		rev, err := GetEnvRevision(appName, version)
+		if err != nil {
+			return nil, err
+		}
+		envs[i] = rev.Env
+	}
+	before, after := envs[0], envs[1]
+	var diff []EnvDiff
+	for _, name := range changedEnvNames(before, after) {
+		oldEnv, inOld := before[name]
+		newEnv, inNew := after[name]
+		d := EnvDiff{Name: name, Action: EnvChanged}
+		if inOld {
+			d.Old = maskedEnvValue(oldEnv)
+		} else {
+			d.Action = EnvAdded
+		}
+		if inNew {
+			d.New = maskedEnvValue(newEnv)
+		} else {
+			d.Action = EnvRemoved
+		}
+		diff = append(diff, d)
+	}
+	return diff, nil
+}
+
+// maskedEnvValue returns the value of the variable as shown in diffs, where
+// only values of public variables are revealed.
+func maskedEnvValue(env bind.EnvVar) string {
+	if env.Secret || !env.Public {
+		return SecretEnvMask
+	}
+	return env.Value
+}
+
+// isManagedEnv returns whether the variable is set by tsuru itself, like the
+// ones set on service binds, which are not changed on env rollbacks.
+func isManagedEnv(env bind.EnvVar) bool {
+	if env.InstanceName != "" {
+		return true
+	}
+	switch env.Name {
+	case TsuruServicesEnvVar, "TSURU_APPNAME", "TSURU_APPDIR", "TSURU_APP_TOKEN":
+		return true
+	}
+	return false
+}
+
+// RollbackEnv sets the environment variables of the app to the ones in the
+// given revision, restarting the app when shouldRestart is true. Variables
+// managed by tsuru keep their current values. The rollback is recorded as a
+// new revision.
+func (app *App) RollbackEnv(version int, owner string, shouldRestart bool, w io.Writer) error {
+	if shouldRestart && app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	rev, err := GetEnvRevision(app.Name, version)
+	if err != nil {
+		return err
+	}
+	previous := copyEnv(app.Env)
+	env := make(map[string]bind.EnvVar)
+	for name, e := range app.Env {
+		if isManagedEnv(e) {
+			env[name] = e
+		}
+	}
+	for name, e := range rev.Env {
+		if !isManagedEnv(e) {
+			env[name] = e
+		}
+	}
+	if w != nil {
+		fmt.Fprintf(w, "---- Rolling back environment variables to revision %d ----\n", version)
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"env": env}})
+	if err != nil {
+		return err
+	}
+	app.Env = env
+	app.saveEnvRevision(previous, owner, version)
+	if !shouldRestart {
+		return nil
+	}
+	units, err := app.GetUnits()
+	if err != nil || len(units) == 0 {
+		return err
+	}
+	prov, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	return prov.Restart(app, "", w)
+}
@@ -0,0 +1,147 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestChangedEnvNames(c *check.C) {
+	before := map[string]bind.EnvVar{
+		"A": {Name: "A", Value: "1"},
+		"B": {Name: "B", Value: "2"},
+		"C": {Name: "C", Value: "3"},
+	}
+	after := map[string]bind.EnvVar{
+		"A": {Name: "A", Value: "1"},
+		"B": {Name: "B", Value: "2", Public: true},
+		"D": {Name: "D", Value: "4"},
+	}
+	c.Assert(changedEnvNames(before, after), check.DeepEquals, []string{"B", "C", "D"})
+	c.Assert(changedEnvNames(before, before), check.IsNil)
+}
+
+func (s *S) TestSetEnvsCreatesRevisions(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs:  []bind.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}},
+		Owner: "me@example.com",
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs:  []bind.EnvVar{{Name: "A", Value: "1"}},
+		Owner: "me@example.com",
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.unsetEnvsToApp(bind.UnsetEnvApp{
+		VariableNames: []string{"B"},
+		Owner:         "other@example.com",
+	}, nil)
+	c.Assert(err, check.IsNil)
+	revisions, err := ListEnvRevisions(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(revisions, check.HasLen, 2)
+	c.Assert(revisions[0].Version, check.Equals, 2)
+	c.Assert(revisions[0].Owner, check.Equals, "other@example.com")
+	c.Assert(revisions[0].Changed, check.DeepEquals, []string{"B"})
+	c.Assert(revisions[0].Env, check.IsNil)
+	c.Assert(revisions[1].Version, check.Equals, 1)
+	c.Assert(revisions[1].Owner, check.Equals, "me@example.com")
+	c.Assert(revisions[1].Changed, check.DeepEquals, []string{"A", "B"})
+	rev, err := GetEnvRevision(a.Name, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(rev.Env, check.DeepEquals, map[string]bind.EnvVar{
+		"A": {Name: "A", Value: "1"},
+		"B": {Name: "B", Value: "2"},
+	})
+	_, err = GetEnvRevision(a.Name, 3)
+	c.Assert(err, check.Equals, ErrEnvRevisionNotFound)
+}
+
+func (s *S) TestDiffEnvRevisions(c *check.C) {
+	config.Set("encryption:key", "my-key")
+	defer config.Unset("encryption:key")
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "A", Value: "1", Public: true}, {Name: "B", Value: "2", Public: true}},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{
+			{Name: "A", Value: "10", Public: true},
+			{Name: "PASSWORD", Value: "123", Secret: true},
+			{Name: "TOKEN", Value: "abc"},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.unsetEnvsToApp(bind.UnsetEnvApp{VariableNames: []string{"B"}}, nil)
+	c.Assert(err, check.IsNil)
+	diff, err := DiffEnvRevisions(a.Name, 1, 3)
+	c.Assert(err, check.IsNil)
+	c.Assert(diff, check.DeepEquals, []EnvDiff{
+		{Name: "A", Action: EnvChanged, Old: "1", New: "10"},
+		{Name: "B", Action: EnvRemoved, Old: "2"},
+		{Name: "PASSWORD", Action: EnvAdded, New: SecretEnvMask},
+		{Name: "TOKEN", Action: EnvAdded, New: SecretEnvMask},
+	})
+	diff, err = DiffEnvRevisions(a.Name, 0, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(diff, check.DeepEquals, []EnvDiff{
+		{Name: "A", Action: EnvAdded, New: "1"},
+		{Name: "B", Action: EnvAdded, New: "2"},
+	})
+	_, err = DiffEnvRevisions(a.Name, 1, 4)
+	c.Assert(err, check.Equals, ErrEnvRevisionNotFound)
+}
+
+func (s *S) TestRollbackEnv(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(1, "", nil)
+	c.Assert(err, check.IsNil)
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{{Name: "A", Value: "1"}},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	revisions, err := ListEnvRevisions(a.Name)
+	c.Assert(err, check.IsNil)
+	version := revisions[0].Version
+	err = a.setEnvsToApp(bind.SetEnvApp{
+		Envs: []bind.EnvVar{
+			{Name: "A", Value: "2"},
+			{Name: "B", Value: "3"},
+			{Name: "DATABASE_HOST", Value: "localhost", InstanceName: "mysql"},
+		},
+	}, nil)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = a.RollbackEnv(version, "me@example.com", true, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, "(?s)---- Rolling back environment variables to revision .*")
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["A"].Value, check.Equals, "1")
+	_, ok := dbApp.Env["B"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(dbApp.Env["DATABASE_HOST"].Value, check.Equals, "localhost")
+	c.Assert(dbApp.Env["TSURU_APP_TOKEN"].Value, check.Equals, a.Env["TSURU_APP_TOKEN"].Value)
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 1)
+	revisions, err = ListEnvRevisions(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(revisions[0].Rollback, check.Equals, version)
+	c.Assert(revisions[0].Owner, check.Equals, "me@example.com")
+	c.Assert(revisions[0].Changed, check.DeepEquals, []string{"A", "B"})
+	err = a.RollbackEnv(100, "me@example.com", true, nil)
+	c.Assert(err, check.Equals, ErrEnvRevisionNotFound)
+}
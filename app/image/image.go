@@ -259,6 +259,36 @@ func ListValidAppImages(appName string) ([]string, error) {
 	return img.Images, nil
 }
 
+// ListAllValidAppImages returns the valid images of every app, limited to
+// the configured history size for each app.
+func ListAllValidAppImages() ([]string, error) {
+	coll, err := appImagesColl()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var allImgs []appImages
+	err = coll.Find(nil).All(&allImgs)
+	if err != nil {
+		return nil, err
+	}
+	historySize := ImageHistorySize()
+	var result []string
+	for _, img := range allImgs {
+		if len(img.Images) > historySize {
+			img.Images = img.Images[len(img.Images)-historySize:]
+		}
+		result = append(result, img.Images...)
+	}
+	return result, nil
+}
+
+// ManagedImagePrefix returns the prefix shared by all images created by
+// tsuru, including the registry when one is configured.
+func ManagedImagePrefix() string {
+	return basicImageName() + "/"
+}
+
 func ImageHistorySize() int {
 	imgHistorySize, _ := config.GetInt("docker:image-history-size")
 	if imgHistorySize == 0 {
@@ -5,6 +5,7 @@
 package image_test
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/tsuru/config"
@@ -121,6 +122,28 @@ func (s *S) TestValidListAppImages(c *check.C) {
 	c.Assert(images, check.DeepEquals, []string{"tsuru/app-myapp:v2", "tsuru/app-myapp:v3"})
 }
 
+func (s *S) TestListAllValidAppImages(c *check.C) {
+	config.Set("docker:image-history-size", 2)
+	defer config.Unset("docker:image-history-size")
+	for _, img := range []string{"tsuru/app-myapp:v1", "tsuru/app-myapp:v2", "tsuru/app-myapp:v3"} {
+		err := image.AppendAppImageName("myapp", img)
+		c.Assert(err, check.IsNil)
+	}
+	err := image.AppendAppImageName("otherapp", "tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	images, err := image.ListAllValidAppImages()
+	c.Assert(err, check.IsNil)
+	sort.Strings(images)
+	c.Assert(images, check.DeepEquals, []string{"tsuru/app-myapp:v2", "tsuru/app-myapp:v3", "tsuru/app-otherapp:v1"})
+}
+
+func (s *S) TestManagedImagePrefix(c *check.C) {
+	c.Assert(image.ManagedImagePrefix(), check.Equals, "tsuru/")
+	config.Set("docker:registry", "localhost:3030")
+	defer config.Unset("docker:registry")
+	c.Assert(image.ManagedImagePrefix(), check.Equals, "localhost:3030/tsuru/")
+}
+
 func (s *S) TestPlatformImageName(c *check.C) {
 	platName := image.PlatformImageName("python")
 	c.Assert(platName, check.Equals, "tsuru/python:latest")
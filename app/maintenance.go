@@ -0,0 +1,167 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrMaintenancePageNotConfigured = errors.New("maintenance page not configured, maintenance:url must be set")
+	ErrAppInMaintenance             = &tsuruErrors.ValidationError{Message: "app is already in maintenance mode"}
+	ErrAppNotInMaintenance          = &tsuruErrors.ValidationError{Message: "app is not in maintenance mode"}
+)
+
+var defaultMaintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}} is under maintenance</title>
+</head>
+<body>
+<h1>{{.}} is under maintenance</h1>
+<p>We'll be back soon.</p>
+</body>
+</html>
+`))
+
+// Maintenance holds the maintenance mode of an app: who enabled it and when.
+type Maintenance struct {
+	Owner string    `json:"owner"`
+	Date  time.Time `json:"date"`
+}
+
+// MaintenancePageURL returns the address of the maintenance page served by
+// tsuru, read from the maintenance:url config.
+func MaintenancePageURL() (*url.URL, error) {
+	value, _ := config.GetString("maintenance:url")
+	if value == "" {
+		return nil, ErrMaintenancePageNotConfigured
+	}
+	return url.Parse(value)
+}
+
+// EnableMaintenance points the routes of the app to the maintenance page.
+// Units are kept running and may still be reached internally.
+func (app *App) EnableMaintenance(owner string, w io.Writer) error {
+	if app.Maintenance != nil {
+		return ErrAppInMaintenance
+	}
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	if _, err := MaintenancePageURL(); err != nil {
+		return err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "---- Enabling maintenance mode of app %q ----\n", app.Name)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	maintenance := Maintenance{Owner: owner, Date: time.Now().UTC()}
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"maintenance": maintenance}})
+	if err != nil {
+		return err
+	}
+	app.Maintenance = &maintenance
+	result, err := rebuild.RebuildRoutes(app)
+	if err != nil {
+		log.Errorf("[maintenance] unable to route app %s to the maintenance page, rolling back: %s", app.Name, err)
+		app.Maintenance = nil
+		if unsetErr := conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$unset": bson.M{"maintenance": ""}}); unsetErr != nil {
+			log.Errorf("[maintenance] unable to roll back maintenance mode of app %s: %s", app.Name, unsetErr)
+		}
+		rebuild.RoutesRebuildOrEnqueue(app.Name)
+		return err
+	}
+	fmt.Fprintf(w, "Routes added: %v\nRoutes removed: %v\n", result.Added, result.Removed)
+	return nil
+}
+
+// DisableMaintenance restores the routes of the app to its units.
+func (app *App) DisableMaintenance(w io.Writer) error {
+	if app.Maintenance == nil {
+		return ErrAppNotInMaintenance
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "---- Disabling maintenance mode of app %q ----\n", app.Name)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$unset": bson.M{"maintenance": ""}})
+	if err != nil {
+		return err
+	}
+	app.Maintenance = nil
+	result, err := rebuild.RebuildRoutes(app)
+	if err != nil {
+		log.Errorf("[maintenance] unable to restore routes of app %s, enqueuing: %s", app.Name, err)
+		fmt.Fprintf(w, "Unable to restore routes, they will be restored in background: %s\n", err)
+		rebuild.RoutesRebuildOrEnqueue(app.Name)
+		return nil
+	}
+	fmt.Fprintf(w, "Routes added: %v\nRoutes removed: %v\n", result.Added, result.Removed)
+	return nil
+}
+
+// SetMaintenancePage sets the HTML page shown while the app is in maintenance
+// mode. An empty page falls back to the page of the team owner of the app.
+func (app *App) SetMaintenancePage(page string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	update := bson.M{"$set": bson.M{"maintenancepage": page}}
+	if page == "" {
+		update = bson.M{"$unset": bson.M{"maintenancepage": ""}}
+	}
+	err = conn.Apps().Update(bson.M{"name": app.Name}, update)
+	if err != nil {
+		return err
+	}
+	app.MaintenancePage = page
+	return nil
+}
+
+// RenderMaintenancePage writes the maintenance page of the app: its own page,
+// the page of its team owner or the default one, in this order.
+func (app *App) RenderMaintenancePage(w io.Writer) error {
+	if app.MaintenancePage != "" {
+		_, err := io.WriteString(w, app.MaintenancePage)
+		return err
+	}
+	team, err := auth.GetTeam(app.TeamOwner)
+	if err != nil && err != auth.ErrTeamNotFound {
+		return err
+	}
+	if team != nil && team.MaintenancePage != "" {
+		_, err = io.WriteString(w, team.MaintenancePage)
+		return err
+	}
+	return defaultMaintenancePage.Execute(w, app.Name)
+}
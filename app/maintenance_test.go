@@ -0,0 +1,127 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestEnableMaintenance(c *check.C) {
+	config.Set("maintenance:url", "http://tsuru-maintenance:8082")
+	defer config.Unset("maintenance:url")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Plan: Plan{Router: "fake"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	_, err = rebuild.RebuildRoutes(&a)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = a.EnableMaintenance(s.user.Email, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s)---- Enabling maintenance mode of app "myapp" ----.*`)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 1)
+	c.Assert(routes[0].String(), check.Equals, "http://tsuru-maintenance:8082")
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.Status, check.Equals, provision.StatusStarted)
+	}
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Maintenance, check.NotNil)
+	c.Assert(dbApp.Maintenance.Owner, check.Equals, s.user.Email)
+	err = dbApp.EnableMaintenance(s.user.Email, nil)
+	c.Assert(err, check.Equals, ErrAppInMaintenance)
+	s.provisioner.AddUnits(dbApp, 1, "web", nil)
+	c.Assert(dbApp.UnitRoutesDisabled(), check.Equals, true)
+	routes, err = routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 1)
+	_, err = rebuild.RebuildRoutes(dbApp)
+	c.Assert(err, check.IsNil)
+	routes, err = routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 1)
+	c.Assert(routes[0].String(), check.Equals, "http://tsuru-maintenance:8082")
+}
+
+func (s *S) TestEnableMaintenanceNotConfigured(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.EnableMaintenance(s.user.Email, nil)
+	c.Assert(err, check.Equals, ErrMaintenancePageNotConfigured)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Maintenance, check.IsNil)
+}
+
+func (s *S) TestDisableMaintenance(c *check.C) {
+	config.Set("maintenance:url", "http://tsuru-maintenance:8082")
+	defer config.Unset("maintenance:url")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name, Plan: Plan{Router: "fake"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	err = a.EnableMaintenance(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = a.DisableMaintenance(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s)---- Disabling maintenance mode of app "myapp" ----.*`)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 2)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://tsuru-maintenance:8082"), check.Equals, false)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	for _, u := range units {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Maintenance, check.IsNil)
+	err = dbApp.DisableMaintenance(nil)
+	c.Assert(err, check.Equals, ErrAppNotInMaintenance)
+}
+
+func (s *S) TestRenderMaintenancePage(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = a.RenderMaintenancePage(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*<h1>myapp is under maintenance</h1>.*`)
+	err = auth.SetTeamMaintenancePage(s.team.Name, "<h1>Team page</h1>")
+	c.Assert(err, check.IsNil)
+	buf.Reset()
+	err = a.RenderMaintenancePage(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "<h1>Team page</h1>")
+	err = a.SetMaintenancePage("<h1>App page</h1>")
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	buf.Reset()
+	err = dbApp.RenderMaintenancePage(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "<h1>App page</h1>")
+	err = dbApp.SetMaintenancePage("")
+	c.Assert(err, check.IsNil)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.MaintenancePage, check.Equals, "")
+}
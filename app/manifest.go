@@ -0,0 +1,425 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/auth"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/service"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrManifestNameRequired      = &tsuruErrors.ValidationError{Message: "manifest app name is required"}
+	ErrManifestPlatformChanged   = &tsuruErrors.ValidationError{Message: "the platform of an existing app can't be changed by a manifest"}
+	ErrManifestPlatformRequired  = &tsuruErrors.ValidationError{Message: "manifest platform is required to create the app"}
+	ErrManifestInvalidBind       = &tsuruErrors.ValidationError{Message: "manifest binds must have both service and instance"}
+	ErrManifestUnitsWithoutImage = &tsuruErrors.ValidationError{Message: "units can only be set on apps with at least one deploy"}
+)
+
+type ManifestAction string
+
+const (
+	ManifestActionCreate            = ManifestAction("create")
+	ManifestActionUpdateDescription = ManifestAction("update-description")
+	ManifestActionUpdatePool        = ManifestAction("update-pool")
+	ManifestActionUpdatePlan        = ManifestAction("update-plan")
+	ManifestActionUpdateTeamOwner   = ManifestAction("update-team-owner")
+	ManifestActionGrant             = ManifestAction("grant")
+	ManifestActionRevoke            = ManifestAction("revoke")
+	ManifestActionSetEnv            = ManifestAction("set-env")
+	ManifestActionUnsetEnv          = ManifestAction("unset-env")
+	ManifestActionAddCName          = ManifestAction("add-cname")
+	ManifestActionRemoveCName       = ManifestAction("remove-cname")
+	ManifestActionBind              = ManifestAction("bind")
+	ManifestActionUnbind            = ManifestAction("unbind")
+	ManifestActionAddUnits          = ManifestAction("add-units")
+	ManifestActionRemoveUnits       = ManifestAction("remove-units")
+)
+
+// Manifest describes the desired state of an app. Empty fields and fields
+// not present in the manifest are left as they are, except for teams, env,
+// cnames and binds, which are replaced by the ones in the manifest when set.
+// Environment variables managed by tsuru, secret and private variables are
+// never changed by a manifest.
+type Manifest struct {
+	Name        string            `yaml:"name" json:"name"`
+	Platform    string            `yaml:"platform" json:"platform,omitempty"`
+	Plan        string            `yaml:"plan" json:"plan,omitempty"`
+	Pool        string            `yaml:"pool" json:"pool,omitempty"`
+	TeamOwner   string            `yaml:"team-owner" json:"teamOwner,omitempty"`
+	Description string            `yaml:"description" json:"description,omitempty"`
+	Teams       []string          `yaml:"teams" json:"teams,omitempty"`
+	Units       map[string]uint   `yaml:"units" json:"units,omitempty"`
+	Env         map[string]string `yaml:"env" json:"env,omitempty"`
+	CNames      []string          `yaml:"cnames" json:"cnames,omitempty"`
+	Binds       []ManifestBind    `yaml:"binds" json:"binds,omitempty"`
+}
+
+type ManifestBind struct {
+	Service  string `yaml:"service" json:"service"`
+	Instance string `yaml:"instance" json:"instance"`
+}
+
+func (b ManifestBind) String() string {
+	return b.Service + "/" + b.Instance
+}
+
+// ManifestChange is a single operation needed to bring an app to the state
+// described by a manifest. Target is the name of the changed item, like the
+// env var, the team or the process, and Value its new value, if any.
+type ManifestChange struct {
+	Action ManifestAction `json:"action"`
+	Target string         `json:"target,omitempty"`
+	Value  string         `json:"value,omitempty"`
+	Units  uint           `json:"units,omitempty"`
+}
+
+func (c ManifestChange) String() string {
+	switch {
+	case c.Units > 0:
+		return fmt.Sprintf("%s %s %d", c.Action, c.Target, c.Units)
+	case c.Value != "":
+		return fmt.Sprintf("%s %s=%s", c.Action, c.Target, c.Value)
+	}
+	return fmt.Sprintf("%s %s", c.Action, c.Target)
+}
+
+// ParseManifest parses a YAML manifest.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	err := yaml.Unmarshal(data, &m)
+	if err != nil {
+		return nil, &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid manifest: %s", err)}
+	}
+	if m.Name == "" {
+		return nil, ErrManifestNameRequired
+	}
+	for _, b := range m.Binds {
+		if b.Service == "" || b.Instance == "" {
+			return nil, ErrManifestInvalidBind
+		}
+	}
+	return &m, nil
+}
+
+// PlanManifest computes the changes needed to bring the app named in the
+// manifest to the described state, in the order they must be applied. The
+// current app is returned along with the changes, or nil when the app must be
+// created.
+func PlanManifest(m *Manifest) (*App, []ManifestChange, error) {
+	app, err := GetByName(m.Name)
+	if err == ErrAppNotFound {
+		if m.Platform == "" {
+			return nil, nil, ErrManifestPlatformRequired
+		}
+		if len(m.Units) > 0 {
+			return nil, nil, ErrManifestUnitsWithoutImage
+		}
+		return nil, planNewApp(m), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.Platform != "" && m.Platform != app.Platform {
+		return nil, nil, ErrManifestPlatformChanged
+	}
+	var changes []ManifestChange
+	if m.Description != "" && m.Description != app.Description {
+		changes = append(changes, ManifestChange{Action: ManifestActionUpdateDescription, Value: m.Description})
+	}
+	if m.Pool != "" && m.Pool != app.Pool {
+		changes = append(changes, ManifestChange{Action: ManifestActionUpdatePool, Value: m.Pool})
+	}
+	if m.Plan != "" && m.Plan != app.Plan.Name {
+		changes = append(changes, ManifestChange{Action: ManifestActionUpdatePlan, Value: m.Plan})
+	}
+	teamOwner := app.TeamOwner
+	if m.TeamOwner != "" && m.TeamOwner != app.TeamOwner {
+		teamOwner = m.TeamOwner
+		changes = append(changes, ManifestChange{Action: ManifestActionUpdateTeamOwner, Value: m.TeamOwner})
+	}
+	if m.Teams != nil {
+		current := app.Teams
+		if teamOwner != app.TeamOwner {
+			// the new team owner is granted access on the team owner update
+			current = append(append([]string{}, app.Teams...), teamOwner)
+		}
+		teams := append([]string{teamOwner}, m.Teams...)
+		changes = append(changes, diffList(current, teams, ManifestActionGrant, ManifestActionRevoke)...)
+	}
+	if m.Env != nil {
+		changes = append(changes, diffEnvs(app.Env, m.Env)...)
+	}
+	if m.CNames != nil {
+		changes = append(changes, diffList(app.CName, m.CNames, ManifestActionAddCName, ManifestActionRemoveCName)...)
+	}
+	if m.Binds != nil {
+		instances, err := app.serviceInstances()
+		if err != nil {
+			return nil, nil, err
+		}
+		current := make([]string, len(instances))
+		for i, si := range instances {
+			current[i] = ManifestBind{Service: si.ServiceName, Instance: si.Name}.String()
+		}
+		wanted := make([]string, len(m.Binds))
+		for i, b := range m.Binds {
+			wanted[i] = b.String()
+		}
+		changes = append(changes, diffList(current, wanted, ManifestActionBind, ManifestActionUnbind)...)
+	}
+	if len(m.Units) > 0 {
+		if app.Deploys == 0 {
+			return nil, nil, ErrManifestUnitsWithoutImage
+		}
+		unitsChanges, err := app.diffUnits(m.Units)
+		if err != nil {
+			return nil, nil, err
+		}
+		changes = append(changes, unitsChanges...)
+	}
+	return app, changes, nil
+}
+
+func planNewApp(m *Manifest) []ManifestChange {
+	changes := []ManifestChange{{Action: ManifestActionCreate, Target: m.Name}}
+	for _, team := range m.Teams {
+		if team != m.TeamOwner {
+			changes = append(changes, ManifestChange{Action: ManifestActionGrant, Target: team})
+		}
+	}
+	changes = append(changes, diffEnvs(nil, m.Env)...)
+	for _, cname := range m.CNames {
+		changes = append(changes, ManifestChange{Action: ManifestActionAddCName, Target: cname})
+	}
+	for _, b := range m.Binds {
+		changes = append(changes, ManifestChange{Action: ManifestActionBind, Target: b.String()})
+	}
+	return changes
+}
+
+// diffList returns the changes adding the items of wanted missing in current
+// and removing the items of current missing in wanted.
+func diffList(current, wanted []string, add, remove ManifestAction) []ManifestChange {
+	currentSet := make(map[string]bool, len(current))
+	for _, item := range current {
+		currentSet[item] = true
+	}
+	wantedSet := make(map[string]bool, len(wanted))
+	var changes []ManifestChange
+	for _, item := range wanted {
+		if wantedSet[item] {
+			continue
+		}
+		wantedSet[item] = true
+		if !currentSet[item] {
+			changes = append(changes, ManifestChange{Action: add, Target: item})
+		}
+	}
+	for _, item := range current {
+		if !wantedSet[item] {
+			changes = append(changes, ManifestChange{Action: remove, Target: item})
+		}
+	}
+	return changes
+}
+
+// ignoredManifestEnv returns whether the env is left untouched by manifests,
+// as they're applied only to public variables.
+func ignoredManifestEnv(env bind.EnvVar) bool {
+	return env.Secret || !env.Public || isManagedEnv(env)
+}
+
+func diffEnvs(current map[string]bind.EnvVar, wanted map[string]string) []ManifestChange {
+	var changes []ManifestChange
+	for _, name := range sortedKeys(wanted) {
+		env, ok := current[name]
+		if ok && ignoredManifestEnv(env) {
+			continue
+		}
+		if !ok || env.Value != wanted[name] {
+			changes = append(changes, ManifestChange{Action: ManifestActionSetEnv, Target: name, Value: wanted[name]})
+		}
+	}
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env := current[name]
+		if ignoredManifestEnv(env) {
+			continue
+		}
+		if _, ok := wanted[name]; !ok {
+			changes = append(changes, ManifestChange{Action: ManifestActionUnsetEnv, Target: name})
+		}
+	}
+	return changes
+}
+
+func (app *App) diffUnits(wanted map[string]uint) ([]ManifestChange, error) {
+	units, err := app.Units()
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]uint)
+	for _, u := range units {
+		current[u.ProcessName]++
+	}
+	processes := make([]string, 0, len(wanted))
+	for process := range wanted {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	var changes []ManifestChange
+	for _, process := range processes {
+		n := wanted[process]
+		switch {
+		case n > current[process]:
+			changes = append(changes, ManifestChange{Action: ManifestActionAddUnits, Target: process, Units: n - current[process]})
+		case n < current[process]:
+			changes = append(changes, ManifestChange{Action: ManifestActionRemoveUnits, Target: process, Units: current[process] - n})
+		}
+	}
+	return changes, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ApplyManifest executes the changes computed by PlanManifest, in order. Env
+// var changes are applied at once, restarting the app a single time. When a
+// change fails the following ones are not executed and the app is returned
+// along with the error.
+func ApplyManifest(m *Manifest, changes []ManifestChange, user *auth.User, w io.Writer) (*App, error) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	app, err := GetByName(m.Name)
+	if err != nil && err != ErrAppNotFound {
+		return nil, err
+	}
+	envsApplied := false
+	for _, change := range changes {
+		fmt.Fprintf(w, "---- Applying %s ----\n", change)
+		switch change.Action {
+		case ManifestActionCreate:
+			app = &App{
+				Name:        m.Name,
+				Platform:    m.Platform,
+				Plan:        Plan{Name: m.Plan},
+				Pool:        m.Pool,
+				TeamOwner:   m.TeamOwner,
+				Description: m.Description,
+			}
+			err = CreateApp(app, user)
+		case ManifestActionUpdateDescription:
+			err = app.Update(App{Description: change.Value}, w)
+		case ManifestActionUpdatePool:
+			err = app.Update(App{Pool: change.Value}, w)
+		case ManifestActionUpdatePlan:
+			err = app.Update(App{Plan: Plan{Name: change.Value}}, w)
+		case ManifestActionUpdateTeamOwner:
+			err = app.Update(App{TeamOwner: change.Value}, w)
+		case ManifestActionGrant, ManifestActionRevoke:
+			err = app.applyTeamChange(change)
+		case ManifestActionSetEnv, ManifestActionUnsetEnv:
+			if !envsApplied {
+				envsApplied = true
+				err = app.applyEnvChanges(changes, user.Email, w)
+			}
+		case ManifestActionAddCName:
+			err = app.AddCName(change.Target)
+		case ManifestActionRemoveCName:
+			err = app.RemoveCName(change.Target)
+		case ManifestActionBind, ManifestActionUnbind:
+			err = app.applyBindChange(change, w)
+		case ManifestActionAddUnits:
+			err = app.AddUnits(change.Units, change.Target, w)
+		case ManifestActionRemoveUnits:
+			err = app.RemoveUnits(change.Units, change.Target, w)
+		default:
+			err = fmt.Errorf("unknown manifest action %q", change.Action)
+		}
+		if err != nil {
+			return app, err
+		}
+	}
+	return app, nil
+}
+
+func (app *App) applyTeamChange(change ManifestChange) error {
+	team, err := auth.GetTeam(change.Target)
+	if err != nil {
+		return err
+	}
+	if change.Action == ManifestActionGrant {
+		return app.Grant(team)
+	}
+	return app.Revoke(team)
+}
+
+func (app *App) applyEnvChanges(changes []ManifestChange, owner string, w io.Writer) error {
+	var envs []bind.EnvVar
+	var unset []string
+	for _, change := range changes {
+		switch change.Action {
+		case ManifestActionSetEnv:
+			envs = append(envs, bind.EnvVar{Name: change.Target, Value: change.Value, Public: true})
+		case ManifestActionUnsetEnv:
+			unset = append(unset, change.Target)
+		}
+	}
+	if len(envs) > 0 {
+		err := app.SetEnvs(bind.SetEnvApp{
+			Envs:          envs,
+			PublicOnly:    true,
+			ShouldRestart: len(unset) == 0,
+			Owner:         owner,
+		}, w)
+		if err != nil {
+			return err
+		}
+	}
+	if len(unset) > 0 {
+		return app.UnsetEnvs(bind.UnsetEnvApp{
+			VariableNames: unset,
+			PublicOnly:    true,
+			ShouldRestart: true,
+			Owner:         owner,
+		}, w)
+	}
+	return nil
+}
+
+func (app *App) applyBindChange(change ManifestChange, w io.Writer) error {
+	parts := strings.SplitN(change.Target, "/", 2)
+	if len(parts) != 2 {
+		return ErrManifestInvalidBind
+	}
+	si, err := service.GetServiceInstance(parts[0], parts[1])
+	if err != nil {
+		return err
+	}
+	if change.Action == ManifestActionBind {
+		return si.BindApp(app, true, w)
+	}
+	return si.UnbindApp(app, true, w)
+}
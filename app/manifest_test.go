@@ -0,0 +1,204 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/auth"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestParseManifest(c *check.C) {
+	data := `
+name: myapp
+platform: python
+team-owner: tsuruteam
+teams: [other]
+units:
+  web: 3
+env:
+  WORKERS: "4"
+cnames: [myapp.example.com]
+binds:
+  - service: mysql
+    instance: my-db
+`
+	m, err := ParseManifest([]byte(data))
+	c.Assert(err, check.IsNil)
+	c.Assert(m, check.DeepEquals, &Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		TeamOwner: "tsuruteam",
+		Teams:     []string{"other"},
+		Units:     map[string]uint{"web": 3},
+		Env:       map[string]string{"WORKERS": "4"},
+		CNames:    []string{"myapp.example.com"},
+		Binds:     []ManifestBind{{Service: "mysql", Instance: "my-db"}},
+	})
+}
+
+func (s *S) TestParseManifestInvalid(c *check.C) {
+	_, err := ParseManifest([]byte("platform: python"))
+	c.Assert(err, check.Equals, ErrManifestNameRequired)
+	_, err = ParseManifest([]byte("name: myapp\nbinds:\n  - service: mysql"))
+	c.Assert(err, check.Equals, ErrManifestInvalidBind)
+	_, err = ParseManifest([]byte("name: [myapp"))
+	c.Assert(err, check.ErrorMatches, "invalid manifest: .*")
+}
+
+func (s *S) TestPlanManifestNewApp(c *check.C) {
+	m := Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		TeamOwner: s.team.Name,
+		Teams:     []string{s.team.Name, "other"},
+		Env:       map[string]string{"WORKERS": "4"},
+		CNames:    []string{"myapp.example.com"},
+	}
+	a, changes, err := PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	c.Assert(a, check.IsNil)
+	c.Assert(changes, check.DeepEquals, []ManifestChange{
+		{Action: ManifestActionCreate, Target: "myapp"},
+		{Action: ManifestActionGrant, Target: "other"},
+		{Action: ManifestActionSetEnv, Target: "WORKERS", Value: "4"},
+		{Action: ManifestActionAddCName, Target: "myapp.example.com"},
+	})
+	m.Platform = ""
+	_, _, err = PlanManifest(&m)
+	c.Assert(err, check.Equals, ErrManifestPlatformRequired)
+}
+
+func (s *S) TestPlanManifestExistingApp(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{
+		{Name: "WORKERS", Value: "2", Public: true},
+		{Name: "DEBUG", Value: "1", Public: true},
+		{Name: "KEEP", Value: "1", Public: true},
+	}}, nil)
+	c.Assert(err, check.IsNil)
+	err = a.AddCName("old.example.com")
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 4, "web", nil)
+	err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"deploys": 1}})
+	c.Assert(err, check.IsNil)
+	m := Manifest{
+		Name:        "myapp",
+		Description: "my app",
+		Units:       map[string]uint{"web": 2, "worker": 1},
+		Env:         map[string]string{"WORKERS": "4", "KEEP": "1", "NEW": "x"},
+		CNames:      []string{"new.example.com"},
+	}
+	planned, changes, err := PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	c.Assert(planned.Name, check.Equals, a.Name)
+	c.Assert(changes, check.DeepEquals, []ManifestChange{
+		{Action: ManifestActionUpdateDescription, Value: "my app"},
+		{Action: ManifestActionSetEnv, Target: "NEW", Value: "x"},
+		{Action: ManifestActionSetEnv, Target: "WORKERS", Value: "4"},
+		{Action: ManifestActionUnsetEnv, Target: "DEBUG"},
+		{Action: ManifestActionAddCName, Target: "new.example.com"},
+		{Action: ManifestActionRemoveCName, Target: "old.example.com"},
+		{Action: ManifestActionRemoveUnits, Target: "web", Units: 2},
+		{Action: ManifestActionAddUnits, Target: "worker", Units: 1},
+	})
+}
+
+func (s *S) TestPlanManifestIgnoresPrivateEnvs(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetEnvs(bind.SetEnvApp{Envs: []bind.EnvVar{
+		{Name: "WORKERS", Value: "2", Public: true},
+		{Name: "PRIVATE", Value: "1", Public: false},
+		{Name: "OTHER_PRIVATE", Value: "1", Public: false},
+	}}, nil)
+	c.Assert(err, check.IsNil)
+	m := Manifest{
+		Name: "myapp",
+		Env:  map[string]string{"WORKERS": "4", "PRIVATE": "2"},
+	}
+	_, changes, err := PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.DeepEquals, []ManifestChange{
+		{Action: ManifestActionSetEnv, Target: "WORKERS", Value: "4"},
+	})
+	_, err = ApplyManifest(&m, changes, s.user, nil)
+	c.Assert(err, check.IsNil)
+	_, changes, err = PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.HasLen, 0)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["PRIVATE"].Value, check.Equals, "1")
+	c.Assert(dbApp.Env["OTHER_PRIVATE"].Value, check.Equals, "1")
+}
+
+func (s *S) TestPlanManifestPlatformChanged(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	_, _, err = PlanManifest(&Manifest{Name: "myapp", Platform: "ruby"})
+	c.Assert(err, check.Equals, ErrManifestPlatformChanged)
+}
+
+func (s *S) TestPlanManifestUnitsWithoutDeploy(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	_, _, err = PlanManifest(&Manifest{Name: "myapp", Units: map[string]uint{"web": 1}})
+	c.Assert(err, check.Equals, ErrManifestUnitsWithoutImage)
+}
+
+func (s *S) TestApplyManifest(c *check.C) {
+	otherTeam := auth.Team{Name: "other"}
+	err := s.conn.Teams().Insert(otherTeam)
+	c.Assert(err, check.IsNil)
+	m := Manifest{
+		Name:      "myapp",
+		Platform:  "python",
+		TeamOwner: s.team.Name,
+		Teams:     []string{"other"},
+		Env:       map[string]string{"WORKERS": "4", "DEBUG": "1"},
+		CNames:    []string{"myapp.example.com"},
+	}
+	_, changes, err := PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	_, err = ApplyManifest(&m, changes, s.user, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*Applying create myapp.*Applying add-cname myapp.example.com.*`)
+	a, err := GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Teams, check.DeepEquals, []string{s.team.Name, "other"})
+	c.Assert(a.Env["WORKERS"].Value, check.Equals, "4")
+	c.Assert(a.Env["DEBUG"].Value, check.Equals, "1")
+	c.Assert(a.CName, check.DeepEquals, []string{"myapp.example.com"})
+	m.Teams = []string{}
+	m.Env = map[string]string{"WORKERS": "8"}
+	_, changes, err = PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.DeepEquals, []ManifestChange{
+		{Action: ManifestActionRevoke, Target: "other"},
+		{Action: ManifestActionSetEnv, Target: "WORKERS", Value: "8"},
+		{Action: ManifestActionUnsetEnv, Target: "DEBUG"},
+	})
+	_, err = ApplyManifest(&m, changes, s.user, nil)
+	c.Assert(err, check.IsNil)
+	a, err = GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Teams, check.DeepEquals, []string{s.team.Name})
+	c.Assert(a.Env["WORKERS"].Value, check.Equals, "8")
+	_, ok := a.Env["DEBUG"]
+	c.Assert(ok, check.Equals, false)
+	_, changes, err = PlanManifest(&m)
+	c.Assert(err, check.IsNil)
+	c.Assert(changes, check.HasLen, 0)
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// operationStaleTimeout is the time after which queued operations whose
+// waiters stopped sending heartbeats, like the ones of a tsuru instance that
+// went down, are dropped from the queue.
+const operationStaleTimeout = 30 * time.Second
+
+var (
+	ErrOperationNotFound  = errors.New("operation not found")
+	ErrOperationCancelled = errors.New("operation cancelled while waiting for the app lock")
+)
+
+// Operation is a request waiting for the lock of an app. Operations acquire
+// the lock in the order they were enqueued, Position is the place of the
+// operation in the queue, starting at 1.
+type Operation struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	App        string        `json:"app"`
+	Owner      string        `json:"owner"`
+	Reason     string        `json:"reason"`
+	EnqueuedAt time.Time     `json:"enqueuedAt"`
+	Heartbeat  time.Time     `json:"-"`
+	Position   int           `bson:"-" json:"position"`
+}
+
+// LockWaitOpts are the options of AcquireApplicationLockQueued. Cancel aborts
+// the wait when it's closed or receives a value, like when the client of the
+// request disconnects. Progress, when set, is called every time the position
+// of the operation in the queue changes.
+type LockWaitOpts struct {
+	App      string
+	Owner    string
+	Reason   string
+	Timeout  time.Duration
+	Cancel   <-chan bool
+	Progress func(op *Operation)
+}
+
+func liveOperationsQuery(appName string) bson.M {
+	return bson.M{
+		"app":       appName,
+		"heartbeat": bson.M{"$gte": time.Now().UTC().Add(-operationStaleTimeout)},
+	}
+}
+
+// ListOperations returns the operations waiting for the lock of the app, in
+// the order they're going to acquire it.
+func ListOperations(appName string) ([]Operation, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var ops []Operation
+	err = conn.AppOperations().Find(liveOperationsQuery(appName)).Sort("enqueuedat", "_id").All(&ops)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		ops[i].Position = i + 1
+	}
+	return ops, nil
+}
+
+// GetOperation returns the operation with the given id waiting for the lock
+// of the app.
+func GetOperation(appName, id string) (*Operation, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, ErrOperationNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var op Operation
+	err = conn.AppOperations().Find(bson.M{"_id": bson.ObjectIdHex(id), "app": appName}).One(&op)
+	if err == mgo.ErrNotFound {
+		return nil, ErrOperationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// Cancel removes the operation from the queue. The request waiting for it
+// fails with ErrOperationCancelled.
+func (op *Operation) Cancel() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.AppOperations().RemoveId(op.ID)
+	if err == mgo.ErrNotFound {
+		return ErrOperationNotFound
+	}
+	return err
+}
+
+// position returns the place of the operation in the queue, refreshing its
+// heartbeat. ErrOperationCancelled is returned when the operation is not in
+// the queue anymore.
+func (op *Operation) position(conn *db.Storage) (int, error) {
+	err := conn.AppOperations().UpdateId(op.ID, bson.M{"$set": bson.M{"heartbeat": time.Now().UTC()}})
+	if err == mgo.ErrNotFound {
+		return 0, ErrOperationCancelled
+	}
+	if err != nil {
+		return 0, err
+	}
+	query := liveOperationsQuery(op.App)
+	query["$or"] = []bson.M{
+		{"enqueuedat": bson.M{"$lt": op.EnqueuedAt}},
+		{"enqueuedat": op.EnqueuedAt, "_id": bson.M{"$lt": op.ID}},
+	}
+	ahead, err := conn.AppOperations().Find(query).Count()
+	if err != nil {
+		return 0, err
+	}
+	return ahead + 1, nil
+}
+
+func tryApplicationLock(conn *db.Storage, appName, owner, reason string) (bool, error) {
+	appLock := AppLock{
+		Locked:      true,
+		Reason:      reason,
+		Owner:       owner,
+		AcquireDate: time.Now().In(time.UTC),
+	}
+	err := conn.Apps().Update(bson.M{"name": appName, "lock.locked": bson.M{"$in": []interface{}{false, nil}}}, bson.M{"$set": bson.M{"lock": appLock}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// AcquireApplicationLockQueued acquires the lock of the app, waiting in its
+// operation queue until the operations enqueued before it are done. It
+// returns false when the timeout is reached or the wait is cancelled by
+// opts.Cancel, and ErrOperationCancelled when the operation is cancelled
+// while in the queue. With a zero timeout the lock is only acquired if it's
+// free and there are no operations waiting for it.
+func AcquireApplicationLockQueued(opts LockWaitOpts) (bool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	queued, err := conn.AppOperations().Find(liveOperationsQuery(opts.App)).Count()
+	if err != nil {
+		return false, err
+	}
+	if queued == 0 {
+		locked, lockErr := tryApplicationLock(conn, opts.App, opts.Owner, opts.Reason)
+		if lockErr != nil || locked || opts.Timeout == 0 {
+			return locked, lockErr
+		}
+	} else if opts.Timeout == 0 {
+		return false, nil
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	_, err = conn.AppOperations().RemoveAll(bson.M{"app": opts.App, "heartbeat": bson.M{"$lt": now.Add(-operationStaleTimeout)}})
+	if err != nil {
+		return false, err
+	}
+	op := Operation{
+		ID:         bson.NewObjectId(),
+		App:        opts.App,
+		Owner:      opts.Owner,
+		Reason:     opts.Reason,
+		EnqueuedAt: now,
+		Heartbeat:  now,
+	}
+	err = conn.AppOperations().Insert(op)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if removeErr := conn.AppOperations().RemoveId(op.ID); removeErr != nil && removeErr != mgo.ErrNotFound {
+			log.Errorf("[lock-queue] unable to remove operation %s of app %s: %s", op.ID.Hex(), op.App, removeErr)
+		}
+	}()
+	timeoutChan := time.After(opts.Timeout)
+	for {
+		position, err := op.position(conn)
+		if err != nil {
+			return false, err
+		}
+		if position == 1 {
+			locked, err := tryApplicationLock(conn, opts.App, opts.Owner, opts.Reason)
+			if err != nil || locked {
+				return locked, err
+			}
+		}
+		if position != op.Position {
+			op.Position = position
+			if opts.Progress != nil {
+				opts.Progress(&op)
+			}
+		}
+		select {
+		case <-timeoutChan:
+			return false, nil
+		case <-opts.Cancel:
+			return false, nil
+		case <-time.After(300 * time.Millisecond):
+		}
+	}
+}
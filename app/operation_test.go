@@ -0,0 +1,178 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type lockResult struct {
+	locked bool
+	err    error
+}
+
+func waitQueuedOperations(c *check.C, appName string, n int) []Operation {
+	timeout := time.After(5 * time.Second)
+	for {
+		ops, err := ListOperations(appName)
+		c.Assert(err, check.IsNil)
+		if len(ops) == n {
+			return ops
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for %d queued operations, got %d", n, len(ops))
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestAcquireApplicationLockQueuedInOrder(c *check.C) {
+	a := App{Name: "test-lock-app"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	locked, err := AcquireApplicationLock(a.Name, "foo", "/something")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	first := make(chan lockResult, 1)
+	go func() {
+		locked, err := AcquireApplicationLockWait(a.Name, "first", "/first", 10*time.Second)
+		first <- lockResult{locked, err}
+	}()
+	waitQueuedOperations(c, a.Name, 1)
+	second := make(chan lockResult, 1)
+	var positions []int
+	go func() {
+		locked, err := AcquireApplicationLockQueued(LockWaitOpts{
+			App:     a.Name,
+			Owner:   "second",
+			Reason:  "/second",
+			Timeout: 10 * time.Second,
+			Progress: func(op *Operation) {
+				positions = append(positions, op.Position)
+			},
+		})
+		second <- lockResult{locked, err}
+	}()
+	ops := waitQueuedOperations(c, a.Name, 2)
+	c.Assert(ops[0].Owner, check.Equals, "first")
+	c.Assert(ops[0].Position, check.Equals, 1)
+	c.Assert(ops[1].Owner, check.Equals, "second")
+	c.Assert(ops[1].Position, check.Equals, 2)
+	ReleaseApplicationLock(a.Name)
+	result := <-first
+	c.Assert(result.err, check.IsNil)
+	c.Assert(result.locked, check.Equals, true)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Lock.Owner, check.Equals, "first")
+	ops = waitQueuedOperations(c, a.Name, 1)
+	c.Assert(ops[0].Owner, check.Equals, "second")
+	ReleaseApplicationLock(a.Name)
+	result = <-second
+	c.Assert(result.err, check.IsNil)
+	c.Assert(result.locked, check.Equals, true)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Lock.Owner, check.Equals, "second")
+	c.Assert(positions, check.DeepEquals, []int{2, 1})
+	waitQueuedOperations(c, a.Name, 0)
+}
+
+func (s *S) TestAcquireApplicationLockWithQueuedOperations(c *check.C) {
+	a := App{Name: "test-lock-app"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	err = s.conn.AppOperations().Insert(Operation{ID: bson.NewObjectId(), App: a.Name, Owner: "someone", EnqueuedAt: now, Heartbeat: now})
+	c.Assert(err, check.IsNil)
+	locked, err := AcquireApplicationLock(a.Name, "foo", "/something")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, false)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+}
+
+func (s *S) TestAcquireApplicationLockIgnoresStaleOperations(c *check.C) {
+	a := App{Name: "test-lock-app"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	past := time.Now().UTC().Add(-time.Hour)
+	err = s.conn.AppOperations().Insert(Operation{ID: bson.NewObjectId(), App: a.Name, Owner: "someone", EnqueuedAt: past, Heartbeat: past})
+	c.Assert(err, check.IsNil)
+	ops, err := ListOperations(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(ops, check.HasLen, 0)
+	locked, err := AcquireApplicationLock(a.Name, "foo", "/something")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+}
+
+func (s *S) TestAcquireApplicationLockQueuedCancelOperation(c *check.C) {
+	a := App{Name: "test-lock-app"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	locked, err := AcquireApplicationLock(a.Name, "foo", "/something")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	result := make(chan lockResult, 1)
+	go func() {
+		locked, err := AcquireApplicationLockWait(a.Name, "zzz", "/other", 10*time.Second)
+		result <- lockResult{locked, err}
+	}()
+	ops := waitQueuedOperations(c, a.Name, 1)
+	op, err := GetOperation(a.Name, ops[0].ID.Hex())
+	c.Assert(err, check.IsNil)
+	c.Assert(op.Owner, check.Equals, "zzz")
+	c.Assert(op.Reason, check.Equals, "/other")
+	err = op.Cancel()
+	c.Assert(err, check.IsNil)
+	r := <-result
+	c.Assert(r.err, check.Equals, ErrOperationCancelled)
+	c.Assert(r.locked, check.Equals, false)
+	err = op.Cancel()
+	c.Assert(err, check.Equals, ErrOperationNotFound)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Lock.Owner, check.Equals, "foo")
+}
+
+func (s *S) TestAcquireApplicationLockQueuedCancelChannel(c *check.C) {
+	a := App{Name: "test-lock-app"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	locked, err := AcquireApplicationLock(a.Name, "foo", "/something")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	cancel := make(chan bool)
+	result := make(chan lockResult, 1)
+	go func() {
+		locked, err := AcquireApplicationLockQueued(LockWaitOpts{
+			App:     a.Name,
+			Owner:   "zzz",
+			Reason:  "/other",
+			Timeout: 10 * time.Second,
+			Cancel:  cancel,
+		})
+		result <- lockResult{locked, err}
+	}()
+	waitQueuedOperations(c, a.Name, 1)
+	close(cancel)
+	r := <-result
+	c.Assert(r.err, check.IsNil)
+	c.Assert(r.locked, check.Equals, false)
+	waitQueuedOperations(c, a.Name, 0)
+}
+
+func (s *S) TestGetOperationNotFound(c *check.C) {
+	_, err := GetOperation("myapp", "invalid")
+	c.Assert(err, check.Equals, ErrOperationNotFound)
+	_, err = GetOperation("myapp", bson.NewObjectId().Hex())
+	c.Assert(err, check.Equals, ErrOperationNotFound)
+}
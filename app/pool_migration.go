@@ -0,0 +1,349 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrPoolMigrationSamePool  = &tsuruErrors.ValidationError{Message: "the app is already in the given pool"}
+	ErrPoolMigrationCanceled  = errors.New("pool migration canceled by user action")
+	ErrPoolProvisionerChanged = &tsuruErrors.ValidationError{Message: "the new pool uses a different provisioner, the app must be migrated to it"}
+
+	poolMigrationCheckInterval = 3 * time.Second
+)
+
+// MigratePoolOptions are the options of an app pool migration. Output is
+// written to Writer and cancel requests are read from Event, which must be
+// cancelable for the migration to be canceled.
+type MigratePoolOptions struct {
+	Pool   string
+	Writer io.Writer
+	Event  *event.Event
+}
+
+type poolMigration struct {
+	app     *App
+	oldApp  *App
+	oldProv provision.Provisioner
+	newProv provision.Provisioner
+	image   string
+	writer  io.Writer
+	evt     *event.Event
+}
+
+func (m *poolMigration) provisionerChanged() bool {
+	return m.oldProv.GetName() != m.newProv.GetName()
+}
+
+func (m *poolMigration) checkCanceled() error {
+	if m.evt == nil {
+		return nil
+	}
+	canceled, err := m.evt.AckCancel()
+	if err != nil {
+		log.Errorf("unable to check if pool migration should be canceled, ignoring: %s", err)
+		return nil
+	}
+	if canceled {
+		return ErrPoolMigrationCanceled
+	}
+	return nil
+}
+
+// MigratePool moves the app to another pool, possibly managed by another
+// provisioner. The current image of the app is deployed to the new pool and,
+// once the new units are available, the routes are switched to them and the
+// old units are destroyed. Any failure, or a cancel request before the routes
+// are switched, rolls the migration back, keeping the app in its old pool.
+func (app *App) MigratePool(opts MigratePoolOptions) error {
+	if opts.Pool == app.Pool {
+		return ErrPoolMigrationSamePool
+	}
+	if opts.Writer == nil {
+		opts.Writer = ioutil.Discard
+	}
+	oldProv, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	migrated := *app
+	migrated.Pool = opts.Pool
+	migrated.provisioner = nil
+	_, err = migrated.getPoolForApp(migrated.Pool)
+	if err != nil {
+		return err
+	}
+	err = migrated.validateUpdateConstraints("", "", true)
+	if err != nil {
+		return err
+	}
+	newProv, err := migrated.getProvisioner()
+	if err != nil {
+		return err
+	}
+	var img string
+	if app.Deploys > 0 {
+		img, err = image.AppCurrentImageName(app.Name)
+		if err != nil {
+			return err
+		}
+	}
+	m := &poolMigration{
+		app:     &migrated,
+		oldApp:  app,
+		oldProv: oldProv,
+		newProv: newProv,
+		image:   img,
+		writer:  opts.Writer,
+		evt:     opts.Event,
+	}
+	fmt.Fprintf(m.writer, "---- Migrating app %q from pool %q (%s) to pool %q (%s) ----\n",
+		app.Name, app.Pool, oldProv.GetName(), migrated.Pool, newProv.GetName())
+	pipeline := action.NewPipeline(
+		&migrateProvisionApp,
+		&migrateSetPool,
+		&migrateDeployImage,
+		&migrateWaitUnits,
+		&migrateSwitchRoutes,
+		&migrateDestroyOldUnits,
+	)
+	err = pipeline.Execute(m)
+	if err != nil {
+		return err
+	}
+	app.Pool = migrated.Pool
+	app.provisioner = newProv
+	app.Ip = migrated.Ip
+	return nil
+}
+
+var migrateProvisionApp = action.Action{
+	Name: "migrate-pool-provision-app",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		m := ctx.Params[0].(*poolMigration)
+		if !m.provisionerChanged() {
+			return m, nil
+		}
+		fmt.Fprintf(m.writer, " ---> Provisioning app in %s\n", m.newProv.GetName())
+		return m, m.newProv.Provision(m.app)
+	},
+	Backward: func(ctx action.BWContext) {
+		m := ctx.FWResult.(*poolMigration)
+		if !m.provisionerChanged() {
+			return
+		}
+		fmt.Fprintf(m.writer, " ---> Rolling back: destroying app in %s\n", m.newProv.GetName())
+		err := destroyAppUnits(m.newProv, m.app)
+		if err != nil {
+			log.Errorf("BACKWARD migrate pool - unable to destroy app %q in %s: %s", m.app.Name, m.newProv.GetName(), err)
+		}
+	},
+}
+
+// migrateSetPool stores the new pool of the app before any unit is deployed,
+// as provisioners read the pool from the database when scheduling units.
+var migrateSetPool = action.Action{
+	Name: "migrate-pool-set-pool",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		m := ctx.Params[0].(*poolMigration)
+		return m, setAppPool(m.app.Name, m.app.Pool)
+	},
+	Backward: func(ctx action.BWContext) {
+		m := ctx.FWResult.(*poolMigration)
+		err := setAppPool(m.app.Name, m.oldApp.Pool)
+		if err != nil {
+			log.Errorf("BACKWARD migrate pool - unable to restore pool of app %q: %s", m.app.Name, err)
+		}
+	},
+}
+
+var migrateDeployImage = action.Action{
+	Name: "migrate-pool-deploy-image",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		m := ctx.Params[0].(*poolMigration)
+		if err := m.checkCanceled(); err != nil {
+			return nil, err
+		}
+		if m.image == "" {
+			return m, nil
+		}
+		deployer, ok := m.newProv.(provision.ImageDeployer)
+		if !ok {
+			return nil, errors.Errorf("provisioner %s doesn't support image deploys", m.newProv.GetName())
+		}
+		fmt.Fprintf(m.writer, " ---> Deploying image %s\n", m.image)
+		_, err := deployer.ImageDeploy(m.app, m.image, m.evt)
+		if err != nil {
+			return nil, err
+		}
+		if !m.provisionerChanged() {
+			return m, nil
+		}
+		return m, m.matchUnits()
+	},
+	Backward: func(ctx action.BWContext) {
+		m := ctx.FWResult.(*poolMigration)
+		if m.image == "" || m.provisionerChanged() {
+			return
+		}
+		fmt.Fprintf(m.writer, " ---> Rolling back: deploying image %s to pool %q\n", m.image, m.oldApp.Pool)
+		err := setAppPool(m.app.Name, m.oldApp.Pool)
+		if err != nil {
+			log.Errorf("BACKWARD migrate pool - unable to restore pool of app %q: %s", m.app.Name, err)
+			return
+		}
+		_, err = m.oldProv.(provision.ImageDeployer).ImageDeploy(m.oldApp, m.image, m.evt)
+		if err != nil {
+			log.Errorf("BACKWARD migrate pool - unable to deploy app %q to pool %q: %s", m.app.Name, m.oldApp.Pool, err)
+		}
+	},
+}
+
+// matchUnits adds units to the new provisioner until each process has as
+// many units as it had in the old one.
+func (m *poolMigration) matchUnits() error {
+	oldUnits, err := m.oldProv.Units(m.oldApp)
+	if err != nil {
+		return err
+	}
+	newUnits, err := m.newProv.Units(m.app)
+	if err != nil {
+		return err
+	}
+	missing := make(map[string]int)
+	for _, u := range oldUnits {
+		missing[u.ProcessName]++
+	}
+	for _, u := range newUnits {
+		missing[u.ProcessName]--
+	}
+	for process, n := range missing {
+		if n <= 0 {
+			continue
+		}
+		fmt.Fprintf(m.writer, " ---> Adding %d units to process %q\n", n, process)
+		err = m.newProv.AddUnits(m.app, uint(n), process, m.writer)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var migrateWaitUnits = action.Action{
+	Name: "migrate-pool-wait-units",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		m := ctx.Params[0].(*poolMigration)
+		maxWaitTime, _ := config.GetInt("docker:healthcheck:max-time")
+		if maxWaitTime == 0 {
+			maxWaitTime = 120
+		}
+		fmt.Fprintf(m.writer, " ---> Waiting for units to become available\n")
+		timeout := time.After(time.Duration(maxWaitTime) * time.Second)
+		for {
+			if err := m.checkCanceled(); err != nil {
+				return nil, err
+			}
+			units, err := m.newProv.Units(m.app)
+			if err != nil {
+				return nil, err
+			}
+			available := true
+			for _, u := range units {
+				if !u.Available() {
+					available = false
+					break
+				}
+			}
+			if available {
+				return m, nil
+			}
+			select {
+			case <-timeout:
+				return nil, errors.Errorf("timeout waiting for units of app %q to become available in pool %q", m.app.Name, m.app.Pool)
+			case <-time.After(poolMigrationCheckInterval):
+			}
+		}
+	},
+}
+
+var migrateSwitchRoutes = action.Action{
+	Name: "migrate-pool-switch-routes",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		m := ctx.Params[0].(*poolMigration)
+		if err := m.checkCanceled(); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(m.writer, " ---> Switching routes to pool %q\n", m.app.Pool)
+		_, err := rebuild.RebuildRoutes(m.app)
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		m := ctx.FWResult.(*poolMigration)
+		fmt.Fprintf(m.writer, " ---> Rolling back: switching routes to pool %q\n", m.oldApp.Pool)
+		_, err := rebuild.RebuildRoutes(m.oldApp)
+		if err != nil {
+			log.Errorf("BACKWARD migrate pool - unable to rebuild routes of app %q: %s", m.app.Name, err)
+		}
+	},
+}
+
+var migrateDestroyOldUnits = action.Action{
+	Name: "migrate-pool-destroy-old-units",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		m := ctx.Params[0].(*poolMigration)
+		if !m.provisionerChanged() {
+			return m, nil
+		}
+		fmt.Fprintf(m.writer, " ---> Destroying old units in %s\n", m.oldProv.GetName())
+		err := destroyAppUnits(m.oldProv, m.oldApp)
+		if err != nil {
+			// Routes already point to the new units, rolling back now would
+			// cause more harm than leaving the old units behind.
+			log.Errorf("unable to destroy old units of app %q in %s: %s", m.app.Name, m.oldProv.GetName(), err)
+			fmt.Fprintf(m.writer, " ---> WARNING: unable to destroy old units: %s\n", err)
+		}
+		return m, nil
+	},
+}
+
+// destroyAppUnits removes the units of the app from the provisioner. The
+// images of the app are shared by both provisioners during the migration, so
+// they're kept whenever the provisioner supports it.
+func destroyAppUnits(prov provision.Provisioner, app *App) error {
+	if unitsProv, ok := prov.(provision.UnitsDestroyerProvisioner); ok {
+		return unitsProv.DestroyUnits(app)
+	}
+	return prov.Destroy(app)
+}
+
+func setAppPool(appName, pool string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Apps().Update(bson.M{"name": appName}, bson.M{"$set": bson.M{"pool": pool}})
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+type migrationFakeProvisioner struct {
+	*provisiontest.FakeProvisioner
+}
+
+func (p *migrationFakeProvisioner) GetName() string {
+	return "fake-migration"
+}
+
+func (s *S) addMigrationPool(c *check.C) *migrationFakeProvisioner {
+	prov := &migrationFakeProvisioner{FakeProvisioner: provisiontest.NewFakeProvisioner()}
+	provision.Register("fake-migration", func() (provision.Provisioner, error) {
+		return prov, nil
+	})
+	err := provision.AddPool(provision.AddPoolOptions{Name: "migration", Public: true, Provisioner: "fake-migration"})
+	c.Assert(err, check.IsNil)
+	return prov
+}
+
+func (s *S) createMigrationApp(c *check.C) *App {
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	s.provisioner.AddUnits(&a, 1, "worker", nil)
+	err = image.AppendAppImageName(a.Name, "tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	a.Deploys = 1
+	return &a
+}
+
+func (s *S) TestMigratePool(c *check.C) {
+	prov := s.addMigrationPool(c)
+	defer provision.Unregister("fake-migration")
+	defer provision.RemovePool("migration")
+	a := s.createMigrationApp(c)
+	var buf bytes.Buffer
+	err := a.MigratePool(MigratePoolOptions{Pool: "migration", Writer: &buf})
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Pool, check.Equals, "migration")
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, "migration")
+	c.Assert(s.provisioner.Provisioned(a), check.Equals, false)
+	c.Assert(prov.Provisioned(a), check.Equals, true)
+	units := prov.GetUnits(a)
+	c.Assert(units, check.HasLen, 3)
+	for _, u := range units {
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, u.Address.String()), check.Equals, true)
+	}
+	c.Assert(buf.String(), check.Matches, `(?s).*Deploying image tsuru/app-myapp:v1.*Switching routes.*Destroying old units.*`)
+}
+
+func (s *S) TestMigratePoolKeepsImages(c *check.C) {
+	s.addMigrationPool(c)
+	defer provision.Unregister("fake-migration")
+	defer provision.RemovePool("migration")
+	a := s.createMigrationApp(c)
+	s.provisioner.PrepareFailure("Destroy", errors.New("images would be removed"))
+	err := a.MigratePool(MigratePoolOptions{Pool: "migration"})
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.Provisioned(a), check.Equals, false)
+	imgs, err := image.ListAppImages(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(imgs, check.DeepEquals, []string{"tsuru/app-myapp:v1"})
+}
+
+func (s *S) TestMigratePoolRollback(c *check.C) {
+	prov := s.addMigrationPool(c)
+	defer provision.Unregister("fake-migration")
+	defer provision.RemovePool("migration")
+	a := s.createMigrationApp(c)
+	prov.PrepareFailure("ImageDeploy", errors.New("deploy failed"))
+	err := a.MigratePool(MigratePoolOptions{Pool: "migration"})
+	c.Assert(err, check.ErrorMatches, ".*deploy failed")
+	c.Assert(a.Pool, check.Equals, s.Pool)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, s.Pool)
+	c.Assert(prov.Provisioned(a), check.Equals, false)
+	c.Assert(s.provisioner.GetUnits(a), check.HasLen, 3)
+}
+
+func (s *S) TestMigratePoolSamePool(c *check.C) {
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.MigratePool(MigratePoolOptions{Pool: a.Pool})
+	c.Assert(err, check.Equals, ErrPoolMigrationSamePool)
+}
+
+func (s *S) TestUpdatePoolProvisionerChanged(c *check.C) {
+	s.addMigrationPool(c)
+	defer provision.Unregister("fake-migration")
+	defer provision.RemovePool("migration")
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.Update(App{Pool: "migration"}, new(bytes.Buffer))
+	c.Assert(err, check.Equals, ErrPoolProvisionerChanged)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Pool, check.Equals, s.Pool)
+}
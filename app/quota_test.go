@@ -5,9 +5,11 @@
 package app
 
 import (
+	"errors"
 	"runtime"
 	"sync"
 
+	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2"
@@ -197,3 +199,81 @@ func (s *S) TestChangeQuotaAppNotFound(c *check.C) {
 	c.Assert(err, check.NotNil)
 	c.Assert(err, check.Equals, mgo.ErrNotFound)
 }
+
+func (s *S) TestCreateAppTeamQuotaExceeded(c *check.C) {
+	err := auth.ChangeTeamQuota(s.team.Name, auth.TeamResources{Apps: 0, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "together", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{
+		Resource:  "apps of team " + s.team.Name,
+		Available: 0,
+		Requested: 1,
+	})
+	_, err = GetByName(a.Name)
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestCreateAppTeamQuotaReleasedOnRollback(c *check.C) {
+	err := auth.ChangeTeamQuota(s.team.Name, auth.TeamResources{Apps: 1, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareFailure("Provision", errors.New("exit status 1"))
+	a := App{Name: "together", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.NotNil)
+	team, err := auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaReservations, check.HasLen, 0)
+	err = CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	team, err = auth.GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaReservations, check.HasLen, 0)
+	b := App{Name: "apart", Platform: "python", TeamOwner: s.team.Name}
+	err = CreateApp(&b, s.user)
+	c.Assert(err, check.FitsTypeOf, &quota.QuotaExceededError{})
+}
+
+func (s *S) TestAddUnitsTeamQuotaExceeded(c *check.C) {
+	a := App{Name: "together", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = auth.ChangeTeamQuota(s.team.Name, auth.TeamResources{Apps: -1, Units: 2, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(3, "web", nil)
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{
+		Resource:  "units of team " + s.team.Name,
+		Available: 2,
+		Requested: 3,
+	})
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+}
+
+func (s *S) TestChangePlanTeamMemoryQuotaExceeded(c *check.C) {
+	plans := []Plan{
+		{Name: "small", Memory: 512},
+		{Name: "large", Memory: 2048},
+	}
+	for _, p := range plans {
+		err := s.conn.Plans().Insert(p)
+		c.Assert(err, check.IsNil)
+	}
+	a := App{Name: "together", Platform: "python", TeamOwner: s.team.Name, Plan: Plan{Name: "small"}}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.AddUnits(2, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = auth.ChangeTeamQuota(s.team.Name, auth.TeamResources{Apps: -1, Units: -1, Memory: 2048, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	err = a.Update(App{Plan: Plan{Name: "large"}}, nil)
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{
+		Resource:  "memory of team " + s.team.Name,
+		Available: 1024,
+		Requested: 3072,
+	})
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Plan.Name, check.Equals, "small")
+}
@@ -0,0 +1,316 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/rebuild"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MinIdleTimeout is the shortest idle timeout accepted in sleep policies.
+const MinIdleTimeout = time.Minute
+
+var (
+	ErrInvalidIdleTimeout       = &tsuruErrors.ValidationError{Message: fmt.Sprintf("idle timeout must be at least %s", MinIdleTimeout)}
+	ErrWakeupProxyNotConfigured = errors.New("wake-up proxy not configured, sleep:wakeup:url must be set")
+	ErrRouterWithoutTraffic     = &tsuruErrors.ValidationError{Message: "the router of the app doesn't report traffic, sleep policies are not supported"}
+)
+
+// SleepPolicy puts the app to sleep automatically after IdleTimeout without
+// requests. LastActivity is the last time the app was woken up or had its
+// policy set, it's used when the router has no newer request to report.
+type SleepPolicy struct {
+	IdleTimeout  time.Duration `json:"idleTimeout"`
+	LastActivity time.Time     `json:"lastActivity"`
+}
+
+// WakeupProxyURL returns the address of the wake-up proxy served by tsuru,
+// read from the sleep:wakeup:url config.
+func WakeupProxyURL() (*url.URL, error) {
+	value, _ := config.GetString("sleep:wakeup:url")
+	if value == "" {
+		return nil, ErrWakeupProxyNotConfigured
+	}
+	return url.Parse(value)
+}
+
+// GetByHost returns the app whose router address or one of its cnames is the
+// given host, port is ignored.
+func GetByHost(host string) (*App, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var app App
+	err = conn.Apps().Find(bson.M{"$or": []bson.M{{"ip": host}, {"cname": host}}}).One(&app)
+	if err == mgo.ErrNotFound {
+		return nil, ErrAppNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+func (app *App) setSleeping(sleeping bool) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"sleeping": sleeping}})
+	if err != nil {
+		return err
+	}
+	app.Sleeping = sleeping
+	return nil
+}
+
+// SetSleepPolicy enables the automatic sleep of the app when it's idle, or
+// disables it when policy is nil. Idle apps are routed to the wake-up proxy,
+// so it must be configured.
+func (app *App) SetSleepPolicy(policy *SleepPolicy) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if policy == nil {
+		app.SleepPolicy = nil
+		return conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$unset": bson.M{"sleeppolicy": ""}})
+	}
+	if policy.IdleTimeout < MinIdleTimeout {
+		return ErrInvalidIdleTimeout
+	}
+	if _, err = WakeupProxyURL(); err != nil {
+		return err
+	}
+	r, err := app.Router()
+	if err != nil {
+		return err
+	}
+	if _, ok := r.(router.TrafficRouter); !ok {
+		return ErrRouterWithoutTraffic
+	}
+	newPolicy := SleepPolicy{
+		IdleTimeout:  policy.IdleTimeout,
+		LastActivity: time.Now().UTC(),
+	}
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"sleeppolicy": newPolicy}})
+	if err != nil {
+		return err
+	}
+	app.SleepPolicy = &newPolicy
+	return nil
+}
+
+// Wake starts the units of a sleeping app and rebuilds its routes, replacing
+// the route to the wake-up proxy with the addresses of the units.
+func (app *App) Wake() (err error) {
+	if app.Deletion != nil {
+		return ErrAppDeleted
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: app.Name},
+		InternalKind: "app-wakeup",
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			append(permission.Contexts(permission.CtxTeam, app.Teams),
+				permission.Context(permission.CtxApp, app.Name),
+				permission.Context(permission.CtxPool, app.Pool),
+			)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	prov, err := app.getProvisioner()
+	if err != nil {
+		return err
+	}
+	err = prov.Start(app, "")
+	if err != nil {
+		log.Errorf("[wakeup] error on wake up the app %s - %s", app.Name, err)
+		return err
+	}
+	result, err := rebuild.RebuildRoutes(app)
+	if err != nil {
+		log.Errorf("[wakeup] error rebuilding routes of the app %s - %s", app.Name, err)
+		return err
+	}
+	evt.Logf("routes added: %v, routes removed: %v", result.Added, result.Removed)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	set := bson.M{"sleeping": false}
+	if app.SleepPolicy != nil {
+		set["sleeppolicy.lastactivity"] = time.Now().UTC()
+	}
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	app.Sleeping = false
+	return nil
+}
+
+// lastActivity returns the last time the app received a request, according
+// to its router, or the last activity recorded in its sleep policy when it's
+// newer. Newer requests are recorded in the policy, as routers may only report
+// recent traffic. The returned bool is false when the router doesn't report
+// traffic.
+func (app *App) lastActivity() (time.Time, bool, error) {
+	r, err := app.Router()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	trafficRouter, ok := r.(router.TrafficRouter)
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	last, err := trafficRouter.LastRequest(app.Name)
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	if !last.After(app.SleepPolicy.LastActivity) {
+		return app.SleepPolicy.LastActivity, true, nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"sleeppolicy.lastactivity": last}})
+	if err != nil {
+		return time.Time{}, true, err
+	}
+	app.SleepPolicy.LastActivity = last
+	return last, true, nil
+}
+
+func (app *App) sleepIdle(proxyURL *url.URL, idle time.Duration) (err error) {
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: app.Name},
+		InternalKind: "app-idle-sleep",
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			append(permission.Contexts(permission.CtxTeam, app.Teams),
+				permission.Context(permission.CtxApp, app.Name),
+				permission.Context(permission.CtxPool, app.Pool),
+			)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	evt.Logf("app %q idle for %s", app.Name, idle)
+	return app.Sleep(evt, "", proxyURL)
+}
+
+// sleepIdleApps puts to sleep the apps with a sleep policy that didn't receive
+// requests for longer than their idle timeout.
+func sleepIdleApps() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"sleeppolicy": bson.M{"$ne": nil}, "sleeping": bson.M{"$ne": true}, "deletion": nil, "maintenance": nil}).All(&apps)
+	if err != nil {
+		return err
+	}
+	if len(apps) == 0 {
+		return nil
+	}
+	proxyURL, err := WakeupProxyURL()
+	if err != nil {
+		return err
+	}
+	for i := range apps {
+		app := &apps[i]
+		last, supported, err := app.lastActivity()
+		if err != nil {
+			log.Errorf("[idle-sleep] unable to get last request of app %s: %s", app.Name, err)
+			continue
+		}
+		if !supported {
+			log.Debugf("[idle-sleep] router of app %s doesn't report traffic, ignoring sleep policy", app.Name)
+			continue
+		}
+		idle := time.Since(last)
+		if idle < app.SleepPolicy.IdleTimeout {
+			continue
+		}
+		err = app.sleepIdle(proxyURL, idle)
+		if err != nil {
+			log.Errorf("[idle-sleep] unable to put app %s to sleep: %s", app.Name, err)
+		}
+	}
+	return nil
+}
+
+// IdleSleeper periodically puts idle apps to sleep, according to their sleep
+// policies.
+type IdleSleeper struct {
+	interval time.Duration
+	done     chan bool
+}
+
+// StartIdleSleeper starts the checker of idle apps. The check interval is
+// read from the sleep:idle-check-interval config, in seconds, and defaults to
+// one minute.
+func StartIdleSleeper() *IdleSleeper {
+	interval, _ := config.GetInt("sleep:idle-check-interval")
+	s := &IdleSleeper{
+		interval: time.Duration(interval) * time.Second,
+		done:     make(chan bool),
+	}
+	if s.interval == 0 {
+		s.interval = time.Minute
+	}
+	go s.run()
+	return s
+}
+
+func (s *IdleSleeper) run() {
+	for {
+		err := sleepIdleApps()
+		if err != nil {
+			log.Errorf("[idle-sleep] %s", err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *IdleSleeper) Shutdown() {
+	s.done <- true
+}
+
+func (s *IdleSleeper) String() string {
+	return "idle apps sleeper"
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"net/url"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/router"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestGetByHost(c *check.C) {
+	a := App{Name: "myapp", Ip: "myapp.fakerouter.com", CName: []string{"myapp.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	for _, host := range []string{"myapp.fakerouter.com", "myapp.example.com", "myapp.example.com:8080"} {
+		found, err := GetByHost(host)
+		c.Assert(err, check.IsNil)
+		c.Assert(found.Name, check.Equals, a.Name)
+	}
+	_, err = GetByHost("other.example.com")
+	c.Assert(err, check.Equals, ErrAppNotFound)
+}
+
+func (s *S) TestSetSleepPolicy(c *check.C) {
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.SetSleepPolicy(&SleepPolicy{IdleTimeout: 30 * time.Minute})
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.SleepPolicy, check.NotNil)
+	c.Assert(dbApp.SleepPolicy.IdleTimeout, check.Equals, 30*time.Minute)
+	c.Assert(dbApp.SleepPolicy.LastActivity.IsZero(), check.Equals, false)
+	err = a.SetSleepPolicy(nil)
+	c.Assert(err, check.IsNil)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.SleepPolicy, check.IsNil)
+}
+
+func (s *S) TestSetSleepPolicyInvalid(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.SetSleepPolicy(&SleepPolicy{IdleTimeout: 30 * time.Minute})
+	c.Assert(err, check.Equals, ErrWakeupProxyNotConfigured)
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	err = a.SetSleepPolicy(&SleepPolicy{IdleTimeout: time.Second})
+	c.Assert(err, check.Equals, ErrInvalidIdleTimeout)
+}
+
+type noTrafficRouter struct {
+	router.Router
+}
+
+func (s *S) TestSetSleepPolicyRouterWithoutTraffic(c *check.C) {
+	router.Register("fake-no-traffic", func(name, prefix string) (router.Router, error) {
+		return noTrafficRouter{Router: &routertest.FakeRouter}, nil
+	})
+	config.Set("routers:fake-no-traffic:type", "fake-no-traffic")
+	defer config.Unset("routers:fake-no-traffic")
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	a := App{Name: "myapp", Plan: Plan{Router: "fake-no-traffic"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.SetSleepPolicy(&SleepPolicy{IdleTimeout: 30 * time.Minute})
+	c.Assert(err, check.Equals, ErrRouterWithoutTraffic)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.SleepPolicy, check.IsNil)
+}
+
+func (s *S) TestSleepAndWake(c *check.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}, TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	var b bytes.Buffer
+	proxyURL := &url.URL{Scheme: "http", Host: "tsuru-wakeup:8081"}
+	err = a.Sleep(&b, "", proxyURL)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, true)
+	err = dbApp.Wake()
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.Starts(dbApp, ""), check.Equals, 1)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, proxyURL.String()), check.Equals, false)
+	units, err := dbApp.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, units[0].Address.String()), check.Equals, true)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, false)
+}
+
+func (s *S) TestStartUnsetsSleeping(c *check.C) {
+	a := App{Name: "myapp", Plan: Plan{Router: "fake"}, TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	var b bytes.Buffer
+	err = a.Sleep(&b, "", &url.URL{Scheme: "http", Host: "tsuru-wakeup:8081"})
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Sleeping, check.Equals, true)
+	err = a.Start(&b, "")
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, false)
+}
+
+func (s *S) TestSleepIdleApps(c *check.C) {
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	idle := App{Name: "idle-app", Plan: Plan{Router: "fake"}, TeamOwner: s.team.Name}
+	err := CreateApp(&idle, s.user)
+	c.Assert(err, check.IsNil)
+	active := App{Name: "active-app", Plan: Plan{Router: "fake"}, TeamOwner: s.team.Name}
+	err = CreateApp(&active, s.user)
+	c.Assert(err, check.IsNil)
+	for _, a := range []*App{&idle, &active} {
+		s.provisioner.AddUnits(a, 1, "web", nil)
+		err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"sleeppolicy": SleepPolicy{
+			IdleTimeout:  time.Hour,
+			LastActivity: time.Now().UTC().Add(-2 * time.Hour),
+		}}})
+		c.Assert(err, check.IsNil)
+	}
+	routertest.FakeRouter.SetLastRequest(idle.Name, time.Now().Add(-90*time.Minute))
+	routertest.FakeRouter.SetLastRequest(active.Name, time.Now().Add(-time.Minute))
+	err = sleepIdleApps()
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(idle.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, true)
+	c.Assert(s.provisioner.Sleeps(dbApp, ""), check.Equals, 1)
+	c.Assert(routertest.FakeRouter.HasRoute(idle.Name, "http://tsuru-wakeup:8081"), check.Equals, true)
+	dbApp, err = GetByName(active.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, false)
+	c.Assert(s.provisioner.Sleeps(dbApp, ""), check.Equals, 0)
+	c.Assert(dbApp.SleepPolicy.LastActivity.After(time.Now().Add(-2*time.Minute)), check.Equals, true)
+}
+
+func (s *S) TestSleepIdleAppsSkipsDeletedApps(c *check.C) {
+	config.Set("sleep:wakeup:url", "http://tsuru-wakeup:8081")
+	defer config.Unset("sleep:wakeup:url")
+	a := App{Name: "idle-app", Plan: Plan{Router: "fake"}, TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{
+		"sleeppolicy": SleepPolicy{IdleTimeout: time.Hour, LastActivity: time.Now().UTC().Add(-2 * time.Hour)},
+		"deletion":    Deletion{Owner: s.user.Email, Date: time.Now().UTC(), PurgeAt: time.Now().UTC().Add(time.Hour)},
+	}})
+	c.Assert(err, check.IsNil)
+	routertest.FakeRouter.SetLastRequest(a.Name, time.Now().Add(-90*time.Minute))
+	err = sleepIdleApps()
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Sleeping, check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, "http://tsuru-wakeup:8081"), check.Equals, false)
+}
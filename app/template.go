@@ -0,0 +1,146 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+)
+
+var (
+	ErrAppTemplateNotFound      = errors.New("app template not found")
+	ErrAppTemplateAlreadyExists = errors.New("app template already exists")
+	ErrAppTemplateNameRequired  = &tsuruErrors.ValidationError{Message: "app template name is required"}
+)
+
+// AppTemplate is a configuration defined by admins that new apps can start
+// from. Empty fields are left for the app creator to choose.
+type AppTemplate struct {
+	Name        string            `bson:"_id" json:"name"`
+	Description string            `json:"description"`
+	Platform    string            `json:"platform"`
+	Plan        string            `json:"plan"`
+	Pool        string            `json:"pool"`
+	Envs        map[string]string `json:"envs"`
+}
+
+// Save validates the template and stores it.
+func (t *AppTemplate) Save() error {
+	if t.Name == "" {
+		return ErrAppTemplateNameRequired
+	}
+	if t.Platform != "" {
+		if _, err := GetPlatform(t.Platform); err != nil {
+			return err
+		}
+	}
+	if t.Plan != "" {
+		if _, err := findPlanByName(t.Plan); err != nil {
+			return err
+		}
+	}
+	if t.Pool != "" {
+		if _, err := provision.GetPoolByName(t.Pool); err != nil {
+			return err
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.AppTemplates().Insert(t)
+	if mgo.IsDup(err) {
+		return ErrAppTemplateAlreadyExists
+	}
+	return err
+}
+
+// GetAppTemplate returns the template with the given name.
+func GetAppTemplate(name string) (*AppTemplate, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var t AppTemplate
+	err = conn.AppTemplates().FindId(name).One(&t)
+	if err == mgo.ErrNotFound {
+		return nil, ErrAppTemplateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListAppTemplates returns all app templates.
+func ListAppTemplates() ([]AppTemplate, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var templates []AppTemplate
+	err = conn.AppTemplates().Find(nil).Sort("_id").All(&templates)
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// RemoveAppTemplate removes the template with the given name. Apps created
+// from it are not affected.
+func RemoveAppTemplate(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.AppTemplates().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrAppTemplateNotFound
+	}
+	return err
+}
+
+// Apply fills the fields of the app that were not set with the values of the
+// template. It must be called before the app is created.
+func (t *AppTemplate) Apply(app *App) {
+	if app.Platform == "" {
+		app.Platform = t.Platform
+	}
+	if app.Plan.Name == "" {
+		app.Plan.Name = t.Plan
+	}
+	if app.Pool == "" {
+		app.Pool = t.Pool
+	}
+	if app.Description == "" {
+		app.Description = t.Description
+	}
+}
+
+// SetEnvs sets the environment variables of the template in an app created
+// from it.
+func (t *AppTemplate) SetEnvs(app *App, owner string, w io.Writer) error {
+	names := make([]string, 0, len(t.Envs))
+	for name := range t.Envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	envs := make([]bind.EnvVar, len(names))
+	for i, name := range names {
+		envs[i] = bind.EnvVar{Name: name, Value: t.Envs[name], Public: true}
+	}
+	return app.SetEnvs(bind.SetEnvApp{Envs: envs, Owner: owner}, w)
+}
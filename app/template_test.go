@@ -0,0 +1,74 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAppTemplateSave(c *check.C) {
+	tpl := AppTemplate{
+		Name:     "python-web",
+		Platform: "python",
+		Plan:     s.defaultPlan.Name,
+		Pool:     s.Pool,
+		Envs:     map[string]string{"WORKERS": "4"},
+	}
+	err := tpl.Save()
+	c.Assert(err, check.IsNil)
+	dbTpl, err := GetAppTemplate(tpl.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(*dbTpl, check.DeepEquals, tpl)
+	err = tpl.Save()
+	c.Assert(err, check.Equals, ErrAppTemplateAlreadyExists)
+	templates, err := ListAppTemplates()
+	c.Assert(err, check.IsNil)
+	c.Assert(templates, check.DeepEquals, []AppTemplate{tpl})
+	err = RemoveAppTemplate(tpl.Name)
+	c.Assert(err, check.IsNil)
+	_, err = GetAppTemplate(tpl.Name)
+	c.Assert(err, check.Equals, ErrAppTemplateNotFound)
+	err = RemoveAppTemplate(tpl.Name)
+	c.Assert(err, check.Equals, ErrAppTemplateNotFound)
+}
+
+func (s *S) TestAppTemplateSaveInvalid(c *check.C) {
+	tests := []struct {
+		tpl AppTemplate
+		err error
+	}{
+		{AppTemplate{}, ErrAppTemplateNameRequired},
+		{AppTemplate{Name: "t", Platform: "cobol"}, InvalidPlatformError},
+		{AppTemplate{Name: "t", Plan: "huge"}, ErrPlanNotFound},
+		{AppTemplate{Name: "t", Pool: "unknown"}, provision.ErrPoolNotFound},
+	}
+	for _, t := range tests {
+		c.Check(t.tpl.Save(), check.Equals, t.err)
+	}
+}
+
+func (s *S) TestAppTemplateApply(c *check.C) {
+	tpl := AppTemplate{Platform: "python", Plan: "small", Pool: "pool2", Description: "vetted"}
+	a := App{Name: "myapp", Pool: "pool1"}
+	tpl.Apply(&a)
+	c.Assert(a.Platform, check.Equals, "python")
+	c.Assert(a.Plan.Name, check.Equals, "small")
+	c.Assert(a.Pool, check.Equals, "pool1")
+	c.Assert(a.Description, check.Equals, "vetted")
+}
+
+func (s *S) TestAppTemplateSetEnvs(c *check.C) {
+	a := App{Name: "myapp", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	tpl := AppTemplate{Envs: map[string]string{"WORKERS": "4", "LOG_LEVEL": "info"}}
+	err = tpl.SetEnvs(&a, s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Env["WORKERS"].Value, check.Equals, "4")
+	c.Assert(dbApp.Env["LOG_LEVEL"].Value, check.Equals, "info")
+}
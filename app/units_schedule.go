@@ -0,0 +1,302 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// maxUnitsScheduleLookback limits how far in the past the scheduler looks for
+// rules that should have fired, after tsuru was down or the app was manually
+// scaled.
+const maxUnitsScheduleLookback = 7 * 24 * time.Hour
+
+var ErrUnitsScheduleProcessRequired = &tsuruErrors.ValidationError{Message: "process is required in units schedule rules"}
+
+// UnitsScheduleRule sets the number of units of a process every time its cron
+// expression fires. Timezone is the name of the location used to evaluate
+// the expression, defaulting to UTC.
+type UnitsScheduleRule struct {
+	Process  string `json:"process"`
+	Cron     string `json:"cron"`
+	Units    uint   `json:"units"`
+	Timezone string `json:"timezone,omitempty"`
+}
+
+func (r *UnitsScheduleRule) validate() error {
+	if r.Process == "" {
+		return ErrUnitsScheduleProcessRequired
+	}
+	if r.Units == 0 {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("units schedule rule %q of process %s must set at least one unit", r.Cron, r.Process)}
+	}
+	if _, err := parseCron(r.Cron); err != nil {
+		return &tsuruErrors.ValidationError{Message: err.Error()}
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("invalid timezone %q: %s", r.Timezone, err)}
+	}
+	return nil
+}
+
+// UnitsSchedule holds the scheduled scaling rules of an app. LastCheck is the
+// last time the rules were evaluated and ManualOverrideUntil the end of the
+// window after a manual scaling in which the rules are not enforced. Rules
+// firing inside the window are applied once it ends.
+type UnitsSchedule struct {
+	Rules               []UnitsScheduleRule `json:"rules"`
+	LastCheck           time.Time           `json:"lastCheck"`
+	ManualOverrideUntil time.Time           `json:"manualOverrideUntil"`
+}
+
+// SetUnitsSchedule replaces the scheduled scaling rules of the app. An empty
+// list of rules removes the schedule.
+func (app *App) SetUnitsSchedule(rules []UnitsScheduleRule) error {
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return err
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(rules) == 0 {
+		app.UnitsSchedule = nil
+		return conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$unset": bson.M{"unitsschedule": ""}})
+	}
+	schedule := UnitsSchedule{Rules: rules, LastCheck: time.Now().UTC()}
+	if app.UnitsSchedule != nil {
+		schedule.ManualOverrideUntil = app.UnitsSchedule.ManualOverrideUntil
+	}
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"unitsschedule": schedule}})
+	if err != nil {
+		return err
+	}
+	app.UnitsSchedule = &schedule
+	return nil
+}
+
+// MarkManualScale starts the manual override window of the units schedule of
+// the app, if it has one. Its length is read from the
+// units-schedule:manual-override-window config, in seconds, and defaults to
+// one hour.
+func (app *App) MarkManualScale() error {
+	if app.UnitsSchedule == nil {
+		return nil
+	}
+	window, _ := config.GetInt("units-schedule:manual-override-window")
+	if window == 0 {
+		window = 3600
+	}
+	until := time.Now().UTC().Add(time.Duration(window) * time.Second)
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"unitsschedule.manualoverrideuntil": until}})
+	if err != nil {
+		return err
+	}
+	app.UnitsSchedule.ManualOverrideUntil = until
+	return nil
+}
+
+// dueRules returns, for each process, the rule that fired last since the
+// previous check of the schedule.
+func (s *UnitsSchedule) dueRules(now time.Time) map[string]UnitsScheduleRule {
+	from := s.LastCheck
+	if now.Sub(from) > maxUnitsScheduleLookback {
+		from = now.Add(-maxUnitsScheduleLookback)
+	}
+	due := make(map[string]UnitsScheduleRule)
+	lastFires := make(map[string]time.Time)
+	for _, rule := range s.Rules {
+		sched, err := parseCron(rule.Cron)
+		if err != nil {
+			log.Errorf("[units-schedule] ignoring invalid rule: %s", err)
+			continue
+		}
+		loc, err := time.LoadLocation(rule.Timezone)
+		if err != nil {
+			log.Errorf("[units-schedule] ignoring rule %q with invalid timezone: %s", rule.Cron, err)
+			continue
+		}
+		fire, ok := sched.lastFire(from, now, loc)
+		if !ok || !fire.After(lastFires[rule.Process]) {
+			continue
+		}
+		lastFires[rule.Process] = fire
+		due[rule.Process] = rule
+	}
+	return due
+}
+
+// enforceUnitsSchedule scales the processes of the app whose rules fired
+// since the last check, holding the app lock. Apps locked by other operations
+// are skipped and checked again later.
+func (app *App) enforceUnitsSchedule(now time.Time) error {
+	locked, err := app.InternalLock("units schedule")
+	if err != nil {
+		return err
+	}
+	if !locked {
+		log.Debugf("[units-schedule] app %s is locked, skipping", app.Name)
+		return nil
+	}
+	defer app.Unlock()
+	dbApp, err := GetByName(app.Name)
+	if err != nil {
+		return err
+	}
+	schedule := dbApp.UnitsSchedule
+	if schedule == nil || now.Before(schedule.ManualOverrideUntil) {
+		return nil
+	}
+	due := schedule.dueRules(now)
+	if len(due) > 0 {
+		err = dbApp.scaleToRules(due)
+	}
+	conn, connErr := db.Conn()
+	if connErr != nil {
+		return connErr
+	}
+	defer conn.Close()
+	updateErr := conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"unitsschedule.lastcheck": now}})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+func (app *App) scaleToRules(rules map[string]UnitsScheduleRule) error {
+	units, err := app.Units()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]uint)
+	for _, u := range units {
+		current[u.ProcessName]++
+	}
+	processes := make([]string, 0, len(rules))
+	for process := range rules {
+		processes = append(processes, process)
+	}
+	sort.Strings(processes)
+	var lastErr error
+	for _, process := range processes {
+		rule := rules[process]
+		if rule.Units == current[process] {
+			continue
+		}
+		err = app.scaleBySchedule(rule, current[process])
+		if err != nil {
+			log.Errorf("[units-schedule] unable to scale process %s of app %s: %s", process, app.Name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (app *App) scaleBySchedule(rule UnitsScheduleRule, current uint) (err error) {
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeApp, Value: app.Name},
+		InternalKind: "app-units-schedule",
+		CustomData:   rule,
+		Allowed: event.Allowed(permission.PermAppReadEvents,
+			append(permission.Contexts(permission.CtxTeam, app.Teams),
+				permission.Context(permission.CtxApp, app.Name),
+				permission.Context(permission.CtxPool, app.Pool),
+			)...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	evt.Logf("scaling process %s of app %s from %d to %d units, rule %q", rule.Process, app.Name, current, rule.Units, rule.Cron)
+	if rule.Units > current {
+		return app.AddUnits(rule.Units-current, rule.Process, evt)
+	}
+	return app.RemoveUnits(current-rule.Units, rule.Process, evt)
+}
+
+// enforceUnitsSchedules checks the units schedules of all apps.
+func enforceUnitsSchedules() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"unitsschedule": bson.M{"$ne": nil}, "sleeping": bson.M{"$ne": true}, "deletion": nil}).All(&apps)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i := range apps {
+		err = apps[i].enforceUnitsSchedule(now)
+		if err != nil {
+			log.Errorf("[units-schedule] error enforcing schedule of app %s: %s", apps[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// UnitsScheduler periodically scales apps according to their units
+// schedules.
+type UnitsScheduler struct {
+	interval time.Duration
+	done     chan bool
+}
+
+// StartUnitsScheduler starts the scheduler of app units. The check interval
+// is read from the units-schedule:check-interval config, in seconds, and
+// defaults to one minute.
+func StartUnitsScheduler() *UnitsScheduler {
+	interval, _ := config.GetInt("units-schedule:check-interval")
+	s := &UnitsScheduler{
+		interval: time.Duration(interval) * time.Second,
+		done:     make(chan bool),
+	}
+	if s.interval == 0 {
+		s.interval = time.Minute
+	}
+	go s.run()
+	return s
+}
+
+func (s *UnitsScheduler) run() {
+	for {
+		err := enforceUnitsSchedules()
+		if err != nil {
+			log.Errorf("[units-schedule] %s", err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *UnitsScheduler) Shutdown() {
+	s.done <- true
+}
+
+func (s *UnitsScheduler) String() string {
+	return "app units scheduler"
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestSetUnitsSchedule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	rules := []UnitsScheduleRule{
+		{Process: "web", Cron: "0 8 * * *", Units: 20},
+		{Process: "web", Cron: "0 20 * * *", Units: 4, Timezone: "America/Sao_Paulo"},
+	}
+	err = a.SetUnitsSchedule(rules)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.UnitsSchedule, check.NotNil)
+	c.Assert(dbApp.UnitsSchedule.Rules, check.DeepEquals, rules)
+	c.Assert(dbApp.UnitsSchedule.LastCheck.IsZero(), check.Equals, false)
+	err = dbApp.SetUnitsSchedule(nil)
+	c.Assert(err, check.IsNil)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.UnitsSchedule, check.IsNil)
+}
+
+func (s *S) TestSetUnitsScheduleInvalid(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		rule UnitsScheduleRule
+		msg  string
+	}{
+		{UnitsScheduleRule{Cron: "0 8 * * *", Units: 2}, "process is required.*"},
+		{UnitsScheduleRule{Process: "web", Cron: "0 8 * * *"}, ".*at least one unit"},
+		{UnitsScheduleRule{Process: "web", Cron: "0 25 * * *", Units: 2}, "invalid cron expression.*"},
+		{UnitsScheduleRule{Process: "web", Cron: "0 8 * * *", Units: 2, Timezone: "Mars/Olympus"}, "invalid timezone.*"},
+	}
+	for _, tt := range tests {
+		err = a.SetUnitsSchedule([]UnitsScheduleRule{tt.rule})
+		c.Check(err, check.ErrorMatches, tt.msg)
+	}
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.UnitsSchedule, check.IsNil)
+}
+
+func (s *S) TestEnforceUnitsSchedule(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 4, "web", nil)
+	s.provisioner.AddUnits(&a, 3, "worker", nil)
+	err = a.SetUnitsSchedule([]UnitsScheduleRule{
+		{Process: "web", Cron: "0 8 * * *", Units: 6},
+		{Process: "web", Cron: "0 20 * * *", Units: 2},
+		{Process: "worker", Cron: "0 20 * * *", Units: 1},
+	})
+	c.Assert(err, check.IsNil)
+	lastCheck := time.Date(2017, 3, 1, 7, 59, 0, 0, time.UTC)
+	err = s.conn.Apps().Update(bson.M{"name": a.Name}, bson.M{"$set": bson.M{"unitsschedule.lastcheck": lastCheck}})
+	c.Assert(err, check.IsNil)
+	now := lastCheck.Add(time.Minute)
+	err = a.enforceUnitsSchedule(now)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 9)
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypeApp, Value: a.Name},
+		Kind:   "app-units-schedule",
+		StartCustomData: map[string]interface{}{
+			"process": "web",
+			"units":   6,
+		},
+		LogMatches: `scaling process web of app myapp from 4 to 6 units`,
+	}, eventtest.HasEvent)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.UnitsSchedule.LastCheck.Equal(now), check.Equals, true)
+	err = a.enforceUnitsSchedule(now.Add(12 * time.Hour))
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 3)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+}
+
+func (s *S) TestEnforceUnitsScheduleManualOverride(c *check.C) {
+	config.Set("units-schedule:manual-override-window", 1800)
+	defer config.Unset("units-schedule:manual-override-window")
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 4, "web", nil)
+	err = a.SetUnitsSchedule([]UnitsScheduleRule{{Process: "web", Cron: "* * * * *", Units: 2}})
+	c.Assert(err, check.IsNil)
+	err = a.MarkManualScale()
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	until := dbApp.UnitsSchedule.ManualOverrideUntil
+	c.Assert(until.Sub(time.Now()) > 29*time.Minute, check.Equals, true)
+	err = a.enforceUnitsSchedule(time.Now().UTC().Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 4)
+	err = a.enforceUnitsSchedule(until.Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 2)
+}
+
+func (s *S) TestEnforceUnitsScheduleLockedApp(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", TeamOwner: s.team.Name}
+	err := CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 4, "web", nil)
+	err = a.SetUnitsSchedule([]UnitsScheduleRule{{Process: "web", Cron: "* * * * *", Units: 2}})
+	c.Assert(err, check.IsNil)
+	locked, err := AcquireApplicationLock(a.Name, "someone", "deploy")
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	defer ReleaseApplicationLock(a.Name)
+	err = a.enforceUnitsSchedule(time.Now().UTC().Add(time.Minute))
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.GetUnits(&a), check.HasLen, 4)
+}
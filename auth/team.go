@@ -39,8 +39,13 @@ func (e *ErrTeamStillUsed) Error() string {
 
 // Team represents a real world team, a team has one creating user and a name.
 type Team struct {
-	Name         string `bson:"_id" json:"name"`
-	CreatingUser string
+	Name            string `bson:"_id" json:"name"`
+	CreatingUser    string
+	Quota           *TeamResources `bson:",omitempty" json:"-"`
+	MaintenancePage string         `bson:",omitempty" json:"-"`
+
+	QuotaVersion      int                    `bson:",omitempty" json:"-"`
+	QuotaReservations []TeamQuotaReservation `bson:",omitempty" json:"-"`
 }
 
 // AllowedApps returns the apps that the team has access.
@@ -125,6 +130,26 @@ func GetTeamsNames(teams []Team) []string {
 	return tn
 }
 
+// SetTeamMaintenancePage sets the HTML page shown by apps of the team in
+// maintenance mode that don't have a page of their own. An empty page
+// restores the default one.
+func SetTeamMaintenancePage(teamName, page string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	update := bson.M{"$set": bson.M{"maintenancepage": page}}
+	if page == "" {
+		update = bson.M{"$unset": bson.M{"maintenancepage": ""}}
+	}
+	err = conn.Teams().UpdateId(teamName, update)
+	if err == mgo.ErrNotFound {
+		return ErrTeamNotFound
+	}
+	return err
+}
+
 func RemoveTeam(teamName string) error {
 	conn, err := db.Conn()
 	if err != nil {
@@ -0,0 +1,263 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TeamResources is an amount of each resource limited by team quotas: apps,
+// units of apps, memory of units, in bytes, as reserved by the plans of the
+// apps, and service instances.
+type TeamResources struct {
+	Apps             int   `json:"apps"`
+	Units            int   `json:"units"`
+	Memory           int64 `json:"memory"`
+	ServiceInstances int   `json:"serviceInstances"`
+}
+
+// UnlimitedTeamResources is the limit of teams without a quota.
+var UnlimitedTeamResources = TeamResources{Apps: -1, Units: -1, Memory: -1, ServiceInstances: -1}
+
+// TeamQuota is the quota of the apps and service instances owned by a team.
+// Negative limits mean that the resource is unlimited.
+type TeamQuota struct {
+	Limit TeamResources `json:"limit"`
+	InUse TeamResources `json:"inuse"`
+}
+
+// QuotaLimit returns the limits of the team quota.
+func (t *Team) QuotaLimit() TeamResources {
+	if t.Quota == nil {
+		return UnlimitedTeamResources
+	}
+	return *t.Quota
+}
+
+// QuotaInUse returns the resources in use by the apps and service instances
+// owned by the team.
+func (t *Team) QuotaInUse() (TeamResources, error) {
+	var inUse TeamResources
+	conn, err := db.Conn()
+	if err != nil {
+		return inUse, err
+	}
+	defer conn.Close()
+	var result struct {
+		Apps   int
+		Units  int
+		Memory int64
+	}
+	err = conn.Apps().Pipe([]bson.M{
+		{"$match": bson.M{"teamowner": t.Name}},
+		{"$group": bson.M{
+			"_id":    nil,
+			"apps":   bson.M{"$sum": 1},
+			"units":  bson.M{"$sum": "$quota.inuse"},
+			"memory": bson.M{"$sum": bson.M{"$multiply": []interface{}{"$quota.inuse", "$plan.memory"}}},
+		}},
+	}).One(&result)
+	if err != nil && err != mgo.ErrNotFound {
+		return inUse, err
+	}
+	inUse.Apps, inUse.Units, inUse.Memory = result.Apps, result.Units, result.Memory
+	inUse.ServiceInstances, err = conn.ServiceInstances().Find(bson.M{"teamowner": t.Name}).Count()
+	return inUse, err
+}
+
+// GetTeamQuota returns the limits and the resources in use of the team quota.
+func GetTeamQuota(teamName string) (*TeamQuota, error) {
+	team, err := GetTeam(teamName)
+	if err != nil {
+		return nil, err
+	}
+	inUse, err := team.QuotaInUse()
+	if err != nil {
+		return nil, err
+	}
+	return &TeamQuota{Limit: team.QuotaLimit(), InUse: inUse}, nil
+}
+
+type teamResourceQuota struct {
+	name      string
+	limit     int64
+	inUse     int64
+	requested int64
+}
+
+func teamResourceQuotas(limit, inUse, requested TeamResources) []teamResourceQuota {
+	return []teamResourceQuota{
+		{"apps", int64(limit.Apps), int64(inUse.Apps), int64(requested.Apps)},
+		{"units", int64(limit.Units), int64(inUse.Units), int64(requested.Units)},
+		{"memory", limit.Memory, inUse.Memory, requested.Memory},
+		{"service instances", int64(limit.ServiceInstances), int64(inUse.ServiceInstances), int64(requested.ServiceInstances)},
+	}
+}
+
+// ChangeTeamQuota redefines the limits of the team quota. Each new limit must
+// be bigger than or equal to the amount of the resource in use, negative
+// limits mean unlimited.
+func ChangeTeamQuota(teamName string, limit TeamResources) error {
+	team, err := GetTeam(teamName)
+	if err != nil {
+		return err
+	}
+	inUse, err := team.QuotaInUse()
+	if err != nil {
+		return err
+	}
+	if limit.Apps < 0 {
+		limit.Apps = -1
+	}
+	if limit.Units < 0 {
+		limit.Units = -1
+	}
+	if limit.Memory < 0 {
+		limit.Memory = -1
+	}
+	if limit.ServiceInstances < 0 {
+		limit.ServiceInstances = -1
+	}
+	for _, q := range teamResourceQuotas(limit, inUse, TeamResources{}) {
+		if q.limit >= 0 && q.limit < q.inUse {
+			return errors.Errorf("new limit of %s is lesser than the current allocated value", q.name)
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if limit == UnlimitedTeamResources {
+		return conn.Teams().UpdateId(team.Name, bson.M{"$unset": bson.M{"quota": ""}})
+	}
+	return conn.Teams().UpdateId(team.Name, bson.M{"$set": bson.M{"quota": limit}})
+}
+
+// TeamQuotaReservation is an amount of resources of a team quota reserved
+// by an operation that didn't write them yet. Reservations expire after
+// teamQuotaReservationTTL, so a crashed operation doesn't hold them forever.
+type TeamQuotaReservation struct {
+	ID        bson.ObjectId
+	Team      string
+	Resources TeamResources
+	Expires   time.Time
+}
+
+const teamQuotaReservationTTL = 30 * time.Minute
+
+// ReserveTeamQuota reserves the requested resources in the quota of the team,
+// returning a *quota.QuotaExceededError when adding them to the ones in use
+// and reserved by the team exceeds its quota. Reservations are added with a
+// conditional update on the version of the quota, so concurrent reservations
+// can't exceed the quota together. The returned reservation, nil for teams
+// without quota, must be released after the resources are written or the
+// operation fails. Unknown teams, like the empty owner of old apps, have no
+// quota.
+func ReserveTeamQuota(teamName string, requested TeamResources) (*TeamQuotaReservation, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	conn.Teams().UpdateId(teamName, bson.M{"$pull": bson.M{"quotareservations": bson.M{"expires": bson.M{"$lt": now}}}})
+	for {
+		team, err := GetTeam(teamName)
+		if err == ErrTeamNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if team.Quota == nil {
+			return nil, nil
+		}
+		inUse, err := team.QuotaInUse()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range team.QuotaReservations {
+			if r.Expires.After(now) {
+				inUse.add(r.Resources)
+			}
+		}
+		err = checkTeamQuota(team, inUse, requested)
+		if err != nil {
+			return nil, err
+		}
+		reservation := TeamQuotaReservation{
+			ID:        bson.NewObjectId(),
+			Team:      team.Name,
+			Resources: requested,
+			Expires:   now.Add(teamQuotaReservationTTL),
+		}
+		var version interface{} = team.QuotaVersion
+		if team.QuotaVersion == 0 {
+			version = bson.M{"$in": []interface{}{0, nil}}
+		}
+		err = conn.Teams().Update(
+			bson.M{"_id": team.Name, "quotaversion": version},
+			bson.M{
+				"$inc":  bson.M{"quotaversion": 1},
+				"$push": bson.M{"quotareservations": reservation},
+			},
+		)
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &reservation, nil
+	}
+}
+
+// Release removes the reservation from the team quota. It must be called once
+// the reserved resources are written, when they're counted as in use, or
+// when the operation that reserved them fails.
+func (r *TeamQuotaReservation) Release() error {
+	if r == nil {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Teams().UpdateId(r.Team, bson.M{"$pull": bson.M{"quotareservations": bson.M{"id": r.ID}}})
+}
+
+func (r *TeamResources) add(other TeamResources) {
+	r.Apps += other.Apps
+	r.Units += other.Units
+	r.Memory += other.Memory
+	r.ServiceInstances += other.ServiceInstances
+}
+
+func checkTeamQuota(team *Team, inUse, requested TeamResources) error {
+	for _, q := range teamResourceQuotas(*team.Quota, inUse, requested) {
+		if q.limit < 0 || q.requested <= 0 || q.inUse+q.requested <= q.limit {
+			continue
+		}
+		var available int64
+		if q.limit > q.inUse {
+			available = q.limit - q.inUse
+		}
+		return &quota.QuotaExceededError{
+			Resource:  fmt.Sprintf("%s of team %s", q.name, team.Name),
+			Available: uint(available),
+			Requested: uint(q.requested),
+		}
+	}
+	return nil
+}
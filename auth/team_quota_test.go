@@ -0,0 +1,147 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) insertQuotaResources(c *check.C, teamName string) {
+	err := s.conn.Apps().Insert(
+		bson.M{"name": "app1", "teamowner": teamName, "quota": bson.M{"inuse": 2}, "plan": bson.M{"memory": 1024}},
+		bson.M{"name": "app2", "teamowner": teamName, "quota": bson.M{"inuse": 1}, "plan": bson.M{"memory": 2048}},
+		bson.M{"name": "app3", "teamowner": "otherteam", "quota": bson.M{"inuse": 5}, "plan": bson.M{"memory": 2048}},
+	)
+	c.Assert(err, check.IsNil)
+	err = s.conn.ServiceInstances().Insert(
+		bson.M{"name": "instance1", "service_name": "mysql", "teamowner": teamName},
+	)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestGetTeamQuota(c *check.C) {
+	s.insertQuotaResources(c, s.team.Name)
+	defer s.conn.Apps().RemoveAll(nil)
+	defer s.conn.ServiceInstances().RemoveAll(nil)
+	teamQuota, err := GetTeamQuota(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(*teamQuota, check.DeepEquals, TeamQuota{
+		Limit: UnlimitedTeamResources,
+		InUse: TeamResources{Apps: 2, Units: 3, Memory: 4096, ServiceInstances: 1},
+	})
+}
+
+func (s *S) TestGetTeamQuotaTeamNotFound(c *check.C) {
+	_, err := GetTeamQuota("unknown")
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+}
+
+func (s *S) TestChangeTeamQuota(c *check.C) {
+	limit := TeamResources{Apps: 10, Units: -1, Memory: 8192, ServiceInstances: -5}
+	err := ChangeTeamQuota(s.team.Name, limit)
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaLimit(), check.DeepEquals, TeamResources{Apps: 10, Units: -1, Memory: 8192, ServiceInstances: -1})
+	err = ChangeTeamQuota(s.team.Name, UnlimitedTeamResources)
+	c.Assert(err, check.IsNil)
+	team, err = GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.Quota, check.IsNil)
+}
+
+func (s *S) TestChangeTeamQuotaLesserThanInUse(c *check.C) {
+	s.insertQuotaResources(c, s.team.Name)
+	defer s.conn.Apps().RemoveAll(nil)
+	defer s.conn.ServiceInstances().RemoveAll(nil)
+	limit := UnlimitedTeamResources
+	limit.Units = 2
+	err := ChangeTeamQuota(s.team.Name, limit)
+	c.Assert(err, check.ErrorMatches, "new limit of units is lesser than the current allocated value")
+}
+
+func (s *S) TestReserveTeamQuota(c *check.C) {
+	s.insertQuotaResources(c, s.team.Name)
+	defer s.conn.Apps().RemoveAll(nil)
+	defer s.conn.ServiceInstances().RemoveAll(nil)
+	reservation, err := ReserveTeamQuota(s.team.Name, TeamResources{Apps: 1, Units: 100})
+	c.Assert(err, check.IsNil)
+	c.Assert(reservation, check.IsNil)
+	err = ChangeTeamQuota(s.team.Name, TeamResources{Apps: 3, Units: 4, Memory: -1, ServiceInstances: 1})
+	c.Assert(err, check.IsNil)
+	reservation, err = ReserveTeamQuota(s.team.Name, TeamResources{Apps: 1, Units: 1, Memory: 1 << 30})
+	c.Assert(err, check.IsNil)
+	c.Assert(reservation, check.NotNil)
+	c.Assert(reservation.Resources, check.DeepEquals, TeamResources{Apps: 1, Units: 1, Memory: 1 << 30})
+	_, err = ReserveTeamQuota(s.team.Name, TeamResources{Units: 1})
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{Resource: "units of team cobrateam", Available: 0, Requested: 1})
+	c.Assert(err, check.ErrorMatches, `Quota exceeded for units of team cobrateam. Available: 0. Requested: 1.`)
+	err = reservation.Release()
+	c.Assert(err, check.IsNil)
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaReservations, check.HasLen, 0)
+	reservation, err = ReserveTeamQuota(s.team.Name, TeamResources{Units: 1})
+	c.Assert(err, check.IsNil)
+	defer reservation.Release()
+	_, err = ReserveTeamQuota(s.team.Name, TeamResources{ServiceInstances: 1})
+	c.Assert(err, check.DeepEquals, &quota.QuotaExceededError{Resource: "service instances of team cobrateam", Available: 0, Requested: 1})
+}
+
+func (s *S) TestReserveTeamQuotaConcurrent(c *check.C) {
+	err := ChangeTeamQuota(s.team.Name, TeamResources{Apps: 5, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reserved, exceeded int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ReserveTeamQuota(s.team.Name, TeamResources{Apps: 1})
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				reserved++
+			} else if _, ok := err.(*quota.QuotaExceededError); ok {
+				exceeded++
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(reserved, check.Equals, 5)
+	c.Assert(exceeded, check.Equals, 15)
+}
+
+func (s *S) TestReserveTeamQuotaIgnoresExpiredReservations(c *check.C) {
+	err := ChangeTeamQuota(s.team.Name, TeamResources{Apps: 1, Units: -1, Memory: -1, ServiceInstances: -1})
+	c.Assert(err, check.IsNil)
+	err = s.conn.Teams().UpdateId(s.team.Name, bson.M{"$push": bson.M{"quotareservations": TeamQuotaReservation{
+		ID:        bson.NewObjectId(),
+		Team:      s.team.Name,
+		Resources: TeamResources{Apps: 1},
+		Expires:   time.Now().UTC().Add(-time.Minute),
+	}}})
+	c.Assert(err, check.IsNil)
+	reservation, err := ReserveTeamQuota(s.team.Name, TeamResources{Apps: 1})
+	c.Assert(err, check.IsNil)
+	defer reservation.Release()
+	team, err := GetTeam(s.team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(team.QuotaReservations, check.HasLen, 1)
+	c.Assert(team.QuotaReservations[0].ID, check.Equals, reservation.ID)
+}
+
+func (s *S) TestReserveTeamQuotaUnknownTeam(c *check.C) {
+	reservation, err := ReserveTeamQuota("unknown", TeamResources{Apps: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(reservation, check.IsNil)
+	c.Assert(reservation.Release(), check.IsNil)
+}
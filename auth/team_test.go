@@ -105,6 +105,24 @@ func (s *S) TestGetTeam(c *check.C) {
 	c.Assert(t, check.IsNil)
 }
 
+func (s *S) TestSetTeamMaintenancePage(c *check.C) {
+	team := Team{Name: "atreides"}
+	err := s.conn.Teams().Insert(team)
+	c.Assert(err, check.IsNil)
+	err = SetTeamMaintenancePage(team.Name, "<h1>Back soon</h1>")
+	c.Assert(err, check.IsNil)
+	t, err := GetTeam(team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.MaintenancePage, check.Equals, "<h1>Back soon</h1>")
+	err = SetTeamMaintenancePage(team.Name, "")
+	c.Assert(err, check.IsNil)
+	t, err = GetTeam(team.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(t.MaintenancePage, check.Equals, "")
+	err = SetTeamMaintenancePage("wat", "<h1>Back soon</h1>")
+	c.Assert(err, check.Equals, ErrTeamNotFound)
+}
+
 func (s *S) TestRemoveTeam(c *check.C) {
 	team := Team{Name: "atreides"}
 	err := s.conn.Teams().Insert(team)
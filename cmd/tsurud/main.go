@@ -33,6 +33,7 @@ func buildManager() *cmd.Manager {
 	m.Register(&tsurudCommand{Command: &migrateCmd{}})
 	m.Register(&tsurudCommand{Command: gandalfSyncCmd{}})
 	m.Register(&tsurudCommand{Command: createRootUserCmd{}})
+	m.Register(&tsurudCommand{Command: rotateSecretsCmd{}})
 	m.Register(&migrationListCmd{})
 	err := registerProvisionersCommands(m)
 	if err != nil {
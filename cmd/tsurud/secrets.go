@@ -0,0 +1,40 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/registry"
+)
+
+type rotateSecretsCmd struct{}
+
+func (rotateSecretsCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	envs, err := app.RotateSecrets()
+	if err != nil {
+		return err
+	}
+	creds, err := registry.RotatePasswords()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "%d secret environment variables and %d registry credentials re-encrypted.\n", envs, creds)
+	return nil
+}
+
+func (rotateSecretsCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "rotate-secrets",
+		Usage: "rotate-secrets",
+		Desc: `Re-encrypts the secret environment variables of all apps, including
+the ones in env revisions, and the passwords of registry credentials with the
+key in encryption:key. The keys previously used must be listed in
+encryption:previous-keys until this command finishes.`,
+		MinArgs: 0,
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/cmd"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestRotateSecretsCmdInfo(c *check.C) {
+	c.Assert(rotateSecretsCmd{}.Info().Name, check.Equals, "rotate-secrets")
+}
+
+func (s *S) TestRotateSecretsCmdRun(c *check.C) {
+	config.Set("encryption:key", "my-key")
+	defer config.Unset("encryption:key")
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{
+		Args:   []string{},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	manager := cmd.NewManager("glb", "", "", &stdout, &stderr, os.Stdin, nil)
+	client := cmd.NewClient(&http.Client{}, nil, manager)
+	err := rotateSecretsCmd{}.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "0 secret environment variables and 0 registry credentials re-encrypted.\n")
+}
@@ -227,3 +227,41 @@ func (s *Storage) InstallHosts() *storage.Collection {
 	c.EnsureIndex(nameIndex)
 	return c
 }
+
+func (s *Storage) RegistryCredentials() *storage.Collection {
+	return s.Collection("registry_credentials")
+}
+
+func (s *Storage) ConfigFiles() *storage.Collection {
+	index := mgo.Index{Key: []string{"app", "name", "version"}, Unique: true}
+	c := s.Collection("app_config_files")
+	c.EnsureIndex(index)
+	return c
+}
+
+func (s *Storage) EnvRevisions() *storage.Collection {
+	index := mgo.Index{Key: []string{"app", "version"}, Unique: true}
+	c := s.Collection("env_revisions")
+	c.EnsureIndex(index)
+	return c
+}
+
+func (s *Storage) Usage() *storage.Collection {
+	resourceIndex := mgo.Index{Key: []string{"kind", "resource"}}
+	teamIndex := mgo.Index{Key: []string{"team", "start"}}
+	c := s.Collection("usage")
+	c.EnsureIndex(resourceIndex)
+	c.EnsureIndex(teamIndex)
+	return c
+}
+
+func (s *Storage) AppTemplates() *storage.Collection {
+	return s.Collection("app_templates")
+}
+
+func (s *Storage) AppOperations() *storage.Collection {
+	index := mgo.Index{Key: []string{"app", "enqueuedat"}}
+	c := s.Collection("app_operations")
+	c.EnsureIndex(index)
+	return c
+}
@@ -211,3 +211,30 @@ func (s *S) TestInstallHosts(c *check.C) {
 	hostsc := strg.Collection("install_hosts")
 	c.Assert(hosts, check.DeepEquals, hostsc)
 }
+
+func (s *S) TestRegistryCredentials(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	creds := strg.RegistryCredentials()
+	credsc := strg.Collection("registry_credentials")
+	c.Assert(creds, check.DeepEquals, credsc)
+}
+
+func (s *S) TestAppTemplates(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	templates := strg.AppTemplates()
+	templatesc := strg.Collection("app_templates")
+	c.Assert(templates, check.DeepEquals, templatesc)
+}
+
+func (s *S) TestAppOperations(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	operations := strg.AppOperations()
+	operationsc := strg.Collection("app_operations")
+	c.Assert(operations, check.DeepEquals, operationsc)
+}
@@ -0,0 +1,269 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package encryption provides symmetric encryption for sensitive values
+// stored by tsuru, like registry credentials and secret environment
+// variables.
+//
+// Values are encrypted with AES-256-GCM using a key derived from the
+// encryption:key config entry, and encoded as a versioned base64 string.
+// Envelope encrypted values use a random data key for each value, which is
+// itself encrypted with the master key, allowing the master key to be rotated
+// by re-encrypting only the data keys. Keys previously used, listed in the
+// encryption:previous-keys config entry, are still accepted when decrypting.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+)
+
+const (
+	versionPrefix  = "v1:"
+	envelopePrefix = "v2:"
+	dataKeySize    = 32
+)
+
+var (
+	ErrKeyNotConfigured = errors.New("encryption key not configured, please set encryption:key in tsuru config")
+	ErrInvalidData      = errors.New("invalid encrypted data")
+	ErrUnknownKey       = errors.New("value encrypted with an unknown key, please check encryption:previous-keys in tsuru config")
+)
+
+func deriveKey(value string) []byte {
+	sum := sha256.Sum256([]byte(value))
+	return sum[:]
+}
+
+// masterKeys returns the current master key followed by the previous ones.
+func masterKeys() ([][]byte, error) {
+	value, _ := config.GetString("encryption:key")
+	if value == "" {
+		return nil, ErrKeyNotConfigured
+	}
+	keys := [][]byte{deriveKey(value)}
+	previous, _ := config.GetList("encryption:previous-keys")
+	for _, p := range previous {
+		if p != "" {
+			keys = append(keys, deriveKey(p))
+		}
+	}
+	return keys, nil
+}
+
+// keyID identifies a master key in envelope encrypted values without
+// revealing it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(key, plain []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrInvalidData
+	}
+	nonce, data := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+	return plain, nil
+}
+
+// Encrypt encrypts value using the configured key.
+func Encrypt(value string) (string, error) {
+	keys, err := masterKeys()
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(keys[0], []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return versionPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// EncryptEnvelope encrypts value with a new random data key, which is
+// encrypted with the configured key and stored along with the value.
+func EncryptEnvelope(value string) (string, error) {
+	keys, err := masterKeys()
+	if err != nil {
+		return "", err
+	}
+	dataKey := make([]byte, dataKeySize)
+	_, err = io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	data, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return wrapEnvelope(keys[0], dataKey, data)
+}
+
+func wrapEnvelope(key, dataKey, data []byte) (string, error) {
+	wrapped, err := seal(key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return envelopePrefix + keyID(key) + ":" +
+		base64.StdEncoding.EncodeToString(wrapped) + ":" +
+		base64.StdEncoding.EncodeToString(data), nil
+}
+
+type envelope struct {
+	keyID   string
+	wrapped []byte
+	data    []byte
+}
+
+func parseEnvelope(value string) (*envelope, error) {
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return nil, ErrInvalidData
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+	data, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidData
+	}
+	return &envelope{keyID: parts[0], wrapped: wrapped, data: data}, nil
+}
+
+// dataKey finds the master key used in the envelope and decrypts the data key
+// with it.
+func (e *envelope) dataKey(keys [][]byte) ([]byte, error) {
+	for _, key := range keys {
+		if keyID(key) == e.keyID {
+			return open(key, e.wrapped)
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// IsEncrypted returns whether value was returned by Encrypt or
+// EncryptEnvelope.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, versionPrefix) || strings.HasPrefix(value, envelopePrefix)
+}
+
+// Decrypt decrypts a value previously returned by Encrypt or
+// EncryptEnvelope.
+func Decrypt(value string) (string, error) {
+	plain, _, err := decrypt(value)
+	return plain, err
+}
+
+// decrypt returns the plain value and whether it was encrypted with the
+// current master key.
+func decrypt(value string) (string, bool, error) {
+	var keys [][]byte
+	switch {
+	case strings.HasPrefix(value, envelopePrefix):
+		e, err := parseEnvelope(value)
+		if err != nil {
+			return "", false, err
+		}
+		if keys, err = masterKeys(); err != nil {
+			return "", false, err
+		}
+		dataKey, err := e.dataKey(keys)
+		if err != nil {
+			return "", false, err
+		}
+		plain, err := open(dataKey, e.data)
+		if err != nil {
+			return "", false, err
+		}
+		return string(plain), e.keyID == keyID(keys[0]), nil
+	case strings.HasPrefix(value, versionPrefix):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, versionPrefix))
+		if err != nil {
+			return "", false, ErrInvalidData
+		}
+		if keys, err = masterKeys(); err != nil {
+			return "", false, err
+		}
+		for i, key := range keys {
+			plain, err := open(key, data)
+			if err == nil {
+				return string(plain), i == 0, nil
+			}
+		}
+		return "", false, ErrInvalidData
+	}
+	return "", false, ErrInvalidData
+}
+
+// Rewrap re-encrypts value with the current master key, returning the new
+// value and whether it was changed. Envelope encrypted values keep their data
+// key, only the data key is encrypted again.
+func Rewrap(value string) (string, bool, error) {
+	if !strings.HasPrefix(value, envelopePrefix) {
+		plain, current, err := decrypt(value)
+		if err != nil || current {
+			return value, false, err
+		}
+		value, err = Encrypt(plain)
+		return value, err == nil, err
+	}
+	keys, err := masterKeys()
+	if err != nil {
+		return value, false, err
+	}
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return value, false, err
+	}
+	if e.keyID == keyID(keys[0]) {
+		return value, false, nil
+	}
+	dataKey, err := e.dataKey(keys)
+	if err != nil {
+		return value, false, err
+	}
+	newValue, err := wrapEnvelope(keys[0], dataKey, e.data)
+	if err != nil {
+		return value, false, err
+	}
+	return newValue, true, nil
+}
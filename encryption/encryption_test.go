@@ -0,0 +1,126 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package encryption
+
+import (
+	"testing"
+
+	"github.com/tsuru/config"
+	"gopkg.in/check.v1"
+)
+
+type S struct{}
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+func (s *S) SetUpTest(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	config.Unset("encryption:key")
+}
+
+func (s *S) TestEncryptDecrypt(c *check.C) {
+	encrypted, err := Encrypt("my password")
+	c.Assert(err, check.IsNil)
+	c.Assert(encrypted, check.Not(check.Equals), "my password")
+	c.Assert(encrypted, check.Matches, `v1:.+`)
+	other, err := Encrypt("my password")
+	c.Assert(err, check.IsNil)
+	c.Assert(other, check.Not(check.Equals), encrypted)
+	plain, err := Decrypt(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "my password")
+}
+
+func (s *S) TestDecryptWrongKey(c *check.C) {
+	encrypted, err := Encrypt("my password")
+	c.Assert(err, check.IsNil)
+	config.Set("encryption:key", "other-key")
+	_, err = Decrypt(encrypted)
+	c.Assert(err, check.Equals, ErrInvalidData)
+}
+
+func (s *S) TestDecryptInvalidData(c *check.C) {
+	for _, value := range []string{"", "my password", "v1:not base64", "v1:YWJj"} {
+		_, err := Decrypt(value)
+		c.Check(err, check.Equals, ErrInvalidData, check.Commentf("value: %q", value))
+	}
+}
+
+func (s *S) TestEncryptKeyNotConfigured(c *check.C) {
+	config.Unset("encryption:key")
+	_, err := Encrypt("my password")
+	c.Assert(err, check.Equals, ErrKeyNotConfigured)
+}
+
+func (s *S) TestEncryptEnvelope(c *check.C) {
+	encrypted, err := EncryptEnvelope("my secret")
+	c.Assert(err, check.IsNil)
+	c.Assert(encrypted, check.Matches, `v2:[0-9a-f]{8}:.+:.+`)
+	c.Assert(IsEncrypted(encrypted), check.Equals, true)
+	other, err := EncryptEnvelope("my secret")
+	c.Assert(err, check.IsNil)
+	c.Assert(other, check.Not(check.Equals), encrypted)
+	plain, err := Decrypt(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "my secret")
+}
+
+func (s *S) TestDecryptEnvelopeUnknownKey(c *check.C) {
+	encrypted, err := EncryptEnvelope("my secret")
+	c.Assert(err, check.IsNil)
+	config.Set("encryption:key", "other-key")
+	_, err = Decrypt(encrypted)
+	c.Assert(err, check.Equals, ErrUnknownKey)
+}
+
+func (s *S) TestDecryptWithPreviousKeys(c *check.C) {
+	envelope, err := EncryptEnvelope("my secret")
+	c.Assert(err, check.IsNil)
+	encrypted, err := Encrypt("my password")
+	c.Assert(err, check.IsNil)
+	config.Set("encryption:key", "new-key")
+	config.Set("encryption:previous-keys", []interface{}{"my-secret-key"})
+	defer config.Unset("encryption:previous-keys")
+	plain, err := Decrypt(envelope)
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "my secret")
+	plain, err = Decrypt(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "my password")
+}
+
+func (s *S) TestRewrap(c *check.C) {
+	envelope, err := EncryptEnvelope("my secret")
+	c.Assert(err, check.IsNil)
+	encrypted, err := Encrypt("my password")
+	c.Assert(err, check.IsNil)
+	value, changed, err := Rewrap(envelope)
+	c.Assert(err, check.IsNil)
+	c.Assert(changed, check.Equals, false)
+	c.Assert(value, check.Equals, envelope)
+	config.Set("encryption:key", "new-key")
+	config.Set("encryption:previous-keys", []interface{}{"my-secret-key"})
+	defer config.Unset("encryption:previous-keys")
+	newEnvelope, changed, err := Rewrap(envelope)
+	c.Assert(err, check.IsNil)
+	c.Assert(changed, check.Equals, true)
+	newEncrypted, changed, err := Rewrap(encrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(changed, check.Equals, true)
+	config.Unset("encryption:previous-keys")
+	plain, err := Decrypt(newEnvelope)
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "my secret")
+	plain, err = Decrypt(newEncrypted)
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "my password")
+	_, err = Decrypt(envelope)
+	c.Assert(err, check.Equals, ErrUnknownKey)
+}
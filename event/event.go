@@ -69,6 +69,10 @@ var (
 	TargetTypePlan            = TargetType("plan")
 	TargetTypeNodeContainer   = TargetType("node-container")
 	TargetTypeInstallHost     = TargetType("install-host")
+
+	TargetTypeRegistryCredential = TargetType("registry-credential")
+	TargetTypeAppTemplate        = TargetType("app-template")
+	TargetTypeGC                 = TargetType("gc")
 )
 
 const (
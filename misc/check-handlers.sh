@@ -53,6 +53,7 @@ github.com/tsuru/tsuru/api.nodeContainerList
 github.com/tsuru/tsuru/api.nodeHealingRead
 github.com/tsuru/tsuru/provision/docker.bsConfigGetHandler
 github.com/tsuru/tsuru/provision/docker.logsConfigGetHandler
+github.com/tsuru/tsuru/provision/docker.schedulerConfigGetHandler
 github.com/tsuru/tsuru/provision/docker.bsEnvSetHandler
 github.com/tsuru/tsuru/provision/docker.bsUpgradeHandler
 EOF
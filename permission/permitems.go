@@ -8,173 +8,217 @@
 package permission
 
 var (
-	PermAll                              = PermissionRegistry.get("")                                    // [global]
-	PermApp                              = PermissionRegistry.get("app")                                 // [global app team pool]
-	PermAppAdmin                         = PermissionRegistry.get("app.admin")                           // [global app team pool]
-	PermAppAdminQuota                    = PermissionRegistry.get("app.admin.quota")                     // [global app team pool]
-	PermAppAdminRoutes                   = PermissionRegistry.get("app.admin.routes")                    // [global app team pool]
-	PermAppAdminUnlock                   = PermissionRegistry.get("app.admin.unlock")                    // [global app team pool]
-	PermAppCreate                        = PermissionRegistry.get("app.create")                          // [global team]
-	PermAppDelete                        = PermissionRegistry.get("app.delete")                          // [global app team pool]
-	PermAppDeploy                        = PermissionRegistry.get("app.deploy")                          // [global app team pool]
-	PermAppDeployArchiveUrl              = PermissionRegistry.get("app.deploy.archive-url")              // [global app team pool]
-	PermAppDeployBuild                   = PermissionRegistry.get("app.deploy.build")                    // [global app team pool]
-	PermAppDeployGit                     = PermissionRegistry.get("app.deploy.git")                      // [global app team pool]
-	PermAppDeployImage                   = PermissionRegistry.get("app.deploy.image")                    // [global app team pool]
-	PermAppDeployRollback                = PermissionRegistry.get("app.deploy.rollback")                 // [global app team pool]
-	PermAppDeployUpload                  = PermissionRegistry.get("app.deploy.upload")                   // [global app team pool]
-	PermAppRead                          = PermissionRegistry.get("app.read")                            // [global app team pool]
-	PermAppReadCertificate               = PermissionRegistry.get("app.read.certificate")                // [global app team pool]
-	PermAppReadDeploy                    = PermissionRegistry.get("app.read.deploy")                     // [global app team pool]
-	PermAppReadEnv                       = PermissionRegistry.get("app.read.env")                        // [global app team pool]
-	PermAppReadEvents                    = PermissionRegistry.get("app.read.events")                     // [global app team pool]
-	PermAppReadLog                       = PermissionRegistry.get("app.read.log")                        // [global app team pool]
-	PermAppReadMetric                    = PermissionRegistry.get("app.read.metric")                     // [global app team pool]
-	PermAppRun                           = PermissionRegistry.get("app.run")                             // [global app team pool]
-	PermAppRunShell                      = PermissionRegistry.get("app.run.shell")                       // [global app team pool]
-	PermAppUpdate                        = PermissionRegistry.get("app.update")                          // [global app team pool]
-	PermAppUpdateBind                    = PermissionRegistry.get("app.update.bind")                     // [global app team pool]
-	PermAppUpdateCertificate             = PermissionRegistry.get("app.update.certificate")              // [global app team pool]
-	PermAppUpdateCertificateSet          = PermissionRegistry.get("app.update.certificate.set")          // [global app team pool]
-	PermAppUpdateCertificateUnset        = PermissionRegistry.get("app.update.certificate.unset")        // [global app team pool]
-	PermAppUpdateCname                   = PermissionRegistry.get("app.update.cname")                    // [global app team pool]
-	PermAppUpdateCnameAdd                = PermissionRegistry.get("app.update.cname.add")                // [global app team pool]
-	PermAppUpdateCnameRemove             = PermissionRegistry.get("app.update.cname.remove")             // [global app team pool]
-	PermAppUpdateDescription             = PermissionRegistry.get("app.update.description")              // [global app team pool]
-	PermAppUpdateEnv                     = PermissionRegistry.get("app.update.env")                      // [global app team pool]
-	PermAppUpdateEnvSet                  = PermissionRegistry.get("app.update.env.set")                  // [global app team pool]
-	PermAppUpdateEnvUnset                = PermissionRegistry.get("app.update.env.unset")                // [global app team pool]
-	PermAppUpdateEvents                  = PermissionRegistry.get("app.update.events")                   // [global app team pool]
-	PermAppUpdateGrant                   = PermissionRegistry.get("app.update.grant")                    // [global app team pool]
-	PermAppUpdateLog                     = PermissionRegistry.get("app.update.log")                      // [global app team pool]
-	PermAppUpdatePlan                    = PermissionRegistry.get("app.update.plan")                     // [global app team pool]
-	PermAppUpdatePool                    = PermissionRegistry.get("app.update.pool")                     // [global app team pool]
-	PermAppUpdateRestart                 = PermissionRegistry.get("app.update.restart")                  // [global app team pool]
-	PermAppUpdateRevoke                  = PermissionRegistry.get("app.update.revoke")                   // [global app team pool]
-	PermAppUpdateSleep                   = PermissionRegistry.get("app.update.sleep")                    // [global app team pool]
-	PermAppUpdateStart                   = PermissionRegistry.get("app.update.start")                    // [global app team pool]
-	PermAppUpdateStop                    = PermissionRegistry.get("app.update.stop")                     // [global app team pool]
-	PermAppUpdateSwap                    = PermissionRegistry.get("app.update.swap")                     // [global app team pool]
-	PermAppUpdateTeamowner               = PermissionRegistry.get("app.update.teamowner")                // [global app team pool]
-	PermAppUpdateUnbind                  = PermissionRegistry.get("app.update.unbind")                   // [global app team pool]
-	PermAppUpdateUnit                    = PermissionRegistry.get("app.update.unit")                     // [global app team pool]
-	PermAppUpdateUnitAdd                 = PermissionRegistry.get("app.update.unit.add")                 // [global app team pool]
-	PermAppUpdateUnitRegister            = PermissionRegistry.get("app.update.unit.register")            // [global app team pool]
-	PermAppUpdateUnitRemove              = PermissionRegistry.get("app.update.unit.remove")              // [global app team pool]
-	PermAppUpdateUnitStatus              = PermissionRegistry.get("app.update.unit.status")              // [global app team pool]
-	PermDebug                            = PermissionRegistry.get("debug")                               // [global]
-	PermHealing                          = PermissionRegistry.get("healing")                             // [global pool]
-	PermHealingDelete                    = PermissionRegistry.get("healing.delete")                      // [global pool]
-	PermHealingRead                      = PermissionRegistry.get("healing.read")                        // [global pool]
-	PermHealingUpdate                    = PermissionRegistry.get("healing.update")                      // [global pool]
-	PermInstall                          = PermissionRegistry.get("install")                             // [global]
-	PermInstallManage                    = PermissionRegistry.get("install.manage")                      // [global]
-	PermMachine                          = PermissionRegistry.get("machine")                             // [global iaas]
-	PermMachineCreate                    = PermissionRegistry.get("machine.create")                      // [global iaas]
-	PermMachineDelete                    = PermissionRegistry.get("machine.delete")                      // [global iaas]
-	PermMachineRead                      = PermissionRegistry.get("machine.read")                        // [global iaas]
-	PermMachineReadEvents                = PermissionRegistry.get("machine.read.events")                 // [global iaas]
-	PermMachineTemplate                  = PermissionRegistry.get("machine.template")                    // [global iaas]
-	PermMachineTemplateCreate            = PermissionRegistry.get("machine.template.create")             // [global iaas]
-	PermMachineTemplateDelete            = PermissionRegistry.get("machine.template.delete")             // [global iaas]
-	PermMachineTemplateRead              = PermissionRegistry.get("machine.template.read")               // [global iaas]
-	PermMachineTemplateUpdate            = PermissionRegistry.get("machine.template.update")             // [global iaas]
-	PermNode                             = PermissionRegistry.get("node")                                // [global pool]
-	PermNodeAutoscale                    = PermissionRegistry.get("node.autoscale")                      // [global]
-	PermNodeAutoscaleDelete              = PermissionRegistry.get("node.autoscale.delete")               // [global]
-	PermNodeAutoscaleRead                = PermissionRegistry.get("node.autoscale.read")                 // [global]
-	PermNodeAutoscaleUpdate              = PermissionRegistry.get("node.autoscale.update")               // [global]
-	PermNodeAutoscaleUpdateRun           = PermissionRegistry.get("node.autoscale.update.run")           // [global]
-	PermNodeCreate                       = PermissionRegistry.get("node.create")                         // [global pool]
-	PermNodeDelete                       = PermissionRegistry.get("node.delete")                         // [global pool]
-	PermNodeRead                         = PermissionRegistry.get("node.read")                           // [global pool]
-	PermNodeUpdate                       = PermissionRegistry.get("node.update")                         // [global pool]
-	PermNodeUpdateMove                   = PermissionRegistry.get("node.update.move")                    // [global pool]
-	PermNodeUpdateMoveContainer          = PermissionRegistry.get("node.update.move.container")          // [global pool]
-	PermNodeUpdateMoveContainers         = PermissionRegistry.get("node.update.move.containers")         // [global pool]
-	PermNodeUpdateRebalance              = PermissionRegistry.get("node.update.rebalance")               // [global pool]
-	PermNodecontainer                    = PermissionRegistry.get("nodecontainer")                       // [global pool]
-	PermNodecontainerCreate              = PermissionRegistry.get("nodecontainer.create")                // [global pool]
-	PermNodecontainerDelete              = PermissionRegistry.get("nodecontainer.delete")                // [global pool]
-	PermNodecontainerRead                = PermissionRegistry.get("nodecontainer.read")                  // [global pool]
-	PermNodecontainerUpdate              = PermissionRegistry.get("nodecontainer.update")                // [global pool]
-	PermNodecontainerUpdateUpgrade       = PermissionRegistry.get("nodecontainer.update.upgrade")        // [global pool]
-	PermPlan                             = PermissionRegistry.get("plan")                                // [global]
-	PermPlanCreate                       = PermissionRegistry.get("plan.create")                         // [global]
-	PermPlanDelete                       = PermissionRegistry.get("plan.delete")                         // [global]
-	PermPlanRead                         = PermissionRegistry.get("plan.read")                           // [global]
-	PermPlanReadEvents                   = PermissionRegistry.get("plan.read.events")                    // [global]
-	PermPlatform                         = PermissionRegistry.get("platform")                            // [global]
-	PermPlatformCreate                   = PermissionRegistry.get("platform.create")                     // [global]
-	PermPlatformDelete                   = PermissionRegistry.get("platform.delete")                     // [global]
-	PermPlatformRead                     = PermissionRegistry.get("platform.read")                       // [global]
-	PermPlatformReadEvents               = PermissionRegistry.get("platform.read.events")                // [global]
-	PermPlatformUpdate                   = PermissionRegistry.get("platform.update")                     // [global]
-	PermPool                             = PermissionRegistry.get("pool")                                // [global pool]
-	PermPoolCreate                       = PermissionRegistry.get("pool.create")                         // [global]
-	PermPoolDelete                       = PermissionRegistry.get("pool.delete")                         // [global pool]
-	PermPoolRead                         = PermissionRegistry.get("pool.read")                           // [global pool]
-	PermPoolReadEvents                   = PermissionRegistry.get("pool.read.events")                    // [global pool]
-	PermPoolUpdate                       = PermissionRegistry.get("pool.update")                         // [global pool]
-	PermPoolUpdateLogs                   = PermissionRegistry.get("pool.update.logs")                    // [global pool]
-	PermPoolUpdateTeam                   = PermissionRegistry.get("pool.update.team")                    // [global pool]
-	PermPoolUpdateTeamAdd                = PermissionRegistry.get("pool.update.team.add")                // [global pool]
-	PermPoolUpdateTeamRemove             = PermissionRegistry.get("pool.update.team.remove")             // [global pool]
-	PermRole                             = PermissionRegistry.get("role")                                // [global]
-	PermRoleCreate                       = PermissionRegistry.get("role.create")                         // [global]
-	PermRoleDefault                      = PermissionRegistry.get("role.default")                        // [global]
-	PermRoleDefaultCreate                = PermissionRegistry.get("role.default.create")                 // [global]
-	PermRoleDefaultDelete                = PermissionRegistry.get("role.default.delete")                 // [global]
-	PermRoleDelete                       = PermissionRegistry.get("role.delete")                         // [global]
-	PermRoleRead                         = PermissionRegistry.get("role.read")                           // [global]
-	PermRoleReadEvents                   = PermissionRegistry.get("role.read.events")                    // [global]
-	PermRoleUpdate                       = PermissionRegistry.get("role.update")                         // [global]
-	PermRoleUpdateAssign                 = PermissionRegistry.get("role.update.assign")                  // [global]
-	PermRoleUpdateDissociate             = PermissionRegistry.get("role.update.dissociate")              // [global]
-	PermRoleUpdatePermission             = PermissionRegistry.get("role.update.permission")              // [global]
-	PermRoleUpdatePermissionAdd          = PermissionRegistry.get("role.update.permission.add")          // [global]
-	PermRoleUpdatePermissionRemove       = PermissionRegistry.get("role.update.permission.remove")       // [global]
-	PermService                          = PermissionRegistry.get("service")                             // [global service team]
-	PermServiceInstance                  = PermissionRegistry.get("service-instance")                    // [global service-instance team]
-	PermServiceInstanceCreate            = PermissionRegistry.get("service-instance.create")             // [global team]
-	PermServiceInstanceDelete            = PermissionRegistry.get("service-instance.delete")             // [global service-instance team]
-	PermServiceInstanceRead              = PermissionRegistry.get("service-instance.read")               // [global service-instance team]
-	PermServiceInstanceReadEvents        = PermissionRegistry.get("service-instance.read.events")        // [global service-instance team]
-	PermServiceInstanceReadStatus        = PermissionRegistry.get("service-instance.read.status")        // [global service-instance team]
-	PermServiceInstanceUpdate            = PermissionRegistry.get("service-instance.update")             // [global service-instance team]
-	PermServiceInstanceUpdateBind        = PermissionRegistry.get("service-instance.update.bind")        // [global service-instance team]
-	PermServiceInstanceUpdateDescription = PermissionRegistry.get("service-instance.update.description") // [global service-instance team]
-	PermServiceInstanceUpdateGrant       = PermissionRegistry.get("service-instance.update.grant")       // [global service-instance team]
-	PermServiceInstanceUpdateProxy       = PermissionRegistry.get("service-instance.update.proxy")       // [global service-instance team]
-	PermServiceInstanceUpdateRevoke      = PermissionRegistry.get("service-instance.update.revoke")      // [global service-instance team]
-	PermServiceInstanceUpdateUnbind      = PermissionRegistry.get("service-instance.update.unbind")      // [global service-instance team]
-	PermServiceCreate                    = PermissionRegistry.get("service.create")                      // [global team]
-	PermServiceDelete                    = PermissionRegistry.get("service.delete")                      // [global service team]
-	PermServiceRead                      = PermissionRegistry.get("service.read")                        // [global service team]
-	PermServiceReadDoc                   = PermissionRegistry.get("service.read.doc")                    // [global service team]
-	PermServiceReadEvents                = PermissionRegistry.get("service.read.events")                 // [global service team]
-	PermServiceReadPlans                 = PermissionRegistry.get("service.read.plans")                  // [global service team]
-	PermServiceUpdate                    = PermissionRegistry.get("service.update")                      // [global service team]
-	PermServiceUpdateDoc                 = PermissionRegistry.get("service.update.doc")                  // [global service team]
-	PermServiceUpdateGrantAccess         = PermissionRegistry.get("service.update.grant-access")         // [global service team]
-	PermServiceUpdateProxy               = PermissionRegistry.get("service.update.proxy")                // [global service team]
-	PermServiceUpdateRevokeAccess        = PermissionRegistry.get("service.update.revoke-access")        // [global service team]
-	PermTeam                             = PermissionRegistry.get("team")                                // [global team]
-	PermTeamCreate                       = PermissionRegistry.get("team.create")                         // [global]
-	PermTeamDelete                       = PermissionRegistry.get("team.delete")                         // [global team]
-	PermTeamRead                         = PermissionRegistry.get("team.read")                           // [global team]
-	PermTeamReadEvents                   = PermissionRegistry.get("team.read.events")                    // [global team]
-	PermUser                             = PermissionRegistry.get("user")                                // [global user]
-	PermUserCreate                       = PermissionRegistry.get("user.create")                         // [global]
-	PermUserDelete                       = PermissionRegistry.get("user.delete")                         // [global user]
-	PermUserRead                         = PermissionRegistry.get("user.read")                           // [global user]
-	PermUserReadEvents                   = PermissionRegistry.get("user.read.events")                    // [global user]
-	PermUserUpdate                       = PermissionRegistry.get("user.update")                         // [global user]
-	PermUserUpdateKey                    = PermissionRegistry.get("user.update.key")                     // [global user]
-	PermUserUpdateKeyAdd                 = PermissionRegistry.get("user.update.key.add")                 // [global user]
-	PermUserUpdateKeyRemove              = PermissionRegistry.get("user.update.key.remove")              // [global user]
-	PermUserUpdatePassword               = PermissionRegistry.get("user.update.password")                // [global user]
-	PermUserUpdateQuota                  = PermissionRegistry.get("user.update.quota")                   // [global user]
-	PermUserUpdateReset                  = PermissionRegistry.get("user.update.reset")                   // [global user]
-	PermUserUpdateToken                  = PermissionRegistry.get("user.update.token")                   // [global user]
+	PermAll                                = PermissionRegistry.get("")                                       // [global]
+	PermApp                                = PermissionRegistry.get("app")                                    // [global app team pool]
+	PermAppTemplate                        = PermissionRegistry.get("app-template")                           // [global]
+	PermAppTemplateCreate                  = PermissionRegistry.get("app-template.create")                    // [global]
+	PermAppTemplateDelete                  = PermissionRegistry.get("app-template.delete")                    // [global]
+	PermAppTemplateRead                    = PermissionRegistry.get("app-template.read")                      // [global]
+	PermAppTemplateReadEvents              = PermissionRegistry.get("app-template.read.events")               // [global]
+	PermAppAdmin                           = PermissionRegistry.get("app.admin")                              // [global app team pool]
+	PermAppAdminQuota                      = PermissionRegistry.get("app.admin.quota")                        // [global app team pool]
+	PermAppAdminRoutes                     = PermissionRegistry.get("app.admin.routes")                       // [global app team pool]
+	PermAppAdminUnlock                     = PermissionRegistry.get("app.admin.unlock")                       // [global app team pool]
+	PermAppCreate                          = PermissionRegistry.get("app.create")                             // [global team]
+	PermAppDelete                          = PermissionRegistry.get("app.delete")                             // [global app team pool]
+	PermAppDeleteRestore                   = PermissionRegistry.get("app.delete.restore")                     // [global app team pool]
+	PermAppDeploy                          = PermissionRegistry.get("app.deploy")                             // [global app team pool]
+	PermAppDeployArchiveUrl                = PermissionRegistry.get("app.deploy.archive-url")                 // [global app team pool]
+	PermAppDeployBuild                     = PermissionRegistry.get("app.deploy.build")                       // [global app team pool]
+	PermAppDeployGit                       = PermissionRegistry.get("app.deploy.git")                         // [global app team pool]
+	PermAppDeployImage                     = PermissionRegistry.get("app.deploy.image")                       // [global app team pool]
+	PermAppDeployRollback                  = PermissionRegistry.get("app.deploy.rollback")                    // [global app team pool]
+	PermAppDeployUpload                    = PermissionRegistry.get("app.deploy.upload")                      // [global app team pool]
+	PermAppRead                            = PermissionRegistry.get("app.read")                               // [global app team pool]
+	PermAppReadCertificate                 = PermissionRegistry.get("app.read.certificate")                   // [global app team pool]
+	PermAppReadConfigFile                  = PermissionRegistry.get("app.read.config-file")                   // [global app team pool]
+	PermAppReadDeploy                      = PermissionRegistry.get("app.read.deploy")                        // [global app team pool]
+	PermAppReadEnv                         = PermissionRegistry.get("app.read.env")                           // [global app team pool]
+	PermAppReadEvents                      = PermissionRegistry.get("app.read.events")                        // [global app team pool]
+	PermAppReadLog                         = PermissionRegistry.get("app.read.log")                           // [global app team pool]
+	PermAppReadMetric                      = PermissionRegistry.get("app.read.metric")                        // [global app team pool]
+	PermAppReadSecrets                     = PermissionRegistry.get("app.read.secrets")                       // [global app team pool]
+	PermAppRun                             = PermissionRegistry.get("app.run")                                // [global app team pool]
+	PermAppRunShell                        = PermissionRegistry.get("app.run.shell")                          // [global app team pool]
+	PermAppUpdate                          = PermissionRegistry.get("app.update")                             // [global app team pool]
+	PermAppUpdateBind                      = PermissionRegistry.get("app.update.bind")                        // [global app team pool]
+	PermAppUpdateCertificate               = PermissionRegistry.get("app.update.certificate")                 // [global app team pool]
+	PermAppUpdateCertificateSet            = PermissionRegistry.get("app.update.certificate.set")             // [global app team pool]
+	PermAppUpdateCertificateUnset          = PermissionRegistry.get("app.update.certificate.unset")           // [global app team pool]
+	PermAppUpdateCname                     = PermissionRegistry.get("app.update.cname")                       // [global app team pool]
+	PermAppUpdateCnameAdd                  = PermissionRegistry.get("app.update.cname.add")                   // [global app team pool]
+	PermAppUpdateCnameRemove               = PermissionRegistry.get("app.update.cname.remove")                // [global app team pool]
+	PermAppUpdateConfigFile                = PermissionRegistry.get("app.update.config-file")                 // [global app team pool]
+	PermAppUpdateConfigFileSet             = PermissionRegistry.get("app.update.config-file.set")             // [global app team pool]
+	PermAppUpdateConfigFileUnset           = PermissionRegistry.get("app.update.config-file.unset")           // [global app team pool]
+	PermAppUpdateDeletionProtection        = PermissionRegistry.get("app.update.deletion-protection")         // [global app team pool]
+	PermAppUpdateDescription               = PermissionRegistry.get("app.update.description")                 // [global app team pool]
+	PermAppUpdateEnv                       = PermissionRegistry.get("app.update.env")                         // [global app team pool]
+	PermAppUpdateEnvRollback               = PermissionRegistry.get("app.update.env.rollback")                // [global app team pool]
+	PermAppUpdateEnvSet                    = PermissionRegistry.get("app.update.env.set")                     // [global app team pool]
+	PermAppUpdateEnvUnset                  = PermissionRegistry.get("app.update.env.unset")                   // [global app team pool]
+	PermAppUpdateEvents                    = PermissionRegistry.get("app.update.events")                      // [global app team pool]
+	PermAppUpdateGrant                     = PermissionRegistry.get("app.update.grant")                       // [global app team pool]
+	PermAppUpdateLog                       = PermissionRegistry.get("app.update.log")                         // [global app team pool]
+	PermAppUpdateMaintenance               = PermissionRegistry.get("app.update.maintenance")                 // [global app team pool]
+	PermAppUpdateMaintenanceDisable        = PermissionRegistry.get("app.update.maintenance.disable")         // [global app team pool]
+	PermAppUpdateMaintenanceEnable         = PermissionRegistry.get("app.update.maintenance.enable")          // [global app team pool]
+	PermAppUpdateMaintenancePage           = PermissionRegistry.get("app.update.maintenance.page")            // [global app team pool]
+	PermAppUpdateManifest                  = PermissionRegistry.get("app.update.manifest")                    // [global app team pool]
+	PermAppUpdatePlan                      = PermissionRegistry.get("app.update.plan")                        // [global app team pool]
+	PermAppUpdatePool                      = PermissionRegistry.get("app.update.pool")                        // [global app team pool]
+	PermAppUpdatePoolMigrate               = PermissionRegistry.get("app.update.pool.migrate")                // [global app team pool]
+	PermAppUpdateRestart                   = PermissionRegistry.get("app.update.restart")                     // [global app team pool]
+	PermAppUpdateRevoke                    = PermissionRegistry.get("app.update.revoke")                      // [global app team pool]
+	PermAppUpdateSleep                     = PermissionRegistry.get("app.update.sleep")                       // [global app team pool]
+	PermAppUpdateSleepPolicy               = PermissionRegistry.get("app.update.sleep.policy")                // [global app team pool]
+	PermAppUpdateStart                     = PermissionRegistry.get("app.update.start")                       // [global app team pool]
+	PermAppUpdateStop                      = PermissionRegistry.get("app.update.stop")                        // [global app team pool]
+	PermAppUpdateSwap                      = PermissionRegistry.get("app.update.swap")                        // [global app team pool]
+	PermAppUpdateTeamowner                 = PermissionRegistry.get("app.update.teamowner")                   // [global app team pool]
+	PermAppUpdateUnbind                    = PermissionRegistry.get("app.update.unbind")                      // [global app team pool]
+	PermAppUpdateUnit                      = PermissionRegistry.get("app.update.unit")                        // [global app team pool]
+	PermAppUpdateUnitAdd                   = PermissionRegistry.get("app.update.unit.add")                    // [global app team pool]
+	PermAppUpdateUnitRegister              = PermissionRegistry.get("app.update.unit.register")               // [global app team pool]
+	PermAppUpdateUnitRemove                = PermissionRegistry.get("app.update.unit.remove")                 // [global app team pool]
+	PermAppUpdateUnitSchedule              = PermissionRegistry.get("app.update.unit.schedule")               // [global app team pool]
+	PermAppUpdateUnitStatus                = PermissionRegistry.get("app.update.unit.status")                 // [global app team pool]
+	PermDebug                              = PermissionRegistry.get("debug")                                  // [global]
+	PermHealing                            = PermissionRegistry.get("healing")                                // [global pool]
+	PermHealingDelete                      = PermissionRegistry.get("healing.delete")                         // [global pool]
+	PermHealingRead                        = PermissionRegistry.get("healing.read")                           // [global pool]
+	PermHealingUpdate                      = PermissionRegistry.get("healing.update")                         // [global pool]
+	PermInstall                            = PermissionRegistry.get("install")                                // [global]
+	PermInstallManage                      = PermissionRegistry.get("install.manage")                         // [global]
+	PermMachine                            = PermissionRegistry.get("machine")                                // [global iaas]
+	PermMachineCreate                      = PermissionRegistry.get("machine.create")                         // [global iaas]
+	PermMachineDelete                      = PermissionRegistry.get("machine.delete")                         // [global iaas]
+	PermMachineRead                        = PermissionRegistry.get("machine.read")                           // [global iaas]
+	PermMachineReadEvents                  = PermissionRegistry.get("machine.read.events")                    // [global iaas]
+	PermMachineTemplate                    = PermissionRegistry.get("machine.template")                       // [global iaas]
+	PermMachineTemplateCreate              = PermissionRegistry.get("machine.template.create")                // [global iaas]
+	PermMachineTemplateDelete              = PermissionRegistry.get("machine.template.delete")                // [global iaas]
+	PermMachineTemplateRead                = PermissionRegistry.get("machine.template.read")                  // [global iaas]
+	PermMachineTemplateUpdate              = PermissionRegistry.get("machine.template.update")                // [global iaas]
+	PermNode                               = PermissionRegistry.get("node")                                   // [global pool]
+	PermNodeAutoscale                      = PermissionRegistry.get("node.autoscale")                         // [global]
+	PermNodeAutoscaleDelete                = PermissionRegistry.get("node.autoscale.delete")                  // [global]
+	PermNodeAutoscaleRead                  = PermissionRegistry.get("node.autoscale.read")                    // [global]
+	PermNodeAutoscaleUpdate                = PermissionRegistry.get("node.autoscale.update")                  // [global]
+	PermNodeAutoscaleUpdateRun             = PermissionRegistry.get("node.autoscale.update.run")              // [global]
+	PermNodeCreate                         = PermissionRegistry.get("node.create")                            // [global pool]
+	PermNodeDelete                         = PermissionRegistry.get("node.delete")                            // [global pool]
+	PermNodeRead                           = PermissionRegistry.get("node.read")                              // [global pool]
+	PermNodeUpdate                         = PermissionRegistry.get("node.update")                            // [global pool]
+	PermNodeUpdateDrain                    = PermissionRegistry.get("node.update.drain")                      // [global pool]
+	PermNodeUpdateMove                     = PermissionRegistry.get("node.update.move")                       // [global pool]
+	PermNodeUpdateMoveContainer            = PermissionRegistry.get("node.update.move.container")             // [global pool]
+	PermNodeUpdateMoveContainers           = PermissionRegistry.get("node.update.move.containers")            // [global pool]
+	PermNodeUpdateRebalance                = PermissionRegistry.get("node.update.rebalance")                  // [global pool]
+	PermNodeUpdateUncordon                 = PermissionRegistry.get("node.update.uncordon")                   // [global pool]
+	PermNodecontainer                      = PermissionRegistry.get("nodecontainer")                          // [global pool]
+	PermNodecontainerCreate                = PermissionRegistry.get("nodecontainer.create")                   // [global pool]
+	PermNodecontainerDelete                = PermissionRegistry.get("nodecontainer.delete")                   // [global pool]
+	PermNodecontainerRead                  = PermissionRegistry.get("nodecontainer.read")                     // [global pool]
+	PermNodecontainerUpdate                = PermissionRegistry.get("nodecontainer.update")                   // [global pool]
+	PermNodecontainerUpdateUpgrade         = PermissionRegistry.get("nodecontainer.update.upgrade")           // [global pool]
+	PermPlan                               = PermissionRegistry.get("plan")                                   // [global]
+	PermPlanCreate                         = PermissionRegistry.get("plan.create")                            // [global]
+	PermPlanDelete                         = PermissionRegistry.get("plan.delete")                            // [global]
+	PermPlanRead                           = PermissionRegistry.get("plan.read")                              // [global]
+	PermPlanReadEvents                     = PermissionRegistry.get("plan.read.events")                       // [global]
+	PermPlatform                           = PermissionRegistry.get("platform")                               // [global]
+	PermPlatformCreate                     = PermissionRegistry.get("platform.create")                        // [global]
+	PermPlatformDelete                     = PermissionRegistry.get("platform.delete")                        // [global]
+	PermPlatformRead                       = PermissionRegistry.get("platform.read")                          // [global]
+	PermPlatformReadEvents                 = PermissionRegistry.get("platform.read.events")                   // [global]
+	PermPlatformUpdate                     = PermissionRegistry.get("platform.update")                        // [global]
+	PermPool                               = PermissionRegistry.get("pool")                                   // [global pool]
+	PermPoolCreate                         = PermissionRegistry.get("pool.create")                            // [global]
+	PermPoolDelete                         = PermissionRegistry.get("pool.delete")                            // [global pool]
+	PermPoolRead                           = PermissionRegistry.get("pool.read")                              // [global pool]
+	PermPoolReadEvents                     = PermissionRegistry.get("pool.read.events")                       // [global pool]
+	PermPoolUpdate                         = PermissionRegistry.get("pool.update")                            // [global pool]
+	PermPoolUpdateLogs                     = PermissionRegistry.get("pool.update.logs")                       // [global pool]
+	PermPoolUpdateScheduler                = PermissionRegistry.get("pool.update.scheduler")                  // [global pool]
+	PermPoolUpdateTeam                     = PermissionRegistry.get("pool.update.team")                       // [global pool]
+	PermPoolUpdateTeamAdd                  = PermissionRegistry.get("pool.update.team.add")                   // [global pool]
+	PermPoolUpdateTeamRemove               = PermissionRegistry.get("pool.update.team.remove")                // [global pool]
+	PermRegistryCredential                 = PermissionRegistry.get("registry-credential")                    // [global team pool]
+	PermRegistryCredentialCreate           = PermissionRegistry.get("registry-credential.create")             // [global team pool]
+	PermRegistryCredentialDelete           = PermissionRegistry.get("registry-credential.delete")             // [global team pool]
+	PermRegistryCredentialRead             = PermissionRegistry.get("registry-credential.read")               // [global team pool]
+	PermRole                               = PermissionRegistry.get("role")                                   // [global]
+	PermRoleCreate                         = PermissionRegistry.get("role.create")                            // [global]
+	PermRoleDefault                        = PermissionRegistry.get("role.default")                           // [global]
+	PermRoleDefaultCreate                  = PermissionRegistry.get("role.default.create")                    // [global]
+	PermRoleDefaultDelete                  = PermissionRegistry.get("role.default.delete")                    // [global]
+	PermRoleDelete                         = PermissionRegistry.get("role.delete")                            // [global]
+	PermRoleRead                           = PermissionRegistry.get("role.read")                              // [global]
+	PermRoleReadEvents                     = PermissionRegistry.get("role.read.events")                       // [global]
+	PermRoleUpdate                         = PermissionRegistry.get("role.update")                            // [global]
+	PermRoleUpdateAssign                   = PermissionRegistry.get("role.update.assign")                     // [global]
+	PermRoleUpdateDissociate               = PermissionRegistry.get("role.update.dissociate")                 // [global]
+	PermRoleUpdatePermission               = PermissionRegistry.get("role.update.permission")                 // [global]
+	PermRoleUpdatePermissionAdd            = PermissionRegistry.get("role.update.permission.add")             // [global]
+	PermRoleUpdatePermissionRemove         = PermissionRegistry.get("role.update.permission.remove")          // [global]
+	PermService                            = PermissionRegistry.get("service")                                // [global service team]
+	PermServiceInstance                    = PermissionRegistry.get("service-instance")                       // [global service-instance team]
+	PermServiceInstanceCreate              = PermissionRegistry.get("service-instance.create")                // [global team]
+	PermServiceInstanceDelete              = PermissionRegistry.get("service-instance.delete")                // [global service-instance team]
+	PermServiceInstanceRead                = PermissionRegistry.get("service-instance.read")                  // [global service-instance team]
+	PermServiceInstanceReadBackups         = PermissionRegistry.get("service-instance.read.backups")          // [global service-instance team]
+	PermServiceInstanceReadEvents          = PermissionRegistry.get("service-instance.read.events")           // [global service-instance team]
+	PermServiceInstanceReadStatus          = PermissionRegistry.get("service-instance.read.status")           // [global service-instance team]
+	PermServiceInstanceUpdate              = PermissionRegistry.get("service-instance.update")                // [global service-instance team]
+	PermServiceInstanceUpdateBackup        = PermissionRegistry.get("service-instance.update.backup")         // [global service-instance team]
+	PermServiceInstanceUpdateBackupCreate  = PermissionRegistry.get("service-instance.update.backup.create")  // [global service-instance team]
+	PermServiceInstanceUpdateBackupDelete  = PermissionRegistry.get("service-instance.update.backup.delete")  // [global service-instance team]
+	PermServiceInstanceUpdateBackupPolicy  = PermissionRegistry.get("service-instance.update.backup.policy")  // [global service-instance team]
+	PermServiceInstanceUpdateBackupRestore = PermissionRegistry.get("service-instance.update.backup.restore") // [global service-instance team]
+	PermServiceInstanceUpdateBind          = PermissionRegistry.get("service-instance.update.bind")           // [global service-instance team]
+	PermServiceInstanceUpdateDescription   = PermissionRegistry.get("service-instance.update.description")    // [global service-instance team]
+	PermServiceInstanceUpdateGrant         = PermissionRegistry.get("service-instance.update.grant")          // [global service-instance team]
+	PermServiceInstanceUpdateParameters    = PermissionRegistry.get("service-instance.update.parameters")     // [global service-instance team]
+	PermServiceInstanceUpdatePlan          = PermissionRegistry.get("service-instance.update.plan")           // [global service-instance team]
+	PermServiceInstanceUpdatePools         = PermissionRegistry.get("service-instance.update.pools")          // [global service-instance team]
+	PermServiceInstanceUpdateProxy         = PermissionRegistry.get("service-instance.update.proxy")          // [global service-instance team]
+	PermServiceInstanceUpdateRevoke        = PermissionRegistry.get("service-instance.update.revoke")         // [global service-instance team]
+	PermServiceInstanceUpdateTags          = PermissionRegistry.get("service-instance.update.tags")           // [global service-instance team]
+	PermServiceInstanceUpdateUnbind        = PermissionRegistry.get("service-instance.update.unbind")         // [global service-instance team]
+	PermServiceCreate                      = PermissionRegistry.get("service.create")                         // [global team]
+	PermServiceDelete                      = PermissionRegistry.get("service.delete")                         // [global service team]
+	PermServiceRead                        = PermissionRegistry.get("service.read")                           // [global service team]
+	PermServiceReadDoc                     = PermissionRegistry.get("service.read.doc")                       // [global service team]
+	PermServiceReadEvents                  = PermissionRegistry.get("service.read.events")                    // [global service team]
+	PermServiceReadPlans                   = PermissionRegistry.get("service.read.plans")                     // [global service team]
+	PermServiceUpdate                      = PermissionRegistry.get("service.update")                         // [global service team]
+	PermServiceUpdateDoc                   = PermissionRegistry.get("service.update.doc")                     // [global service team]
+	PermServiceUpdateGrantAccess           = PermissionRegistry.get("service.update.grant-access")            // [global service team]
+	PermServiceUpdateProxy                 = PermissionRegistry.get("service.update.proxy")                   // [global service team]
+	PermServiceUpdateRevokeAccess          = PermissionRegistry.get("service.update.revoke-access")           // [global service team]
+	PermTeam                               = PermissionRegistry.get("team")                                   // [global team]
+	PermTeamCreate                         = PermissionRegistry.get("team.create")                            // [global]
+	PermTeamDelete                         = PermissionRegistry.get("team.delete")                            // [global team]
+	PermTeamRead                           = PermissionRegistry.get("team.read")                              // [global team]
+	PermTeamReadEvents                     = PermissionRegistry.get("team.read.events")                       // [global team]
+	PermTeamReadQuota                      = PermissionRegistry.get("team.read.quota")                        // [global team]
+	PermTeamReadUsage                      = PermissionRegistry.get("team.read.usage")                        // [global team]
+	PermTeamUpdate                         = PermissionRegistry.get("team.update")                            // [global team]
+	PermTeamUpdateMaintenance              = PermissionRegistry.get("team.update.maintenance")                // [global team]
+	PermTeamUpdateMaintenancePage          = PermissionRegistry.get("team.update.maintenance.page")           // [global team]
+	PermTeamUpdateQuota                    = PermissionRegistry.get("team.update.quota")                      // [global team]
+	PermUser                               = PermissionRegistry.get("user")                                   // [global user]
+	PermUserCreate                         = PermissionRegistry.get("user.create")                            // [global]
+	PermUserDelete                         = PermissionRegistry.get("user.delete")                            // [global user]
+	PermUserRead                           = PermissionRegistry.get("user.read")                              // [global user]
+	PermUserReadEvents                     = PermissionRegistry.get("user.read.events")                       // [global user]
+	PermUserUpdate                         = PermissionRegistry.get("user.update")                            // [global user]
+	PermUserUpdateKey                      = PermissionRegistry.get("user.update.key")                        // [global user]
+	PermUserUpdateKeyAdd                   = PermissionRegistry.get("user.update.key.add")                    // [global user]
+	PermUserUpdateKeyRemove                = PermissionRegistry.get("user.update.key.remove")                 // [global user]
+	PermUserUpdatePassword                 = PermissionRegistry.get("user.update.password")                   // [global user]
+	PermUserUpdateQuota                    = PermissionRegistry.get("user.update.quota")                      // [global user]
+	PermUserUpdateReset                    = PermissionRegistry.get("user.update.reset")                      // [global user]
+	PermUserUpdateToken                    = PermissionRegistry.get("user.update.token")                      // [global user]
 )
@@ -12,16 +12,25 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.create", []contextType{CtxTeam},
 ).add(
 	"app.update.description",
+	"app.update.deletion-protection",
 	"app.update.log",
+	"app.update.maintenance.enable",
+	"app.update.maintenance.disable",
+	"app.update.maintenance.page",
+	"app.update.manifest",
 	"app.update.pool",
+	"app.update.pool.migrate",
 	"app.update.unit.add",
 	"app.update.unit.remove",
+	"app.update.unit.schedule",
 	"app.update.unit.register",
 	"app.update.unit.status",
 	"app.update.env.set",
 	"app.update.env.unset",
+	"app.update.env.rollback",
 	"app.update.restart",
 	"app.update.sleep",
+	"app.update.sleep.policy",
 	"app.update.start",
 	"app.update.stop",
 	"app.update.swap",
@@ -36,6 +45,8 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.update.unbind",
 	"app.update.certificate.set",
 	"app.update.certificate.unset",
+	"app.update.config-file.set",
+	"app.update.config-file.unset",
 	"app.deploy",
 	"app.deploy.archive-url",
 	"app.deploy.build",
@@ -46,11 +57,14 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"app.read",
 	"app.read.deploy",
 	"app.read.env",
+	"app.read.secrets",
 	"app.read.events",
 	"app.read.metric",
 	"app.read.log",
 	"app.read.certificate",
+	"app.read.config-file",
 	"app.delete",
+	"app.delete.restore",
 	"app.run",
 	"app.run.shell",
 	"app.admin.unlock",
@@ -64,6 +78,8 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"node.update.move.container",
 	"node.update.move.containers",
 	"node.update.rebalance",
+	"node.update.drain",
+	"node.update.uncordon",
 	"node.delete",
 ).addWithCtx(
 	"node.autoscale", []contextType{},
@@ -89,6 +105,10 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"team.create", []contextType{},
 ).add(
 	"team.read.events",
+	"team.read.usage",
+	"team.read.quota",
+	"team.update.quota",
+	"team.update.maintenance.page",
 	"team.delete",
 ).addWithCtx(
 	"user", []contextType{CtxUser},
@@ -123,6 +143,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 ).add(
 	"service-instance.read.events",
 	"service-instance.read.status",
+	"service-instance.read.backups",
 	"service-instance.delete",
 	"service-instance.update.proxy",
 	"service-instance.update.bind",
@@ -130,6 +151,14 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"service-instance.update.grant",
 	"service-instance.update.revoke",
 	"service-instance.update.description",
+	"service-instance.update.plan",
+	"service-instance.update.parameters",
+	"service-instance.update.tags",
+	"service-instance.update.pools",
+	"service-instance.update.backup.create",
+	"service-instance.update.backup.restore",
+	"service-instance.update.backup.delete",
+	"service-instance.update.backup.policy",
 ).add(
 	"role.create",
 	"role.delete",
@@ -149,6 +178,10 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"plan.create",
 	"plan.delete",
 	"plan.read.events",
+).add(
+	"app-template.create",
+	"app-template.delete",
+	"app-template.read.events",
 ).addWithCtx(
 	"pool", []contextType{CtxPool},
 ).addWithCtx(
@@ -158,6 +191,7 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"pool.update.team.add",
 	"pool.update.team.remove",
 	"pool.update.logs",
+	"pool.update.scheduler",
 	"pool.delete",
 ).add(
 	"debug",
@@ -177,6 +211,12 @@ var PermissionRegistry = (&registry{}).addWithCtx(
 	"nodecontainer.update",
 	"nodecontainer.update.upgrade",
 	"nodecontainer.delete",
+).addWithCtx(
+	"registry-credential", []contextType{CtxTeam, CtxPool},
+).add(
+	"registry-credential.create",
+	"registry-credential.read",
+	"registry-credential.delete",
 ).add(
 	"install.manage",
 )
@@ -451,6 +451,12 @@ var addNewRoutes = action.Action{
 		if writer == nil {
 			writer = ioutil.Discard
 		}
+		if provision.UnitRoutesDisabled(args.app) {
+			if len(newContainers) > 0 {
+				fmt.Fprintf(writer, "\n---- Not adding routes to new units, app routes are disabled ----\n")
+			}
+			return newContainers, nil
+		}
 		if len(newContainers) > 0 {
 			fmt.Fprintf(writer, "\n---- Adding routes to new units ----\n")
 		}
@@ -599,6 +605,10 @@ var removeOldRoutes = action.Action{
 		if writer == nil {
 			writer = ioutil.Discard
 		}
+		if provision.UnitRoutesDisabled(args.app) {
+			// Routes of the app don't point to its units.
+			return
+		}
 		if len(args.toRemove) > 0 {
 			fmt.Fprintf(writer, "\n---- Removing routes from old units ----\n")
 		}
@@ -18,6 +18,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/tsuru/config"
 	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/db/storage"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/net"
@@ -135,6 +136,18 @@ func (c *Container) Create(args *CreateArgs) error {
 	if err != nil {
 		return err
 	}
+	var configFiles []provision.ConfigFile
+	var configVolume string
+	if !args.Deploy && !args.Building {
+		configFiles, err = dockercommon.AppConfigFiles(args.App)
+		if err != nil {
+			return err
+		}
+		if len(configFiles) > 0 {
+			configVolume = dockercommon.ConfigFilesVolumeName(args.App.GetName(), configFiles)
+			hostConf.Binds = append(hostConf.Binds, dockercommon.ConfigFilesBind(configVolume))
+		}
+	}
 	conf := docker.Config{
 		Image:        args.ImageID,
 		Cmd:          args.Commands,
@@ -157,7 +170,10 @@ func (c *Container) Create(args *CreateArgs) error {
 			"tsuru.router.type":  routerType,
 		},
 	}
-	c.addEnvsToConfig(args, strings.TrimSuffix(c.ExposedPort, "/tcp"), &conf)
+	err = c.addEnvsToConfig(args, strings.TrimSuffix(c.ExposedPort, "/tcp"), &conf)
+	if err != nil {
+		return err
+	}
 	opts := docker.CreateContainerOptions{Name: c.Name, Config: &conf, HostConfig: hostConf}
 	var nodeList []string
 	if len(args.DestinationHosts) > 0 {
@@ -184,12 +200,38 @@ func (c *Container) Create(args *CreateArgs) error {
 	}
 	c.ID = cont.ID
 	c.HostAddr = hostAddr
+	if configVolume != "" {
+		err = c.ensureConfigFilesVolume(args, addr, configVolume, configFiles)
+		if err != nil {
+			args.Provisioner.Cluster().RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true})
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *Container) addEnvsToConfig(args *CreateArgs, port string, cfg *docker.Config) {
+// ensureConfigFilesVolume populates the config files volume mounted by the
+// container in its node, the app image is already there as the container
+// was just created from it.
+func (c *Container) ensureConfigFilesVolume(args *CreateArgs, addr, volume string, files []provision.ConfigFile) error {
+	node, err := args.Provisioner.Cluster().GetNode(addr)
+	if err != nil {
+		return err
+	}
+	client, err := node.Client()
+	if err != nil {
+		return err
+	}
+	return dockercommon.EnsureConfigFilesVolume(client, args.App.GetName(), volume, args.ImageID, files)
+}
+
+func (c *Container) addEnvsToConfig(args *CreateArgs, port string, cfg *docker.Config) error {
 	if !args.Deploy {
-		for _, envData := range args.App.Envs() {
+		envs, err := args.App.UnitEnvs()
+		if err != nil {
+			return err
+		}
+		for _, envData := range bind.ProcessEnvs(envs, c.ProcessName) {
 			cfg.Env = append(cfg.Env, fmt.Sprintf("%s=%s", envData.Name, envData.Value))
 		}
 		cfg.Env = append(cfg.Env, fmt.Sprintf("%s=%s", "TSURU_PROCESSNAME", c.ProcessName))
@@ -208,6 +250,7 @@ func (c *Container) addEnvsToConfig(args *CreateArgs, port string, cfg *docker.C
 		}
 		cfg.Env = append(cfg.Env, fmt.Sprintf("TSURU_SHAREDFS_MOUNTPOINT=%s", sharedMount))
 	}
+	return nil
 }
 
 func (c *Container) user() string {
@@ -26,6 +26,7 @@ import (
 	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
 	"github.com/tsuru/tsuru/provision/provisiontest"
 	"github.com/tsuru/tsuru/router/routertest"
 	"gopkg.in/check.v1"
@@ -153,6 +154,115 @@ func (s *S) TestContainerCreate(c *check.C) {
 	c.Assert(cont.Status, check.Equals, "created")
 }
 
+type configFilesApp struct {
+	*provisiontest.FakeApp
+	files []provision.ConfigFile
+}
+
+func (a *configFilesApp) ConfigFiles() ([]provision.ConfigFile, error) {
+	return a.files, nil
+}
+
+func (s *S) TestContainerCreateConfigFiles(c *check.C) {
+	var uploads []string
+	s.server.CustomHandler("/containers/.*/archive", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		uploads = append(uploads, r.URL.Query().Get("path"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	content := strings.Repeat("secret", 64*1024/6)
+	app := &configFilesApp{
+		FakeApp: provisiontest.NewFakeApp("app-name", "brainfuck", 1),
+		files:   []provision.ConfigFile{{Name: "app.conf", Content: []byte(content)}},
+	}
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	img := "tsuru/brainfuck:latest"
+	s.p.Cluster().PullImage(docker.PullImageOptions{Repository: img}, docker.AuthConfiguration{})
+	cont := Container{
+		Name:        "myName",
+		AppName:     app.GetName(),
+		Type:        app.GetPlatform(),
+		Status:      "created",
+		ProcessName: "web",
+		ExposedPort: "8888/tcp",
+	}
+	err := cont.Create(&CreateArgs{
+		App:         app,
+		ImageID:     img,
+		Commands:    []string{"docker", "run"},
+		Provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(&cont)
+	dcli, _ := docker.NewClient(s.server.URL())
+	container, err := dcli.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	volume := dockercommon.ConfigFilesVolumeName(app.GetName(), app.files)
+	c.Assert(container.HostConfig.Binds, check.DeepEquals, []string{volume + ":/home/application/config:ro"})
+	c.Assert(strings.Join(container.Config.Cmd, " "), check.Not(check.Matches), "(?s).*secret.*")
+	c.Assert(uploads, check.DeepEquals, []string{"/tsuru-config-files"})
+	vol, err := dcli.InspectVolume(volume)
+	c.Assert(err, check.IsNil)
+	c.Assert(vol.Name, check.Equals, volume)
+}
+
+func (s *S) TestContainerCreateProcessScopedEnvs(c *check.C) {
+	s.server.CustomHandler("/images/.*/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := docker.Image{
+			Config: &docker.Config{
+				ExposedPorts: map[docker.Port]struct{}{},
+			},
+		}
+		j, _ := json.Marshal(response)
+		w.Write(j)
+	}))
+	app := provisiontest.NewFakeApp("app-name", "brainfuck", 1)
+	app.SetEnv(bind.EnvVar{Name: "A", Value: "myenva"})
+	app.SetEnv(bind.EnvVar{Name: "WEB_ONLY", Value: "web", Processes: []string{"web"}})
+	app.SetEnv(bind.EnvVar{Name: "WORKER_ONLY", Value: "worker", Processes: []string{"worker"}})
+	app.SetEnv(bind.EnvVar{
+		Name:  bind.TsuruServicesEnvVar,
+		Value: `{"mysql":[{"instance_name":"db","envs":{"WEB_ONLY":"web"},"processes":["web"]},{"instance_name":"jobs","envs":{"WORKER_ONLY":"worker"},"processes":["worker"]}]}`,
+	})
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	img := "tsuru/brainfuck:latest"
+	s.p.Cluster().PullImage(docker.PullImageOptions{Repository: img}, docker.AuthConfiguration{})
+	cont := Container{
+		Name:        "myName",
+		AppName:     app.GetName(),
+		Type:        app.GetPlatform(),
+		Status:      "created",
+		ProcessName: "web",
+		ExposedPort: "8888/tcp",
+	}
+	err := cont.Create(&CreateArgs{
+		App:         app,
+		ImageID:     img,
+		Commands:    []string{"docker", "run"},
+		Provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(&cont)
+	dcli, _ := docker.NewClient(s.server.URL())
+	container, err := dcli.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	envs := map[string]string{}
+	for _, env := range container.Config.Env {
+		parts := strings.SplitN(env, "=", 2)
+		envs[parts[0]] = parts[1]
+	}
+	c.Assert(envs["A"], check.Equals, "myenva")
+	c.Assert(envs["WEB_ONLY"], check.Equals, "web")
+	_, ok := envs["WORKER_ONLY"]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(envs[bind.TsuruServicesEnvVar], check.Equals, `{"mysql":[{"instance_name":"db","envs":{"WEB_ONLY":"web"},"processes":["web"]}]}`)
+}
+
 func (s *S) TestContainerCreateCustomLog(c *check.C) {
 	client, err := docker.NewClient(s.server.URL())
 	c.Assert(err, check.IsNil)
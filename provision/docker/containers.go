@@ -13,6 +13,7 @@ import (
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
+	"github.com/tsuru/docker-cluster/cluster"
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/app/image"
@@ -22,6 +23,7 @@ import (
 	"github.com/tsuru/tsuru/net"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/dockercommon"
 	"github.com/tsuru/tsuru/router/rebuild"
 )
 
@@ -119,9 +121,48 @@ func (p *dockerProvisioner) runReplaceUnitsPipeline(w io.Writer, a provision.App
 	if err != nil {
 		return nil, err
 	}
+	if !p.isDryMode {
+		p.removeConfigFilesVolumes(a, true)
+	}
 	return pipeline.Result().([]container.Container), nil
 }
 
+// removeConfigFilesVolumes removes the config files volumes of the app from
+// the nodes where its units may have run. The volume of the current config
+// files is kept when keepCurrent is true, otherwise every node is cleaned.
+// Failures are only logged, as stale volumes are removed again later.
+func (p *dockerProvisioner) removeConfigFilesVolumes(a provision.App, keepCurrent bool) {
+	var keep string
+	var nodes []cluster.Node
+	var err error
+	if keepCurrent {
+		files, filesErr := dockercommon.AppConfigFiles(a)
+		if filesErr != nil {
+			log.Errorf("unable to get config files of app %s: %s", a.GetName(), filesErr)
+			return
+		}
+		if len(files) > 0 {
+			keep = dockercommon.ConfigFilesVolumeName(a.GetName(), files)
+		}
+		nodes, err = p.Cluster().UnfilteredNodesForMetadata(map[string]string{"pool": a.GetPool()})
+	} else {
+		nodes, err = p.Cluster().UnfilteredNodes()
+	}
+	if err != nil {
+		log.Errorf("unable to list nodes to remove config files volumes of app %s: %s", a.GetName(), err)
+		return
+	}
+	for _, node := range nodes {
+		client, err := node.Client()
+		if err == nil {
+			_, err = dockercommon.RemoveConfigFilesVolumes(client, a.GetName(), keep)
+		}
+		if err != nil {
+			log.Errorf("unable to remove config files volumes of app %s from node %s: %s", a.GetName(), node.Address, err)
+		}
+	}
+}
+
 func (p *dockerProvisioner) runCreateUnitsPipeline(w io.Writer, a provision.App, toAdd map[string]*containersToAdd, imageId, exposedPort string) ([]container.Container, error) {
 	if w == nil {
 		w = ioutil.Discard
@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/ajg/form"
+	"github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
 	"github.com/tsuru/config"
 	"github.com/tsuru/docker-cluster/cluster"
@@ -872,3 +873,130 @@ func (s *HandlersSuite) TestDockerLogsInfoHandler(c *check.C) {
 		"p1": {Driver: "syslog", LogOpts: map[string]string{}},
 	})
 }
+
+func (s *HandlersSuite) TestSchedulerConfigSetHandler(c *check.C) {
+	values := url.Values{
+		"pool":           []string{"POOL1"},
+		"Strategy":       []string{"binpack"},
+		"MaxMemoryRatio": []string{"0.8"},
+		"ZoneMetadata":   []string{"zone"},
+		"Taints.0":       []string{"dedicated=gpu"},
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/scheduler", strings.NewReader(values.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	entries, err := SchedulerConfigLoadAll()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries["POOL1"], check.DeepEquals, SchedulerConfig{
+		Strategy:       "binpack",
+		MaxMemoryRatio: 0.8,
+		ZoneMetadata:   "zone",
+		Taints:         []string{"dedicated=gpu"},
+	})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypePool, Value: "POOL1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "pool.update.scheduler",
+		StartCustomData: []map[string]interface{}{
+			{"name": "Strategy", "value": "binpack"},
+			{"name": "MaxMemoryRatio", "value": "0.8"},
+			{"name": "ZoneMetadata", "value": "zone"},
+			{"name": "Taints.0", "value": "dedicated=gpu"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *HandlersSuite) TestSchedulerConfigSetHandlerInvalid(c *check.C) {
+	values := url.Values{
+		"pool":     []string{"POOL1"},
+		"Strategy": []string{"random"},
+	}
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/scheduler", strings.NewReader(values.Encode()))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, ErrInvalidSchedulerStrategy.Error()+"\n")
+}
+
+func (s *HandlersSuite) TestSchedulerConfigInfoHandler(c *check.C) {
+	conf := SchedulerConfig{Strategy: "spread"}
+	err := conf.Save("p1")
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/docker/scheduler", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result map[string]SchedulerConfig
+	err = json.Unmarshal(recorder.Body.Bytes(), &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string]SchedulerConfig{
+		"":   {},
+		"p1": {Strategy: "spread"},
+	})
+}
+
+func (s *HandlersSuite) TestSchedulerConfigDeleteHandler(c *check.C) {
+	conf := SchedulerConfig{Strategy: "spread"}
+	err := conf.Save("p1")
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("DELETE", "/docker/scheduler?pool=p1", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	entries, err := SchedulerConfigLoadAll()
+	c.Assert(err, check.IsNil)
+	c.Assert(entries, check.DeepEquals, map[string]SchedulerConfig{"": {}})
+	c.Assert(eventtest.EventDesc{
+		Target: event.Target{Type: event.TargetTypePool, Value: "p1"},
+		Owner:  s.token.GetUserName(),
+		Kind:   "pool.update.scheduler",
+		StartCustomData: []map[string]interface{}{
+			{"name": "pool", "value": "p1"},
+		},
+	}, eventtest.HasEvent)
+}
+
+func (s *HandlersSuite) TestImageGCReportHandler(c *check.C) {
+	server, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	mainDockerProvisioner.cluster, err = cluster.New(&segregatedScheduler{}, &cluster.MapStorage{},
+		cluster.Node{Address: server.URL()},
+	)
+	c.Assert(err, check.IsNil)
+	err = mainDockerProvisioner.Cluster().PullImage(docker.PullImageOptions{Repository: "tsuru/app-removed:v1"}, docker.AuthConfiguration{})
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/docker/images/gc", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	api.RunServer(true).ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var report ImageGCReport
+	err = json.Unmarshal(recorder.Body.Bytes(), &report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, true)
+	c.Assert(report.Nodes, check.DeepEquals, []ImageGCNodeReport{
+		{Address: server.URL(), Images: []string{"tsuru/app-removed:v1"}},
+	})
+	images, err := mainDockerProvisioner.Cluster().ListImages(docker.ListImagesOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(images, check.HasLen, 1)
+}
@@ -0,0 +1,355 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	clusterStorage "github.com/tsuru/docker-cluster/storage"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/provision/nodecontainer"
+	"github.com/tsuru/tsuru/registry"
+)
+
+const (
+	danglingImageTag   = "<none>:<none>"
+	imageGCEventKind   = "image-gc"
+	imageGCEventTarget = "docker-images"
+)
+
+// ImageGCReport describes the images removed, or that would be removed in
+// dry mode, by a run of the image garbage collector.
+type ImageGCReport struct {
+	DryRun     bool
+	Referenced []string
+	Nodes      []ImageGCNodeReport
+	Registry   []string
+	Errors     []string
+}
+
+type ImageGCNodeReport struct {
+	Address  string
+	Images   []string
+	Dangling []string
+}
+
+type imageGC struct {
+	RunInterval time.Duration
+	GracePeriod time.Duration
+	Enabled     bool
+	provisioner *dockerProvisioner
+	done        chan bool
+}
+
+func (p *dockerProvisioner) initImageGC() *imageGC {
+	enabled, _ := config.GetBool("docker:image-gc:enabled")
+	runInterval, _ := config.GetInt("docker:image-gc:run-interval")
+	gracePeriod, _ := config.GetInt("docker:image-gc:grace-period")
+	gc := &imageGC{
+		RunInterval: time.Duration(runInterval) * time.Second,
+		GracePeriod: time.Duration(gracePeriod) * time.Second,
+		Enabled:     enabled,
+		provisioner: p,
+		done:        make(chan bool),
+	}
+	if gc.RunInterval == 0 {
+		gc.RunInterval = 24 * time.Hour
+	}
+	if gc.GracePeriod == 0 {
+		gc.GracePeriod = time.Hour
+	}
+	return gc
+}
+
+func (gc *imageGC) run() {
+	for {
+		gc.runOnce()
+		select {
+		case <-gc.done:
+			return
+		case <-time.After(gc.RunInterval):
+		}
+	}
+}
+
+// runOnce collects images holding a lock shared by all tsuru instances, as
+// the event of the run, skipping the run if another instance collected
+// images less than RunInterval ago.
+func (gc *imageGC) runOnce() {
+	target := event.Target{Type: event.TargetTypeGC, Value: imageGCEventTarget}
+	running := false
+	recent, err := event.List(&event.Filter{
+		Target:  target,
+		Running: &running,
+		Since:   time.Now().Add(-gc.RunInterval),
+		Limit:   1,
+	})
+	if err != nil {
+		log.Errorf("[image gc] unable to list previous runs: %s", err)
+		return
+	}
+	if len(recent) > 0 {
+		log.Debugf("[image gc] skipping, images already collected at %s", recent[0].StartTime)
+		return
+	}
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       target,
+		InternalKind: imageGCEventKind,
+		Allowed:      event.Allowed(permission.PermPoolReadEvents),
+	})
+	if err != nil {
+		if _, ok := err.(event.ErrEventLocked); ok {
+			log.Debugf("[image gc] skipping, already running in another tsuru instance")
+		} else {
+			log.Errorf("[image gc] unable to create event: %s", err)
+		}
+		return
+	}
+	report, err := gc.collect(false)
+	if err != nil {
+		log.Errorf("[image gc] %s", err)
+	} else {
+		for _, e := range report.Errors {
+			log.Errorf("[image gc] %s", e)
+		}
+	}
+	evt.DoneCustomData(err, report)
+}
+
+func (gc *imageGC) Shutdown() {
+	gc.done <- true
+}
+
+func (gc *imageGC) String() string {
+	return "image gc"
+}
+
+// referencedImages returns the images that must be kept in nodes: valid app
+// images, platform images, node container images and images used by
+// existing containers. Node container images are also returned as
+// repositories as they may be pinned to a digest.
+func (gc *imageGC) referencedImages() (map[string]struct{}, map[string]struct{}, error) {
+	images := map[string]struct{}{}
+	repos := map[string]struct{}{}
+	appImages, err := image.ListAllValidAppImages()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, img := range appImages {
+		images[normalizeImageTag(img)] = struct{}{}
+	}
+	platforms, err := app.Platforms(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, plat := range platforms {
+		images[image.PlatformImageName(plat.Name)] = struct{}{}
+	}
+	nodeContainers, err := nodecontainer.AllNodeContainers()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, group := range nodeContainers {
+		for _, conf := range group.ConfigPools {
+			if img := conf.Image(); img != "" {
+				images[normalizeImageTag(img)] = struct{}{}
+				repos[imageRepository(img)] = struct{}{}
+			}
+		}
+	}
+	containers, err := gc.provisioner.listAllContainers()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range containers {
+		if c.Image != "" {
+			images[normalizeImageTag(c.Image)] = struct{}{}
+		}
+	}
+	return images, repos, nil
+}
+
+// collect finds images in nodes and in the registry which are not referenced
+// by tsuru, removing them unless dry is set. Only dangling images and images
+// in the tsuru namespace are considered.
+func (gc *imageGC) collect(dry bool) (*ImageGCReport, error) {
+	referenced, referencedRepos, err := gc.referencedImages()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find referenced images")
+	}
+	report := &ImageGCReport{DryRun: dry}
+	for img := range referenced {
+		report.Referenced = append(report.Referenced, img)
+	}
+	sort.Strings(report.Referenced)
+	nodes, err := gc.provisioner.Cluster().UnfilteredNodes()
+	if err != nil {
+		return nil, err
+	}
+	prefix := image.ManagedImagePrefix()
+	minCreated := time.Now().Add(-gc.GracePeriod)
+	removedFromNodes := map[string]struct{}{}
+	for i := range nodes {
+		node := &nodes[i]
+		client, err := node.Client()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", node.Address, err))
+			continue
+		}
+		nodeImages, err := client.ListImages(docker.ListImagesOptions{})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: unable to list images: %s", node.Address, err))
+			continue
+		}
+		nodeReport := ImageGCNodeReport{Address: node.Address}
+		for _, img := range nodeImages {
+			if img.Created > minCreated.Unix() {
+				continue
+			}
+			if isDanglingImage(img) {
+				nodeReport.Dangling = append(nodeReport.Dangling, img.ID)
+				continue
+			}
+			for _, tag := range img.RepoTags {
+				if strings.HasPrefix(tag, prefix) && !isReferencedImage(tag, referenced, referencedRepos) {
+					nodeReport.Images = append(nodeReport.Images, tag)
+				}
+			}
+		}
+		if len(nodeReport.Images) == 0 && len(nodeReport.Dangling) == 0 {
+			continue
+		}
+		sort.Strings(nodeReport.Images)
+		sort.Strings(nodeReport.Dangling)
+		report.Nodes = append(report.Nodes, nodeReport)
+		if dry {
+			continue
+		}
+		for _, name := range nodeReport.Images {
+			removedFromNodes[name] = struct{}{}
+		}
+		for _, name := range append(nodeReport.Images, nodeReport.Dangling...) {
+			err = client.RemoveImage(name)
+			if err != nil && err != docker.ErrNoSuchImage {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: unable to remove image %s: %s", node.Address, name, err))
+			}
+		}
+	}
+	for name := range removedFromNodes {
+		err = gc.provisioner.Cluster().RemoveImage(name)
+		if err != nil && err != clusterStorage.ErrNoSuchImage {
+			report.Errors = append(report.Errors, fmt.Sprintf("unable to remove image %s: %s", name, err))
+		}
+	}
+	catalog, err := registry.TsuruCatalog()
+	if err == registry.ErrRegistryNotConfigured {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gc.collectRegistry(catalog, referenced, referencedRepos, minCreated, dry, report)
+	return report, nil
+}
+
+// collectRegistry removes the images in the tsuru namespace of the registry
+// which are not referenced by tsuru. Images are removed by digest, so tags
+// sharing the digest of a referenced tag are kept.
+func (gc *imageGC) collectRegistry(catalog *registry.Catalog, referenced, referencedRepos map[string]struct{}, minCreated time.Time, dry bool, report *ImageGCReport) {
+	repos, err := catalog.Repositories()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("unable to list registry repositories: %s", err))
+		return
+	}
+	namespace := strings.TrimPrefix(image.ManagedImagePrefix(), catalog.Registry+"/")
+	for _, repo := range repos {
+		if !strings.HasPrefix(repo, namespace) {
+			continue
+		}
+		tags, err := catalog.Tags(repo)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("unable to list tags of %s: %s", repo, err))
+			continue
+		}
+		keptDigests := map[string]struct{}{}
+		var toRemove []*registry.CatalogImage
+		for _, tag := range tags {
+			img, err := catalog.Image(repo, tag)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("unable to get image %s:%s: %s", repo, tag, err))
+				// the digest of the tag is unknown, removing other tags could
+				// remove it too.
+				toRemove = nil
+				break
+			}
+			if img.Created.After(minCreated) || isReferencedImage(img.Name(catalog.Registry), referenced, referencedRepos) {
+				keptDigests[img.Digest] = struct{}{}
+				continue
+			}
+			toRemove = append(toRemove, img)
+		}
+		for _, img := range toRemove {
+			if _, ok := keptDigests[img.Digest]; ok {
+				continue
+			}
+			name := img.Name(catalog.Registry)
+			report.Registry = append(report.Registry, name)
+			if dry {
+				continue
+			}
+			err = catalog.Remove(img)
+			if err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+	}
+	sort.Strings(report.Registry)
+}
+
+func isReferencedImage(name string, referenced, referencedRepos map[string]struct{}) bool {
+	if _, ok := referenced[name]; ok {
+		return true
+	}
+	_, ok := referencedRepos[imageRepository(name)]
+	return ok
+}
+
+func isDanglingImage(img docker.APIImages) bool {
+	for _, tag := range img.RepoTags {
+		if tag != danglingImageTag {
+			return false
+		}
+	}
+	return true
+}
+
+// imageRepository returns the image name without its tag or digest.
+func imageRepository(img string) string {
+	if idx := strings.Index(img, "@"); idx != -1 {
+		img = img[:idx]
+	}
+	if idx := strings.LastIndex(img, ":"); idx > strings.LastIndex(img, "/") {
+		img = img[:idx]
+	}
+	return img
+}
+
+// normalizeImageTag adds the latest tag to images without a tag.
+func normalizeImageTag(img string) string {
+	if strings.Contains(img, "@") || imageRepository(img) != img {
+		return img
+	}
+	return img + ":latest"
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/permission"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) prepareImageGC(c *check.C) {
+	err := image.AppendAppImageName("myapp", "tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName("myapp", "tsuru/app-myapp:v2")
+	c.Assert(err, check.IsNil)
+	err = s.storage.Platforms().Insert(app.Platform{Name: "python"})
+	c.Assert(err, check.IsNil)
+	for _, img := range []string{"tsuru/app-myapp:v1", "tsuru/app-myapp:v2", "tsuru/app-removed:v1", "tsuru/python:latest", "other/image:latest"} {
+		err = s.newFakeImage(s.p, img, nil)
+		c.Assert(err, check.IsNil)
+	}
+	err = image.PullAppImageNames("myapp", []string{"tsuru/app-myapp:v1"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) nodeImageTags(c *check.C) []string {
+	client, err := docker.NewClient(s.server.URL())
+	c.Assert(err, check.IsNil)
+	images, err := client.ListImages(docker.ListImagesOptions{})
+	c.Assert(err, check.IsNil)
+	var tags []string
+	for _, img := range images {
+		tags = append(tags, img.RepoTags...)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (s *S) TestImageGCDryRun(c *check.C) {
+	s.prepareImageGC(c)
+	gc := &imageGC{provisioner: s.p}
+	report, err := gc.collect(true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, true)
+	c.Assert(report.Errors, check.IsNil)
+	c.Assert(report.Referenced, check.DeepEquals, []string{"tsuru/app-myapp:v2", "tsuru/python:latest"})
+	c.Assert(report.Nodes, check.DeepEquals, []ImageGCNodeReport{
+		{Address: s.server.URL(), Images: []string{"tsuru/app-myapp:v1", "tsuru/app-removed:v1"}},
+	})
+	c.Assert(report.Registry, check.IsNil)
+	c.Assert(s.nodeImageTags(c), check.HasLen, 5)
+}
+
+func (s *S) TestImageGCRemovesUnreferencedImages(c *check.C) {
+	s.prepareImageGC(c)
+	gc := &imageGC{provisioner: s.p}
+	report, err := gc.collect(false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, false)
+	c.Assert(report.Errors, check.IsNil)
+	c.Assert(s.nodeImageTags(c), check.DeepEquals, []string{"other/image:latest", "tsuru/app-myapp:v2", "tsuru/python:latest"})
+}
+
+func (s *S) TestImageGCRemovesUnreferencedImagesFromRegistry(c *check.C) {
+	var removed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/_catalog":
+			w.Write([]byte(`{"repositories":["other/image","tsuru/app-myapp","tsuru/app-removed"]}`))
+		case r.URL.Path == "/v2/tsuru/app-myapp/tags/list":
+			w.Write([]byte(`{"tags":["v1","v2","v3","latest"]}`))
+		case r.URL.Path == "/v2/tsuru/app-removed/tags/list":
+			w.Write([]byte(`{"tags":["v1"]}`))
+		case r.Method == "DELETE":
+			removed = append(removed, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		case strings.Contains(r.URL.Path, "/manifests/"):
+			c.Check(r.Header.Get("Accept"), check.Equals, "application/vnd.docker.distribution.manifest.v2+json")
+			digest := path.Base(r.URL.Path)
+			if digest == "latest" {
+				digest = "v2"
+			}
+			w.Header().Set("Docker-Content-Digest", "sha256:"+digest)
+			fmt.Fprintf(w, `{"config":{"digest":"sha256:config-%s"}}`, digest)
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			created := time.Now().Add(-2 * time.Hour)
+			if path.Base(r.URL.Path) == "sha256:config-v3" {
+				created = time.Now()
+			}
+			json.NewEncoder(w).Encode(map[string]time.Time{"created": created})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	config.Set("docker:registry", u.Host)
+	defer config.Unset("docker:registry")
+	err := image.AppendAppImageName("myapp", u.Host+"/tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName("myapp", u.Host+"/tsuru/app-myapp:v2")
+	c.Assert(err, check.IsNil)
+	err = image.PullAppImageNames("myapp", []string{u.Host + "/tsuru/app-myapp:v1"})
+	c.Assert(err, check.IsNil)
+	gc := &imageGC{provisioner: s.p, GracePeriod: time.Hour}
+	report, err := gc.collect(true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Errors, check.IsNil)
+	c.Assert(report.Registry, check.DeepEquals, []string{u.Host + "/tsuru/app-myapp:v1", u.Host + "/tsuru/app-removed:v1"})
+	c.Assert(removed, check.IsNil)
+	report, err = gc.collect(false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Errors, check.IsNil)
+	sort.Strings(removed)
+	c.Assert(removed, check.DeepEquals, []string{
+		"/v2/tsuru/app-myapp/manifests/sha256:v1",
+		"/v2/tsuru/app-removed/manifests/sha256:v1",
+	})
+}
+
+func (s *S) TestImageGCRunOnceLocked(c *check.C) {
+	s.prepareImageGC(c)
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeGC, Value: imageGCEventTarget},
+		InternalKind: imageGCEventKind,
+		Allowed:      event.Allowed(permission.PermPoolReadEvents),
+	})
+	c.Assert(err, check.IsNil)
+	gc := &imageGC{provisioner: s.p, RunInterval: time.Hour}
+	gc.runOnce()
+	c.Assert(s.nodeImageTags(c), check.HasLen, 5)
+	err = evt.Abort()
+	c.Assert(err, check.IsNil)
+	gc.runOnce()
+	c.Assert(s.nodeImageTags(c), check.HasLen, 3)
+}
+
+func (s *S) TestImageGCRunOnceSkipsRecentRun(c *check.C) {
+	s.prepareImageGC(c)
+	gc := &imageGC{provisioner: s.p, RunInterval: time.Hour}
+	gc.runOnce()
+	c.Assert(s.nodeImageTags(c), check.HasLen, 3)
+	err := s.newFakeImage(s.p, "tsuru/app-removed:v2", nil)
+	c.Assert(err, check.IsNil)
+	gc.runOnce()
+	c.Assert(s.nodeImageTags(c), check.HasLen, 4)
+	evts, err := event.List(&event.Filter{KindName: imageGCEventKind})
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+}
+
+func (s *S) TestImageRepository(c *check.C) {
+	c.Assert(imageRepository("tsuru/bs"), check.Equals, "tsuru/bs")
+	c.Assert(imageRepository("tsuru/bs:v1"), check.Equals, "tsuru/bs")
+	c.Assert(imageRepository("localhost:5000/tsuru/bs:v1"), check.Equals, "localhost:5000/tsuru/bs")
+	c.Assert(imageRepository("localhost:5000/tsuru/bs"), check.Equals, "localhost:5000/tsuru/bs")
+	c.Assert(imageRepository("tsuru/bs@sha256:abc"), check.Equals, "tsuru/bs")
+	c.Assert(normalizeImageTag("tsuru/bs"), check.Equals, "tsuru/bs:latest")
+	c.Assert(normalizeImageTag("localhost:5000/tsuru/bs"), check.Equals, "localhost:5000/tsuru/bs:latest")
+	c.Assert(normalizeImageTag("tsuru/bs:v1"), check.Equals, "tsuru/bs:v1")
+	c.Assert(normalizeImageTag("tsuru/bs@sha256:abc"), check.Equals, "tsuru/bs@sha256:abc")
+}
+
+func (s *S) TestIsDanglingImage(c *check.C) {
+	c.Assert(isDanglingImage(docker.APIImages{}), check.Equals, true)
+	c.Assert(isDanglingImage(docker.APIImages{RepoTags: []string{"<none>:<none>"}}), check.Equals, true)
+	c.Assert(isDanglingImage(docker.APIImages{RepoTags: []string{"tsuru/bs:latest"}}), check.Equals, false)
+}
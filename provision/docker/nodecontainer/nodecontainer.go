@@ -20,6 +20,7 @@ import (
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/net"
 	"github.com/tsuru/tsuru/provision/docker/fix"
+	"github.com/tsuru/tsuru/provision/dockercommon"
 	"github.com/tsuru/tsuru/provision/nodecontainer"
 )
 
@@ -114,7 +115,11 @@ func ensureContainersStarted(p DockerProvisioner, w io.Writer, relaunch bool, na
 
 func pullImage(c *nodecontainer.NodeContainerConfig, client *docker.Client, p DockerProvisioner, pool string) (string, error) {
 	image := c.Image()
-	output, err := pullWithRetry(client, p, image, 3)
+	registryAuth, err := dockercommon.RegistryAuthForImage(image, "", pool, p.RegistryAuthConfig())
+	if err != nil {
+		return "", err
+	}
+	output, err := pullWithRetry(client, registryAuth, image, 3)
 	if err != nil {
 		return "", err
 	}
@@ -185,11 +190,10 @@ func tryRemovingOld(client *docker.Client, id string) error {
 	return err
 }
 
-func pullWithRetry(client *docker.Client, p DockerProvisioner, image string, maxTries int) (string, error) {
+func pullWithRetry(client *docker.Client, registryAuth docker.AuthConfiguration, image string, maxTries int) (string, error) {
 	var buf bytes.Buffer
 	var err error
 	pullOpts := docker.PullImageOptions{Repository: image, OutputStream: &buf, InactivityTimeout: net.StreamInactivityTimeout}
-	registryAuth := p.RegistryAuthConfig()
 	for ; maxTries > 0; maxTries-- {
 		err = client.PullImage(pullOpts, registryAuth)
 		if err == nil {
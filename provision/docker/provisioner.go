@@ -171,6 +171,11 @@ func (p *dockerProvisioner) initDockerCluster() error {
 		shutdown.Register(autoScale)
 		go autoScale.run()
 	}
+	imageGC := p.initImageGC()
+	if imageGC.Enabled {
+		shutdown.Register(imageGC)
+		go imageGC.run()
+	}
 	limitMode, _ := config.GetString("docker:limit:mode")
 	if limitMode == "global" {
 		p.actionLimiter = &provision.MongodbLimiter{}
@@ -325,6 +330,48 @@ func (p *dockerProvisioner) Restart(a provision.App, process string, w io.Writer
 		w = ioutil.Discard
 	}
 	writer := io.MultiWriter(w, &app.LogWriter{App: a})
+	_, err = p.runReplaceUnitsPipeline(writer, a, restartToAdd(containers), containers, imageId)
+	return err
+}
+
+func (p *dockerProvisioner) RollingRestart(a provision.App, process string, opts provision.RestartOptions, w io.Writer) error {
+	if opts.BatchSize <= 0 {
+		return p.Restart(a, process, w)
+	}
+	containers, err := p.listContainersByProcess(a.GetName(), process)
+	if err != nil {
+		return err
+	}
+	imageId, err := image.AppCurrentImageName(a.GetName())
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	writer := io.MultiWriter(w, &app.LogWriter{App: a})
+	batches := (len(containers) + opts.BatchSize - 1) / opts.BatchSize
+	for i := 0; i < batches; i++ {
+		start := i * opts.BatchSize
+		end := start + opts.BatchSize
+		if end > len(containers) {
+			end = len(containers)
+		}
+		batch := containers[start:end]
+		if i > 0 && opts.Pause > 0 {
+			fmt.Fprintf(writer, "\n---- Waiting %s before restarting the next batch ----\n", opts.Pause)
+			time.Sleep(opts.Pause)
+		}
+		fmt.Fprintf(writer, "\n---- Restarting batch %d/%d (%d %s) ----\n", i+1, batches, len(batch), pluralize("unit", len(batch)))
+		_, err = p.runReplaceUnitsPipeline(writer, a, restartToAdd(batch), batch, imageId)
+		if err != nil {
+			return errors.Wrapf(err, "rolling restart aborted on batch %d/%d, %d units were not restarted", i+1, batches, len(containers)-start)
+		}
+	}
+	return nil
+}
+
+func restartToAdd(containers []container.Container) map[string]*containersToAdd {
 	toAdd := make(map[string]*containersToAdd, len(containers))
 	for _, c := range containers {
 		if _, ok := toAdd[c.ProcessName]; !ok {
@@ -333,8 +380,7 @@ func (p *dockerProvisioner) Restart(a provision.App, process string, w io.Writer
 		toAdd[c.ProcessName].Quantity++
 		toAdd[c.ProcessName].Status = provision.StatusStarted
 	}
-	_, err = p.runReplaceUnitsPipeline(writer, a, toAdd, containers, imageId)
-	return err
+	return toAdd
 }
 
 func (p *dockerProvisioner) Start(app provision.App, process string) error {
@@ -427,7 +473,11 @@ func (p *dockerProvisioner) ImageDeploy(app provision.App, imageId string, evt *
 	if err != nil {
 		return "", err
 	}
-	err = cluster.PullImage(pullOpts, docker.AuthConfiguration{}, node)
+	pullAuth, err := dockercommon.RegistryAuthForImage(imageId, app.GetTeamOwner(), app.GetPool(), docker.AuthConfiguration{})
+	if err != nil {
+		return "", err
+	}
+	err = cluster.PullImage(pullOpts, pullAuth, node)
 	if err != nil {
 		return "", err
 	}
@@ -609,24 +659,7 @@ func getContainersToAdd(data image.ImageMetadata, oldContainers []container.Cont
 }
 
 func (p *dockerProvisioner) Destroy(app provision.App) error {
-	containers, err := p.listContainersByApp(app.GetName())
-	if err != nil {
-		log.Errorf("Failed to list app containers: %s", err)
-		return err
-	}
-	args := changeUnitsPipelineArgs{
-		app:         app,
-		toRemove:    containers,
-		writer:      ioutil.Discard,
-		provisioner: p,
-		appDestroy:  true,
-	}
-	pipeline := action.NewPipeline(
-		&removeOldRoutes,
-		&provisionRemoveOldUnits,
-		&provisionUnbindOldUnits,
-	)
-	err = pipeline.Execute(args)
+	err := p.DestroyUnits(app)
 	if err != nil {
 		return err
 	}
@@ -652,6 +685,34 @@ func (p *dockerProvisioner) Destroy(app provision.App) error {
 	return nil
 }
 
+// DestroyUnits removes the containers and routes of the app, keeping its
+// images in the nodes and in the registry.
+func (p *dockerProvisioner) DestroyUnits(app provision.App) error {
+	containers, err := p.listContainersByApp(app.GetName())
+	if err != nil {
+		log.Errorf("Failed to list app containers: %s", err)
+		return err
+	}
+	args := changeUnitsPipelineArgs{
+		app:         app,
+		toRemove:    containers,
+		writer:      ioutil.Discard,
+		provisioner: p,
+		appDestroy:  true,
+	}
+	pipeline := action.NewPipeline(
+		&removeOldRoutes,
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	err = pipeline.Execute(args)
+	if err != nil {
+		return err
+	}
+	p.removeConfigFilesVolumes(app, false)
+	return nil
+}
+
 func (p *dockerProvisioner) runRestartAfterHooks(cont *container.Container, w io.Writer) error {
 	yamlData, err := image.GetImageTsuruYamlData(cont.Image)
 	if err != nil {
@@ -1387,6 +1448,47 @@ func (p *dockerProvisioner) RemoveNode(opts provision.RemoveNodeOptions) error {
 	return p.Cluster().Unregister(opts.Address)
 }
 
+func (p *dockerProvisioner) DrainNode(opts provision.DrainNodeOptions) error {
+	err := p.UpdateNode(provision.UpdateNodeOptions{Address: opts.Address, Disable: true})
+	if err != nil {
+		return err
+	}
+	writer := opts.Writer
+	if writer == nil {
+		writer = ioutil.Discard
+	}
+	host := net.URLToHost(opts.Address)
+	containers, err := p.listContainersByHost(host)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		fmt.Fprintf(writer, "No units to move in %s\n", host)
+		return nil
+	}
+	fmt.Fprintf(writer, "Draining %d units from %s...\n", len(containers), host)
+	locker := &appLocker{}
+	for i, c := range containers {
+		if checkCanceled(opts.Event) != nil {
+			return provision.ErrNodeDrainCanceled
+		}
+		fmt.Fprintf(writer, "[%d/%d] ", i+1, len(containers))
+		moveErrors := make(chan error, 1)
+		p.MoveOneContainer(c, "", moveErrors, nil, writer, locker)
+		close(moveErrors)
+		err = p.HandleMoveErrors(moveErrors, writer)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(writer, "Node %s drained\n", host)
+	return nil
+}
+
+func (p *dockerProvisioner) UncordonNode(address string) error {
+	return p.UpdateNode(provision.UpdateNodeOptions{Address: address, Enable: true})
+}
+
 func (p *dockerProvisioner) UpgradeNodeContainer(name string, pool string, writer io.Writer) error {
 	return internalNodeContainer.RecreateNamedContainers(p, writer, name, pool)
 }
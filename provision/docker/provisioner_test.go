@@ -110,6 +110,71 @@ func (s *S) TestProvisionerRestart(c *check.C) {
 	c.Assert(dbConts[0].HostPort, check.Equals, expectedPort)
 }
 
+func (s *S) TestProvisionerRollingRestart(c *check.C) {
+	app := provisiontest.NewFakeApp("almah", "static", 1)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python web.py",
+		},
+	}
+	oldIDs := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		cont, err := s.newContainer(&newContainerOpts{
+			AppName:         app.GetName(),
+			ProcessName:     "web",
+			ImageCustomData: customData,
+			Image:           "tsuru/app-" + app.GetName(),
+		}, nil)
+		c.Assert(err, check.IsNil)
+		defer s.removeTestContainer(cont)
+		oldIDs[cont.ID] = true
+	}
+	var buf bytes.Buffer
+	err := s.p.RollingRestart(app, "", provision.RestartOptions{BatchSize: 2, Pause: time.Millisecond}, &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*Restarting batch 1/2 \(2 units\).*Waiting 1ms before restarting the next batch.*Restarting batch 2/2 \(1 unit\).*`)
+	dbConts, err := s.p.listAllContainers()
+	c.Assert(err, check.IsNil)
+	c.Assert(dbConts, check.HasLen, 3)
+	for _, cont := range dbConts {
+		c.Assert(oldIDs[cont.ID], check.Equals, false)
+		c.Assert(cont.Status, check.Equals, provision.StatusStarting.String())
+	}
+}
+
+func (s *S) TestProvisionerRollingRestartAbortsOnFailure(c *check.C) {
+	app := provisiontest.NewFakeApp("almah", "static", 1)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python web.py",
+		},
+	}
+	oldIDs := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		cont, err := s.newContainer(&newContainerOpts{
+			AppName:         app.GetName(),
+			ProcessName:     "web",
+			ImageCustomData: customData,
+			Image:           "tsuru/app-" + app.GetName(),
+		}, nil)
+		c.Assert(err, check.IsNil)
+		defer s.removeTestContainer(cont)
+		oldIDs[cont.ID] = true
+	}
+	s.server.CustomHandler("/containers/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer s.server.CustomHandler("/containers/create", s.server.DefaultHandler())
+	err := s.p.RollingRestart(app, "", provision.RestartOptions{BatchSize: 1}, nil)
+	c.Assert(err, check.ErrorMatches, `(?s)rolling restart aborted on batch 1/2, 2 units were not restarted.*`)
+	dbConts, err := s.p.listAllContainers()
+	c.Assert(err, check.IsNil)
+	c.Assert(dbConts, check.HasLen, 2)
+	for _, cont := range dbConts {
+		c.Assert(oldIDs[cont.ID], check.Equals, true)
+	}
+}
+
 func (s *S) TestProvisionerRestartStoppedContainer(c *check.C) {
 	app := provisiontest.NewFakeApp("almah", "static", 1)
 	customData := map[string]interface{}{
@@ -300,6 +365,49 @@ func (s *S) TestDeploy(c *check.C) {
 	c.Assert(app.Quota, check.DeepEquals, quota.Quota{Limit: -1, InUse: 1})
 }
 
+func (s *S) TestArchiveDeployAppInMaintenance(c *check.C) {
+	config.Set("maintenance:url", "http://maintenance.tsuru.io")
+	defer config.Unset("maintenance:url")
+	stopCh := s.stopContainers(s.server.URL(), 1)
+	defer func() { <-stopCh }()
+	err := s.newFakeImage(s.p, "tsuru/python:latest", nil)
+	c.Assert(err, check.IsNil)
+	a := app.App{
+		Name:      "otherapp",
+		Platform:  "python",
+		TeamOwner: s.team.Name,
+	}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	}
+	err = image.SaveImageCustomData("tsuru/app-"+a.Name+":v1", customData)
+	c.Assert(err, check.IsNil)
+	err = a.EnableMaintenance(s.user.Email, nil)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: "app", Value: a.Name},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = s.p.ArchiveDeploy(&a, "https://mystorage.com/archive.tar.gz", evt)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(&a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	routes, err := routertest.FakeRouter.Routes(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 1)
+	c.Assert(routes[0].String(), check.Equals, "http://maintenance.tsuru.io")
+}
+
 func (s *S) TestDeployWithLimiterActive(c *check.C) {
 	config.Set("docker:limit:actions-per-host", 1)
 	defer config.Unset("docker:limit:actions-per-host")
@@ -1077,6 +1185,77 @@ func (s *S) TestImageDeploy(c *check.C) {
 	c.Assert(dockerContainer.HostConfig.PortBindings, check.DeepEquals, expectedPortBindings)
 }
 
+func (s *S) TestImageDeployMigratePool(c *check.C) {
+	p, err := s.startMultipleServersClusterSeggregated()
+	c.Assert(err, check.IsNil)
+	mainDockerProvisioner = p
+	u, _ := url.Parse(s.server.URL())
+	imageName := fmt.Sprintf("%s/%s", u.Host, "customimage")
+	config.Set("docker:registry", u.Host)
+	defer config.Unset("docker:registry")
+	attachHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "cannot hijack connection", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		w.WriteHeader(http.StatusOK)
+		conn, _, cErr := hijacker.Hijack()
+		if cErr != nil {
+			http.Error(w, cErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		outStream := stdcopy.NewStdWriter(conn, stdcopy.Stdout)
+		fmt.Fprintf(outStream, "web: test.sh\n")
+		conn.Close()
+	})
+	s.server.CustomHandler("/containers/.*/attach", attachHandler)
+	s.extraServer.CustomHandler("/containers/.*/attach", attachHandler)
+	err = s.newFakeImage(p, imageName, nil)
+	c.Assert(err, check.IsNil)
+	a := app.App{
+		Name:      "otherapp",
+		Platform:  "python",
+		Quota:     quota.Unlimited,
+		Pool:      "pool1",
+		TeamOwner: s.team.Name,
+	}
+	err = app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	image.PullAppImageNames(a.Name, []string{imageName})
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: "app", Value: a.Name},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		OutputStream: ioutil.Discard,
+		Image:        imageName,
+		Event:        evt,
+	})
+	c.Assert(err, check.IsNil)
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(units[0].Address.Hostname(), check.Equals, "127.0.0.1")
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	err = dbApp.MigratePool(app.MigratePoolOptions{Pool: "pool2", Event: evt})
+	c.Assert(err, check.IsNil)
+	units, err = dbApp.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(units[0].Address.Hostname(), check.Equals, "localhost")
+	containers, err := p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers[0].HostAddr, check.Equals, "localhost")
+}
+
 func (s *S) TestImageDeployWithProcfile(c *check.C) {
 	p, err := s.startMultipleServersClusterSeggregated()
 	c.Assert(err, check.IsNil)
@@ -1296,6 +1475,73 @@ func (s *S) TestProvisionerDestroyRemovesImage(c *check.C) {
 	c.Assert(imgs[0].RepoTags[0], check.Equals, registryURL+"/tsuru/python:latest")
 }
 
+func (s *S) TestProvisionerDestroyUnitsKeepsImages(c *check.C) {
+	var registryRequests []*http.Request
+	registryServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryRequests = append(registryRequests, r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer registryServer.Close()
+	registryURL := strings.Replace(registryServer.URL, "http://", "", 1)
+	config.Set("docker:registry", registryURL)
+	defer config.Unset("docker:registry")
+	stopCh := s.stopContainers(s.server.URL(), 1)
+	defer func() { <-stopCh }()
+	a := app.App{
+		Name:      "mymovedapp",
+		Platform:  "python",
+		Quota:     quota.Unlimited,
+		TeamOwner: s.team.Name,
+	}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	w := safe.NewBuffer(make([]byte, 2048))
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	}
+	err = image.SaveImageCustomData(fmt.Sprintf("%s/tsuru/app-%s:v1", registryURL, a.Name), customData)
+	c.Assert(err, check.IsNil)
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: "app", Value: a.Name},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermApp),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		ArchiveURL:   "https://mystorage.com/archive.tar.gz",
+		Commit:       "123",
+		OutputStream: w,
+		Event:        evt,
+	})
+	c.Assert(err, check.IsNil)
+	err = s.p.DestroyUnits(&a)
+	c.Assert(err, check.IsNil)
+	coll := s.p.Collection()
+	defer coll.Close()
+	count, err := coll.Find(bson.M{"appname": a.Name}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+	c.Assert(registryRequests, check.HasLen, 0)
+	imgs, err := s.p.Cluster().ListImages(docker.ListImagesOptions{All: true})
+	c.Assert(err, check.IsNil)
+	var tags []string
+	for _, img := range imgs {
+		tags = append(tags, img.RepoTags...)
+	}
+	sort.Strings(tags)
+	c.Assert(tags, check.DeepEquals, []string{
+		registryURL + "/tsuru/app-mymovedapp:v1",
+		registryURL + "/tsuru/python:latest",
+	})
+	names, err := image.ListAppImages(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{registryURL + "/tsuru/app-mymovedapp:v1"})
+}
+
 func (s *S) TestProvisionerDestroyEmptyUnit(c *check.C) {
 	a := provisiontest.NewFakeApp("myapp", "python", 0)
 	s.p.Provision(a)
@@ -3376,6 +3622,85 @@ func (s *S) TestRemoveNodeNoAddress(c *check.C) {
 	c.Assert(nodes, check.HasLen, 1)
 }
 
+func (s *S) TestDrainNodeNoUnits(c *check.C) {
+	var buf bytes.Buffer
+	nodes, err := s.p.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	err = s.p.DrainNode(provision.DrainNodeOptions{Address: nodes[0].Address, Writer: &buf})
+	c.Assert(err, check.IsNil)
+	nodes, err = s.p.Cluster().UnfilteredNodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(nodes[0].CreationStatus, check.Equals, cluster.NodeCreationStatusDisabled)
+	c.Assert(buf.String(), check.Equals, "No units to move in 127.0.0.1\n")
+}
+
+func (s *S) TestDrainNodeWithUnits(c *check.C) {
+	p, err := s.startMultipleServersCluster()
+	c.Assert(err, check.IsNil)
+	mainDockerProvisioner = p
+	err = s.newFakeImage(p, "tsuru/app-myapp", nil)
+	c.Assert(err, check.IsNil)
+	appInstance := provisiontest.NewFakeApp("myapp", "python", 0)
+	p.Provision(appInstance)
+	imageId, err := image.AppCurrentImageName(appInstance.GetName())
+	c.Assert(err, check.IsNil)
+	_, err = addContainersWithHost(&changeUnitsPipelineArgs{
+		toHost:      "127.0.0.1",
+		toAdd:       map[string]*containersToAdd{"web": {Quantity: 3}},
+		app:         appInstance,
+		imageId:     imageId,
+		provisioner: p,
+	})
+	c.Assert(err, check.IsNil)
+	appStruct := &app.App{
+		Name:     appInstance.GetName(),
+		Platform: appInstance.GetPlatform(),
+	}
+	err = s.storage.Apps().Insert(appStruct)
+	c.Assert(err, check.IsNil)
+	buf := safe.NewBuffer(nil)
+	nodes, err := p.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2)
+	c.Assert(net.URLToHost(nodes[0].Address), check.Equals, "127.0.0.1")
+	err = p.DrainNode(provision.DrainNodeOptions{Address: nodes[0].Address, Writer: buf})
+	c.Assert(err, check.IsNil)
+	nodes, err = p.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(net.URLToHost(nodes[0].Address), check.Equals, "localhost")
+	parts := strings.Split(buf.String(), "\n")
+	c.Assert(parts, check.HasLen, 9)
+	c.Assert(parts[0], check.Equals, "Draining 3 units from 127.0.0.1...")
+	c.Assert(parts[1], check.Matches, `\[1/3\] Moving unit .+? for "myapp" from 127\.0\.0\.1\.\.\.`)
+	c.Assert(parts[7], check.Equals, "Node 127.0.0.1 drained")
+	containerList, err := p.listContainersByHost("127.0.0.1")
+	c.Assert(err, check.IsNil)
+	c.Assert(containerList, check.HasLen, 0)
+	containerList, err = p.listContainersByHost("localhost")
+	c.Assert(err, check.IsNil)
+	c.Assert(containerList, check.HasLen, 3)
+}
+
+func (s *S) TestDrainNodeNotFound(c *check.C) {
+	err := s.p.DrainNode(provision.DrainNodeOptions{Address: "http://notfound:2375"})
+	c.Assert(err, check.Equals, provision.ErrNodeNotFound)
+}
+
+func (s *S) TestUncordonNode(c *check.C) {
+	nodes, err := s.p.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	err = s.p.DrainNode(provision.DrainNodeOptions{Address: nodes[0].Address})
+	c.Assert(err, check.IsNil)
+	err = s.p.UncordonNode(nodes[0].Address)
+	c.Assert(err, check.IsNil)
+	nodes, err = s.p.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(nodes[0].CreationStatus, check.Equals, cluster.NodeCreationStatusCreated)
+}
+
 func (s *S) TestNodeUnits(c *check.C) {
 	nodes, err := s.p.ListNodes(nil)
 	c.Assert(err, check.IsNil)
@@ -45,11 +45,24 @@ func (s *segregatedScheduler) Schedule(c *cluster.Cluster, opts docker.CreateCon
 	if err != nil {
 		return cluster.Node{}, &container.SchedulerError{Base: err}
 	}
+	schedConf, err := loadSchedulerConfig(a.Pool)
+	if err != nil {
+		return cluster.Node{}, &container.SchedulerError{Base: err}
+	}
+	nodes, err = s.filterByConstraints(a, schedConf, nodes)
+	if err != nil {
+		return cluster.Node{}, &container.SchedulerError{Base: err}
+	}
 	nodes, err = s.filterByMemoryUsage(a, nodes, s.maxMemoryRatio, s.TotalMemoryMetadata)
 	if err != nil {
 		return cluster.Node{}, &container.SchedulerError{Base: err}
 	}
-	node, err := s.chooseNodeToAdd(nodes, opts.Name, schedOpts.AppName, schedOpts.ProcessName)
+	var node string
+	if schedConf.usesResources() {
+		node, err = s.chooseNodeByResources(a, schedConf, nodes, opts.Name, schedOpts.ProcessName)
+	} else {
+		node, err = s.chooseNodeToAdd(nodes, opts.Name, schedOpts.AppName, schedOpts.ProcessName)
+	}
 	if err != nil {
 		return cluster.Node{}, &container.SchedulerError{Base: err}
 	}
@@ -225,12 +238,18 @@ func (s *segregatedScheduler) chooseNodeToAdd(nodes []cluster.Node, contName str
 		return "", err
 	}
 	log.Debugf("[scheduler] Chosen node for container %s: %#v", contName, chosenNode)
-	if contName != "" {
-		coll := s.provisioner.Collection()
-		defer coll.Close()
-		err = coll.Update(bson.M{"name": contName}, bson.M{"$set": bson.M{"hostaddr": net.URLToHost(chosenNode)}})
+	return chosenNode, s.setContainerHost(contName, chosenNode)
+}
+
+// setContainerHost records the chosen node in the container document, so that
+// concurrent scheduling calls take it into account.
+func (s *segregatedScheduler) setContainerHost(contName, node string) error {
+	if contName == "" {
+		return nil
 	}
-	return chosenNode, err
+	coll := s.provisioner.Collection()
+	defer coll.Close()
+	return coll.Update(bson.M{"name": contName}, bson.M{"$set": bson.M{"hostaddr": net.URLToHost(node)}})
 }
 
 // chooseContainerToRemove finds a container from the the node with maximum
@@ -0,0 +1,183 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/scopedconfig"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	schedulerConfigCollection = "docker-scheduler"
+
+	SchedulerStrategySegregated = "segregated"
+	SchedulerStrategyBinpack    = "binpack"
+	SchedulerStrategySpread     = "spread"
+)
+
+var ErrInvalidSchedulerStrategy = errors.Errorf("invalid scheduler strategy, must be one of: %s, %s, %s",
+	SchedulerStrategySegregated, SchedulerStrategyBinpack, SchedulerStrategySpread)
+
+// SchedulerConfig holds the per pool settings used by the scheduler when
+// choosing a node for a new container.
+//
+// Taints are node metadata entries in the form key=value, nodes matching any
+// taint are excluded unless the app being scheduled tolerates it, tolerations
+// are set per app name. Affinity and AntiAffinity are also keyed by app name,
+// listing apps that should (or must not) share nodes with the key app.
+type SchedulerConfig struct {
+	Strategy       string
+	MaxMemoryRatio float64
+	MaxDiskRatio   float64
+	ZoneMetadata   string
+	Taints         []string            `bson:",omitempty"`
+	Tolerations    map[string][]string `bson:",omitempty"`
+	Affinity       map[string][]string `bson:",omitempty"`
+	AntiAffinity   map[string][]string `bson:",omitempty"`
+}
+
+func schedulerConfig() *scopedconfig.ScopedConfig {
+	conf := scopedconfig.FindScopedConfig(schedulerConfigCollection)
+	conf.ShallowMerge = true
+	return conf
+}
+
+func loadSchedulerConfig(pool string) (*SchedulerConfig, error) {
+	var conf SchedulerConfig
+	err := schedulerConfig().Load(pool, &conf)
+	if err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func SchedulerConfigLoadAll() (map[string]SchedulerConfig, error) {
+	var all map[string]SchedulerConfig
+	err := schedulerConfig().LoadAll(&all)
+	return all, err
+}
+
+func SchedulerConfigRemove(pool string) error {
+	return schedulerConfig().Remove(pool)
+}
+
+func (c *SchedulerConfig) validate() error {
+	switch c.Strategy {
+	case "", SchedulerStrategySegregated, SchedulerStrategyBinpack, SchedulerStrategySpread:
+	default:
+		return ErrInvalidSchedulerStrategy
+	}
+	if c.MaxMemoryRatio < 0 || c.MaxMemoryRatio > 1 {
+		return errors.Errorf("invalid max memory ratio %v, must be between 0 and 1", c.MaxMemoryRatio)
+	}
+	if c.MaxDiskRatio < 0 || c.MaxDiskRatio > 1 {
+		return errors.Errorf("invalid max disk ratio %v, must be between 0 and 1", c.MaxDiskRatio)
+	}
+	taints := append([]string{}, c.Taints...)
+	for _, tolerations := range c.Tolerations {
+		taints = append(taints, tolerations...)
+	}
+	for _, t := range taints {
+		if _, _, err := parseTaint(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *SchedulerConfig) Save(pool string) error {
+	err := c.validate()
+	if err != nil {
+		return err
+	}
+	return schedulerConfig().Save(pool, *c)
+}
+
+// usesResources returns whether the configured strategy takes node resources
+// into account.
+func (c *SchedulerConfig) usesResources() bool {
+	return c.Strategy == SchedulerStrategyBinpack || c.Strategy == SchedulerStrategySpread
+}
+
+// tolerates returns whether appName can run on a node with the given metadata.
+func (c *SchedulerConfig) tolerates(appName string, metadata map[string]string) bool {
+	tolerated := map[string]struct{}{}
+	for _, t := range c.Tolerations[appName] {
+		tolerated[t] = struct{}{}
+	}
+	for _, t := range c.Taints {
+		key, value, _ := parseTaint(t)
+		if metadata[key] != value {
+			continue
+		}
+		if _, ok := tolerated[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func parseTaint(taint string) (string, string, error) {
+	parts := strings.SplitN(taint, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", errors.Errorf("invalid taint %q, must be in the form key=value", taint)
+	}
+	return parts[0], parts[1], nil
+}
+
+type nodeResourcesEntry struct {
+	HostAddr  string `bson:"_id"`
+	Resources provision.NodeResources
+}
+
+func nodeResourcesCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_node_resources", name)), nil
+}
+
+func (p *dockerProvisioner) UpdateNodeResources(node provision.Node, resources provision.NodeResources) error {
+	coll, err := nodeResourcesCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	host := net.URLToHost(node.Address())
+	_, err = coll.UpsertId(host, nodeResourcesEntry{HostAddr: host, Resources: resources})
+	return err
+}
+
+func nodeResourcesByHost(hosts []string) (map[string]provision.NodeResources, error) {
+	coll, err := nodeResourcesCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var entries []nodeResourcesEntry
+	err = coll.Find(bson.M{"_id": bson.M{"$in": hosts}}).All(&entries)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]provision.NodeResources, len(entries))
+	for _, e := range entries {
+		result[e.HostAddr] = e.Resources
+	}
+	return result, nil
+}
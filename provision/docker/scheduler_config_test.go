@@ -0,0 +1,76 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSchedulerConfigSaveLoad(c *check.C) {
+	base := SchedulerConfig{Strategy: SchedulerStrategySpread, ZoneMetadata: "zone"}
+	err := base.Save("")
+	c.Assert(err, check.IsNil)
+	poolConf := SchedulerConfig{Strategy: SchedulerStrategyBinpack, MaxMemoryRatio: 0.9}
+	err = poolConf.Save("p1")
+	c.Assert(err, check.IsNil)
+	conf, err := loadSchedulerConfig("p1")
+	c.Assert(err, check.IsNil)
+	c.Assert(conf, check.DeepEquals, &SchedulerConfig{
+		Strategy:       SchedulerStrategyBinpack,
+		MaxMemoryRatio: 0.9,
+		ZoneMetadata:   "zone",
+	})
+	conf, err = loadSchedulerConfig("p2")
+	c.Assert(err, check.IsNil)
+	c.Assert(conf, check.DeepEquals, &base)
+	err = SchedulerConfigRemove("p1")
+	c.Assert(err, check.IsNil)
+	all, err := SchedulerConfigLoadAll()
+	c.Assert(err, check.IsNil)
+	c.Assert(all, check.DeepEquals, map[string]SchedulerConfig{"": base})
+}
+
+func (s *S) TestSchedulerConfigSaveInvalid(c *check.C) {
+	tests := []struct {
+		conf SchedulerConfig
+		err  string
+	}{
+		{SchedulerConfig{Strategy: "random"}, ErrInvalidSchedulerStrategy.Error()},
+		{SchedulerConfig{MaxMemoryRatio: 1.5}, "invalid max memory ratio 1.5, must be between 0 and 1"},
+		{SchedulerConfig{MaxDiskRatio: -1}, "invalid max disk ratio -1, must be between 0 and 1"},
+		{SchedulerConfig{Taints: []string{"gpu"}}, `invalid taint "gpu", must be in the form key=value`},
+		{SchedulerConfig{Tolerations: map[string][]string{"myapp": {"=x"}}}, `invalid taint "=x", must be in the form key=value`},
+	}
+	for _, tt := range tests {
+		err := tt.conf.Save("p1")
+		c.Check(err, check.ErrorMatches, tt.err)
+	}
+}
+
+func (s *S) TestSchedulerConfigTolerates(c *check.C) {
+	conf := SchedulerConfig{
+		Taints:      []string{"dedicated=gpu", "maintenance=true"},
+		Tolerations: map[string][]string{"ml": {"dedicated=gpu"}},
+	}
+	c.Assert(conf.tolerates("web", map[string]string{"pool": "p1"}), check.Equals, true)
+	c.Assert(conf.tolerates("web", map[string]string{"dedicated": "gpu"}), check.Equals, false)
+	c.Assert(conf.tolerates("ml", map[string]string{"dedicated": "gpu"}), check.Equals, true)
+	c.Assert(conf.tolerates("ml", map[string]string{"dedicated": "gpu", "maintenance": "true"}), check.Equals, false)
+}
+
+func (s *S) TestUpdateNodeResources(c *check.C) {
+	node := &clusterNodeWrapper{Node: &cluster.Node{Address: "http://server1:2375"}, prov: s.p}
+	res := provision.NodeResources{CPUs: 2, Memory: 1024, Disk: 2048, DiskAvailable: 1024}
+	err := s.p.UpdateNodeResources(node, res)
+	c.Assert(err, check.IsNil)
+	res.CPUs = 4
+	err = s.p.UpdateNodeResources(node, res)
+	c.Assert(err, check.IsNil)
+	result, err := nodeResourcesByHost([]string{"server1", "server2"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, map[string]provision.NodeResources{"server1": res})
+}
@@ -0,0 +1,247 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/net"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// dockerCPUShares is the amount of cpu shares docker assigns to a full CPU.
+const dockerCPUShares = 1024
+
+type nodeUsage struct {
+	containers int
+	memory     int64
+	cpuShares  int64
+}
+
+type nodeScore struct {
+	host        string
+	affinity    int
+	zoneCount   int
+	appCount    int
+	utilization float64
+}
+
+type nodeScoreList struct {
+	scores  []nodeScore
+	binpack bool
+}
+
+func (l nodeScoreList) Len() int      { return len(l.scores) }
+func (l nodeScoreList) Swap(i, j int) { l.scores[i], l.scores[j] = l.scores[j], l.scores[i] }
+func (l nodeScoreList) Less(i, j int) bool {
+	a, b := l.scores[i], l.scores[j]
+	if a.affinity != b.affinity {
+		return a.affinity > b.affinity
+	}
+	if a.zoneCount != b.zoneCount {
+		return a.zoneCount < b.zoneCount
+	}
+	if a.utilization != b.utilization {
+		if l.binpack {
+			return a.utilization > b.utilization
+		}
+		return a.utilization < b.utilization
+	}
+	if a.appCount != b.appCount {
+		return a.appCount < b.appCount
+	}
+	return a.host < b.host
+}
+
+// relatedApps returns the apps associated with appName in the rules map,
+// considering rules declared in both directions.
+func relatedApps(rules map[string][]string, appName string) []string {
+	set := map[string]struct{}{}
+	for _, name := range rules[appName] {
+		set[name] = struct{}{}
+	}
+	for name, related := range rules {
+		for _, r := range related {
+			if r == appName {
+				set[name] = struct{}{}
+			}
+		}
+	}
+	delete(set, appName)
+	result := make([]string, 0, len(set))
+	for name := range set {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// filterByConstraints removes nodes that are tainted for the app or which
+// already run apps that must not share nodes with it.
+func (s *segregatedScheduler) filterByConstraints(a *app.App, conf *SchedulerConfig, nodes []cluster.Node) ([]cluster.Node, error) {
+	antiApps := relatedApps(conf.AntiAffinity, a.Name)
+	if len(conf.Taints) == 0 && len(antiApps) == 0 {
+		return nodes, nil
+	}
+	var antiCount map[string]int
+	if len(antiApps) > 0 {
+		hosts, _ := s.nodesToHosts(nodes)
+		var err error
+		antiCount, err = s.aggregateContainersBy(bson.M{"$match": bson.M{
+			"appname":  bson.M{"$in": antiApps},
+			"hostaddr": bson.M{"$in": hosts},
+			"id":       bson.M{"$nin": s.ignoredContainers},
+		}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	nodeList := make([]cluster.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !conf.tolerates(a.Name, node.Metadata) {
+			log.Debugf("[scheduler] node %q is tainted for app %q", node.Address, a.Name)
+			continue
+		}
+		if antiCount[net.URLToHost(node.Address)] > 0 {
+			log.Debugf("[scheduler] node %q runs apps with anti-affinity to %q", node.Address, a.Name)
+			continue
+		}
+		nodeList = append(nodeList, node)
+	}
+	if len(nodeList) == 0 {
+		return nil, errors.Errorf("no nodes available for app %q after applying scheduler constraints", a.Name)
+	}
+	return nodeList, nil
+}
+
+func (s *segregatedScheduler) usageByHost(hosts []string) (map[string]nodeUsage, error) {
+	containers, err := s.provisioner.ListContainers(bson.M{"hostaddr": bson.M{"$in": hosts}, "id": bson.M{"$nin": s.ignoredContainers}})
+	if err != nil {
+		return nil, err
+	}
+	plans := map[string]app.Plan{}
+	usage := map[string]nodeUsage{}
+	for _, cont := range containers {
+		plan, ok := plans[cont.AppName]
+		if !ok {
+			contApp, err := app.GetByName(cont.AppName)
+			if err != nil {
+				return nil, err
+			}
+			plan = contApp.Plan
+			plans[cont.AppName] = plan
+		}
+		u := usage[cont.HostAddr]
+		u.containers++
+		u.memory += plan.Memory
+		u.cpuShares += int64(plan.CpuShare)
+		usage[cont.HostAddr] = u
+	}
+	return usage, nil
+}
+
+// resourceRatios returns the memory, cpu and disk usage ratios of a node
+// after adding a container with the given plan. Ratios for resources not
+// reported by the node are negative.
+func resourceRatios(res provision.NodeResources, usage nodeUsage, plan app.Plan) (float64, float64, float64) {
+	memRatio, cpuRatio, diskRatio := -1.0, -1.0, -1.0
+	if res.Memory > 0 {
+		memRatio = float64(usage.memory+plan.Memory) / float64(res.Memory)
+	}
+	if res.CPUs > 0 {
+		cpuRatio = float64(usage.cpuShares+int64(plan.CpuShare)) / float64(res.CPUs*dockerCPUShares)
+	}
+	if res.Disk > 0 {
+		diskRatio = float64(res.Disk-res.DiskAvailable) / float64(res.Disk)
+	}
+	return memRatio, cpuRatio, diskRatio
+}
+
+// chooseNodeByResources finds the best node for a new container of the app
+// considering the resources reported by each node. Nodes hosting apps with
+// affinity come first, followed by nodes in zones with less containers of the
+// app process, the node utilization is then used to either pack containers in
+// the fullest node or spread them in the emptiest one.
+func (s *segregatedScheduler) chooseNodeByResources(a *app.App, conf *SchedulerConfig, nodes []cluster.Node, contName, process string) (string, error) {
+	log.Debugf("[scheduler] Possible nodes for container %s: %#v", contName, nodes)
+	s.hostMutex.Lock()
+	defer s.hostMutex.Unlock()
+	hosts, hostsMap := s.nodesToHosts(nodes)
+	resources, err := nodeResourcesByHost(hosts)
+	if err != nil {
+		return "", err
+	}
+	usage, err := s.usageByHost(hosts)
+	if err != nil {
+		return "", err
+	}
+	appCount, err := s.aggregateContainersByHostAppProcess(hosts, a.Name, process)
+	if err != nil {
+		return "", err
+	}
+	var affinityCount map[string]int
+	if affinityApps := relatedApps(conf.Affinity, a.Name); len(affinityApps) > 0 {
+		affinityCount, err = s.aggregateContainersBy(bson.M{"$match": bson.M{
+			"appname":  bson.M{"$in": affinityApps},
+			"hostaddr": bson.M{"$in": hosts},
+			"id":       bson.M{"$nin": s.ignoredContainers},
+		}})
+		if err != nil {
+			return "", err
+		}
+	}
+	hostZone := map[string]string{}
+	zoneCount := map[string]int{}
+	if conf.ZoneMetadata != "" {
+		for _, node := range nodes {
+			host := net.URLToHost(node.Address)
+			zone := node.Metadata[conf.ZoneMetadata]
+			hostZone[host] = zone
+			zoneCount[zone] += appCount[host]
+		}
+	}
+	scores := make([]nodeScore, 0, len(hosts))
+	for _, host := range hosts {
+		memRatio, cpuRatio, diskRatio := resourceRatios(resources[host], usage[host], a.Plan)
+		if conf.MaxMemoryRatio > 0 && memRatio > conf.MaxMemoryRatio {
+			log.Errorf("[scheduler] Node %q has reached its memory limit: %0.2f of %0.2f", host, memRatio, conf.MaxMemoryRatio)
+			continue
+		}
+		if conf.MaxDiskRatio > 0 && diskRatio > conf.MaxDiskRatio {
+			log.Errorf("[scheduler] Node %q has reached its disk limit: %0.2f of %0.2f", host, diskRatio, conf.MaxDiskRatio)
+			continue
+		}
+		var total float64
+		var count int
+		for _, ratio := range []float64{memRatio, cpuRatio, diskRatio} {
+			if ratio >= 0 {
+				total += ratio
+				count++
+			}
+		}
+		score := nodeScore{
+			host:      host,
+			affinity:  affinityCount[host],
+			zoneCount: zoneCount[hostZone[host]],
+			appCount:  appCount[host],
+		}
+		if count > 0 {
+			score.utilization = total / float64(count)
+		}
+		scores = append(scores, score)
+	}
+	if len(scores) == 0 {
+		return "", errors.Errorf("no nodes found with enough resources for container of %q", a.Name)
+	}
+	sort.Sort(nodeScoreList{scores: scores, binpack: conf.Strategy == SchedulerStrategyBinpack})
+	chosenNode := hostsMap[scores[0].host]
+	log.Debugf("[scheduler] Chosen node for container %s: %#v", contName, chosenNode)
+	return chosenNode, s.setContainerHost(contName, chosenNode)
+}
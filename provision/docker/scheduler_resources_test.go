@@ -0,0 +1,146 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestRelatedApps(c *check.C) {
+	rules := map[string][]string{
+		"a": {"b", "c"},
+		"d": {"a"},
+		"e": {"f"},
+	}
+	c.Assert(relatedApps(rules, "a"), check.DeepEquals, []string{"b", "c", "d"})
+	c.Assert(relatedApps(rules, "b"), check.DeepEquals, []string{"a"})
+	c.Assert(relatedApps(rules, "x"), check.DeepEquals, []string{})
+}
+
+func (s *S) TestFilterByConstraints(c *check.C) {
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234", Metadata: map[string]string{"dedicated": "gpu"}},
+		{Address: "http://server2:1234"},
+		{Address: "http://server3:1234"},
+	}
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	err := contColl.Insert(container.Container{ID: "c1", Name: "db1", AppName: "db", HostAddr: "server2"})
+	c.Assert(err, check.IsNil)
+	conf := &SchedulerConfig{
+		Taints:       []string{"dedicated=gpu"},
+		AntiAffinity: map[string][]string{"db": {"web"}},
+	}
+	sched := segregatedScheduler{provisioner: s.p}
+	result, err := sched.filterByConstraints(&app.App{Name: "web"}, conf, nodes)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []cluster.Node{nodes[2]})
+	conf.Tolerations = map[string][]string{"web": {"dedicated=gpu"}}
+	result, err = sched.filterByConstraints(&app.App{Name: "web"}, conf, nodes)
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []cluster.Node{nodes[0], nodes[2]})
+	_, err = sched.filterByConstraints(&app.App{Name: "web"}, conf, nodes[1:2])
+	c.Assert(err, check.ErrorMatches, `no nodes available for app "web" after applying scheduler constraints`)
+}
+
+func (s *S) insertNodeResources(c *check.C, resources map[string]provision.NodeResources) {
+	for addr, res := range resources {
+		node := &clusterNodeWrapper{Node: &cluster.Node{Address: addr}, prov: s.p}
+		err := s.p.UpdateNodeResources(node, res)
+		c.Assert(err, check.IsNil)
+	}
+}
+
+func (s *S) TestChooseNodeByResources(c *check.C) {
+	a1 := app.App{Name: "big", Plan: app.Plan{Memory: 4096, CpuShare: 1024}}
+	a2 := app.App{Name: "small", Plan: app.Plan{Memory: 1024, CpuShare: 256}}
+	err := s.storage.Apps().Insert(a1, a2)
+	c.Assert(err, check.IsNil)
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234"},
+		{Address: "http://server2:1234"},
+	}
+	s.insertNodeResources(c, map[string]provision.NodeResources{
+		"http://server1:1234": {CPUs: 4, Memory: 16384},
+		"http://server2:1234": {CPUs: 4, Memory: 16384},
+	})
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	err = contColl.Insert(
+		container.Container{ID: "c1", Name: "big1", AppName: "big", HostAddr: "server1"},
+		container.Container{ID: "c2", Name: "small1", AppName: "small", HostAddr: "server2"},
+		container.Container{ID: "c3", Name: "small2", AppName: "small"},
+		container.Container{ID: "c4", Name: "small3", AppName: "small"},
+	)
+	c.Assert(err, check.IsNil)
+	sched := segregatedScheduler{provisioner: s.p}
+	node, err := sched.chooseNodeByResources(&a2, &SchedulerConfig{Strategy: SchedulerStrategySpread}, nodes, "small2", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server2:1234")
+	node, err = sched.chooseNodeByResources(&a2, &SchedulerConfig{Strategy: SchedulerStrategyBinpack}, nodes, "small3", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server1:1234")
+	n, err := contColl.Find(bson.M{"hostaddr": "server1"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+}
+
+func (s *S) TestChooseNodeByResourcesMaxMemory(c *check.C) {
+	a1 := app.App{Name: "big", Plan: app.Plan{Memory: 4096}}
+	err := s.storage.Apps().Insert(a1)
+	c.Assert(err, check.IsNil)
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234"},
+		{Address: "http://server2:1234"},
+	}
+	s.insertNodeResources(c, map[string]provision.NodeResources{
+		"http://server1:1234": {Memory: 8192},
+		"http://server2:1234": {Memory: 8192},
+	})
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	err = contColl.Insert(container.Container{ID: "c1", Name: "big1", AppName: "big", HostAddr: "server1"})
+	c.Assert(err, check.IsNil)
+	sched := segregatedScheduler{provisioner: s.p}
+	conf := &SchedulerConfig{Strategy: SchedulerStrategyBinpack, MaxMemoryRatio: 0.75}
+	node, err := sched.chooseNodeByResources(&a1, conf, nodes, "", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server2:1234")
+	_, err = sched.chooseNodeByResources(&a1, conf, nodes[:1], "", "")
+	c.Assert(err, check.ErrorMatches, `no nodes found with enough resources for container of "big"`)
+}
+
+func (s *S) TestChooseNodeByResourcesZonesAndAffinity(c *check.C) {
+	a1 := app.App{Name: "web"}
+	a2 := app.App{Name: "cache"}
+	err := s.storage.Apps().Insert(a1, a2)
+	c.Assert(err, check.IsNil)
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234", Metadata: map[string]string{"zone": "a"}},
+		{Address: "http://server2:1234", Metadata: map[string]string{"zone": "a"}},
+		{Address: "http://server3:1234", Metadata: map[string]string{"zone": "b"}},
+	}
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	err = contColl.Insert(
+		container.Container{ID: "c1", Name: "web1", AppName: "web", HostAddr: "server1"},
+		container.Container{ID: "c2", Name: "cache1", AppName: "cache", HostAddr: "server2"},
+	)
+	c.Assert(err, check.IsNil)
+	sched := segregatedScheduler{provisioner: s.p}
+	conf := &SchedulerConfig{Strategy: SchedulerStrategySpread, ZoneMetadata: "zone"}
+	node, err := sched.chooseNodeByResources(&a1, conf, nodes, "", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server3:1234")
+	conf.Affinity = map[string][]string{"web": {"cache"}}
+	node, err = sched.chooseNodeByResources(&a1, conf, nodes, "", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server2:1234")
+}
@@ -90,6 +90,13 @@ func LeanContainerCmdsWithExtra(processName, imageId string, app provision.App,
 	if err != nil {
 		return nil, "", err
 	}
+	files, err := AppConfigFiles(app)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(files) > 0 {
+		extraCmds = append([]string{ConfigFilesCheckCmd()}, extraCmds...)
+	}
 	extraCmds = append(extraCmds, yamlData.Hooks.Restart.Before...)
 	before := strings.Join(extraCmds, " && ")
 	if before != "" {
@@ -5,6 +5,7 @@
 package dockercommon
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
@@ -224,3 +225,23 @@ func (s *S) TestWebProcessDefaultPortWithConfig(c *check.C) {
 	port := WebProcessDefaultPort()
 	c.Assert(port, check.Equals, "9191")
 }
+
+func (s *S) TestRunLeanContainersCmdConfigFiles(c *check.C) {
+	imageId := "tsuru/app-sample"
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python web.py",
+		},
+	}
+	err := image.SaveImageCustomData(imageId, customData)
+	c.Assert(err, check.IsNil)
+	app := &configFilesApp{
+		FakeApp: provisiontest.NewFakeApp("app-name", "python", 1),
+		files:   []provision.ConfigFile{{Name: "a.conf", Content: bytes.Repeat([]byte("a"), 64*1024)}},
+	}
+	cmds, _, err := LeanContainerCmds("web", imageId, app)
+	c.Assert(err, check.IsNil)
+	expected := []string{"/bin/sh", "-lc", "[ -d /home/application/current ] && cd /home/application/current; " +
+		"{ [ -f '/home/application/config/.tsuru-config-files' ] || { echo 'config files not found in /home/application/config' >&2; exit 1; }; } && exec python web.py"}
+	c.Assert(cmds, check.DeepEquals, expected)
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dockercommon
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+)
+
+const (
+	// configFilesMarker is written to the config files volume after all
+	// files, units refuse to start when it's missing.
+	configFilesMarker = ".tsuru-config-files"
+
+	// configFilesStagePath is where the volume is mounted, read-write, in the
+	// helper container used to populate it.
+	configFilesStagePath = "/tsuru-config-files"
+
+	LabelConfigFilesApp = "tsuru.config-files.app"
+)
+
+// ConfigFilesClient is the set of docker operations used to populate the
+// config files volume in a docker node.
+type ConfigFilesClient interface {
+	CreateVolume(docker.CreateVolumeOptions) (*docker.Volume, error)
+	CreateContainer(docker.CreateContainerOptions) (*docker.Container, error)
+	DownloadFromContainer(string, docker.DownloadFromContainerOptions) error
+	UploadToContainer(string, docker.UploadToContainerOptions) error
+	RemoveContainer(docker.RemoveContainerOptions) error
+}
+
+// ConfigFilesVolumesClient is the set of docker operations used to remove
+// stale config files volumes from a docker node.
+type ConfigFilesVolumesClient interface {
+	ListVolumes(docker.ListVolumesOptions) ([]docker.Volume, error)
+	RemoveVolume(string) error
+}
+
+// ConfigFilesPath returns the directory where the config files of apps are
+// mounted in units.
+func ConfigFilesPath() string {
+	path, _ := config.GetString("docker:config-files:path")
+	if path == "" {
+		return "/home/application/config"
+	}
+	return strings.TrimSuffix(path, "/")
+}
+
+// AppConfigFiles returns the config files of the app, sorted by name, or nil
+// when the app doesn't support config files.
+func AppConfigFiles(app provision.App) ([]provision.ConfigFile, error) {
+	filesApp, ok := app.(provision.ConfigFilesApp)
+	if !ok {
+		return nil, nil
+	}
+	files, err := filesApp.ConfigFiles()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, nil
+}
+
+// ConfigFilesVolumeName returns the name of the volume holding the given
+// config files of the app. The name depends on the content of the files, so
+// a volume is never changed once populated and units of different versions of
+// the files may run side by side in a node.
+func ConfigFilesVolumeName(appName string, files []provision.ConfigFile) string {
+	h := sha256.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00%d\x00", f.Name, len(f.Content))
+		h.Write(f.Content)
+	}
+	return fmt.Sprintf("tsuru-config-%s-%x", appName, h.Sum(nil)[:8])
+}
+
+func isConfigFilesVolumeOf(appName, volume string) bool {
+	hash := strings.TrimPrefix(volume, fmt.Sprintf("tsuru-config-%s-", appName))
+	if hash == volume || len(hash) != 16 {
+		return false
+	}
+	return strings.Trim(hash, "0123456789abcdef") == ""
+}
+
+// ConfigFilesBind returns the bind that mounts the config files volume,
+// read-only, in ConfigFilesPath.
+func ConfigFilesBind(volume string) string {
+	return fmt.Sprintf("%s:%s:ro", volume, ConfigFilesPath())
+}
+
+// ConfigFilesCheckCmd returns the command that aborts the start of a unit
+// when the config files volume mounted in it wasn't populated, which happens
+// when a unit is started in a node added after the volume was created.
+func ConfigFilesCheckCmd() string {
+	dir := ConfigFilesPath()
+	return fmt.Sprintf("{ [ -f '%s/%s' ] || { echo 'config files not found in %s' >&2; exit 1; }; }", dir, configFilesMarker, dir)
+}
+
+// configFilesArchive returns a tar archive with the config files, owned by
+// root and read-only, followed by the marker file.
+func configFilesArchive(files []provision.ConfigFile) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := append(append([]provision.ConfigFile{}, files...), provision.ConfigFile{Name: configFilesMarker})
+	for _, f := range entries {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     f.Name,
+			Mode:     0444,
+			Size:     int64(len(f.Content)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = tw.Write(f.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// EnsureConfigFilesVolume creates the config files volume in the node of the
+// client and writes the files to it, unless a previous call already did it.
+// The files are written through a helper container created, and never
+// started, from the given image, which must be available in the node.
+func EnsureConfigFilesVolume(client ConfigFilesClient, appName, volume, image string, files []provision.ConfigFile) error {
+	labels := map[string]string{LabelConfigFilesApp: appName}
+	_, err := client.CreateVolume(docker.CreateVolumeOptions{Name: volume, Labels: labels})
+	if err != nil {
+		return errors.Wrapf(err, "unable to create config files volume %s", volume)
+	}
+	cont, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image:      image,
+			Entrypoint: []string{},
+			Cmd:        []string{"true"},
+			Labels:     labels,
+		},
+		HostConfig: &docker.HostConfig{
+			Binds: []string{fmt.Sprintf("%s:%s", volume, configFilesStagePath)},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to create container to populate config files volume %s", volume)
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID, Force: true})
+	err = client.DownloadFromContainer(cont.ID, docker.DownloadFromContainerOptions{
+		Path:         configFilesStagePath + "/" + configFilesMarker,
+		OutputStream: ioutil.Discard,
+	})
+	if err == nil {
+		return nil
+	}
+	archive, err := configFilesArchive(files)
+	if err != nil {
+		return err
+	}
+	err = client.UploadToContainer(cont.ID, docker.UploadToContainerOptions{
+		InputStream: archive,
+		Path:        configFilesStagePath,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to write config files to volume %s", volume)
+	}
+	return nil
+}
+
+// RemoveConfigFilesVolumes removes the config files volumes of the app from
+// the node of the client, except the one named keep. Volumes still mounted by
+// containers are left behind and their names are returned, so they can be
+// removed once they're no longer in use.
+func RemoveConfigFilesVolumes(client ConfigFilesVolumesClient, appName, keep string) ([]string, error) {
+	volumes, err := client.ListVolumes(docker.ListVolumesOptions{
+		Filters: map[string][]string{"label": {fmt.Sprintf("%s=%s", LabelConfigFilesApp, appName)}},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list config files volumes of app %s", appName)
+	}
+	var inUse []string
+	for _, v := range volumes {
+		if v.Name == keep || !isConfigFilesVolumeOf(appName, v.Name) {
+			continue
+		}
+		err = client.RemoveVolume(v.Name)
+		if err == docker.ErrVolumeInUse {
+			inUse = append(inUse, v.Name)
+			continue
+		}
+		if err != nil && err != docker.ErrNoSuchVolume {
+			log.Errorf("unable to remove config files volume %s: %s", v.Name, err)
+		}
+	}
+	return inUse, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dockercommon
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"gopkg.in/check.v1"
+)
+
+type configFilesApp struct {
+	*provisiontest.FakeApp
+	files []provision.ConfigFile
+}
+
+func (a *configFilesApp) ConfigFiles() ([]provision.ConfigFile, error) {
+	return a.files, nil
+}
+
+type fakeConfigFilesClient struct {
+	volumes        []docker.CreateVolumeOptions
+	containers     []docker.CreateContainerOptions
+	removed        []string
+	populated      bool
+	uploaded       []byte
+	uploadPath     string
+	listed         []docker.Volume
+	listFilters    map[string][]string
+	inUse          map[string]bool
+	removedVolumes []string
+}
+
+func (f *fakeConfigFilesClient) CreateVolume(opts docker.CreateVolumeOptions) (*docker.Volume, error) {
+	f.volumes = append(f.volumes, opts)
+	return &docker.Volume{Name: opts.Name, Labels: opts.Labels}, nil
+}
+
+func (f *fakeConfigFilesClient) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	f.containers = append(f.containers, opts)
+	return &docker.Container{ID: "helper"}, nil
+}
+
+func (f *fakeConfigFilesClient) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	if !f.populated {
+		return errors.New("no such file")
+	}
+	return nil
+}
+
+func (f *fakeConfigFilesClient) UploadToContainer(id string, opts docker.UploadToContainerOptions) error {
+	data, err := ioutil.ReadAll(opts.InputStream)
+	if err != nil {
+		return err
+	}
+	f.uploaded = data
+	f.uploadPath = opts.Path
+	f.populated = true
+	return nil
+}
+
+func (f *fakeConfigFilesClient) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	f.removed = append(f.removed, opts.ID)
+	return nil
+}
+
+func (f *fakeConfigFilesClient) ListVolumes(opts docker.ListVolumesOptions) ([]docker.Volume, error) {
+	f.listFilters = opts.Filters
+	return f.listed, nil
+}
+
+func (f *fakeConfigFilesClient) RemoveVolume(name string) error {
+	if f.inUse[name] {
+		return docker.ErrVolumeInUse
+	}
+	f.removedVolumes = append(f.removedVolumes, name)
+	return nil
+}
+
+func (s *S) TestConfigFilesPath(c *check.C) {
+	c.Assert(ConfigFilesPath(), check.Equals, "/home/application/config")
+	config.Set("docker:config-files:path", "/etc/app/")
+	defer config.Unset("docker:config-files:path")
+	c.Assert(ConfigFilesPath(), check.Equals, "/etc/app")
+}
+
+func (s *S) TestAppConfigFiles(c *check.C) {
+	app := &configFilesApp{
+		FakeApp: provisiontest.NewFakeApp("app-name", "python", 1),
+		files: []provision.ConfigFile{
+			{Name: "settings.json", Content: []byte(`{"debug": false}`)},
+			{Name: "nginx.conf", Content: []byte("server {}\n")},
+		},
+	}
+	files, err := AppConfigFiles(app)
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 2)
+	c.Assert(files[0].Name, check.Equals, "nginx.conf")
+	c.Assert(files[1].Name, check.Equals, "settings.json")
+	files, err = AppConfigFiles(provisiontest.NewFakeApp("app-name", "python", 1))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.IsNil)
+}
+
+func (s *S) TestConfigFilesVolumeName(c *check.C) {
+	files := []provision.ConfigFile{{Name: "a.conf", Content: []byte("a")}}
+	name := ConfigFilesVolumeName("myapp", files)
+	c.Assert(name, check.Matches, `tsuru-config-myapp-[0-9a-f]{16}`)
+	c.Assert(ConfigFilesVolumeName("myapp", files), check.Equals, name)
+	c.Assert(ConfigFilesVolumeName("otherapp", files), check.Matches, `tsuru-config-otherapp-[0-9a-f]{16}`)
+	changed := []provision.ConfigFile{{Name: "a.conf", Content: []byte("b")}}
+	c.Assert(ConfigFilesVolumeName("myapp", changed), check.Not(check.Equals), name)
+	renamed := []provision.ConfigFile{{Name: "a.con", Content: []byte("fa")}}
+	c.Assert(ConfigFilesVolumeName("myapp", renamed), check.Not(check.Equals), name)
+}
+
+func (s *S) TestConfigFilesBind(c *check.C) {
+	c.Assert(ConfigFilesBind("tsuru-config-myapp-abc"), check.Equals, "tsuru-config-myapp-abc:/home/application/config:ro")
+}
+
+func (s *S) TestEnsureConfigFilesVolumeNearSizeLimit(c *check.C) {
+	var files []provision.ConfigFile
+	for _, name := range []string{"a.conf", "b.conf", "c.conf", "d.conf"} {
+		files = append(files, provision.ConfigFile{Name: name, Content: bytes.Repeat([]byte(name[:1]), 64*1024)})
+	}
+	client := &fakeConfigFilesClient{}
+	err := EnsureConfigFilesVolume(client, "myapp", "tsuru-config-myapp-abc", "tsuru/app-myapp:v1", files)
+	c.Assert(err, check.IsNil)
+	c.Assert(client.volumes, check.DeepEquals, []docker.CreateVolumeOptions{
+		{Name: "tsuru-config-myapp-abc", Labels: map[string]string{"tsuru.config-files.app": "myapp"}},
+	})
+	c.Assert(client.containers, check.HasLen, 1)
+	c.Assert(client.containers[0].Config.Image, check.Equals, "tsuru/app-myapp:v1")
+	c.Assert(client.containers[0].HostConfig.Binds, check.DeepEquals, []string{"tsuru-config-myapp-abc:/tsuru-config-files"})
+	c.Assert(client.removed, check.DeepEquals, []string{"helper"})
+	c.Assert(client.uploadPath, check.Equals, "/tsuru-config-files")
+	tr := tar.NewReader(bytes.NewReader(client.uploaded))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		c.Assert(hdr.Mode, check.Equals, int64(0444))
+		c.Assert(hdr.Uid, check.Equals, 0)
+		content, err := ioutil.ReadAll(tr)
+		c.Assert(err, check.IsNil)
+		if len(names) < len(files) {
+			c.Assert(content, check.DeepEquals, files[len(names)].Content)
+		}
+		names = append(names, hdr.Name)
+	}
+	c.Assert(names, check.DeepEquals, []string{"a.conf", "b.conf", "c.conf", "d.conf", ".tsuru-config-files"})
+}
+
+func (s *S) TestEnsureConfigFilesVolumeAlreadyPopulated(c *check.C) {
+	files := []provision.ConfigFile{{Name: "a.conf", Content: []byte("a")}}
+	client := &fakeConfigFilesClient{populated: true}
+	err := EnsureConfigFilesVolume(client, "myapp", "tsuru-config-myapp-abc", "tsuru/app-myapp:v1", files)
+	c.Assert(err, check.IsNil)
+	c.Assert(client.uploaded, check.IsNil)
+	c.Assert(client.removed, check.DeepEquals, []string{"helper"})
+}
+
+func (s *S) TestRemoveConfigFilesVolumes(c *check.C) {
+	client := &fakeConfigFilesClient{
+		listed: []docker.Volume{
+			{Name: "tsuru-config-myapp-0123456789abcdef"},
+			{Name: "tsuru-config-myapp-aaaaaaaaaaaaaaaa"},
+			{Name: "tsuru-config-myapp-bbbbbbbbbbbbbbbb"},
+			{Name: "tsuru-config-myapp-other-cccccccccccccccc"},
+			{Name: "myvolume"},
+		},
+		inUse: map[string]bool{"tsuru-config-myapp-bbbbbbbbbbbbbbbb": true},
+	}
+	inUse, err := RemoveConfigFilesVolumes(client, "myapp", "tsuru-config-myapp-0123456789abcdef")
+	c.Assert(err, check.IsNil)
+	c.Assert(inUse, check.DeepEquals, []string{"tsuru-config-myapp-bbbbbbbbbbbbbbbb"})
+	c.Assert(client.listFilters, check.DeepEquals, map[string][]string{"label": {"tsuru.config-files.app=myapp"}})
+	c.Assert(client.removedVolumes, check.DeepEquals, []string{"tsuru-config-myapp-aaaaaaaaaaaaaaaa"})
+	client.removedVolumes = nil
+	client.inUse = nil
+	inUse, err = RemoveConfigFilesVolumes(client, "myapp", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(inUse, check.IsNil)
+	c.Assert(client.removedVolumes, check.DeepEquals, []string{
+		"tsuru-config-myapp-0123456789abcdef",
+		"tsuru-config-myapp-aaaaaaaaaaaaaaaa",
+		"tsuru-config-myapp-bbbbbbbbbbbbbbbb",
+	})
+}
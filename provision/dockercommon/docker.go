@@ -15,6 +15,7 @@ import (
 	"github.com/tsuru/tsuru/app/image"
 	"github.com/tsuru/tsuru/net"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/registry"
 )
 
 type Client interface {
@@ -112,3 +113,21 @@ func PrepareImageForDeploy(args PrepareImageArgs) (string, error) {
 	}
 	return newImage, nil
 }
+
+// RegistryAuthForImage returns the auth configuration used to pull image for
+// the given team and pool. Registry credentials registered for the team or
+// the pool take precedence over defaultAuth.
+func RegistryAuthForImage(img, team, pool string, defaultAuth docker.AuthConfiguration) (docker.AuthConfiguration, error) {
+	cred, err := registry.FindForImage(img, team, pool)
+	if err == registry.ErrCredentialNotFound {
+		return defaultAuth, nil
+	}
+	if err != nil {
+		return docker.AuthConfiguration{}, err
+	}
+	return docker.AuthConfiguration{
+		Username:      cred.Username,
+		Password:      cred.Password,
+		ServerAddress: cred.Registry,
+	}, nil
+}
@@ -13,8 +13,11 @@ import (
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/encryption"
+	"github.com/tsuru/tsuru/registry"
 	"gopkg.in/check.v1"
 )
 
@@ -129,3 +132,28 @@ func (s *S) TestUploadToContainer(c *check.C) {
 	c.Assert(imgId, check.Matches, "^img-.{32}$")
 	c.Assert(path, check.Equals, "file:///home/application/archive.tar.gz")
 }
+
+func (s *S) TestRegistryAuthForImage(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	defaultAuth := docker.AuthConfiguration{Username: "tsuru", Password: "tsurupass", ServerAddress: "my.registry"}
+	auth, err := RegistryAuthForImage("r.com/img:v1", "myteam", "mypool", defaultAuth)
+	c.Assert(err, check.IsNil)
+	c.Assert(auth, check.DeepEquals, defaultAuth)
+	encrypted, err := encryption.Encrypt("secret")
+	c.Assert(err, check.IsNil)
+	err = s.conn.RegistryCredentials().Insert(registry.Credential{
+		Name:              "cred1",
+		Registry:          "r.com",
+		Username:          "user",
+		EncryptedPassword: encrypted,
+		Pool:              "mypool",
+	})
+	c.Assert(err, check.IsNil)
+	auth, err = RegistryAuthForImage("r.com/img:v1", "myteam", "mypool", defaultAuth)
+	c.Assert(err, check.IsNil)
+	c.Assert(auth, check.DeepEquals, docker.AuthConfiguration{Username: "user", Password: "secret", ServerAddress: "r.com"})
+	auth, err = RegistryAuthForImage("other.com/img:v1", "myteam", "mypool", defaultAuth)
+	c.Assert(err, check.IsNil)
+	c.Assert(auth, check.DeepEquals, defaultAuth)
+}
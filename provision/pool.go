@@ -24,6 +24,7 @@ type Pool struct {
 	Public      bool
 	Default     bool
 	Provisioner string
+	Constraints map[string]*PoolConstraint `bson:",omitempty"`
 }
 
 type AddPoolOptions struct {
@@ -39,6 +40,9 @@ type UpdatePoolOptions struct {
 	Public      *bool
 	Force       bool
 	Provisioner string
+	// Constraints replaces the constraints of the pool on each given field,
+	// a constraint without values removes the one on its field.
+	Constraints map[string]*PoolConstraint `form:"-"`
 }
 
 func (p *Pool) GetProvisioner() (Provisioner, error) {
@@ -206,6 +210,11 @@ func GetDefaultPool() (*Pool, error) {
 }
 
 func PoolUpdate(name string, opts UpdatePoolOptions) error {
+	for field := range opts.Constraints {
+		if err := validatePoolConstraintField(field); err != nil {
+			return err
+		}
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return err
@@ -227,7 +236,22 @@ func PoolUpdate(name string, opts UpdatePoolOptions) error {
 	if opts.Provisioner != "" {
 		query["provisioner"] = opts.Provisioner
 	}
-	err = conn.Pools().UpdateId(name, bson.M{"$set": query})
+	unset := bson.M{}
+	for field, constraint := range opts.Constraints {
+		if constraint == nil || len(constraint.Values) == 0 {
+			unset["constraints."+field] = ""
+		} else {
+			query["constraints."+field] = constraint
+		}
+	}
+	update := bson.M{"$set": query}
+	if len(unset) > 0 {
+		update = bson.M{"$unset": unset}
+		if len(query) > 0 {
+			update["$set"] = query
+		}
+	}
+	err = conn.Pools().UpdateId(name, update)
 	if err == mgo.ErrNotFound {
 		return ErrPoolNotFound
 	}
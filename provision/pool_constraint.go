@@ -0,0 +1,77 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Fields of apps and service instances that pool constraints may restrict.
+const (
+	PoolConstraintPlan    = "plan"
+	PoolConstraintRouter  = "router"
+	PoolConstraintService = "service"
+	PoolConstraintTeam    = "team"
+)
+
+var (
+	poolConstraintFields = []string{PoolConstraintPlan, PoolConstraintRouter, PoolConstraintService, PoolConstraintTeam}
+
+	ErrInvalidPoolConstraintField = errors.Errorf("invalid pool constraint field, it must be one of: %s", strings.Join(poolConstraintFields, ", "))
+)
+
+// PoolConstraint restricts the values of a field in a pool. Only the listed
+// values are allowed, unless Blacklist is true, in which case they're the
+// only ones denied.
+type PoolConstraint struct {
+	Values    []string
+	Blacklist bool
+}
+
+// PoolConstraintError is returned when a value is not allowed by the
+// constraints of a pool.
+type PoolConstraintError struct {
+	Pool  string
+	Field string
+	Value string
+}
+
+func (e *PoolConstraintError) Error() string {
+	return fmt.Sprintf("%s %q is not allowed in pool %q", e.Field, e.Value, e.Pool)
+}
+
+func validatePoolConstraintField(field string) error {
+	for _, f := range poolConstraintFields {
+		if f == field {
+			return nil
+		}
+	}
+	return ErrInvalidPoolConstraintField
+}
+
+// Allows returns whether the value is allowed by the constraint.
+func (c *PoolConstraint) Allows(value string) bool {
+	if c == nil || len(c.Values) == 0 {
+		return true
+	}
+	for _, v := range c.Values {
+		if v == value {
+			return !c.Blacklist
+		}
+	}
+	return c.Blacklist
+}
+
+// CheckConstraint returns a *PoolConstraintError when the constraint of the
+// pool on the given field doesn't allow the value.
+func (p *Pool) CheckConstraint(field, value string) error {
+	if p.Constraints[field].Allows(value) {
+		return nil
+	}
+	return &PoolConstraintError{Pool: p.Name, Field: field, Value: value}
+}
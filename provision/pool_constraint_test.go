@@ -0,0 +1,65 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provision
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestPoolConstraintAllows(c *check.C) {
+	var nilConstraint *PoolConstraint
+	c.Assert(nilConstraint.Allows("small"), check.Equals, true)
+	whitelist := &PoolConstraint{Values: []string{"small", "medium"}}
+	c.Assert(whitelist.Allows("small"), check.Equals, true)
+	c.Assert(whitelist.Allows("large"), check.Equals, false)
+	blacklist := &PoolConstraint{Values: []string{"large"}, Blacklist: true}
+	c.Assert(blacklist.Allows("small"), check.Equals, true)
+	c.Assert(blacklist.Allows("large"), check.Equals, false)
+}
+
+func (s *S) TestPoolCheckConstraint(c *check.C) {
+	pool := Pool{Name: "gpu-free", Constraints: map[string]*PoolConstraint{
+		PoolConstraintPlan: {Values: []string{"small", "medium"}},
+	}}
+	c.Assert(pool.CheckConstraint(PoolConstraintPlan, "small"), check.IsNil)
+	c.Assert(pool.CheckConstraint(PoolConstraintRouter, "internal"), check.IsNil)
+	err := pool.CheckConstraint(PoolConstraintPlan, "large")
+	c.Assert(err, check.DeepEquals, &PoolConstraintError{Pool: "gpu-free", Field: "plan", Value: "large"})
+	c.Assert(err, check.ErrorMatches, `plan "large" is not allowed in pool "gpu-free"`)
+}
+
+func (s *S) TestPoolUpdateConstraints(c *check.C) {
+	coll := s.storage.Pools()
+	pool := Pool{Name: "pool1", Constraints: map[string]*PoolConstraint{
+		PoolConstraintTeam: {Values: []string{"team1"}},
+	}}
+	err := coll.Insert(pool)
+	c.Assert(err, check.IsNil)
+	defer coll.RemoveId(pool.Name)
+	err = PoolUpdate("pool1", UpdatePoolOptions{Constraints: map[string]*PoolConstraint{
+		PoolConstraintPlan:    {Values: []string{"small", "medium"}},
+		PoolConstraintService: {Values: []string{"mongodb"}, Blacklist: true},
+		PoolConstraintTeam:    {},
+	}})
+	c.Assert(err, check.IsNil)
+	p, err := GetPoolByName("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.Constraints, check.DeepEquals, map[string]*PoolConstraint{
+		PoolConstraintPlan:    {Values: []string{"small", "medium"}},
+		PoolConstraintService: {Values: []string{"mongodb"}, Blacklist: true},
+	})
+}
+
+func (s *S) TestPoolUpdateInvalidConstraint(c *check.C) {
+	coll := s.storage.Pools()
+	pool := Pool{Name: "pool1"}
+	err := coll.Insert(pool)
+	c.Assert(err, check.IsNil)
+	defer coll.RemoveId(pool.Name)
+	err = PoolUpdate("pool1", UpdatePoolOptions{Constraints: map[string]*PoolConstraint{
+		"platform": {Values: []string{"python"}},
+	}})
+	c.Assert(err, check.Equals, ErrInvalidPoolConstraintField)
+}
@@ -28,6 +28,8 @@ var (
 	ErrEmptyApp      = errors.New("no units for this app")
 	ErrNodeNotFound  = errors.New("node not found")
 
+	ErrNodeDrainCanceled = errors.New("node drain canceled by user action")
+
 	DefaultProvisioner = defaultDockerProvisioner
 )
 
@@ -221,6 +223,10 @@ type App interface {
 
 	Envs() map[string]bind.EnvVar
 
+	// UnitEnvs returns the environment variables with the values units must
+	// be started with, failing when a secret value can't be read.
+	UnitEnvs() (map[string]bind.EnvVar, error)
+
 	GetMemory() int64
 	GetSwap() int64
 	GetCpuShare() int
@@ -248,6 +254,31 @@ type App interface {
 	GetRouterOpts() map[string]string
 }
 
+// ConfigFile is a configuration file to be placed in the units of an app.
+type ConfigFile struct {
+	Name    string
+	Content []byte
+}
+
+// ConfigFilesApp is an app with configuration files, which provisioners
+// supporting them place in the units of the app, read-only.
+type ConfigFilesApp interface {
+	ConfigFiles() ([]ConfigFile, error)
+}
+
+// UnitRoutesApp is an app whose routes may be kept away from its units, like
+// apps in maintenance mode or soft deleted.
+type UnitRoutesApp interface {
+	UnitRoutesDisabled() bool
+}
+
+// UnitRoutesDisabled returns whether provisioners must not add routes to the
+// units of the app, leaving its routes as they are.
+func UnitRoutesDisabled(app App) bool {
+	routesApp, ok := app.(UnitRoutesApp)
+	return ok && routesApp.UnitRoutesDisabled()
+}
+
 type AppLock interface {
 	json.Marshaler
 
@@ -379,6 +410,13 @@ type SleepableProvisioner interface {
 	Sleep(App, string) error
 }
 
+// UnitsDestroyerProvisioner is a provisioner that is able to remove the
+// units and routes of an application while keeping its images, which are
+// still needed when the application is moved to another provisioner.
+type UnitsDestroyerProvisioner interface {
+	DestroyUnits(App) error
+}
+
 // MessageProvisioner is a provisioner that provides a welcome message for
 // logging.
 type MessageProvisioner interface {
@@ -398,6 +436,33 @@ type OptionalLogsProvisioner interface {
 	LogsEnabled(App) (bool, string, error)
 }
 
+// RestartOptions controls how units are replaced in a rolling restart.
+type RestartOptions struct {
+	// BatchSize is the maximum number of units unavailable at the same
+	// time, zero means all units are restarted at once.
+	BatchSize int
+	// Pause is the time to wait between batches.
+	Pause time.Duration
+}
+
+func (o RestartOptions) Validate() error {
+	if o.BatchSize < 0 {
+		return errors.New("batch size must be greater than or equal to zero")
+	}
+	if o.Pause < 0 {
+		return errors.New("pause must be greater than or equal to zero")
+	}
+	return nil
+}
+
+// RollingRestartProvisioner is a provisioner able to restart units in
+// batches. Units in a batch must pass the app healthcheck before the next
+// batch is restarted and the restart is aborted on the first failure,
+// leaving the remaining units untouched.
+type RollingRestartProvisioner interface {
+	RollingRestart(App, string, RestartOptions, io.Writer) error
+}
+
 // UnitStatusProvisioner is a provisioner that receive notifications about unit
 // status changes.
 type UnitStatusProvisioner interface {
@@ -428,6 +493,12 @@ type UpdateNodeOptions struct {
 	Disable  bool
 }
 
+type DrainNodeOptions struct {
+	Address string
+	Writer  io.Writer
+	Event   *event.Event
+}
+
 type NodeProvisioner interface {
 	// ListNodes returns a list of all nodes registered in the provisioner.
 	ListNodes(addressFilter []string) ([]Node, error)
@@ -456,6 +527,25 @@ type RebalanceNodesOptions struct {
 	Force          bool
 }
 
+// NodeResourcesProvisioner is a provisioner that keeps track of the resources
+// reported by node agents, usually to use them when scheduling units.
+type NodeResourcesProvisioner interface {
+	UpdateNodeResources(Node, NodeResources) error
+}
+
+// NodeDrainProvisioner is a provisioner able to put a node in maintenance
+// mode, moving all its units to other nodes without downtime.
+type NodeDrainProvisioner interface {
+	// DrainNode cordons the node, preventing new units from being scheduled
+	// to it, and moves its units to other nodes, returning only after all
+	// units are moved. The operation is aborted with ErrNodeDrainCanceled
+	// if the event in the options is canceled.
+	DrainNode(DrainNodeOptions) error
+
+	// UncordonNode allows new units to be scheduled to the node again.
+	UncordonNode(address string) error
+}
+
 type NodeRebalanceProvisioner interface {
 	RebalanceNodes(RebalanceNodesOptions) (bool, error)
 }
@@ -516,9 +606,19 @@ func NodeToJSON(n Node) ([]byte, error) {
 }
 
 type NodeStatusData struct {
-	Addrs  []string
-	Units  []UnitStatusData
-	Checks []NodeCheckResult
+	Addrs     []string
+	Units     []UnitStatusData
+	Checks    []NodeCheckResult
+	Resources NodeResources
+}
+
+// NodeResources holds the capacity of a node as reported by the node agent.
+// Memory and disk values are in bytes.
+type NodeResources struct {
+	CPUs          int
+	Memory        int64
+	Disk          int64
+	DiskAvailable int64
 }
 
 type UnitStatusData struct {
@@ -318,6 +318,10 @@ func (a *FakeApp) Envs() map[string]bind.EnvVar {
 	return a.env
 }
 
+func (a *FakeApp) UnitEnvs() (map[string]bind.EnvVar, error) {
+	return a.env, nil
+}
+
 func (a *FakeApp) SerializeEnvVars() error {
 	a.commMut.Lock()
 	a.Commands = append(a.Commands, "serialize")
@@ -411,6 +415,7 @@ type FakeNode struct {
 	p          *FakeProvisioner
 	failures   int
 	hasSuccess bool
+	resources  provision.NodeResources
 }
 
 func (n *FakeNode) Pool() string {
@@ -463,6 +468,10 @@ func (n *FakeNode) Provisioner() provision.NodeProvisioner {
 	return n.p
 }
 
+func (n *FakeNode) Resources() provision.NodeResources {
+	return n.resources
+}
+
 func (n *FakeNode) SetHealth(failures int, hasSuccess bool) {
 	n.failures = failures
 	n.hasSuccess = hasSuccess
@@ -535,6 +544,48 @@ func (p *FakeProvisioner) UpdateNode(opts provision.UpdateNodeOptions) error {
 	return nil
 }
 
+func (p *FakeProvisioner) DrainNode(opts provision.DrainNodeOptions) error {
+	if err := p.getError("DrainNode"); err != nil {
+		return err
+	}
+	n, ok := p.nodes[opts.Address]
+	if !ok {
+		return provision.ErrNodeNotFound
+	}
+	n.status = "disabled"
+	p.nodes[opts.Address] = n
+	if opts.Writer != nil {
+		opts.Writer.Write([]byte("drain done!"))
+	}
+	return nil
+}
+
+func (p *FakeProvisioner) UncordonNode(address string) error {
+	if err := p.getError("UncordonNode"); err != nil {
+		return err
+	}
+	n, ok := p.nodes[address]
+	if !ok {
+		return provision.ErrNodeNotFound
+	}
+	n.status = "enabled"
+	p.nodes[address] = n
+	return nil
+}
+
+func (p *FakeProvisioner) UpdateNodeResources(node provision.Node, resources provision.NodeResources) error {
+	if err := p.getError("UpdateNodeResources"); err != nil {
+		return err
+	}
+	n, ok := p.nodes[node.Address()]
+	if !ok {
+		return provision.ErrNodeNotFound
+	}
+	n.resources = resources
+	p.nodes[node.Address()] = n
+	return nil
+}
+
 func (p *FakeProvisioner) ListNodes(addressFilter []string) ([]provision.Node, error) {
 	if err := p.getError("ListNodes"); err != nil {
 		return nil, err
@@ -615,6 +666,14 @@ func (p *FakeProvisioner) Restarts(a provision.App, process string) int {
 	return p.apps[a.GetName()].restarts[process]
 }
 
+// LastRestartOptions returns the options used in the last rolling restart of
+// the given app.
+func (p *FakeProvisioner) LastRestartOptions(a provision.App) provision.RestartOptions {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[a.GetName()].restartOpts
+}
+
 // Starts returns the number of starts for a given app.
 func (p *FakeProvisioner) Starts(app provision.App, process string) int {
 	p.mut.RLock()
@@ -831,6 +890,22 @@ func (p *FakeProvisioner) Provision(app provision.App) error {
 	return nil
 }
 
+func (p *FakeProvisioner) RollingRestart(app provision.App, process string, opts provision.RestartOptions, w io.Writer) error {
+	if err := p.getError("RollingRestart"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		p.mut.Unlock()
+		return errNotProvisioned
+	}
+	pApp.restartOpts = opts
+	p.apps[app.GetName()] = pApp
+	p.mut.Unlock()
+	return p.Restart(app, process, w)
+}
+
 func (p *FakeProvisioner) Restart(app provision.App, process string, w io.Writer) error {
 	if err := p.getError("Restart"); err != nil {
 		return err
@@ -930,6 +1005,19 @@ func (p *FakeProvisioner) Destroy(app provision.App) error {
 	return nil
 }
 
+func (p *FakeProvisioner) DestroyUnits(app provision.App) error {
+	if err := p.getError("DestroyUnits"); err != nil {
+		return err
+	}
+	if !p.Provisioned(app) {
+		return errNotProvisioned
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	delete(p.apps, app.GetName())
+	return nil
+}
+
 func (p *FakeProvisioner) AddUnits(app provision.App, n uint, process string, w io.Writer) error {
 	_, err := p.AddUnitsToNode(app, n, process, w, "")
 	return err
@@ -976,9 +1064,11 @@ func (p *FakeProvisioner) AddUnitsToNode(app provision.App, n uint, process stri
 				Host:   fmt.Sprintf("%s:%d", hostAddr, val),
 			},
 		}
-		err := routertest.FakeRouter.AddRoute(name, unit.Address)
-		if err != nil {
-			return nil, err
+		if !provision.UnitRoutesDisabled(app) {
+			err := routertest.FakeRouter.AddRoute(name, unit.Address)
+			if err != nil {
+				return nil, err
+			}
 		}
 		pApp.units = append(pApp.units, unit)
 		pApp.unitLen++
@@ -1491,6 +1581,7 @@ type provisionedApp struct {
 	unitLen     int
 	lastData    map[string]interface{}
 	image       string
+	restartOpts provision.RestartOptions
 }
 
 type provisionedPlatform struct {
@@ -6,6 +6,7 @@ package swarm
 
 import (
 	"sort"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
@@ -21,6 +22,8 @@ type processState struct {
 	start     bool
 	restart   bool
 	increment int
+	batchSize int
+	pause     time.Duration
 }
 
 type processSpec map[string]processState
@@ -61,6 +64,10 @@ var updateServices = &action.Action{
 			toDeployProcesses = append(toDeployProcesses, processName)
 		}
 		sort.Strings(toDeployProcesses)
+		err = ensureConfigFilesVolume(args.client, args.app, args.newImage)
+		if err != nil {
+			return nil, err
+		}
 		for _, processName := range toDeployProcesses {
 			err = deploy(args.client, args.app, processName, args.newImageSpec[processName], args.newImage)
 			if err != nil {
@@ -105,6 +112,10 @@ var removeOldServices = &action.Action{
 				log.Errorf("ignored error removing unwanted service for %s[%s]: %+v", args.app.GetName(), processName, err)
 			}
 		}
+		err := removeConfigFilesVolumes(args.client, args.app, false)
+		if err != nil {
+			log.Errorf("ignored error removing stale config files volumes for %s: %+v", args.app.GetName(), err)
+		}
 		return nil, nil
 	},
 }
@@ -19,7 +19,9 @@ import (
 	"github.com/fsouza/go-dockerclient"
 	"github.com/pkg/errors"
 	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/log"
 	tsuruNet "github.com/tsuru/tsuru/net"
 	"github.com/tsuru/tsuru/provision"
@@ -253,6 +255,57 @@ func waitForTasks(client *docker.Client, serviceID string, wantedState swarm.Tas
 	}
 }
 
+var (
+	waitNodeDrainedTimeout   = 10 * time.Minute
+	waitServiceUpdateTimeout = 30 * time.Minute
+)
+
+// waitNodeDrained waits until no tsuru tasks are running in the node,
+// reporting the amount of remaining units as they are moved by swarm.
+func waitNodeDrained(client *docker.Client, nodeID, address string, w io.Writer, evt *event.Event) error {
+	timeout := time.After(waitNodeDrainedTimeout)
+	lastRemaining := -1
+	for {
+		tasks, err := client.ListTasks(docker.ListTasksOptions{
+			Filters: map[string][]string{
+				"node":  {nodeID},
+				"label": {fmt.Sprintf("%s=true", labelService)},
+			},
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		var remaining int
+		for _, t := range tasks {
+			if t.DesiredState == swarm.TaskStateRunning || t.Status.State == swarm.TaskStateRunning {
+				remaining++
+			}
+		}
+		if remaining == 0 {
+			fmt.Fprintf(w, "Node %s drained\n", address)
+			return nil
+		}
+		if remaining != lastRemaining {
+			fmt.Fprintf(w, "Waiting for %d units to be moved from node %s...\n", remaining, address)
+			lastRemaining = remaining
+		}
+		if evt != nil {
+			canceled, err := evt.AckCancel()
+			if err != nil {
+				log.Errorf("unable to check if event should be canceled, ignoring: %s", err)
+			}
+			if canceled {
+				return provision.ErrNodeDrainCanceled
+			}
+		}
+		select {
+		case <-timeout:
+			return errors.Errorf("timeout waiting for node %q to be drained, %d units remaining", address, remaining)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
 func commitPushBuildImage(client *docker.Client, img, contID string, app provision.App) (string, error) {
 	parts := strings.Split(img, ":")
 	repository := strings.Join(parts[:len(parts)-1], ":")
@@ -331,17 +384,21 @@ func extraRegisterCmds(app provision.App) string {
 }
 
 func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
+	appEnvs, err := opts.app.UnitEnvs()
+	if err != nil {
+		return nil, err
+	}
 	var envs []string
-	for _, envData := range opts.app.Envs() {
+	for _, envData := range bind.ProcessEnvs(appEnvs, opts.process) {
 		envs = append(envs, fmt.Sprintf("%s=%s", envData.Name, envData.Value))
 	}
 	host, _ := config.GetString("host")
 	envs = append(envs, fmt.Sprintf("%s=%s", "TSURU_HOST", host))
 	var cmds []string
-	var err error
 	var endpointSpec *swarm.EndpointSpec
 	var networks []swarm.NetworkAttachmentConfig
 	var healthConfig *container.HealthConfig
+	var mounts []mount.Mount
 	port := dockercommon.WebProcessDefaultPort()
 	portInt, _ := strconv.Atoi(port)
 	if !opts.isDeploy && !opts.isIsolatedRun {
@@ -369,6 +426,23 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 			return nil, errors.WithStack(err)
 		}
 		healthConfig = toHealthConfig(yamlData.Healthcheck, portInt)
+		var configFiles []provision.ConfigFile
+		configFiles, err = dockercommon.AppConfigFiles(opts.app)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(configFiles) > 0 {
+			mounts = append(mounts, mount.Mount{
+				Type:     mount.TypeVolume,
+				Source:   dockercommon.ConfigFilesVolumeName(opts.app.GetName(), configFiles),
+				Target:   dockercommon.ConfigFilesPath(),
+				ReadOnly: true,
+				VolumeOptions: &mount.VolumeOptions{
+					NoCopy: true,
+					Labels: map[string]string{dockercommon.LabelConfigFilesApp: opts.app.GetName()},
+				},
+			})
+		}
 	}
 	restartCount := 0
 	replicas := 0
@@ -440,6 +514,7 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 				Command:     cmds,
 				User:        user,
 				Healthcheck: healthConfig,
+				Mounts:      mounts,
 			},
 			Networks: networks,
 			RestartPolicy: &swarm.RestartPolicy{
@@ -461,9 +536,102 @@ func serviceSpecForApp(opts tsuruServiceOpts) (*swarm.ServiceSpec, error) {
 			},
 		},
 	}
+	if opts.processState.batchSize > 0 {
+		spec.UpdateConfig = &swarm.UpdateConfig{
+			Parallelism:   uint64(opts.processState.batchSize),
+			Delay:         opts.processState.pause,
+			FailureAction: swarm.UpdateFailureActionPause,
+		}
+	}
 	return &spec, nil
 }
 
+// servicesLastUpdate returns the start time of the last update of each app
+// service, so that waitServicesUpdated is able to tell updates apart.
+func servicesLastUpdate(client *docker.Client, a provision.App, processes []string) (map[string]time.Time, error) {
+	lastUpdates := map[string]time.Time{}
+	for _, process := range processes {
+		srv, err := client.InspectService(serviceNameForApp(a, process))
+		if err != nil {
+			if _, notFound := err.(*docker.NoSuchService); notFound {
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+		lastUpdates[process] = srv.UpdateStatus.StartedAt
+	}
+	return lastUpdates, nil
+}
+
+// waitServicesUpdated waits for rolling updates in the app services to
+// finish and for every task to run the updated spec, failing if swarm paused
+// any of them due to unhealthy tasks. Update states reported before the
+// times in lastUpdates belong to previous updates and are ignored.
+func waitServicesUpdated(client *docker.Client, a provision.App, processes []string, lastUpdates map[string]time.Time) error {
+	timeout := time.After(waitServiceUpdateTimeout)
+	for _, process := range processes {
+		srvName := serviceNameForApp(a, process)
+		for {
+			srv, err := client.InspectService(srvName)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			status := srv.UpdateStatus
+			newUpdate := !status.StartedAt.IsZero() && status.StartedAt.After(lastUpdates[process])
+			if newUpdate && status.State == swarm.UpdateStatePaused {
+				return errors.Errorf("rolling restart of process %q aborted: %s", process, status.Message)
+			}
+			if !newUpdate || status.State != swarm.UpdateStateUpdating {
+				done, err := serviceTasksUpdated(client, srv)
+				if err != nil {
+					return err
+				}
+				if done {
+					break
+				}
+			}
+			select {
+			case <-timeout:
+				return errors.Errorf("timeout waiting for rolling restart of process %q", process)
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// serviceTasksUpdated returns whether every live task of the service was
+// created from its current spec and reached its desired state.
+func serviceTasksUpdated(client *docker.Client, srv *swarm.Service) (bool, error) {
+	tasks, err := client.ListTasks(docker.ListTasksOptions{
+		Filters: map[string][]string{
+			"service": {srv.ID},
+		},
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	restartLabel := labelServiceRestart.String()
+	wantedRestart := srv.Spec.TaskTemplate.ContainerSpec.Labels[restartLabel]
+	var updated int
+	for _, t := range tasks {
+		if t.DesiredState == swarm.TaskStateShutdown {
+			continue
+		}
+		if t.Spec.ContainerSpec.Labels[restartLabel] != wantedRestart {
+			return false, nil
+		}
+		if t.Status.State != t.DesiredState {
+			return false, nil
+		}
+		updated++
+	}
+	if repl := srv.Spec.Mode.Replicated; repl != nil && repl.Replicas != nil {
+		return updated == int(*repl.Replicas), nil
+	}
+	return updated > 0, nil
+}
+
 func removeServiceAndLog(client *docker.Client, id string) {
 	err := client.RemoveService(docker.RemoveServiceOptions{
 		ID: id,
@@ -473,6 +641,103 @@ func removeServiceAndLog(client *docker.Client, id string) {
 	}
 }
 
+// ensureConfigFilesVolume populates the config files volume of the app in
+// every node of its pool, as swarm may start units of the app in any of them.
+func ensureConfigFilesVolume(client *docker.Client, a provision.App, imgID string) error {
+	files, err := dockercommon.AppConfigFiles(a)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	volume := dockercommon.ConfigFilesVolumeName(a.GetName(), files)
+	nodeClients, err := pullImageInPoolNodes(client, a, imgID, registryAuthConfig())
+	if err != nil {
+		return err
+	}
+	for _, nodeClient := range nodeClients {
+		err = dockercommon.EnsureConfigFilesVolume(nodeClient, a.GetName(), volume, imgID, files)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var waitConfigFilesVolumesTimeout = time.Minute
+
+// removeConfigFilesVolumes removes the config files volumes of the app from
+// the nodes of its pool, except the volume of its current config files. When
+// the app is being destroyed every volume is removed from every node, waiting
+// for the units being shut down to release them.
+func removeConfigFilesVolumes(client *docker.Client, a provision.App, destroy bool) error {
+	var keep string
+	if !destroy {
+		files, err := dockercommon.AppConfigFiles(a)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			keep = dockercommon.ConfigFilesVolumeName(a.GetName(), files)
+		}
+	}
+	nodes, err := listValidNodes(client)
+	if err != nil {
+		return err
+	}
+	timeout := time.After(waitConfigFilesVolumesTimeout)
+	for _, n := range nodes {
+		if !destroy && n.Spec.Annotations.Labels[labelNodePoolName.String()] != a.GetPool() {
+			continue
+		}
+		nodeClient, err := newClient(n.Spec.Annotations.Labels[labelNodeDockerAddr.String()])
+		if err != nil {
+			return err
+		}
+		for {
+			inUse, err := dockercommon.RemoveConfigFilesVolumes(nodeClient, a.GetName(), keep)
+			if err != nil {
+				return err
+			}
+			if !destroy || len(inUse) == 0 {
+				break
+			}
+			select {
+			case <-timeout:
+				return errors.Errorf("timeout waiting for config files volumes %v of app %s to be released", inUse, a.GetName())
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+	return nil
+}
+
+// pullImageInPoolNodes pulls the image in every node of the app pool using
+// the given credentials, returning clients for each one of these nodes.
+func pullImageInPoolNodes(client *docker.Client, a provision.App, imgID string, auth docker.AuthConfiguration) ([]*docker.Client, error) {
+	nodes, err := listValidNodes(client)
+	if err != nil {
+		return nil, err
+	}
+	var nodeClients []*docker.Client
+	for _, n := range nodes {
+		if n.Spec.Annotations.Labels[labelNodePoolName.String()] != a.GetPool() {
+			continue
+		}
+		nodeClient, err := newClient(n.Spec.Annotations.Labels[labelNodeDockerAddr.String()])
+		if err != nil {
+			return nil, err
+		}
+		err = nodeClient.PullImage(docker.PullImageOptions{
+			Repository:        imgID,
+			InactivityTimeout: tsuruNet.StreamInactivityTimeout,
+		}, auth)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		nodeClients = append(nodeClients, nodeClient)
+	}
+	return nodeClients, nil
+}
+
 func clientForNode(baseClient *docker.Client, nodeID string) (*docker.Client, error) {
 	node, err := baseClient.InspectNode(nodeID)
 	if err != nil {
@@ -103,6 +103,10 @@ func (p *swarmProvisioner) Destroy(a provision.App) error {
 	if err != nil {
 		multiErrors.Add(errors.WithStack(err))
 	}
+	err = removeConfigFilesVolumes(client, a, true)
+	if err != nil {
+		log.Errorf("unable to remove config files volumes of app %s: %+v", a.GetName(), err)
+	}
 	if multiErrors.Len() > 0 {
 		return multiErrors
 	}
@@ -142,34 +146,73 @@ func (p *swarmProvisioner) RemoveUnits(a provision.App, units uint, processName
 }
 
 func changeAppState(a provision.App, process string, state processState) error {
+	_, _, err := changeAppStateProcesses(a, process, state)
+	return err
+}
+
+func changeAppStateProcesses(a provision.App, process string, state processState) (*docker.Client, []string, error) {
 	client, err := chooseDBSwarmNode()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	var processes []string
-	if process == "" {
-		processes, err = allAppProcesses(a.GetName())
-		if err != nil {
-			return err
-		}
-	} else {
-		processes = []string{process}
+	processes, err := appStateProcesses(a, process)
+	if err != nil {
+		return nil, nil, err
 	}
 	imgID, err := image.AppCurrentImageName(a.GetName())
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, nil, errors.WithStack(err)
 	}
 	spec := processSpec{}
 	for _, procName := range processes {
 		spec[procName] = state
 	}
-	return deployProcesses(client, a, imgID, spec)
+	return client, processes, deployProcesses(client, a, imgID, spec)
+}
+
+func appStateProcesses(a provision.App, process string) ([]string, error) {
+	if process != "" {
+		return []string{process}, nil
+	}
+	return allAppProcesses(a.GetName())
 }
 
 func (p *swarmProvisioner) Restart(a provision.App, process string, w io.Writer) error {
 	return changeAppState(a, process, processState{start: true, restart: true})
 }
 
+func (p *swarmProvisioner) RollingRestart(a provision.App, process string, opts provision.RestartOptions, w io.Writer) error {
+	if opts.BatchSize <= 0 {
+		return p.Restart(a, process, w)
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Restarting units in batches of %d ----\n", opts.BatchSize)
+	client, err := chooseDBSwarmNode()
+	if err != nil {
+		return err
+	}
+	processes, err := appStateProcesses(a, process)
+	if err != nil {
+		return err
+	}
+	lastUpdates, err := servicesLastUpdate(client, a, processes)
+	if err != nil {
+		return err
+	}
+	_, _, err = changeAppStateProcesses(a, process, processState{
+		start:     true,
+		restart:   true,
+		batchSize: opts.BatchSize,
+		pause:     opts.Pause,
+	})
+	if err != nil {
+		return err
+	}
+	return waitServicesUpdated(client, a, processes, lastUpdates)
+}
+
 func (p *swarmProvisioner) Start(a provision.App, process string) error {
 	return changeAppState(a, process, processState{start: true})
 }
@@ -597,6 +640,35 @@ func (p *swarmProvisioner) UpdateNode(opts provision.UpdateNodeOptions) error {
 	return nil
 }
 
+func (p *swarmProvisioner) DrainNode(opts provision.DrainNodeOptions) error {
+	node, err := p.GetNode(opts.Address)
+	if err != nil {
+		return err
+	}
+	client, err := chooseDBSwarmNode()
+	if err != nil {
+		return err
+	}
+	swarmNode := node.(*swarmNodeWrapper).Node
+	swarmNode.Spec.Availability = swarm.NodeAvailabilityDrain
+	err = client.UpdateNode(swarmNode.ID, docker.UpdateNodeOptions{
+		NodeSpec: swarmNode.Spec,
+		Version:  swarmNode.Version.Index,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	writer := opts.Writer
+	if writer == nil {
+		writer = ioutil.Discard
+	}
+	return waitNodeDrained(client, swarmNode.ID, opts.Address, writer, opts.Event)
+}
+
+func (p *swarmProvisioner) UncordonNode(address string) error {
+	return p.UpdateNode(provision.UpdateNodeOptions{Address: address, Enable: true})
+}
+
 func (p *swarmProvisioner) ArchiveDeploy(a provision.App, archiveURL string, evt *event.Event) (imgID string, err error) {
 	baseImage := image.GetBuildImage(a)
 	buildingImage, err := image.AppNewImageName(a.GetName())
@@ -635,6 +707,16 @@ func (p *swarmProvisioner) ImageDeploy(a provision.App, imgID string, evt *event
 		imgID = fmt.Sprintf("%s:latest", imgID)
 	}
 	fmt.Fprintln(evt, "---- Pulling image to tsuru ----")
+	pullAuth, err := dockercommon.RegistryAuthForImage(imgID, a.GetTeamOwner(), a.GetPool(), docker.AuthConfiguration{})
+	if err != nil {
+		return "", err
+	}
+	// The service is created without credentials, the image must already be
+	// available in the nodes where swarm may schedule its task.
+	_, err = pullImageInPoolNodes(client, a, imgID, pullAuth)
+	if err != nil {
+		return "", err
+	}
 	var buf bytes.Buffer
 	cmds := []string{"/bin/bash", "-c", "cat /home/application/current/Procfile || cat /app/user/Procfile || cat /Procfile"}
 	srvID, task, err := runOnceBuildCmds(client, a, cmds, imgID, "", &buf)
@@ -653,6 +735,7 @@ func (p *swarmProvisioner) ImageDeploy(a provision.App, imgID string, evt *event
 		App:         a,
 		ProcfileRaw: buf.String(),
 		ImageId:     imgID,
+		AuthConfig:  registryAuthConfig(),
 		Out:         evt,
 	})
 	if err != nil {
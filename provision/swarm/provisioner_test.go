@@ -6,10 +6,12 @@ package swarm
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"regexp"
@@ -19,6 +21,7 @@ import (
 	"time"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
@@ -26,11 +29,14 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/app/image"
+	"github.com/tsuru/tsuru/encryption"
 	"github.com/tsuru/tsuru/event"
 	"github.com/tsuru/tsuru/permission"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/dockercommon"
 	"github.com/tsuru/tsuru/provision/nodecontainer"
 	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/registry"
 	"github.com/tsuru/tsuru/safe"
 	"gopkg.in/check.v1"
 )
@@ -378,6 +384,139 @@ func (s *S) TestRestartExisting(c *check.C) {
 	c.Assert(service.Spec.TaskTemplate.ContainerSpec.Labels[labelServiceRestart.String()], check.Equals, "1")
 }
 
+func (s *S) TestRollingRestart(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	buf := bytes.NewBuffer(nil)
+	err = s.p.RollingRestart(a, "web", provision.RestartOptions{BatchSize: 2, Pause: time.Second}, buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "\n---- Restarting units in batches of 2 ----\n")
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 3)
+	cli, err := docker.NewClient(srv.URL())
+	c.Assert(err, check.IsNil)
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(service.Spec.TaskTemplate.ContainerSpec.Labels[labelServiceRestart.String()], check.Equals, "1")
+	c.Assert(service.Spec.UpdateConfig, check.DeepEquals, &swarm.UpdateConfig{
+		Parallelism:   2,
+		Delay:         time.Second,
+		FailureAction: swarm.UpdateFailureActionPause,
+	})
+}
+
+func (s *S) serviceUpdateStatusHandler(srv *testing.DockerServer, statusFn func(*swarm.Service) swarm.UpdateStatus) {
+	srv.CustomHandler("^/services/myapp-web$", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := httptest.NewRecorder()
+		srv.DefaultHandler().ServeHTTP(recorder, r)
+		if r.Method != http.MethodGet || recorder.Code != http.StatusOK {
+			w.WriteHeader(recorder.Code)
+			w.Write(recorder.Body.Bytes())
+			return
+		}
+		var service swarm.Service
+		json.Unmarshal(recorder.Body.Bytes(), &service)
+		service.UpdateStatus = statusFn(&service)
+		json.NewEncoder(w).Encode(service)
+	}))
+}
+
+func (s *S) TestRollingRestartIgnoresPreviousPausedUpdate(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	previousUpdate := time.Now().Add(-time.Hour)
+	s.serviceUpdateStatusHandler(srv, func(service *swarm.Service) swarm.UpdateStatus {
+		return swarm.UpdateStatus{
+			State:     swarm.UpdateStatePaused,
+			StartedAt: previousUpdate,
+			Message:   "old failure",
+		}
+	})
+	err = s.p.RollingRestart(a, "web", provision.RestartOptions{BatchSize: 1}, nil)
+	c.Assert(err, check.IsNil)
+	cli, err := docker.NewClient(srv.URL())
+	c.Assert(err, check.IsNil)
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	c.Assert(service.Spec.TaskTemplate.ContainerSpec.Labels[labelServiceRestart.String()], check.Equals, "1")
+}
+
+func (s *S) TestRollingRestartPausedUpdate(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{Address: srv.URL()}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	previousUpdate := time.Now().Add(-time.Hour)
+	s.serviceUpdateStatusHandler(srv, func(service *swarm.Service) swarm.UpdateStatus {
+		if service.Spec.TaskTemplate.ContainerSpec.Labels[labelServiceRestart.String()] == "1" {
+			return swarm.UpdateStatus{
+				State:     swarm.UpdateStatePaused,
+				StartedAt: previousUpdate.Add(time.Hour),
+				Message:   "task unhealthy",
+			}
+		}
+		return swarm.UpdateStatus{
+			State:     swarm.UpdateStateCompleted,
+			StartedAt: previousUpdate,
+		}
+	})
+	err = s.p.RollingRestart(a, "web", provision.RestartOptions{BatchSize: 1}, nil)
+	c.Assert(err, check.ErrorMatches, `rolling restart of process "web" aborted: task unhealthy`)
+}
+
 func (s *S) TestStopStart(c *check.C) {
 	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, check.IsNil)
@@ -581,6 +720,131 @@ func (s *S) TestRoutableUnits(c *check.C) {
 	})
 }
 
+func (s *S) TestAddUnitsConfigFiles(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	var uploads []string
+	srv.CustomHandler("/containers/.*/archive", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		uploads = append(uploads, r.URL.Query().Get("path"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	opts := provision.AddNodeOptions{Address: srv.URL(), Metadata: map[string]string{"pool": "px"}}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	err = provision.AddPool(provision.AddPoolOptions{Name: "px", Public: true, Provisioner: "swarm"})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1, Pool: "px"}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("app.conf", []byte(strings.Repeat("secret", 10000)), "", false, nil)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	files, err := a.ConfigFiles()
+	c.Assert(err, check.IsNil)
+	cli, err := docker.NewClient(srv.URL())
+	c.Assert(err, check.IsNil)
+	service, err := cli.InspectService("myapp-web")
+	c.Assert(err, check.IsNil)
+	spec := service.Spec.TaskTemplate.ContainerSpec
+	c.Assert(spec.Mounts, check.DeepEquals, []mount.Mount{{
+		Type:     mount.TypeVolume,
+		Source:   dockercommon.ConfigFilesVolumeName(a.Name, files),
+		Target:   "/home/application/config",
+		ReadOnly: true,
+		VolumeOptions: &mount.VolumeOptions{
+			NoCopy: true,
+			Labels: map[string]string{"tsuru.config-files.app": "myapp"},
+		},
+	}})
+	c.Assert(strings.Join(spec.Command, " "), check.Not(check.Matches), "(?s).*secret.*")
+	c.Assert(uploads, check.DeepEquals, []string{"/tsuru-config-files"})
+}
+
+func (s *S) TestConfigFilesVolumesRemoved(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	var volumes, removed []string
+	srv.CustomHandler("/containers/.*/archive", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.CustomHandler("^/volumes$", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result []docker.Volume
+		for _, v := range volumes {
+			result = append(result, docker.Volume{Name: v})
+		}
+		json.NewEncoder(w).Encode(map[string][]docker.Volume{"Volumes": result})
+	}))
+	srv.CustomHandler("^/volumes/tsuru-config-", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/volumes/")
+		removed = append(removed, name)
+		for i := range volumes {
+			if volumes[i] == name {
+				volumes = append(volumes[:i], volumes[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	opts := provision.AddNodeOptions{Address: srv.URL(), Metadata: map[string]string{"pool": "px"}}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	err = provision.AddPool(provision.AddPoolOptions{Name: "px", Public: true, Provisioner: "swarm"})
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name, Deploys: 1, Pool: "px"}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	_, err = a.SetConfigFile("app.conf", []byte("v1"), "", false, nil)
+	c.Assert(err, check.IsNil)
+	imgName := "myapp:v1"
+	err = image.SaveImageCustomData(imgName, map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = image.AppendAppImageName(a.GetName(), imgName)
+	c.Assert(err, check.IsNil)
+	err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	files, err := a.ConfigFiles()
+	c.Assert(err, check.IsNil)
+	oldVolume := dockercommon.ConfigFilesVolumeName(a.Name, files)
+	volumes = append(volumes, oldVolume)
+	c.Assert(removed, check.IsNil)
+	_, err = a.SetConfigFile("app.conf", []byte("v2"), "", false, nil)
+	c.Assert(err, check.IsNil)
+	files, err = a.ConfigFiles()
+	c.Assert(err, check.IsNil)
+	newVolume := dockercommon.ConfigFilesVolumeName(a.Name, files)
+	volumes = append(volumes, newVolume)
+	err = s.p.Restart(a, "", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(removed, check.DeepEquals, []string{oldVolume})
+	err = s.p.Destroy(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(removed, check.DeepEquals, []string{oldVolume, newVolume})
+}
+
 func (s *S) TestRoutableUnitsNoNodesInPool(c *check.C) {
 	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, check.IsNil)
@@ -1020,6 +1284,35 @@ func (s *S) TestUpdateNodeDisableEnable(c *check.C) {
 	c.Assert(node.Status(), check.Equals, "ready")
 }
 
+func (s *S) TestDrainNodeUncordonNode(c *check.C) {
+	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	opts := provision.AddNodeOptions{
+		Address:  srv.URL(),
+		Metadata: map[string]string{labelNodePoolName.String(): "p1"},
+	}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	buf := bytes.NewBuffer(nil)
+	err = s.p.DrainNode(provision.DrainNodeOptions{Address: srv.URL(), Writer: buf})
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "Node "+srv.URL()+" drained\n")
+	node, err := s.p.GetNode(srv.URL())
+	c.Assert(err, check.IsNil)
+	c.Assert(node.Status(), check.Equals, "ready (drain)")
+	err = s.p.UncordonNode(srv.URL())
+	c.Assert(err, check.IsNil)
+	node, err = s.p.GetNode(srv.URL())
+	c.Assert(err, check.IsNil)
+	c.Assert(node.Status(), check.Equals, "ready")
+}
+
+func (s *S) TestDrainNodeNotFound(c *check.C) {
+	err := s.p.DrainNode(provision.DrainNodeOptions{Address: "localhost:1000"})
+	c.Assert(errors.Cause(err), check.Equals, provision.ErrNodeNotFound)
+}
+
 func (s *S) TestUpdateNodeNotFound(c *check.C) {
 	err := s.p.UpdateNode(provision.UpdateNodeOptions{
 		Address: "localhost:1000",
@@ -1401,6 +1694,64 @@ func (s *S) TestImageDeploy(c *check.C) {
 	})
 }
 
+func (s *S) TestImageDeployRegistryCredentials(c *check.C) {
+	config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:key")
+	var pullAuths []string
+	srv, err := testing.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		if r.URL.Path == "/images/create" && strings.HasPrefix(r.URL.Query().Get("fromImage"), "r.com/myimg") {
+			pullAuths = append(pullAuths, r.Header.Get("X-Registry-Auth"))
+		}
+	})
+	c.Assert(err, check.IsNil)
+	defer srv.Stop()
+	imageName := "r.com/myimg:v1"
+	srv.CustomHandler(fmt.Sprintf("/images/%s/json", imageName), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := docker.Image{
+			Config: &docker.Config{
+				Entrypoint:   []string{"/bin/sh", "-c", "python test.py"},
+				ExposedPorts: map[docker.Port]struct{}{"80/tcp": {}},
+			},
+		}
+		j, _ := json.Marshal(response)
+		w.Write(j)
+	}))
+	opts := provision.AddNodeOptions{Address: srv.URL(), Metadata: map[string]string{"pool": "bonehunters"}}
+	err = s.p.AddNode(opts)
+	c.Assert(err, check.IsNil)
+	a := &app.App{Name: "myapp", TeamOwner: s.team.Name}
+	err = app.CreateApp(a, s.user)
+	c.Assert(err, check.IsNil)
+	encrypted, err := encryption.Encrypt("secret")
+	c.Assert(err, check.IsNil)
+	err = s.conn.RegistryCredentials().Insert(registry.Credential{
+		Name:              "cred1",
+		Registry:          "r.com",
+		Username:          "user",
+		EncryptedPassword: encrypted,
+		Pool:              a.Pool,
+	})
+	c.Assert(err, check.IsNil)
+	attached := s.attachRegister(c, srv, false, a)
+	evt, err := event.New(&event.Opts{
+		Target:  event.Target{Type: event.TargetTypeApp, Value: a.GetName()},
+		Kind:    permission.PermAppDeploy,
+		Owner:   s.token,
+		Allowed: event.Allowed(permission.PermAppDeploy),
+	})
+	c.Assert(err, check.IsNil)
+	_, err = s.p.ImageDeploy(a, imageName, evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(<-attached, check.Equals, true)
+	c.Assert(pullAuths, check.HasLen, 1)
+	data, err := base64.StdEncoding.DecodeString(pullAuths[0])
+	c.Assert(err, check.IsNil)
+	var providedAuth docker.AuthConfiguration
+	err = json.Unmarshal(data, &providedAuth)
+	c.Assert(err, check.IsNil)
+	c.Assert(providedAuth, check.DeepEquals, docker.AuthConfiguration{Username: "user", Password: "secret", ServerAddress: "r.com"})
+}
+
 func (s *S) TestDestroy(c *check.C) {
 	srv, err := testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, check.IsNil)
@@ -18,11 +18,17 @@ func (q *Quota) Unlimited() bool {
 	return q.Limit == -1
 }
 
+// QuotaExceededError is returned when a request exceeds a quota. Resource
+// describes the limited resource, when the quota limits more than one.
 type QuotaExceededError struct {
+	Resource  string
 	Requested uint
 	Available uint
 }
 
 func (err *QuotaExceededError) Error() string {
+	if err.Resource != "" {
+		return fmt.Sprintf("Quota exceeded for %s. Available: %d. Requested: %d.", err.Resource, err.Available, err.Requested)
+	}
 	return fmt.Sprintf("Quota exceeded. Available: %d. Requested: %d.", err.Available, err.Requested)
 }
@@ -21,6 +21,11 @@ func (Suite) TestQuotaExceededError(c *check.C) {
 	c.Assert(err.Error(), check.Equals, "Quota exceeded. Available: 9. Requested: 10.")
 }
 
+func (Suite) TestQuotaExceededErrorWithResource(c *check.C) {
+	err := QuotaExceededError{Resource: "units of team myteam", Requested: 3, Available: 2}
+	c.Assert(err.Error(), check.Equals, "Quota exceeded for units of team myteam. Available: 2. Requested: 3.")
+}
+
 func (Suite) TestQuotaUnlimited(c *check.C) {
 	var q Quota
 	q.Limit = -1
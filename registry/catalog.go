@@ -0,0 +1,197 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	tsuruNet "github.com/tsuru/tsuru/net"
+)
+
+const manifestV2MediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+var ErrRegistryNotConfigured = errors.New("docker registry not configured")
+
+// Catalog lists and removes the images stored in the registry tsuru pushes
+// app images to, using the registry v2 API. Removing images requires the
+// registry to have deletion enabled.
+type Catalog struct {
+	Registry string
+	Scheme   string
+	Username string
+	Password string
+}
+
+// CatalogImage is a tag of a repository in the registry.
+type CatalogImage struct {
+	Repository string
+	Tag        string
+	Digest     string
+	Created    time.Time
+}
+
+// Name returns the full name of the image, including the registry host.
+func (i *CatalogImage) Name(registry string) string {
+	return fmt.Sprintf("%s/%s:%s", registry, i.Repository, i.Tag)
+}
+
+// TsuruCatalog returns the catalog of the registry set in docker:registry,
+// authenticating with the credentials in docker:registry-auth.
+func TsuruCatalog() (*Catalog, error) {
+	registry, _ := config.GetString("docker:registry")
+	if registry == "" {
+		return nil, ErrRegistryNotConfigured
+	}
+	scheme, _ := config.GetString("docker:registry-scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	c := &Catalog{Registry: registry, Scheme: scheme}
+	c.Username, _ = config.GetString("docker:registry-auth:username")
+	c.Password, _ = config.GetString("docker:registry-auth:password")
+	return c, nil
+}
+
+func (c *Catalog) do(method, path string, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s", c.Scheme, c.Registry, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return tsuruNet.Dial5Full60ClientNoKeepAlive.Do(req)
+}
+
+func (c *Catalog) getJSON(path, accept string, result interface{}) (*http.Response, error) {
+	resp, err := c.do("GET", path, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, errors.Errorf("unexpected status from registry %s for %s: %d", c.Registry, path, resp.StatusCode)
+	}
+	return resp, json.NewDecoder(resp.Body).Decode(result)
+}
+
+// nextPage returns the path of the next page from the Link header of a
+// paginated response, or an empty string on the last page.
+func nextPage(resp *http.Response) string {
+	link := resp.Header.Get("Link")
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start == -1 || end < start {
+		return ""
+	}
+	u, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return u.RequestURI()
+}
+
+// Repositories returns the name of all repositories in the registry.
+func (c *Catalog) Repositories() ([]string, error) {
+	var repos []string
+	path := "/v2/_catalog?n=100"
+	for path != "" {
+		var result struct {
+			Repositories []string `json:"repositories"`
+		}
+		resp, err := c.getJSON(path, "", &result)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, result.Repositories...)
+		path = nextPage(resp)
+	}
+	return repos, nil
+}
+
+// Tags returns the tags of the given repository.
+func (c *Catalog) Tags(repo string) ([]string, error) {
+	var tags []string
+	path := fmt.Sprintf("/v2/%s/tags/list?n=100", repo)
+	for path != "" {
+		var result struct {
+			Tags []string `json:"tags"`
+		}
+		resp, err := c.getJSON(path, "", &result)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, result.Tags...)
+		path = nextPage(resp)
+	}
+	return tags, nil
+}
+
+// Image returns the digest and the creation date of a tag in the registry.
+func (c *Catalog) Image(repo, tag string) (*CatalogImage, error) {
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		History []struct {
+			V1Compatibility string `json:"v1Compatibility"`
+		} `json:"history"`
+	}
+	resp, err := c.getJSON(fmt.Sprintf("/v2/%s/manifests/%s", repo, tag), manifestV2MediaType, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	img := CatalogImage{Repository: repo, Tag: tag, Digest: resp.Header.Get("Docker-Content-Digest")}
+	var imgConfig struct {
+		Created time.Time `json:"created"`
+	}
+	if manifest.Config.Digest != "" {
+		_, err = c.getJSON(fmt.Sprintf("/v2/%s/blobs/%s", repo, manifest.Config.Digest), "", &imgConfig)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(manifest.History) > 0 {
+		err = json.Unmarshal([]byte(manifest.History[0].V1Compatibility), &imgConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse manifest of %s:%s", repo, tag)
+		}
+	}
+	img.Created = imgConfig.Created
+	return &img, nil
+}
+
+// Remove removes the manifest of the image from the registry, along with all
+// tags pointing to it.
+func (c *Catalog) Remove(img *CatalogImage) error {
+	if img.Digest == "" {
+		return errors.Errorf("unable to remove %s:%s from registry: unknown digest", img.Repository, img.Tag)
+	}
+	resp, err := c.do("DELETE", fmt.Sprintf("/v2/%s/manifests/%s", img.Repository, img.Digest), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	}
+	return errors.Errorf("unexpected status removing %s:%s from registry %s: %d", img.Repository, img.Tag, c.Registry, resp.StatusCode)
+}
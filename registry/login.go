@@ -0,0 +1,102 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package registry
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	tsuruNet "github.com/tsuru/tsuru/net"
+)
+
+var loginCheck = checkLogin
+
+var errInvalidLogin = &tsuruErrors.ValidationError{Message: "invalid registry credentials: login failed"}
+
+func registryEndpoint(registry string) string {
+	if registry == defaultRegistry {
+		registry = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if isInsecureRegistry(registry) {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/", scheme, registry)
+}
+
+// checkLogin verifies the credentials against the registry v2 API, following
+// token authentication challenges when the registry uses them. Errors never
+// include the credentials themselves.
+func checkLogin(registry, username, password string) error {
+	resp, err := authenticatedGet(registryEndpoint(registry), username, password)
+	if err != nil {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("unable to reach registry %s: %s", registry, err)}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		challenge := resp.Header.Get("Www-Authenticate")
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return errInvalidLogin
+		}
+		return checkTokenLogin(challenge[len("bearer "):], username, password)
+	}
+	return &tsuruErrors.ValidationError{Message: fmt.Sprintf("unexpected status from registry %s: %d", registry, resp.StatusCode)}
+}
+
+func checkTokenLogin(challenge, username, password string) error {
+	params := parseChallenge(challenge)
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return errInvalidLogin
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("account", username)
+	realm.RawQuery = query.Encode()
+	resp, err := authenticatedGet(realm.String(), username, password)
+	if err != nil {
+		return &tsuruErrors.ValidationError{Message: fmt.Sprintf("unable to reach registry auth server %s: %s", realm.Host, err)}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return errInvalidLogin
+	}
+	return nil
+}
+
+func authenticatedGet(endpoint, username, password string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, password)
+	return tsuruNet.Dial5Full60ClientNoKeepAlive.Do(req)
+}
+
+// parseChallenge parses the parameters of a Www-Authenticate header, like
+// realm="https://auth.example.com/token",service="registry.example.com".
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.Split(challenge, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCheckLoginBasicAuth(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/v2/")
+		user, pass, _ := r.BasicAuth()
+		if user != "user" || pass != "secret" {
+			w.Header().Set("Www-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	err := checkLogin(u.Host, "user", "secret")
+	c.Assert(err, check.IsNil)
+	err = checkLogin(u.Host, "user", "wrong")
+	c.Assert(err, check.Equals, errInvalidLogin)
+}
+
+func (s *S) TestCheckLoginTokenAuth(c *check.C) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="myregistry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		c.Check(r.URL.Path, check.Equals, "/token")
+		c.Check(r.URL.Query().Get("service"), check.Equals, "myregistry")
+		user, pass, _ := r.BasicAuth()
+		if user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	err := checkLogin(u.Host, "user", "secret")
+	c.Assert(err, check.IsNil)
+	err = checkLogin(u.Host, "user", "wrong")
+	c.Assert(err, check.Equals, errInvalidLogin)
+}
+
+func (s *S) TestCheckLoginErrorDoesNotLeakPassword(c *check.C) {
+	err := checkLogin("127.0.0.1:1", "user", "secret")
+	c.Assert(err, check.FitsTypeOf, &tsuruErrors.ValidationError{})
+	c.Assert(err, check.ErrorMatches, "unable to reach registry 127.0.0.1:1: .*")
+	c.Assert(err, check.Not(check.ErrorMatches), ".*secret.*")
+}
+
+func (s *S) TestRegistryEndpoint(c *check.C) {
+	c.Assert(registryEndpoint("docker.io"), check.Equals, "https://registry-1.docker.io/v2/")
+	c.Assert(registryEndpoint("registry.example.com"), check.Equals, "https://registry.example.com/v2/")
+	c.Assert(registryEndpoint("localhost:5000"), check.Equals, "http://localhost:5000/v2/")
+	c.Assert(registryEndpoint("127.0.0.1:5000"), check.Equals, "http://127.0.0.1:5000/v2/")
+}
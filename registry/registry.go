@@ -0,0 +1,277 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package registry manages credentials used to pull images from private
+// docker registries. Credentials are scoped to a team or to a pool, and their
+// passwords are stored encrypted.
+package registry
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/encryption"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultRegistry = "docker.io"
+
+var (
+	ErrCredentialNotFound      = errors.New("registry credential not found")
+	ErrCredentialAlreadyExists = errors.New("registry credential already exists")
+)
+
+// Credential holds the login information for a registry. The plain text
+// password is never stored nor serialized to JSON.
+type Credential struct {
+	Name              string `bson:"_id"`
+	Registry          string
+	Username          string
+	Password          string `bson:"-" json:"-"`
+	EncryptedPassword string `json:"-"`
+	Team              string `bson:",omitempty" json:",omitempty"`
+	Pool              string `bson:",omitempty" json:",omitempty"`
+}
+
+// Filter limits the credentials returned by List to the ones belonging to
+// the given teams or pools.
+type Filter struct {
+	Teams []string
+	Pools []string
+}
+
+func (c *Credential) validate() error {
+	if c.Name == "" {
+		return &tsuruErrors.ValidationError{Message: "credential name is required"}
+	}
+	if c.Registry == "" {
+		return &tsuruErrors.ValidationError{Message: "registry is required"}
+	}
+	if c.Username == "" || c.Password == "" {
+		return &tsuruErrors.ValidationError{Message: "username and password are required"}
+	}
+	if (c.Team == "") == (c.Pool == "") {
+		return &tsuruErrors.ValidationError{Message: "exactly one of team or pool must be set"}
+	}
+	if c.Team != "" {
+		if _, err := auth.GetTeam(c.Team); err != nil {
+			if err == auth.ErrTeamNotFound {
+				return &tsuruErrors.ValidationError{Message: err.Error()}
+			}
+			return err
+		}
+	}
+	if c.Pool != "" {
+		if _, err := provision.GetPoolByName(c.Pool); err != nil {
+			if err == provision.ErrPoolNotFound {
+				return &tsuruErrors.ValidationError{Message: err.Error()}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Create validates the credential, checking it against the registry, and
+// stores it with its password encrypted.
+func Create(c Credential) error {
+	c.Registry = normalizeRegistry(c.Registry)
+	err := c.validate()
+	if err != nil {
+		return err
+	}
+	err = loginCheck(c.Registry, c.Username, c.Password)
+	if err != nil {
+		return err
+	}
+	c.EncryptedPassword, err = encryption.Encrypt(c.Password)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.RegistryCredentials().Insert(c)
+	if mgo.IsDup(err) {
+		return ErrCredentialAlreadyExists
+	}
+	return err
+}
+
+// List returns the stored credentials, without their passwords.
+func List(filter *Filter) ([]Credential, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := bson.M{}
+	if filter != nil {
+		query["$or"] = []bson.M{
+			{"team": bson.M{"$in": append([]string{}, filter.Teams...)}},
+			{"pool": bson.M{"$in": append([]string{}, filter.Pools...)}},
+		}
+	}
+	var creds []Credential
+	err = conn.RegistryCredentials().Find(query).Sort("_id").All(&creds)
+	if err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// Get returns the credential with the given name, without its password.
+func Get(name string) (*Credential, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var c Credential
+	err = conn.RegistryCredentials().FindId(name).One(&c)
+	if err == mgo.ErrNotFound {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func Remove(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.RegistryCredentials().RemoveId(name)
+	if err == mgo.ErrNotFound {
+		return ErrCredentialNotFound
+	}
+	return err
+}
+
+// RotatePasswords encrypts the passwords of all credentials with the current
+// encryption key, returning the number of credentials changed. It must be
+// called after changing encryption:key, with the old key listed in
+// encryption:previous-keys.
+func RotatePasswords() (int, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	var creds []Credential
+	err = conn.RegistryCredentials().Find(nil).Select(bson.M{"encryptedpassword": 1}).All(&creds)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, c := range creds {
+		password, changed, err := encryption.Rewrap(c.EncryptedPassword)
+		if err != nil {
+			return count, errors.Wrapf(err, "unable to rotate registry credential %q", c.Name)
+		}
+		if !changed {
+			continue
+		}
+		err = conn.RegistryCredentials().UpdateId(c.Name, bson.M{"$set": bson.M{"encryptedpassword": password}})
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// FindForImage returns the credential, with its decrypted password, that
+// should be used to pull the given image for an app owned by team in pool.
+// Team credentials take precedence over pool credentials.
+// ErrCredentialNotFound is returned when no credential matches.
+func FindForImage(image, team, pool string) (*Credential, error) {
+	var scopes []bson.M
+	if team != "" {
+		scopes = append(scopes, bson.M{"team": team})
+	}
+	if pool != "" {
+		scopes = append(scopes, bson.M{"pool": pool})
+	}
+	if len(scopes) == 0 {
+		return nil, ErrCredentialNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var creds []Credential
+	query := bson.M{"registry": ImageRegistry(image), "$or": scopes}
+	err = conn.RegistryCredentials().Find(query).Sort("_id").All(&creds)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, ErrCredentialNotFound
+	}
+	c := creds[0]
+	for _, cred := range creds {
+		if cred.Team != "" {
+			c = cred
+			break
+		}
+	}
+	c.Password, err = encryption.Decrypt(c.EncryptedPassword)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to decrypt registry credential %q", c.Name)
+	}
+	return &c, nil
+}
+
+// ImageRegistry returns the registry host of the given image name, following
+// the docker convention of considering the first path component a registry
+// only if it looks like a host name.
+func ImageRegistry(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 1 {
+		return defaultRegistry
+	}
+	host := parts[0]
+	if host != "localhost" && !strings.ContainsAny(host, ".:") {
+		return defaultRegistry
+	}
+	return normalizeRegistry(host)
+}
+
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry = strings.TrimRight(registry, "/")
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io":
+		return defaultRegistry
+	}
+	return registry
+}
+
+// isInsecureRegistry follows the docker daemon default of only allowing
+// plain http registries on the loopback interface.
+func isInsecureRegistry(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
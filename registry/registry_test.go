@@ -0,0 +1,143 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package registry
+
+import (
+	"errors"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/encryption"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCreate(c *check.C) {
+	var loginArgs []string
+	loginCheck = func(registry, username, password string) error {
+		loginArgs = []string{registry, username, password}
+		return nil
+	}
+	err := Create(Credential{Name: "cred1", Registry: "https://registry.example.com/", Username: "user", Password: "secret", Team: "myteam"})
+	c.Assert(err, check.IsNil)
+	c.Assert(loginArgs, check.DeepEquals, []string{"registry.example.com", "user", "secret"})
+	var stored map[string]interface{}
+	err = s.storage.RegistryCredentials().FindId("cred1").One(&stored)
+	c.Assert(err, check.IsNil)
+	c.Assert(stored["password"], check.IsNil)
+	c.Assert(stored["encryptedpassword"], check.Not(check.Equals), "secret")
+	plain, err := encryption.Decrypt(stored["encryptedpassword"].(string))
+	c.Assert(err, check.IsNil)
+	c.Assert(plain, check.Equals, "secret")
+	cred, err := Get("cred1")
+	c.Assert(err, check.IsNil)
+	c.Assert(cred.Registry, check.Equals, "registry.example.com")
+	c.Assert(cred.Password, check.Equals, "")
+	err = Create(Credential{Name: "cred1", Registry: "registry.example.com", Username: "user", Password: "secret", Pool: "mypool"})
+	c.Assert(err, check.Equals, ErrCredentialAlreadyExists)
+}
+
+func (s *S) TestCreateValidation(c *check.C) {
+	tests := []struct {
+		cred    Credential
+		message string
+	}{
+		{Credential{Registry: "r.com", Username: "u", Password: "p", Team: "myteam"}, "credential name is required"},
+		{Credential{Name: "c", Username: "u", Password: "p", Team: "myteam"}, "registry is required"},
+		{Credential{Name: "c", Registry: "r.com", Username: "u", Team: "myteam"}, "username and password are required"},
+		{Credential{Name: "c", Registry: "r.com", Username: "u", Password: "p"}, "exactly one of team or pool must be set"},
+		{Credential{Name: "c", Registry: "r.com", Username: "u", Password: "p", Team: "myteam", Pool: "mypool"}, "exactly one of team or pool must be set"},
+		{Credential{Name: "c", Registry: "r.com", Username: "u", Password: "p", Team: "otherteam"}, "team not found"},
+		{Credential{Name: "c", Registry: "r.com", Username: "u", Password: "p", Pool: "otherpool"}, "Pool does not exist."},
+	}
+	for _, tt := range tests {
+		err := Create(tt.cred)
+		c.Check(err, check.DeepEquals, &tsuruErrors.ValidationError{Message: tt.message})
+	}
+	creds, err := List(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(creds, check.HasLen, 0)
+}
+
+func (s *S) TestCreateLoginFailure(c *check.C) {
+	loginCheck = func(registry, username, password string) error {
+		return errors.New("login failed")
+	}
+	err := Create(Credential{Name: "cred1", Registry: "r.com", Username: "user", Password: "secret", Team: "myteam"})
+	c.Assert(err, check.ErrorMatches, "login failed")
+	_, err = Get("cred1")
+	c.Assert(err, check.Equals, ErrCredentialNotFound)
+}
+
+func (s *S) TestListAndRemove(c *check.C) {
+	err := Create(Credential{Name: "cred1", Registry: "r.com", Username: "user", Password: "secret", Team: "myteam"})
+	c.Assert(err, check.IsNil)
+	err = Create(Credential{Name: "cred2", Registry: "r.com", Username: "user", Password: "secret", Pool: "mypool"})
+	c.Assert(err, check.IsNil)
+	creds, err := List(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(creds, check.HasLen, 2)
+	creds, err = List(&Filter{Pools: []string{"mypool"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(creds, check.HasLen, 1)
+	c.Assert(creds[0].Name, check.Equals, "cred2")
+	creds, err = List(&Filter{})
+	c.Assert(err, check.IsNil)
+	c.Assert(creds, check.HasLen, 0)
+	err = Remove("cred1")
+	c.Assert(err, check.IsNil)
+	err = Remove("cred1")
+	c.Assert(err, check.Equals, ErrCredentialNotFound)
+	creds, err = List(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(creds, check.HasLen, 1)
+}
+
+func (s *S) TestFindForImage(c *check.C) {
+	err := Create(Credential{Name: "a-pool", Registry: "r.com", Username: "pooluser", Password: "poolpass", Pool: "mypool"})
+	c.Assert(err, check.IsNil)
+	cred, err := FindForImage("r.com/myimg:v1", "myteam", "mypool")
+	c.Assert(err, check.IsNil)
+	c.Assert(cred.Username, check.Equals, "pooluser")
+	c.Assert(cred.Password, check.Equals, "poolpass")
+	err = Create(Credential{Name: "b-team", Registry: "r.com", Username: "teamuser", Password: "teampass", Team: "myteam"})
+	c.Assert(err, check.IsNil)
+	cred, err = FindForImage("r.com/myimg:v1", "myteam", "mypool")
+	c.Assert(err, check.IsNil)
+	c.Assert(cred.Username, check.Equals, "teamuser")
+	c.Assert(cred.Password, check.Equals, "teampass")
+	_, err = FindForImage("other.com/myimg:v1", "myteam", "mypool")
+	c.Assert(err, check.Equals, ErrCredentialNotFound)
+	_, err = FindForImage("r.com/myimg:v1", "otherteam", "otherpool")
+	c.Assert(err, check.Equals, ErrCredentialNotFound)
+}
+
+func (s *S) TestImageRegistry(c *check.C) {
+	c.Assert(ImageRegistry("myimg"), check.Equals, "docker.io")
+	c.Assert(ImageRegistry("tsuru/myimg:v1"), check.Equals, "docker.io")
+	c.Assert(ImageRegistry("docker.io/tsuru/myimg"), check.Equals, "docker.io")
+	c.Assert(ImageRegistry("index.docker.io/tsuru/myimg"), check.Equals, "docker.io")
+	c.Assert(ImageRegistry("localhost/myimg"), check.Equals, "localhost")
+	c.Assert(ImageRegistry("localhost:5000/myimg"), check.Equals, "localhost:5000")
+	c.Assert(ImageRegistry("registry.example.com/team/myimg:v1"), check.Equals, "registry.example.com")
+}
+
+func (s *S) TestRotatePasswords(c *check.C) {
+	defer config.Set("encryption:key", "my-secret-key")
+	defer config.Unset("encryption:previous-keys")
+	err := Create(Credential{Name: "cred1", Registry: "registry.example.com", Username: "user", Password: "secret", Team: "myteam"})
+	c.Assert(err, check.IsNil)
+	n, err := RotatePasswords()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+	config.Set("encryption:key", "new-key")
+	config.Set("encryption:previous-keys", []interface{}{"my-secret-key"})
+	n, err = RotatePasswords()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 1)
+	config.Unset("encryption:previous-keys")
+	cred, err := FindForImage("registry.example.com/tsuru/app", "myteam", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(cred.Password, check.Equals, "secret")
+}
@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tsuru/config"
@@ -124,6 +125,12 @@ type TLSRouter interface {
 	GetCertificate(cname string) (string, error)
 }
 
+// TrafficRouter is a router able to report the last time a backend received
+// a request. It's used to put idle apps to sleep.
+type TrafficRouter interface {
+	LastRequest(name string) (time.Time, error)
+}
+
 type HealthcheckData struct {
 	Path   string
 	Status int
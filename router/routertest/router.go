@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tsuru/tsuru/router"
@@ -45,7 +46,7 @@ func createTLSRouter(name, prefix string) (router.Router, error) {
 }
 
 func newFakeRouter() fakeRouter {
-	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), mutex: &sync.Mutex{}}
+	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), healthcheck: make(map[string]router.HealthcheckData), lastRequest: make(map[string]time.Time), mutex: &sync.Mutex{}}
 }
 
 type fakeRouter struct {
@@ -53,6 +54,7 @@ type fakeRouter struct {
 	cnames       map[string]string
 	failuresByIp map[string]bool
 	healthcheck  map[string]router.HealthcheckData
+	lastRequest  map[string]time.Time
 	mutex        *sync.Mutex
 }
 
@@ -137,6 +139,25 @@ func (r *fakeRouter) HasRoute(name, address string) bool {
 	return false
 }
 
+func (r *fakeRouter) SetLastRequest(name string, t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastRequest[name] = t
+}
+
+func (r *fakeRouter) LastRequest(name string) (time.Time, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.backends[backendName]; !ok {
+		return time.Time{}, router.ErrBackendNotFound
+	}
+	return r.lastRequest[backendName], nil
+}
+
 func (r *fakeRouter) AddBackend(name string) error {
 	if r.HasBackend(name) {
 		return router.ErrBackendExists
@@ -343,6 +364,7 @@ func (r *fakeRouter) Reset() {
 	r.failuresByIp = make(map[string]bool)
 	r.cnames = make(map[string]string)
 	r.healthcheck = make(map[string]router.HealthcheckData)
+	r.lastRequest = make(map[string]time.Time)
 }
 
 func (r *fakeRouter) Routes(name string) ([]*url.URL, error) {
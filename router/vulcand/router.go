@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/hc"
@@ -389,6 +390,34 @@ func (r *vulcandRouter) Routes(name string) (routes []*url.URL, err error) {
 	return routes, nil
 }
 
+// LastRequest returns the current time when any frontend of the app received
+// requests in the stats window of vulcand, or the zero time otherwise, as
+// vulcand doesn't keep the time of the last request.
+func (r *vulcandRouter) LastRequest(name string) (last time.Time, err error) {
+	done := router.InstrumentRequest(r.routerName)
+	defer func() {
+		done(err)
+	}()
+	usedName, err := router.Retrieve(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	backendKey := engine.BackendKey{Id: r.backendName(usedName)}
+	if found, _ := r.client.GetBackend(backendKey); found == nil {
+		return time.Time{}, router.ErrBackendNotFound
+	}
+	frontends, err := r.client.TopFrontends(&backendKey, 0)
+	if err != nil {
+		return time.Time{}, &router.RouterError{Err: err, Op: "last-request"}
+	}
+	for _, f := range frontends {
+		if f.Stats != nil && f.Stats.Counters.Total > 0 {
+			return time.Now().UTC(), nil
+		}
+	}
+	return time.Time{}, nil
+}
+
 func (r *vulcandRouter) StartupMessage() (string, error) {
 	message := fmt.Sprintf("vulcand router %q with API at %q", r.domain, r.client.Addr)
 	return message, nil
@@ -7,6 +7,7 @@
 package vulcand
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -433,6 +434,43 @@ func (s *S) TestRoutes(c *check.C) {
 	c.Assert(routes, check.DeepEquals, []*url.URL{u1, u2})
 }
 
+func (s *S) TestLastRequest(c *check.C) {
+	vRouter, err := router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	err = vRouter.AddBackend("myapp")
+	c.Assert(err, check.IsNil)
+	var total int64
+	apiHandler := s.vulcandServer.Config.Handler
+	statsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/top/frontends" {
+			apiHandler.ServeHTTP(w, r)
+			return
+		}
+		c.Check(r.URL.Query().Get("backendId"), check.Equals, "tsuru_myapp")
+		frontends, err := s.engine.GetFrontends()
+		c.Check(err, check.IsNil)
+		for i := range frontends {
+			frontends[i].Stats = &engine.RoundTripStats{Counters: engine.Counters{Period: 10 * time.Second, Total: total}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Frontends": frontends})
+	}))
+	defer statsServer.Close()
+	config.Set("routers:vulcand:api-url", statsServer.URL)
+	vRouter, err = router.Get("vulcand")
+	c.Assert(err, check.IsNil)
+	trafficRouter, ok := vRouter.(router.TrafficRouter)
+	c.Assert(ok, check.Equals, true)
+	last, err := trafficRouter.LastRequest("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(last.IsZero(), check.Equals, true)
+	total = 3
+	last, err = trafficRouter.LastRequest("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(time.Since(last) < time.Minute, check.Equals, true)
+	_, err = trafficRouter.LastRequest("otherapp")
+	c.Assert(err, check.Equals, router.ErrBackendNotFound)
+}
+
 func (s *S) TestStartupMessage(c *check.C) {
 	got, err := router.Get("vulcand")
 	c.Assert(err, check.IsNil)
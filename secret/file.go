@@ -0,0 +1,54 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+)
+
+func init() {
+	Register("file", newFileProvider)
+}
+
+// fileProvider reads secrets from JSON files in a directory. Each file holds
+// an object with the keys of the secret, and references to files that are not
+// valid JSON objects, without a key, resolve to the whole file content.
+type fileProvider struct {
+	root string
+}
+
+func newFileProvider(name, configPrefix string) (Provider, error) {
+	root, err := config.GetString(configPrefix + ":path")
+	if err != nil {
+		return nil, errors.Errorf("config key '%s:path' not found", configPrefix)
+	}
+	return &fileProvider{root: root}, nil
+}
+
+func (p *fileProvider) Get(path, key string) (string, error) {
+	clean := filepath.Clean("/" + path)
+	data, err := ioutil.ReadFile(filepath.Join(p.root, clean))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrSecretNotFound
+		}
+		return "", errors.WithStack(err)
+	}
+	var values map[string]interface{}
+	if err = json.Unmarshal(data, &values); err != nil {
+		if key != "" {
+			return "", errors.Wrapf(err, "invalid secret file %q", path)
+		}
+		return strings.TrimRight(string(data), "\n"), nil
+	}
+	return lookupKey(values, key)
+}
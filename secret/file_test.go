@@ -0,0 +1,55 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/config"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestFileProvider(c *check.C) {
+	dir, err := ioutil.TempDir("", "secrets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "myapp"), 0700)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "myapp", "db"), []byte(`{"user": "root", "port": 3306}`), 0600)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "token"), []byte("abc123\n"), 0600)
+	c.Assert(err, check.IsNil)
+	config.Set("secret-providers:files:type", "file")
+	config.Set("secret-providers:files:path", dir)
+	config.Set("secret-providers:files:allowed-paths", []interface{}{"{team}"})
+	provider, err := Get("files")
+	c.Assert(err, check.IsNil)
+	value, err := provider.Get("myapp/db", "user")
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "root")
+	value, err = provider.Get("myapp/db", "port")
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "3306")
+	value, err = provider.Get("token", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "abc123")
+	_, err = provider.Get("myapp/db", "password")
+	c.Assert(err, check.Equals, ErrSecretNotFound)
+	_, err = provider.Get("myapp/other", "user")
+	c.Assert(err, check.Equals, ErrSecretNotFound)
+	_, err = provider.Get("../../etc/passwd", "")
+	c.Assert(err, check.Equals, ErrSecretNotFound)
+	value, err = Resolve("files://myapp/db#user", Scope{Team: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "root")
+}
+
+func (s *S) TestFileProviderWithoutPath(c *check.C) {
+	config.Set("secret-providers:files:type", "file")
+	_, err := Get("files")
+	c.Assert(err, check.ErrorMatches, `config key 'secret-providers:files:path' not found`)
+}
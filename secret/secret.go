@@ -0,0 +1,175 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package secret provides interfaces that need to be satisfied in order to
+// implement external secret stores, used to resolve the value of environment
+// variables set as references to them.
+//
+// Secret providers are configured in the secret-providers config entry, keyed
+// by name, and references have the format <name>://<path>#<key>. Apps may only
+// reference paths under the allowed-paths of the provider.
+package secret
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+)
+
+type providerFactory func(name, configPrefix string) (Provider, error)
+
+var (
+	ErrInvalidReference = errors.New("invalid secret reference, expected <provider>://<path>#<key>")
+	ErrSecretNotFound   = errors.New("secret not found")
+	ErrPathNotAllowed   = errors.New("secret path not allowed")
+)
+
+var providers = make(map[string]providerFactory)
+
+// Register registers a new type of secret provider.
+func Register(providerType string, factory providerFactory) {
+	providers[providerType] = factory
+}
+
+// Provider is the interface of external secret stores.
+type Provider interface {
+	// Get returns the value of key in the secret stored at path. An empty key
+	// is used for secrets with a single value.
+	Get(path, key string) (string, error)
+}
+
+// Scope identifies the owner of a reference, restricting the paths it's
+// allowed to read.
+type Scope struct {
+	Team string
+	Pool string
+}
+
+// Reference points to a value stored in a secret provider.
+type Reference struct {
+	Provider string
+	Path     string
+	Key      string
+}
+
+func (r Reference) String() string {
+	ref := r.Provider + "://" + r.Path
+	if r.Key != "" {
+		ref += "#" + r.Key
+	}
+	return ref
+}
+
+// ParseReference parses value as a reference to a secret. It returns false
+// when value doesn't use one of the configured secret providers, meaning it
+// should be used as is.
+func ParseReference(value string) (Reference, bool, error) {
+	parts := strings.SplitN(value, "://", 2)
+	if len(parts) != 2 || !isConfigured(parts[0]) {
+		return Reference{}, false, nil
+	}
+	ref := Reference{Provider: parts[0], Path: parts[1]}
+	if i := strings.LastIndex(ref.Path, "#"); i >= 0 {
+		ref.Path, ref.Key = ref.Path[:i], ref.Path[i+1:]
+	}
+	if ref.Path == "" {
+		return ref, true, ErrInvalidReference
+	}
+	return ref, true, nil
+}
+
+// IsReference returns whether value is a reference to a secret stored in one
+// of the configured secret providers.
+func IsReference(value string) bool {
+	_, ok, _ := ParseReference(value)
+	return ok
+}
+
+func isConfigured(name string) bool {
+	if name == "" {
+		return false
+	}
+	_, err := config.Get("secret-providers:" + name)
+	return err == nil
+}
+
+// Get gets the named secret provider from the registry.
+func Get(name string) (Provider, error) {
+	prefix := "secret-providers:" + name
+	providerType, err := config.GetString(prefix + ":type")
+	if err != nil {
+		return nil, errors.Errorf("config key '%s:type' not found", prefix)
+	}
+	factory, ok := providers[providerType]
+	if !ok {
+		return nil, errors.Errorf("unknown secret provider: %q.", providerType)
+	}
+	return factory(name, prefix)
+}
+
+// Resolve returns the value referenced by value, or value itself when it's
+// not a reference to a secret. References to paths not allowed for the scope
+// are refused.
+func Resolve(value string, scope Scope) (string, error) {
+	ref, ok, err := ParseReference(value)
+	if !ok || err != nil {
+		return value, err
+	}
+	refPath, allowed := allowedPath(ref, scope)
+	if !allowed {
+		return "", errors.Wrapf(ErrPathNotAllowed, "unable to resolve secret %s", ref)
+	}
+	provider, err := Get(ref.Provider)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := provider.Get(refPath, ref.Key)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to resolve secret %s", ref)
+	}
+	return resolved, nil
+}
+
+// allowedPath returns the cleaned path of the reference and whether it's
+// under one of the allowed-paths of its provider. The {team} and {pool}
+// placeholders in allowed paths are replaced by the values in scope, and
+// paths using a placeholder without value are ignored.
+func allowedPath(ref Reference, scope Scope) (string, bool) {
+	refPath := path.Clean("/" + ref.Path)
+	prefixes, _ := config.GetList("secret-providers:" + ref.Provider + ":allowed-paths")
+	replacer := strings.NewReplacer("{team}", scope.Team, "{pool}", scope.Pool)
+	for _, prefix := range prefixes {
+		if (scope.Team == "" && strings.Contains(prefix, "{team}")) ||
+			(scope.Pool == "" && strings.Contains(prefix, "{pool}")) {
+			continue
+		}
+		prefix = path.Clean("/" + replacer.Replace(prefix))
+		if refPath == prefix || strings.HasPrefix(refPath, strings.TrimSuffix(prefix, "/")+"/") {
+			return strings.TrimPrefix(refPath, "/"), true
+		}
+	}
+	return "", false
+}
+
+func lookupKey(data map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(data) != 1 {
+			return "", ErrInvalidReference
+		}
+		for k := range data {
+			key = k
+		}
+	}
+	v, ok := data[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
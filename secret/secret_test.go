@@ -0,0 +1,116 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"github.com/tsuru/config"
+	"gopkg.in/check.v1"
+)
+
+type fakeProvider map[string]string
+
+func (p fakeProvider) Get(path, key string) (string, error) {
+	v, ok := p[path+"#"+key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return v, nil
+}
+
+func init() {
+	Register("fake", func(name, prefix string) (Provider, error) {
+		return fakeProvider{"db#password": "s3cr3t"}, nil
+	})
+}
+
+func (s *S) TestParseReference(c *check.C) {
+	config.Set("secret-providers:vault:type", "vault")
+	tests := []struct {
+		value string
+		ref   Reference
+		ok    bool
+		err   error
+	}{
+		{value: "vault://secret/db#password", ref: Reference{Provider: "vault", Path: "secret/db", Key: "password"}, ok: true},
+		{value: "vault://secret/db", ref: Reference{Provider: "vault", Path: "secret/db"}, ok: true},
+		{value: "vault://#password", ref: Reference{Provider: "vault", Key: "password"}, ok: true, err: ErrInvalidReference},
+		{value: "http://example.com#anchor"},
+		{value: "plain value"},
+		{value: "://x"},
+	}
+	for _, tt := range tests {
+		ref, ok, err := ParseReference(tt.value)
+		c.Check(err, check.Equals, tt.err, check.Commentf("value: %q", tt.value))
+		c.Check(ok, check.Equals, tt.ok, check.Commentf("value: %q", tt.value))
+		c.Check(ref, check.DeepEquals, tt.ref, check.Commentf("value: %q", tt.value))
+	}
+}
+
+func (s *S) TestReferenceString(c *check.C) {
+	c.Assert(Reference{Provider: "vault", Path: "secret/db", Key: "user"}.String(), check.Equals, "vault://secret/db#user")
+	c.Assert(Reference{Provider: "vault", Path: "secret/db"}.String(), check.Equals, "vault://secret/db")
+}
+
+func (s *S) TestResolve(c *check.C) {
+	config.Set("secret-providers:store:type", "fake")
+	config.Set("secret-providers:store:allowed-paths", []interface{}{"db"})
+	value, err := Resolve("store://db#password", Scope{})
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "s3cr3t")
+	value, err = Resolve("plain value", Scope{})
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "plain value")
+	_, err = Resolve("store://db#user", Scope{})
+	c.Assert(err, check.ErrorMatches, `unable to resolve secret store://db#user: secret not found`)
+}
+
+func (s *S) TestResolveAllowedPaths(c *check.C) {
+	Register("fake-scoped", func(name, prefix string) (Provider, error) {
+		return fakeProvider{
+			"teams/myteam/db#password":   "s3cr3t",
+			"teams/other/db#password":    "other",
+			"pools/mypool/db#password":   "pool",
+			"shared#password":            "shared",
+			"teams/myteam-dev/db#secret": "dev",
+		}, nil
+	})
+	config.Set("secret-providers:store:type", "fake-scoped")
+	config.Set("secret-providers:store:allowed-paths", []interface{}{"teams/{team}/", "pools/{pool}"})
+	scope := Scope{Team: "myteam", Pool: "mypool"}
+	tests := []struct {
+		value    string
+		expected string
+		err      string
+	}{
+		{value: "store://teams/myteam/db#password", expected: "s3cr3t"},
+		{value: "store://pools/mypool/db#password", expected: "pool"},
+		{value: "store://teams/other/db#password", err: "unable to resolve secret store://teams/other/db#password: secret path not allowed"},
+		{value: "store://teams/myteam/../other/db#password", err: "unable to resolve secret store://teams/myteam/../other/db#password: secret path not allowed"},
+		{value: "store://teams/myteam-dev/db#secret", err: "unable to resolve secret store://teams/myteam-dev/db#secret: secret path not allowed"},
+		{value: "store://shared#password", err: "unable to resolve secret store://shared#password: secret path not allowed"},
+	}
+	for _, tt := range tests {
+		value, err := Resolve(tt.value, scope)
+		if tt.err != "" {
+			c.Check(err, check.ErrorMatches, tt.err, check.Commentf("value: %q", tt.value))
+			continue
+		}
+		c.Check(err, check.IsNil, check.Commentf("value: %q", tt.value))
+		c.Check(value, check.Equals, tt.expected, check.Commentf("value: %q", tt.value))
+	}
+	_, err := Resolve("store://teams/myteam/db#password", Scope{Pool: "mypool"})
+	c.Assert(err, check.ErrorMatches, ".*secret path not allowed")
+	config.Unset("secret-providers:store:allowed-paths")
+	_, err = Resolve("store://teams/myteam/db#password", scope)
+	c.Assert(err, check.ErrorMatches, ".*secret path not allowed")
+}
+
+func (s *S) TestGetUnknownType(c *check.C) {
+	config.Set("secret-providers:store:type", "unknown")
+	_, err := Get("store")
+	c.Assert(err, check.ErrorMatches, `unknown secret provider: "unknown".`)
+	_, err = Get("other")
+	c.Assert(err, check.ErrorMatches, `config key 'secret-providers:other:type' not found`)
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/net"
+)
+
+func init() {
+	Register("vault", newVaultProvider)
+}
+
+// vaultProvider reads secrets using the HTTP API of Vault, supporting both
+// versions of its key/value secrets engine.
+type vaultProvider struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+func newVaultProvider(name, configPrefix string) (Provider, error) {
+	address, err := config.GetString(configPrefix + ":address")
+	if err != nil {
+		return nil, errors.Errorf("config key '%s:address' not found", configPrefix)
+	}
+	token, _ := config.GetString(configPrefix + ":token")
+	return &vaultProvider{
+		address: strings.TrimRight(address, "/"),
+		token:   token,
+		client:  net.Dial5Full60ClientNoKeepAlive,
+	}, nil
+}
+
+func (p *vaultProvider) Get(path, key string) (string, error) {
+	req, err := http.NewRequest("GET", p.address+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if p.token != "" {
+		req.Header.Set("X-Vault-Token", p.token)
+	}
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNotFound {
+		return "", ErrSecretNotFound
+	}
+	if rsp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(rsp.Body)
+		return "", errors.Errorf("invalid response from vault (%d): %s", rsp.StatusCode, body)
+	}
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.NewDecoder(rsp.Body).Decode(&result)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid response from vault")
+	}
+	data := result.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	return lookupKey(data, key)
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package secret
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/tsuru/config"
+	"gopkg.in/check.v1"
+)
+
+func vaultStub() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "my-token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/db":
+			w.Write([]byte(`{"data": {"user": "root", "password": "s3cr3t"}}`))
+		case "/v1/kv/data/db":
+			w.Write([]byte(`{"data": {"data": {"password": "v2-s3cr3t"}, "metadata": {"version": 2}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+}
+
+func (s *S) TestVaultProvider(c *check.C) {
+	srv := vaultStub()
+	defer srv.Close()
+	config.Set("secret-providers:vault:type", "vault")
+	config.Set("secret-providers:vault:address", srv.URL+"/")
+	config.Set("secret-providers:vault:token", "my-token")
+	config.Set("secret-providers:vault:allowed-paths", []interface{}{"secret", "kv/data"})
+	value, err := Resolve("vault://secret/db#password", Scope{})
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "s3cr3t")
+	value, err = Resolve("vault://kv/data/db#password", Scope{})
+	c.Assert(err, check.IsNil)
+	c.Assert(value, check.Equals, "v2-s3cr3t")
+	provider, err := Get("vault")
+	c.Assert(err, check.IsNil)
+	_, err = provider.Get("secret/db", "other")
+	c.Assert(err, check.Equals, ErrSecretNotFound)
+	_, err = provider.Get("secret/unknown", "password")
+	c.Assert(err, check.Equals, ErrSecretNotFound)
+	_, err = provider.Get("secret/db", "")
+	c.Assert(err, check.Equals, ErrInvalidReference)
+}
+
+func (s *S) TestVaultProviderInvalidToken(c *check.C) {
+	srv := vaultStub()
+	defer srv.Close()
+	config.Set("secret-providers:vault:type", "vault")
+	config.Set("secret-providers:vault:address", srv.URL)
+	config.Set("secret-providers:vault:token", "wrong")
+	config.Set("secret-providers:vault:allowed-paths", []interface{}{"secret"})
+	_, err := Resolve("vault://secret/db#password", Scope{})
+	c.Assert(err, check.ErrorMatches, `(?s)unable to resolve secret vault://secret/db#password: invalid response from vault \(403\).*permission denied.*`)
+}
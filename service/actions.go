@@ -18,7 +18,8 @@ import (
 )
 
 // createServiceInstance is an action that calls the service endpoint
-// to create a service instance.
+// to create a service instance. The resulting instance is ready, unless the
+// endpoint marked it as pending.
 //
 // The first argument in the context must be a Service.
 // The second argument in the context must be a ServiceInstance.
@@ -49,6 +50,9 @@ var createServiceInstance = action.Action{
 		if err != nil {
 			return nil, err
 		}
+		if instance.State == "" {
+			instance.State = StateReady
+		}
 		return instance, nil
 	},
 	Backward: func(ctx action.BWContext) {
@@ -75,20 +79,28 @@ var createServiceInstance = action.Action{
 
 // insertServiceInstance is an action that inserts an instance in the database.
 //
-// The first argument in the context must be a Service Instance.
+// The second argument in the context must be a Service Instance. The instance
+// returned by the previous action, if any, takes precedence over it.
 var insertServiceInstance = action.Action{
 	Name: "insert-service-instance",
 	Forward: func(ctx action.FWContext) (action.Result, error) {
-		instance, ok := ctx.Params[1].(ServiceInstance)
+		instance, ok := ctx.Previous.(ServiceInstance)
 		if !ok {
-			return nil, errors.New("Second parameter must be a ServiceInstance.")
+			instance, ok = ctx.Params[1].(ServiceInstance)
+			if !ok {
+				return nil, errors.New("Second parameter must be a ServiceInstance.")
+			}
 		}
 		conn, err := db.Conn()
 		if err != nil {
 			return nil, err
 		}
 		defer conn.Close()
-		return nil, conn.ServiceInstances().Insert(&instance)
+		err = conn.ServiceInstances().Insert(&instance)
+		if err != nil {
+			return nil, err
+		}
+		return instance, nil
 	},
 	Backward: func(ctx action.BWContext) {
 		instance, ok := ctx.Params[1].(ServiceInstance)
@@ -110,6 +122,7 @@ type bindPipelineArgs struct {
 	writer          io.Writer
 	serviceInstance *ServiceInstance
 	shouldRestart   bool
+	processes       []string
 }
 
 var bindAppDBAction = &action.Action{
@@ -126,6 +139,9 @@ var bindAppDBAction = &action.Action{
 		defer conn.Close()
 		si := args.serviceInstance
 		updateOp := bson.M{"$addToSet": bson.M{"apps": args.app.GetName()}}
+		if len(args.processes) > 0 {
+			updateOp["$set"] = bson.M{"appprocesses." + args.app.GetName(): args.processes}
+		}
 		err = conn.ServiceInstances().Update(bson.M{"name": si.Name, "service_name": si.ServiceName, "apps": bson.M{"$ne": args.app.GetName()}}, updateOp)
 		if err != nil {
 			if err == mgo.ErrNotFound {
@@ -137,7 +153,11 @@ var bindAppDBAction = &action.Action{
 	},
 	Backward: func(ctx action.BWContext) {
 		args, _ := ctx.Params[0].(*bindPipelineArgs)
-		if err := args.serviceInstance.update(bson.M{"$pull": bson.M{"apps": args.app.GetName()}}); err != nil {
+		updateOp := bson.M{
+			"$pull":  bson.M{"apps": args.app.GetName()},
+			"$unset": bson.M{"appprocesses." + args.app.GetName(): ""},
+		}
+		if err := args.serviceInstance.update(updateOp); err != nil {
 			log.Errorf("[bind-app-db backward] could not remove app from service instance: %s", err)
 		}
 	},
@@ -180,8 +200,9 @@ var setBoundEnvsAction = &action.Action{
 			return nil, errors.New("invalid arguments for pipeline, expected *bindPipelineArgs")
 		}
 		instance := bind.ServiceInstance{
-			Name: args.serviceInstance.Name,
-			Envs: ctx.Previous.(map[string]string),
+			Name:      args.serviceInstance.Name,
+			Envs:      ctx.Previous.(map[string]string),
+			Processes: args.processes,
 		}
 		return instance, args.app.AddInstance(
 			bind.InstanceApp{
@@ -316,11 +337,18 @@ var unbindAppDB = action.Action{
 		if args == nil {
 			return nil, errors.New("invalid arguments for pipeline, expected *bindPipelineArgs")
 		}
-		return nil, args.serviceInstance.update(bson.M{"$pull": bson.M{"apps": args.app.GetName()}})
+		return nil, args.serviceInstance.update(bson.M{
+			"$pull":  bson.M{"apps": args.app.GetName()},
+			"$unset": bson.M{"appprocesses." + args.app.GetName(): ""},
+		})
 	},
 	Backward: func(ctx action.BWContext) {
 		args, _ := ctx.Params[0].(*bindPipelineArgs)
-		err := args.serviceInstance.update(bson.M{"$addToSet": bson.M{"apps": args.app.GetName()}})
+		updateOp := bson.M{"$addToSet": bson.M{"apps": args.app.GetName()}}
+		if processes := args.serviceInstance.AppProcesses[args.app.GetName()]; len(processes) > 0 {
+			updateOp["$set"] = bson.M{"appprocesses." + args.app.GetName(): processes}
+		}
+		err := args.serviceInstance.update(updateOp)
 		if err != nil {
 			log.Errorf("[unbind-app-db backward] failed to rebind app in db: %s", err)
 		}
@@ -0,0 +1,447 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/config"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const backupTaskName = "service-instance-backup"
+
+// MinBackupInterval is the shortest interval accepted for periodic backups.
+const MinBackupInterval = time.Hour
+
+var (
+	ErrBackupNotSupported    = errors.New("service does not support backups")
+	ErrBackupNotFound        = errors.New("backup not found")
+	ErrInvalidBackupInterval = errors.Errorf("backup interval must be at least %s", MinBackupInterval)
+	ErrInvalidBackupKeep     = errors.New("number of backups to keep must not be negative")
+)
+
+// Backup is a snapshot of a service instance taken by the service API.
+type Backup struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size,omitempty"`
+}
+
+// BackupPolicy configures periodic backups of a service instance. Keep is
+// the number of backups kept after each periodic backup, zero means backups
+// are never removed by tsuru.
+type BackupPolicy struct {
+	Interval   time.Duration
+	Keep       int
+	NextBackup time.Time
+	LastBackup time.Time `bson:",omitempty"`
+	LastError  string    `bson:",omitempty"`
+}
+
+// BackupClient is implemented by service clients able to manage backups of
+// service instances. Services must also advertise the support setting the
+// Backup flag.
+type BackupClient interface {
+	CreateBackup(instance *ServiceInstance, requestID string) (*Backup, error)
+	ListBackups(instance *ServiceInstance, requestID string) ([]Backup, error)
+	RestoreBackup(instance *ServiceInstance, backupID, requestID string) error
+	DeleteBackup(instance *ServiceInstance, backupID, requestID string) error
+}
+
+// CreateBackup asks the service API for a new backup of the instance:
+// POST /resources/<name>/backups
+func (c *Client) CreateBackup(instance *ServiceInstance, requestID string) (*Backup, error) {
+	log.Debugf("Attempting to call backup of service instance %q at %q api", instance.Name, instance.ServiceName)
+	params := map[string][]string{
+		"requestID": {requestID},
+	}
+	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier()+"/backups", "POST", params)
+	if err == nil {
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return nil, ErrInstanceNotFoundInAPI
+		case resp.StatusCode < 300:
+			var backup Backup
+			err = c.jsonFromResponse(resp, &backup)
+			if err != nil {
+				return nil, err
+			}
+			return &backup, nil
+		}
+	}
+	err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to backup the instance %s", instance.Name)
+	return nil, log.WrapError(err)
+}
+
+// ListBackups returns the backups of the instance available in the service
+// API: GET /resources/<name>/backups
+func (c *Client) ListBackups(instance *ServiceInstance, requestID string) ([]Backup, error) {
+	params := map[string][]string{
+		"requestID": {requestID},
+	}
+	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier()+"/backups", "GET", params)
+	if err == nil {
+		switch resp.StatusCode {
+		case http.StatusOK:
+			var backups []Backup
+			err = c.jsonFromResponse(resp, &backups)
+			if err != nil {
+				return nil, err
+			}
+			return backups, nil
+		case http.StatusNoContent:
+			resp.Body.Close()
+			return nil, nil
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return nil, ErrInstanceNotFoundInAPI
+		}
+	}
+	err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to list backups of the instance %s", instance.Name)
+	return nil, log.WrapError(err)
+}
+
+// RestoreBackup restores the instance to the given backup:
+// POST /resources/<name>/backups/<id>/restore
+func (c *Client) RestoreBackup(instance *ServiceInstance, backupID, requestID string) error {
+	log.Debugf("Attempting to call restore of backup %q of service instance %q at %q api", backupID, instance.Name, instance.ServiceName)
+	params := map[string][]string{
+		"requestID": {requestID},
+	}
+	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier()+"/backups/"+backupID+"/restore", "POST", params)
+	if err == nil {
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return ErrBackupNotFound
+		case resp.StatusCode < 300:
+			resp.Body.Close()
+			return nil
+		}
+	}
+	err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to restore backup %s of the instance %s", backupID, instance.Name)
+	return log.WrapError(err)
+}
+
+// DeleteBackup removes a backup of the instance:
+// DELETE /resources/<name>/backups/<id>
+func (c *Client) DeleteBackup(instance *ServiceInstance, backupID, requestID string) error {
+	params := map[string][]string{
+		"requestID": {requestID},
+	}
+	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier()+"/backups/"+backupID, "DELETE", params)
+	if err == nil {
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return ErrBackupNotFound
+		case resp.StatusCode < 300:
+			resp.Body.Close()
+			return nil
+		}
+	}
+	err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to delete backup %s of the instance %s", backupID, instance.Name)
+	return log.WrapError(err)
+}
+
+func (si *ServiceInstance) backupClient() (BackupClient, error) {
+	s := si.Service()
+	if s == nil || !s.Backup {
+		return nil, ErrBackupNotSupported
+	}
+	endpoint, err := s.getClient("production")
+	if err != nil {
+		return nil, err
+	}
+	client, ok := endpoint.(BackupClient)
+	if !ok {
+		return nil, ErrBackupNotSupported
+	}
+	return client, nil
+}
+
+// CreateBackup takes a new backup of the instance.
+func (si *ServiceInstance) CreateBackup(requestID string) (*Backup, error) {
+	if !si.IsReady() {
+		return nil, ErrServiceInstanceNotReady
+	}
+	client, err := si.backupClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateBackup(si, requestID)
+}
+
+// ListBackups returns the backups of the instance, newest first.
+func (si *ServiceInstance) ListBackups(requestID string) ([]Backup, error) {
+	client, err := si.backupClient()
+	if err != nil {
+		return nil, err
+	}
+	backups, err := client.ListBackups(si, requestID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(backupsByDate(backups))
+	return backups, nil
+}
+
+// RestoreBackup restores the instance to the given backup.
+func (si *ServiceInstance) RestoreBackup(backupID, requestID string) error {
+	if !si.IsReady() {
+		return ErrServiceInstanceNotReady
+	}
+	client, err := si.backupClient()
+	if err != nil {
+		return err
+	}
+	return client.RestoreBackup(si, backupID, requestID)
+}
+
+// DeleteBackup removes a backup of the instance.
+func (si *ServiceInstance) DeleteBackup(backupID, requestID string) error {
+	client, err := si.backupClient()
+	if err != nil {
+		return err
+	}
+	return client.DeleteBackup(si, backupID, requestID)
+}
+
+// SetBackupPolicy enables periodic backups of the instance, or disables them
+// when policy is nil. The first periodic backup is taken after one interval.
+func (si *ServiceInstance) SetBackupPolicy(policy *BackupPolicy) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	query := bson.M{"name": si.Name, "service_name": si.ServiceName}
+	if policy == nil {
+		si.BackupPolicy = nil
+		return conn.ServiceInstances().Update(query, bson.M{"$unset": bson.M{"backuppolicy": ""}})
+	}
+	if _, err = si.backupClient(); err != nil {
+		return err
+	}
+	if policy.Interval < MinBackupInterval {
+		return ErrInvalidBackupInterval
+	}
+	if policy.Keep < 0 {
+		return ErrInvalidBackupKeep
+	}
+	newPolicy := BackupPolicy{
+		Interval:   policy.Interval,
+		Keep:       policy.Keep,
+		NextBackup: time.Now().UTC().Add(policy.Interval),
+	}
+	if si.BackupPolicy != nil {
+		newPolicy.LastBackup = si.BackupPolicy.LastBackup
+	}
+	err = conn.ServiceInstances().Update(query, bson.M{"$set": bson.M{"backuppolicy": newPolicy}})
+	if err != nil {
+		return err
+	}
+	si.BackupPolicy = &newPolicy
+	return nil
+}
+
+// pruneBackups removes the oldest backups of the instance, keeping only the
+// given number of them.
+func (si *ServiceInstance) pruneBackups(keep int, requestID string) ([]string, error) {
+	backups, err := si.ListBackups(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if keep <= 0 || len(backups) <= keep {
+		return nil, nil
+	}
+	var removed []string
+	for _, b := range backups[keep:] {
+		err = si.DeleteBackup(b.ID, requestID)
+		if err != nil && err != ErrBackupNotFound {
+			return removed, err
+		}
+		removed = append(removed, b.ID)
+	}
+	return removed, nil
+}
+
+type backupsByDate []Backup
+
+func (b backupsByDate) Len() int           { return len(b) }
+func (b backupsByDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b backupsByDate) Less(i, j int) bool { return b[i].CreatedAt.After(b[j].CreatedAt) }
+
+// backupTask takes a periodic backup of a service instance and removes the
+// backups exceeding the instance policy.
+type backupTask struct{}
+
+func (t *backupTask) Name() string {
+	return backupTaskName
+}
+
+func (t *backupTask) Run(job monsterqueue.Job) {
+	params := job.Parameters()
+	serviceName, _ := params["service"].(string)
+	instanceName, _ := params["instance"].(string)
+	if serviceName == "" || instanceName == "" {
+		job.Error(errors.New("invalid parameters, expected service and instance"))
+		return
+	}
+	err := runScheduledBackup(serviceName, instanceName)
+	if err != nil {
+		log.Errorf("[service-instance-backup] error backing up instance %s/%s: %s", serviceName, instanceName, err)
+		job.Error(err)
+		return
+	}
+	job.Success(nil)
+}
+
+func runScheduledBackup(serviceName, instanceName string) (err error) {
+	si, err := GetServiceInstance(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	if si.BackupPolicy == nil {
+		return nil
+	}
+	permValue := fmt.Sprintf("%s/%s", serviceName, instanceName)
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeServiceInstance, Value: permValue},
+		InternalKind: "service-instance-backup",
+		Allowed: event.Allowed(permission.PermServiceInstanceReadEvents,
+			append(permission.Contexts(permission.CtxTeam, si.Teams),
+				permission.Context(permission.CtxServiceInstance, permValue))...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	backup, err := si.CreateBackup("")
+	set := bson.M{"backuppolicy.lasterror": ""}
+	if err != nil {
+		set["backuppolicy.lasterror"] = err.Error()
+	} else {
+		set["backuppolicy.lastbackup"] = time.Now().UTC()
+	}
+	conn, dbErr := db.Conn()
+	if dbErr != nil {
+		return dbErr
+	}
+	defer conn.Close()
+	dbErr = conn.ServiceInstances().Update(bson.M{"name": si.Name, "service_name": si.ServiceName}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if dbErr != nil {
+		return dbErr
+	}
+	evt.Logf("backup %q of instance %q created", backup.ID, instanceName)
+	removed, err := si.pruneBackups(si.BackupPolicy.Keep, "")
+	for _, id := range removed {
+		evt.Logf("backup %q of instance %q removed", id, instanceName)
+	}
+	return err
+}
+
+// enqueueDueBackups enqueues a backup task for each instance whose next
+// periodic backup is due. The next backup is moved forward before enqueuing
+// and only if it wasn't changed concurrently, so that multiple API servers
+// don't enqueue the same backup twice.
+func enqueueDueBackups() error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	var instances []ServiceInstance
+	err = conn.ServiceInstances().Find(bson.M{"backuppolicy.nextbackup": bson.M{"$lte": now}}).All(&instances)
+	if err != nil {
+		return err
+	}
+	for _, si := range instances {
+		query := bson.M{
+			"name":                    si.Name,
+			"service_name":            si.ServiceName,
+			"backuppolicy.nextbackup": si.BackupPolicy.NextBackup,
+		}
+		next := now.Add(si.BackupPolicy.Interval)
+		err = conn.ServiceInstances().Update(query, bson.M{"$set": bson.M{"backuppolicy.nextbackup": next}})
+		if err != nil {
+			continue
+		}
+		_, err = q.Enqueue(backupTaskName, monsterqueue.JobParams{
+			"service":  si.ServiceName,
+			"instance": si.Name,
+		})
+		if err != nil {
+			log.Errorf("[service-instance-backup] unable to enqueue backup of instance %s/%s: %s", si.ServiceName, si.Name, err)
+		}
+	}
+	return nil
+}
+
+// BackupScheduler periodically enqueues the due backups of service
+// instances.
+type BackupScheduler struct {
+	interval time.Duration
+	done     chan bool
+}
+
+// StartBackupScheduler starts the scheduler of periodic backups. The check
+// interval is read from the service:backup-scheduler-interval config, in
+// seconds, and defaults to one minute.
+func StartBackupScheduler() *BackupScheduler {
+	interval, _ := config.GetInt("service:backup-scheduler-interval")
+	s := &BackupScheduler{
+		interval: time.Duration(interval) * time.Second,
+		done:     make(chan bool),
+	}
+	if s.interval == 0 {
+		s.interval = time.Minute
+	}
+	go s.run()
+	return s
+}
+
+func (s *BackupScheduler) run() {
+	for {
+		err := enqueueDueBackups()
+		if err != nil {
+			log.Errorf("[service-instance-backup] %s", err)
+		}
+		select {
+		case <-s.done:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *BackupScheduler) Shutdown() {
+	s.done <- true
+}
+
+func (s *BackupScheduler) String() string {
+	return "service instance backup scheduler"
+}
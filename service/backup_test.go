@@ -0,0 +1,203 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type backupServer struct {
+	sync.Mutex
+	backups  []Backup
+	restored []string
+	deleted  []string
+}
+
+func (b *backupServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.Lock()
+	defer b.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/resources/instance/backups")
+	switch {
+	case r.Method == "POST" && path == "":
+		backup := Backup{ID: "new", Status: "done", CreatedAt: time.Now().UTC()}
+		b.backups = append(b.backups, backup)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(backup)
+	case r.Method == "GET" && path == "":
+		json.NewEncoder(w).Encode(b.backups)
+	case r.Method == "POST" && strings.HasSuffix(path, "/restore"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/restore")
+		if b.find(id) < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b.restored = append(b.restored, id)
+	case r.Method == "DELETE":
+		id := strings.TrimPrefix(path, "/")
+		i := b.find(id)
+		if i < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		b.backups = append(b.backups[:i], b.backups[i+1:]...)
+		b.deleted = append(b.deleted, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (b *backupServer) find(id string) int {
+	for i := range b.backups {
+		if b.backups[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *S) newBackupService(c *check.C) (*backupServer, *httptest.Server) {
+	now := time.Now().UTC()
+	handler := &backupServer{backups: []Backup{
+		{ID: "b1", Status: "done", CreatedAt: now.Add(-3 * time.Hour)},
+		{ID: "b3", Status: "done", CreatedAt: now.Add(-time.Hour)},
+		{ID: "b2", Status: "done", CreatedAt: now.Add(-2 * time.Hour)},
+	}}
+	ts := httptest.NewServer(handler)
+	srv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}, Backup: true}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mysql", Teams: []string{s.team.Name}}
+	err = s.conn.ServiceInstances().Insert(&si)
+	c.Assert(err, check.IsNil)
+	return handler, ts
+}
+
+func (s *S) TestClientBackups(c *check.C) {
+	handler, ts := s.newBackupService(c)
+	defer ts.Close()
+	client := &Client{serviceName: "mysql", endpoint: ts.URL}
+	si := &ServiceInstance{Name: "instance", ServiceName: "mysql"}
+	backup, err := client.CreateBackup(si, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.ID, check.Equals, "new")
+	backups, err := client.ListBackups(si, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(backups, check.HasLen, 4)
+	err = client.RestoreBackup(si, "b2", "")
+	c.Assert(err, check.IsNil)
+	err = client.RestoreBackup(si, "unknown", "")
+	c.Assert(err, check.Equals, ErrBackupNotFound)
+	err = client.DeleteBackup(si, "b1", "")
+	c.Assert(err, check.IsNil)
+	err = client.DeleteBackup(si, "b1", "")
+	c.Assert(err, check.Equals, ErrBackupNotFound)
+	c.Assert(handler.restored, check.DeepEquals, []string{"b2"})
+	c.Assert(handler.deleted, check.DeepEquals, []string{"b1"})
+}
+
+func (s *S) TestServiceInstanceListBackupsNewestFirst(c *check.C) {
+	_, ts := s.newBackupService(c)
+	defer ts.Close()
+	si, err := GetServiceInstance("mysql", "instance")
+	c.Assert(err, check.IsNil)
+	backups, err := si.ListBackups("")
+	c.Assert(err, check.IsNil)
+	c.Assert(backups, check.HasLen, 3)
+	c.Assert([]string{backups[0].ID, backups[1].ID, backups[2].ID}, check.DeepEquals, []string{"b3", "b2", "b1"})
+}
+
+func (s *S) TestServiceInstanceBackupNotSupported(c *check.C) {
+	_, ts := s.newBackupService(c)
+	defer ts.Close()
+	err := s.conn.Services().Update(bson.M{"_id": "mysql"}, bson.M{"$set": bson.M{"backup": false}})
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mysql", "instance")
+	c.Assert(err, check.IsNil)
+	_, err = si.CreateBackup("")
+	c.Assert(err, check.Equals, ErrBackupNotSupported)
+	_, err = si.ListBackups("")
+	c.Assert(err, check.Equals, ErrBackupNotSupported)
+	err = si.SetBackupPolicy(&BackupPolicy{Interval: 24 * time.Hour})
+	c.Assert(err, check.Equals, ErrBackupNotSupported)
+}
+
+func (s *S) TestServiceInstanceCreateBackupNotReady(c *check.C) {
+	si := ServiceInstance{Name: "instance", ServiceName: "mysql", State: StateProvisioning}
+	_, err := si.CreateBackup("")
+	c.Assert(err, check.Equals, ErrServiceInstanceNotReady)
+	err = si.RestoreBackup("b1", "")
+	c.Assert(err, check.Equals, ErrServiceInstanceNotReady)
+}
+
+func (s *S) TestServiceInstanceSetBackupPolicy(c *check.C) {
+	_, ts := s.newBackupService(c)
+	defer ts.Close()
+	si, err := GetServiceInstance("mysql", "instance")
+	c.Assert(err, check.IsNil)
+	err = si.SetBackupPolicy(&BackupPolicy{Interval: time.Minute})
+	c.Assert(err, check.Equals, ErrInvalidBackupInterval)
+	err = si.SetBackupPolicy(&BackupPolicy{Interval: time.Hour, Keep: -1})
+	c.Assert(err, check.Equals, ErrInvalidBackupKeep)
+	err = si.SetBackupPolicy(&BackupPolicy{Interval: 24 * time.Hour, Keep: 5})
+	c.Assert(err, check.IsNil)
+	dbSi, err := GetServiceInstance("mysql", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbSi.BackupPolicy, check.NotNil)
+	c.Assert(dbSi.BackupPolicy.Interval, check.Equals, 24*time.Hour)
+	c.Assert(dbSi.BackupPolicy.Keep, check.Equals, 5)
+	c.Assert(dbSi.BackupPolicy.NextBackup.After(time.Now().Add(23*time.Hour)), check.Equals, true)
+	err = si.SetBackupPolicy(nil)
+	c.Assert(err, check.IsNil)
+	dbSi, err = GetServiceInstance("mysql", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbSi.BackupPolicy, check.IsNil)
+}
+
+func (s *S) TestEnqueueDueBackups(c *check.C) {
+	handler, ts := s.newBackupService(c)
+	defer ts.Close()
+	policy := BackupPolicy{Interval: time.Hour, Keep: 2, NextBackup: time.Now().UTC().Add(-time.Minute)}
+	err := s.conn.ServiceInstances().Update(bson.M{"name": "instance"}, bson.M{"$set": bson.M{"backuppolicy": policy}})
+	c.Assert(err, check.IsNil)
+	err = enqueueDueBackups()
+	c.Assert(err, check.IsNil)
+	timeout := time.After(5 * time.Second)
+	for {
+		n, err := s.conn.Events().Find(bson.M{"kind.name": "service-instance-backup", "running": false}).Count()
+		c.Assert(err, check.IsNil)
+		if n == 1 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for instance backup")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	handler.Lock()
+	c.Assert(handler.deleted, check.DeepEquals, []string{"b2", "b1"})
+	c.Assert(handler.backups, check.HasLen, 2)
+	handler.Unlock()
+	si, err := GetServiceInstance("mysql", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.BackupPolicy.NextBackup.After(time.Now()), check.Equals, true)
+	c.Assert(si.BackupPolicy.LastBackup.IsZero(), check.Equals, false)
+	c.Assert(si.BackupPolicy.LastError, check.Equals, "")
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeServiceInstance, Value: "mysql/instance"},
+		Kind:       "service-instance-backup",
+		LogMatches: `(?s).*backup "new" of instance "instance" created.*backup "b2" of instance "instance" removed.*`,
+	}, eventtest.HasEvent)
+}
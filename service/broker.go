@@ -0,0 +1,432 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/app/bind"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/net"
+)
+
+const (
+	brokerAPIVersion = "2.13"
+
+	brokerStateInProgress = "in progress"
+	brokerStateSucceeded  = "succeeded"
+	brokerStateFailed     = "failed"
+)
+
+var (
+	ErrBrokerProxyNotSupported = errors.New("proxy is not supported by services backed by service brokers")
+
+	invalidEnvCharsRegexp = regexp.MustCompile(`[^A-Z0-9_]`)
+)
+
+type brokerPlan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type brokerService struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Bindable    bool         `json:"bindable"`
+	Plans       []brokerPlan `json:"plans"`
+}
+
+func (s *brokerService) plan(name string) (*brokerPlan, error) {
+	if name == "" && len(s.Plans) > 0 {
+		return &s.Plans[0], nil
+	}
+	for i := range s.Plans {
+		if s.Plans[i].Name == name {
+			return &s.Plans[i], nil
+		}
+	}
+	return nil, errors.Errorf("plan %q not found in service broker catalog", name)
+}
+
+type brokerOperation struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+}
+
+// brokerClient implements the ServiceClient interface for services backed by
+// an Open Service Broker API endpoint. Asynchronous operations leave the
+// instance pending, and their progress is reported by Status using the
+// last_operation endpoint.
+type brokerClient struct {
+	serviceName   string
+	brokerService string
+	endpoint      string
+	username      string
+	password      string
+}
+
+func (c *brokerClient) instanceID(instance *ServiceInstance) string {
+	return fmt.Sprintf("%s-%s", c.serviceName, instance.GetIdentifier())
+}
+
+func (c *brokerClient) bindingID(instance *ServiceInstance, app bind.App) string {
+	return fmt.Sprintf("%s-%s", c.instanceID(instance), app.GetName())
+}
+
+func (c *brokerClient) doRequest(method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	url := strings.TrimRight(c.endpoint, "/") + path
+	if len(query) > 0 {
+		url += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Broker-API-Version", brokerAPIVersion)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Close = true
+	t0 := time.Now()
+	resp, err := net.Dial5Full300ClientNoKeepAlive.Do(req)
+	requestLatencies.WithLabelValues(c.serviceName).Observe(time.Since(t0).Seconds())
+	if err != nil {
+		requestErrors.WithLabelValues(c.serviceName).Inc()
+	}
+	return resp, err
+}
+
+func (c *brokerClient) responseError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(resp.Body)
+	var brokerErr struct {
+		Error       string `json:"error"`
+		Description string `json:"description"`
+	}
+	if json.Unmarshal(b, &brokerErr) == nil && brokerErr.Description != "" {
+		return errors.Errorf("invalid response from service broker (%d): %s", resp.StatusCode, brokerErr.Description)
+	}
+	return errors.Errorf("invalid response from service broker (%d): %s", resp.StatusCode, string(b))
+}
+
+func (c *brokerClient) catalog() (*brokerService, error) {
+	resp, err := c.doRequest("GET", "/v2/catalog", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.responseError(resp)
+	}
+	var catalog struct {
+		Services []brokerService `json:"services"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&catalog)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse service broker catalog")
+	}
+	for i := range catalog.Services {
+		if catalog.Services[i].Name == c.brokerService {
+			return &catalog.Services[i], nil
+		}
+	}
+	return nil, errors.Errorf("service %q not found in service broker catalog", c.brokerService)
+}
+
+func (c *brokerClient) servicePlan(planName string) (*brokerService, *brokerPlan, error) {
+	svc, err := c.catalog()
+	if err != nil {
+		return nil, nil, err
+	}
+	plan, err := svc.plan(planName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return svc, plan, nil
+}
+
+func (c *brokerClient) lastOperation(instance *ServiceInstance, query url.Values) (*brokerOperation, int, error) {
+	resp, err := c.doRequest("GET", "/v2/service_instances/"+c.instanceID(instance)+"/last_operation", query, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil, resp.StatusCode, nil
+	}
+	var op brokerOperation
+	err = json.NewDecoder(resp.Body).Decode(&op)
+	if err != nil {
+		return nil, resp.StatusCode, errors.Wrap(err, "unable to parse service broker last operation")
+	}
+	return &op, resp.StatusCode, nil
+}
+
+func (c *brokerClient) Create(instance *ServiceInstance, user, requestID string) error {
+	svc, plan, err := c.servicePlan(instance.PlanName)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"service_id":        svc.ID,
+		"plan_id":           plan.ID,
+		"organization_guid": instance.TeamOwner,
+		"space_guid":        instance.TeamOwner,
+		"context": map[string]string{
+			"platform": "tsuru",
+			"team":     instance.TeamOwner,
+			"user":     user,
+		},
+	}
+	if len(instance.Parameters) > 0 {
+		body["parameters"] = instance.Parameters
+	}
+	log.Debugf("Attempting to provision service instance %q at %q service broker", instance.Name, instance.ServiceName)
+	query := url.Values{"accepts_incomplete": []string{"true"}}
+	resp, err := c.doRequest("PUT", "/v2/service_instances/"+c.instanceID(instance), query, body)
+	if err != nil {
+		return log.WrapError(errors.Wrapf(err, "Failed to create the instance %s", instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusAccepted:
+		instance.State = StatePending
+		return nil
+	case http.StatusConflict:
+		return ErrInstanceAlreadyExistsInAPI
+	}
+	return log.WrapError(errors.Wrapf(c.responseError(resp), "Failed to create the instance %s", instance.Name))
+}
+
+func (c *brokerClient) Update(instance *ServiceInstance, planName string, parameters map[string]interface{}, requestID string) error {
+	svc, previousPlan, err := c.servicePlan(instance.PlanName)
+	if err != nil {
+		return err
+	}
+	plan, err := svc.plan(planName)
+	if err != nil {
+		return err
+	}
+	body := map[string]interface{}{
+		"service_id": svc.ID,
+		"plan_id":    plan.ID,
+		"parameters": parameters,
+		"previous_values": map[string]string{
+			"service_id": svc.ID,
+			"plan_id":    previousPlan.ID,
+		},
+	}
+	log.Debugf("Attempting to update service instance %q at %q service broker", instance.Name, instance.ServiceName)
+	query := url.Values{"accepts_incomplete": []string{"true"}}
+	resp, err := c.doRequest("PATCH", "/v2/service_instances/"+c.instanceID(instance), query, body)
+	if err != nil {
+		return log.WrapError(errors.Wrapf(err, "Failed to update the instance %s", instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusAccepted:
+		instance.State = StatePending
+		return nil
+	case http.StatusUnprocessableEntity:
+		return ErrInstanceNotReady
+	}
+	return log.WrapError(errors.Wrapf(c.responseError(resp), "Failed to update the instance %s", instance.Name))
+}
+
+func (c *brokerClient) Destroy(instance *ServiceInstance, requestID string) error {
+	svc, plan, err := c.servicePlan(instance.PlanName)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Attempting to deprovision service instance %q at %q service broker", instance.Name, instance.ServiceName)
+	query := url.Values{
+		"service_id":         []string{svc.ID},
+		"plan_id":            []string{plan.ID},
+		"accepts_incomplete": []string{"true"},
+	}
+	resp, err := c.doRequest("DELETE", "/v2/service_instances/"+c.instanceID(instance), query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusAccepted:
+		instance.State = StateDeprovisioning
+		return nil
+	case http.StatusGone:
+		return ErrInstanceNotFoundInAPI
+	}
+	return log.WrapError(errors.Wrapf(c.responseError(resp), "Failed to destroy the instance %s", instance.Name))
+}
+
+func (c *brokerClient) BindApp(instance *ServiceInstance, app bind.App) (map[string]string, error) {
+	svc, plan, err := c.servicePlan(instance.PlanName)
+	if err != nil {
+		return nil, err
+	}
+	if !svc.Bindable {
+		return nil, errors.Errorf("service %q is not bindable", c.brokerService)
+	}
+	log.Debugf("Calling bind of instance %q and %q app at %q service broker", instance.Name, app.GetName(), instance.ServiceName)
+	body := map[string]interface{}{
+		"service_id": svc.ID,
+		"plan_id":    plan.ID,
+		"app_guid":   app.GetName(),
+		"bind_resource": map[string]string{
+			"app_guid": app.GetName(),
+		},
+	}
+	path := "/v2/service_instances/" + c.instanceID(instance) + "/service_bindings/" + c.bindingID(instance, app)
+	resp, err := c.doRequest("PUT", path, nil, body)
+	if err != nil {
+		return nil, log.WrapError(errors.Wrapf(err, `Failed to bind app %q to service instance "%s/%s"`, app.GetName(), instance.ServiceName, instance.Name))
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var result struct {
+			Credentials map[string]interface{} `json:"credentials"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse service broker binding")
+		}
+		return credentialsToEnvs(result.Credentials), nil
+	case http.StatusUnprocessableEntity:
+		return nil, ErrInstanceNotReady
+	case http.StatusNotFound, http.StatusGone:
+		return nil, ErrInstanceNotFoundInAPI
+	}
+	err = errors.Wrapf(c.responseError(resp), `Failed to bind the instance "%s/%s" to the app %q`, instance.ServiceName, instance.Name, app.GetName())
+	return nil, log.WrapError(err)
+}
+
+// BindUnit is a noop, service brokers only know about app bindings.
+func (c *brokerClient) BindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error {
+	return nil
+}
+
+func (c *brokerClient) UnbindApp(instance *ServiceInstance, app bind.App) error {
+	svc, plan, err := c.servicePlan(instance.PlanName)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Calling unbind of service instance %q and app %q at %q service broker", instance.Name, app.GetName(), instance.ServiceName)
+	query := url.Values{"service_id": []string{svc.ID}, "plan_id": []string{plan.ID}}
+	path := "/v2/service_instances/" + c.instanceID(instance) + "/service_bindings/" + c.bindingID(instance, app)
+	resp, err := c.doRequest("DELETE", path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusGone:
+		return ErrInstanceNotFoundInAPI
+	}
+	return log.WrapError(errors.Wrapf(c.responseError(resp), "Failed to unbind (%q)", path))
+}
+
+// UnbindUnit is a noop, service brokers only know about app bindings.
+func (c *brokerClient) UnbindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error {
+	return nil
+}
+
+func (c *brokerClient) Status(instance *ServiceInstance, requestID string) (string, error) {
+	op, status, err := c.lastOperation(instance, nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "not implemented for this service", nil
+	}
+	switch op.State {
+	case brokerStateInProgress:
+		return "pending", nil
+	case brokerStateFailed:
+		return "down", nil
+	}
+	return "up", nil
+}
+
+// Info returns the dashboard url of the instance, when the service broker
+// supports fetching instances.
+func (c *brokerClient) Info(instance *ServiceInstance, requestID string) ([]map[string]string, error) {
+	resp, err := c.doRequest("GET", "/v2/service_instances/"+c.instanceID(instance), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	var result struct {
+		DashboardURL string `json:"dashboard_url"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	if result.DashboardURL == "" {
+		return nil, nil
+	}
+	return []map[string]string{{"label": "Dashboard", "value": result.DashboardURL}}, nil
+}
+
+func (c *brokerClient) Plans(requestID string) ([]Plan, error) {
+	svc, err := c.catalog()
+	if err != nil {
+		return nil, err
+	}
+	plans := make([]Plan, len(svc.Plans))
+	for i, p := range svc.Plans {
+		plans[i] = Plan{Name: p.Name, Description: p.Description}
+	}
+	return plans, nil
+}
+
+func (c *brokerClient) Proxy(path string, w http.ResponseWriter, r *http.Request) error {
+	return ErrBrokerProxyNotSupported
+}
+
+// credentialsToEnvs maps binding credentials to environment variables, names
+// are uppercased and values which are not strings are encoded as JSON.
+func credentialsToEnvs(credentials map[string]interface{}) map[string]string {
+	envs := make(map[string]string, len(credentials))
+	for k, v := range credentials {
+		name := invalidEnvCharsRegexp.ReplaceAllString(strings.ToUpper(k), "_")
+		envs[name] = formatParameterValue(v)
+	}
+	return envs
+}
@@ -0,0 +1,257 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/service/brokertest"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) newBrokerClient(b *brokertest.FakeBroker) *brokerClient {
+	return &brokerClient{
+		serviceName:   "mysql-tsuru",
+		brokerService: "mysql",
+		endpoint:      b.URL(),
+		username:      b.Username,
+		password:      b.Password,
+	}
+}
+
+func (s *S) TestBrokerClientPlans(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	plans, err := s.newBrokerClient(b).Plans("")
+	c.Assert(err, check.IsNil)
+	c.Assert(plans, check.DeepEquals, []Plan{
+		{Name: "small", Description: "small database"},
+		{Name: "large", Description: "large database"},
+	})
+}
+
+func (s *S) TestBrokerClientServiceNotInCatalog(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	cli := s.newBrokerClient(b)
+	cli.brokerService = "redis"
+	_, err := cli.Plans("")
+	c.Assert(err, check.ErrorMatches, `service "redis" not found in service broker catalog`)
+}
+
+func (s *S) TestBrokerClientInvalidCredentials(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	cli := s.newBrokerClient(b)
+	cli.password = "wrong"
+	_, err := cli.Plans("")
+	c.Assert(err, check.ErrorMatches, `invalid response from service broker \(401\): `)
+}
+
+func (s *S) TestBrokerClientCreateDestroy(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", PlanName: "large", TeamOwner: "myteam"}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	instances := b.Instances()
+	c.Assert(instances, check.HasLen, 1)
+	c.Assert(instances["mysql-tsuru-db1"].ServiceID, check.Equals, "mysql-id")
+	c.Assert(instances["mysql-tsuru-db1"].PlanID, check.Equals, "large-id")
+	c.Assert(instances["mysql-tsuru-db1"].Context, check.DeepEquals, map[string]interface{}{
+		"platform": "tsuru",
+		"team":     "myteam",
+		"user":     "me@example.com",
+	})
+	err = cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.Equals, ErrInstanceAlreadyExistsInAPI)
+	err = cli.Destroy(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(b.Instances(), check.HasLen, 0)
+	err = cli.Destroy(&instance, "")
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestBrokerClientCreateInvalidPlan(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", PlanName: "huge", TeamOwner: "myteam"}
+	err := s.newBrokerClient(b).Create(&instance, "me@example.com", "")
+	c.Assert(err, check.ErrorMatches, `plan "huge" not found in service broker catalog`)
+	c.Assert(b.Instances(), check.HasLen, 0)
+}
+
+func (s *S) TestBrokerClientCreateDestroyAsync(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	b.Async = true
+	b.PollsToComplete = 2
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", TeamOwner: "myteam"}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, StatePending)
+	c.Assert(b.Instances()["mysql-tsuru-db1"].PlanID, check.Equals, "small-id")
+	for _, expected := range []string{"pending", "pending", "up"} {
+		status, err := cli.Status(&instance, "")
+		c.Assert(err, check.IsNil)
+		c.Assert(status, check.Equals, expected)
+	}
+	err = cli.Destroy(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, StateDeprovisioning)
+	c.Assert(b.Instances(), check.HasLen, 1)
+	for _, expected := range []string{"pending", "pending", "not implemented for this service"} {
+		status, err := cli.Status(&instance, "")
+		c.Assert(err, check.IsNil)
+		c.Assert(status, check.Equals, expected)
+	}
+	c.Assert(b.Instances(), check.HasLen, 0)
+}
+
+func (s *S) TestBrokerClientCreateAsyncFailure(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	b.Async = true
+	b.FailOperations = true
+	b.PollsToComplete = 0
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", TeamOwner: "myteam"}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	status, err := cli.Status(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, "down")
+}
+
+func (s *S) TestBrokerClientCreateUpdateWithParameters(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{
+		Name:        "db1",
+		ServiceName: "mysql-tsuru",
+		PlanName:    "small",
+		TeamOwner:   "myteam",
+		Parameters:  map[string]interface{}{"version": "5.7"},
+	}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(b.Instances()["mysql-tsuru-db1"].Parameters, check.DeepEquals, map[string]interface{}{"version": "5.7"})
+	err = cli.Update(&instance, "large", map[string]interface{}{"version": "8.0", "replicas": float64(2)}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, "")
+	updated := b.Instances()["mysql-tsuru-db1"]
+	c.Assert(updated.PlanID, check.Equals, "large-id")
+	c.Assert(updated.Parameters, check.DeepEquals, map[string]interface{}{"version": "8.0", "replicas": float64(2)})
+	err = cli.Update(&instance, "huge", nil, "")
+	c.Assert(err, check.ErrorMatches, `plan "huge" not found in service broker catalog`)
+}
+
+func (s *S) TestBrokerClientUpdateAsync(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", PlanName: "small", TeamOwner: "myteam"}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	b.Async = true
+	err = cli.Update(&instance, "large", nil, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, StatePending)
+	err = cli.Update(&instance, "small", nil, "")
+	c.Assert(err, check.Equals, ErrInstanceNotReady)
+}
+
+func (s *S) TestBrokerClientBindUnbindApp(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	b.Credentials = map[string]interface{}{"host": "db.example.com", "port": 3306, "db-name": "mydb"}
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", TeamOwner: "myteam"}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	envs, err := cli.BindApp(&instance, a)
+	c.Assert(err, check.IsNil)
+	c.Assert(envs, check.DeepEquals, map[string]string{
+		"HOST":    "db.example.com",
+		"PORT":    "3306",
+		"DB_NAME": "mydb",
+	})
+	bindings := b.Bindings()
+	c.Assert(bindings, check.HasLen, 1)
+	c.Assert(bindings["mysql-tsuru-db1-myapp"].AppGUID, check.Equals, "myapp")
+	c.Assert(bindings["mysql-tsuru-db1-myapp"].InstanceID, check.Equals, "mysql-tsuru-db1")
+	units, err := a.GetUnits()
+	c.Assert(err, check.IsNil)
+	err = cli.BindUnit(&instance, a, units[0])
+	c.Assert(err, check.IsNil)
+	err = cli.UnbindUnit(&instance, a, units[0])
+	c.Assert(err, check.IsNil)
+	err = cli.UnbindApp(&instance, a)
+	c.Assert(err, check.IsNil)
+	c.Assert(b.Bindings(), check.HasLen, 0)
+	err = cli.UnbindApp(&instance, a)
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestBrokerClientBindAppInstanceNotFound(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", TeamOwner: "myteam"}
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	_, err := s.newBrokerClient(b).BindApp(&instance, a)
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
+func (s *S) TestBrokerClientInfo(c *check.C) {
+	b := brokertest.NewFakeBroker("user", "pass")
+	defer b.Close()
+	b.DashboardURL = "http://dashboard.example.com/db1"
+	cli := s.newBrokerClient(b)
+	instance := ServiceInstance{Name: "db1", ServiceName: "mysql-tsuru", TeamOwner: "myteam"}
+	err := cli.Create(&instance, "me@example.com", "")
+	c.Assert(err, check.IsNil)
+	info, err := cli.Info(&instance, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info, check.DeepEquals, []map[string]string{{"label": "Dashboard", "value": "http://dashboard.example.com/db1"}})
+}
+
+func (s *S) TestBrokerClientProxy(c *check.C) {
+	cli := &brokerClient{}
+	err := cli.Proxy("/", nil, nil)
+	c.Assert(err, check.Equals, ErrBrokerProxyNotSupported)
+}
+
+func (s *S) TestCreateServiceInstanceWithBroker(c *check.C) {
+	b := brokertest.NewFakeBroker("mysql-tsuru", "pass")
+	defer b.Close()
+	srv := Service{Name: "mysql-tsuru", Password: "pass", Broker: "mysql", Endpoint: map[string]string{"production": b.URL()}}
+	err := srv.Create()
+	c.Assert(err, check.IsNil)
+	instance := ServiceInstance{Name: "db1", PlanName: "small", TeamOwner: s.team.Name}
+	err = CreateServiceInstance(instance, &srv, s.user, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(b.Instances(), check.HasLen, 1)
+	si, err := GetServiceInstance("mysql-tsuru", "db1")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.PlanName, check.Equals, "small")
+}
+
+func (s *S) TestCredentialsToEnvs(c *check.C) {
+	envs := credentialsToEnvs(map[string]interface{}{
+		"uri":   "mysql://db",
+		"port":  3306,
+		"tags":  []string{"a", "b"},
+		"a.b-c": true,
+	})
+	c.Assert(envs, check.DeepEquals, map[string]string{
+		"URI":   "mysql://db",
+		"PORT":  "3306",
+		"TAGS":  `["a","b"]`,
+		"A_B_C": "true",
+	})
+}
@@ -0,0 +1,331 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package brokertest provides a fake implementation of the Open Service
+// Broker API, to be used in tests.
+package brokertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const APIVersionHeader = "X-Broker-API-Version"
+
+type Plan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type Service struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Bindable    bool   `json:"bindable"`
+	Plans       []Plan `json:"plans"`
+}
+
+type Instance struct {
+	ID         string
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Context    map[string]interface{} `json:"context"`
+}
+
+type Binding struct {
+	ID         string
+	InstanceID string
+	ServiceID  string `json:"service_id"`
+	PlanID     string `json:"plan_id"`
+	AppGUID    string `json:"app_guid"`
+}
+
+type operation struct {
+	remainingPolls int
+	fail           bool
+	deprovision    bool
+	update         bool
+}
+
+// FakeBroker is a fake Open Service Broker API server. Instances and bindings
+// are kept in memory. When Async is set, provision and deprovision requests
+// are accepted and only complete after PollsToComplete last_operation
+// requests.
+type FakeBroker struct {
+	Username        string
+	Password        string
+	Catalog         []Service
+	Credentials     map[string]interface{}
+	DashboardURL    string
+	Async           bool
+	PollsToComplete int
+	FailOperations  bool
+	server          *httptest.Server
+	mu              sync.Mutex
+	instances       map[string]Instance
+	bindings        map[string]Binding
+	operations      map[string]*operation
+}
+
+// NewFakeBroker starts a fake broker with a catalog containing a single
+// bindable service, named mysql, with two plans: small and large.
+func NewFakeBroker(username, password string) *FakeBroker {
+	b := &FakeBroker{
+		Username: username,
+		Password: password,
+		Catalog: []Service{
+			{
+				ID:          "mysql-id",
+				Name:        "mysql",
+				Description: "MySQL databases",
+				Bindable:    true,
+				Plans: []Plan{
+					{ID: "small-id", Name: "small", Description: "small database"},
+					{ID: "large-id", Name: "large", Description: "large database"},
+				},
+			},
+		},
+		Credentials:     map[string]interface{}{"host": "mysql.example.com", "port": 3306},
+		PollsToComplete: 1,
+		instances:       map[string]Instance{},
+		bindings:        map[string]Binding{},
+		operations:      map[string]*operation{},
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/v2/catalog", b.catalog).Methods("GET")
+	r.HandleFunc("/v2/service_instances/{instance}", b.provision).Methods("PUT")
+	r.HandleFunc("/v2/service_instances/{instance}", b.updateInstance).Methods("PATCH")
+	r.HandleFunc("/v2/service_instances/{instance}", b.fetchInstance).Methods("GET")
+	r.HandleFunc("/v2/service_instances/{instance}", b.deprovision).Methods("DELETE")
+	r.HandleFunc("/v2/service_instances/{instance}/last_operation", b.lastOperation).Methods("GET")
+	r.HandleFunc("/v2/service_instances/{instance}/service_bindings/{binding}", b.bind).Methods("PUT")
+	r.HandleFunc("/v2/service_instances/{instance}/service_bindings/{binding}", b.unbind).Methods("DELETE")
+	b.server = httptest.NewServer(b.authenticated(r))
+	return b
+}
+
+func (b *FakeBroker) URL() string {
+	return b.server.URL
+}
+
+func (b *FakeBroker) Close() {
+	b.server.Close()
+}
+
+// Instances returns the provisioned instances, including the ones with
+// operations in progress.
+func (b *FakeBroker) Instances() map[string]Instance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make(map[string]Instance, len(b.instances))
+	for k, v := range b.instances {
+		result[k] = v
+	}
+	return result
+}
+
+func (b *FakeBroker) Bindings() map[string]Binding {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make(map[string]Binding, len(b.bindings))
+	for k, v := range b.bindings {
+		result[k] = v
+	}
+	return result
+}
+
+func (b *FakeBroker) authenticated(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != b.Username || pass != b.Password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(APIVersionHeader) == "" {
+			writeJSON(w, http.StatusPreconditionFailed, map[string]string{"description": "missing api version"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (b *FakeBroker) catalog(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"services": b.Catalog})
+}
+
+func (b *FakeBroker) provision(w http.ResponseWriter, r *http.Request) {
+	var inst Instance
+	err := json.NewDecoder(r.Body).Decode(&inst)
+	if err != nil || inst.ServiceID == "" || inst.PlanID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"description": "invalid body"})
+		return
+	}
+	inst.ID = mux.Vars(r)["instance"]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[inst.ID]; ok {
+		writeJSON(w, http.StatusConflict, map[string]string{})
+		return
+	}
+	b.instances[inst.ID] = inst
+	if b.Async && r.URL.Query().Get("accepts_incomplete") == "true" {
+		b.operations[inst.ID] = &operation{remainingPolls: b.PollsToComplete, fail: b.FailOperations}
+		writeJSON(w, http.StatusAccepted, map[string]string{"operation": "provision"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{})
+}
+
+func (b *FakeBroker) updateInstance(w http.ResponseWriter, r *http.Request) {
+	var update Instance
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil || update.ServiceID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"description": "invalid body"})
+		return
+	}
+	id := mux.Vars(r)["instance"]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[id]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"description": "instance not found"})
+		return
+	}
+	if _, ok = b.operations[id]; ok {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "ConcurrencyError"})
+		return
+	}
+	if update.PlanID != "" {
+		inst.PlanID = update.PlanID
+	}
+	if update.Parameters != nil {
+		inst.Parameters = update.Parameters
+	}
+	b.instances[id] = inst
+	if b.Async && r.URL.Query().Get("accepts_incomplete") == "true" {
+		b.operations[id] = &operation{remainingPolls: b.PollsToComplete, fail: b.FailOperations, update: true}
+		writeJSON(w, http.StatusAccepted, map[string]string{"operation": "update"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+func (b *FakeBroker) fetchInstance(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[mux.Vars(r)["instance"]]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"service_id":    inst.ServiceID,
+		"plan_id":       inst.PlanID,
+		"dashboard_url": b.DashboardURL,
+		"parameters":    inst.Parameters,
+	})
+}
+
+func (b *FakeBroker) deprovision(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["instance"]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[id]; !ok {
+		writeJSON(w, http.StatusGone, map[string]string{})
+		return
+	}
+	if b.Async && r.URL.Query().Get("accepts_incomplete") == "true" {
+		b.operations[id] = &operation{remainingPolls: b.PollsToComplete, fail: b.FailOperations, deprovision: true}
+		writeJSON(w, http.StatusAccepted, map[string]string{"operation": "deprovision"})
+		return
+	}
+	delete(b.instances, id)
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+func (b *FakeBroker) lastOperation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["instance"]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	op, ok := b.operations[id]
+	if !ok {
+		if _, ok = b.instances[id]; !ok {
+			writeJSON(w, http.StatusGone, map[string]string{})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"state": "succeeded"})
+		return
+	}
+	if op.remainingPolls > 0 {
+		op.remainingPolls--
+		writeJSON(w, http.StatusOK, map[string]string{"state": "in progress", "description": "working"})
+		return
+	}
+	delete(b.operations, id)
+	if op.fail {
+		if !op.deprovision && !op.update {
+			delete(b.instances, id)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"state": "failed", "description": "something went wrong"})
+		return
+	}
+	if op.deprovision {
+		delete(b.instances, id)
+		writeJSON(w, http.StatusGone, map[string]string{})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"state": "succeeded"})
+}
+
+func (b *FakeBroker) bind(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var binding Binding
+	err := json.NewDecoder(r.Body).Decode(&binding)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"description": "invalid body"})
+		return
+	}
+	binding.ID = vars["binding"]
+	binding.InstanceID = vars["instance"]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[binding.InstanceID]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{})
+		return
+	}
+	if _, ok := b.operations[binding.InstanceID]; ok {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "ConcurrencyError"})
+		return
+	}
+	if _, ok := b.bindings[binding.ID]; ok {
+		writeJSON(w, http.StatusConflict, map[string]string{})
+		return
+	}
+	b.bindings[binding.ID] = binding
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"credentials": b.Credentials})
+}
+
+func (b *FakeBroker) unbind(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["binding"]
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.bindings[id]; !ok {
+		writeJSON(w, http.StatusGone, map[string]string{})
+		return
+	}
+	delete(b.bindings, id)
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
@@ -42,6 +42,23 @@ func init() {
 	prometheus.MustRegister(requestErrors)
 }
 
+// ServiceClient is implemented by clients able to manage instances of a
+// service, either speaking tsuru's service API or the Open Service Broker
+// API.
+type ServiceClient interface {
+	Create(instance *ServiceInstance, user, requestID string) error
+	Destroy(instance *ServiceInstance, requestID string) error
+	Update(instance *ServiceInstance, planName string, parameters map[string]interface{}, requestID string) error
+	BindApp(instance *ServiceInstance, app bind.App) (map[string]string, error)
+	BindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error
+	UnbindApp(instance *ServiceInstance, app bind.App) error
+	UnbindUnit(instance *ServiceInstance, app bind.App, unit bind.Unit) error
+	Status(instance *ServiceInstance, requestID string) (string, error)
+	Info(instance *ServiceInstance, requestID string) ([]map[string]string, error)
+	Plans(requestID string) ([]Plan, error)
+	Proxy(path string, w http.ResponseWriter, r *http.Request) error
+}
+
 type Client struct {
 	serviceName string
 	endpoint    string
@@ -127,10 +144,18 @@ func (c *Client) Create(instance *ServiceInstance, user, requestID string) error
 	if instance.Description != "" {
 		params["description"] = []string{instance.Description}
 	}
+	for _, tag := range instance.Tags {
+		params["tag"] = append(params["tag"], tag)
+	}
+	addParameters(params, instance.Parameters)
 	log.Debugf("Attempting to call creation of service instance for %q, params: %#v", instance.ServiceName, params)
 	resp, err = c.issueRequest("/resources", "POST", params)
 	if err == nil {
 		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusAccepted {
+			instance.State = StatePending
+			return nil
+		}
 		if resp.StatusCode < 300 {
 			return nil
 		}
@@ -142,6 +167,39 @@ func (c *Client) Create(instance *ServiceInstance, user, requestID string) error
 	return log.WrapError(err)
 }
 
+// Update sends a new plan and parameters of the instance to the service API.
+// Services may answer with 202 Accepted to finish the update asynchronously,
+// leaving the instance pending.
+func (c *Client) Update(instance *ServiceInstance, planName string, parameters map[string]interface{}, requestID string) error {
+	log.Debugf("Attempting to call update of service instance %q at %q api", instance.Name, instance.ServiceName)
+	params := map[string][]string{
+		"plan":      {planName},
+		"requestID": {requestID},
+	}
+	addParameters(params, parameters)
+	resp, err := c.issueRequest("/resources/"+instance.GetIdentifier(), "PUT", params)
+	if err == nil {
+		defer resp.Body.Close()
+		switch {
+		case resp.StatusCode == http.StatusAccepted:
+			instance.State = StatePending
+			return nil
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrInstanceNotFoundInAPI
+		}
+	}
+	err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to update the instance %s", instance.Name)
+	return log.WrapError(err)
+}
+
+func addParameters(params map[string][]string, parameters map[string]interface{}) {
+	for k, v := range parameters {
+		params["parameters."+k] = []string{formatParameterValue(v)}
+	}
+}
+
 func (c *Client) Destroy(instance *ServiceInstance, requestID string) error {
 	log.Debugf("Attempting to call destroy of service instance %q at %q api", instance.Name, instance.ServiceName)
 	params := map[string][]string{
@@ -157,6 +215,9 @@ func (c *Client) Destroy(instance *ServiceInstance, requestID string) error {
 			err = errors.Wrapf(c.buildErrorMessage(err, resp), "Failed to destroy the instance %s", instance.Name)
 			return log.WrapError(err)
 		}
+		if resp.StatusCode == http.StatusAccepted {
+			instance.State = StateDeprovisioning
+		}
 	}
 	return err
 }
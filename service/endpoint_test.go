@@ -218,6 +218,78 @@ func (s *S) TestDestroyNotFound(c *check.C) {
 	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
 }
 
+func (s *S) TestCreateWithTagsAndParameters(c *check.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	instance := ServiceInstance{
+		Name:        "my-redis",
+		ServiceName: "redis",
+		TeamOwner:   "myteam",
+		Tags:        []string{"cache", "prod"},
+		Parameters:  map[string]interface{}{"size": float64(3), "engine": "redis3"},
+	}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Create(&instance, "my@user", "")
+	c.Assert(err, check.IsNil)
+	h.Lock()
+	defer h.Unlock()
+	v, err := url.ParseQuery(string(h.body))
+	c.Assert(err, check.IsNil)
+	c.Assert(map[string][]string(v), check.DeepEquals, map[string][]string{
+		"name":              {"my-redis"},
+		"user":              {"my@user"},
+		"team":              {"myteam"},
+		"tag":               {"cache", "prod"},
+		"parameters.size":   {"3"},
+		"parameters.engine": {"redis3"},
+	})
+}
+
+func (s *S) TestUpdate(c *check.C) {
+	h := TestHandler{}
+	ts := httptest.NewServer(&h)
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", PlanName: "small"}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Update(&instance, "large", map[string]interface{}{"persistent": true}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, "")
+	h.Lock()
+	defer h.Unlock()
+	c.Assert(h.url, check.Equals, "/resources/my-redis")
+	c.Assert(h.method, check.Equals, "PUT")
+	v, err := url.ParseQuery(string(h.body))
+	c.Assert(err, check.IsNil)
+	c.Assert(map[string][]string(v), check.DeepEquals, map[string][]string{
+		"plan":                  {"large"},
+		"parameters.persistent": {"true"},
+	})
+}
+
+func (s *S) TestUpdateAccepted(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", PlanName: "small"}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Update(&instance, "large", nil, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(instance.State, check.Equals, StatePending)
+}
+
+func (s *S) TestUpdateNotFound(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+	instance := ServiceInstance{Name: "my-redis", ServiceName: "redis", PlanName: "small"}
+	client := &Client{endpoint: ts.URL, username: "user", password: "abcde"}
+	err := client.Update(&instance, "large", nil, "")
+	c.Assert(err, check.Equals, ErrInstanceNotFoundInAPI)
+}
+
 func (s *S) TestBindAppEndpointDown(c *check.C) {
 	instance := ServiceInstance{Name: "her-redis", ServiceName: "redis"}
 	a := provisiontest.NewFakeApp("her-app", "python", 1)
@@ -23,6 +23,13 @@ type Service struct {
 	Teams        []string
 	Doc          string
 	IsRestricted bool `bson:"is_restricted"`
+	// Broker is the name of the service in the catalog of an Open Service
+	// Broker API endpoint. Services with a broker are managed using the
+	// service broker API instead of tsuru's service API.
+	Broker string `bson:",omitempty"`
+	// Backup indicates the service API implements the backup endpoints
+	// used to create, list, restore and delete snapshots of instances.
+	Backup bool `bson:",omitempty"`
 }
 
 var (
@@ -74,16 +81,18 @@ func (s *Service) Delete() error {
 	return err
 }
 
-func (s *Service) getClient(endpoint string) (cli *Client, err error) {
-	if e, ok := s.Endpoint[endpoint]; ok {
-		if p, _ := regexp.MatchString("^https?://", e); !p {
-			e = "http://" + e
-		}
-		cli = &Client{serviceName: s.Name, endpoint: e, username: s.GetUsername(), password: s.Password}
-	} else {
-		err = errors.New("Unknown endpoint: " + endpoint)
+func (s *Service) getClient(endpoint string) (ServiceClient, error) {
+	e, ok := s.Endpoint[endpoint]
+	if !ok {
+		return nil, errors.New("Unknown endpoint: " + endpoint)
+	}
+	if p, _ := regexp.MatchString("^https?://", e); !p {
+		e = "http://" + e
+	}
+	if s.Broker != "" {
+		return &brokerClient{serviceName: s.Name, brokerService: s.Broker, endpoint: e, username: s.GetUsername(), password: s.Password}, nil
 	}
-	return
+	return &Client{serviceName: s.Name, endpoint: e, username: s.GetUsername(), password: s.Password}, nil
 }
 
 func (s *Service) GetUsername() string {
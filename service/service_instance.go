@@ -6,9 +6,12 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/tsuru/tsuru/action"
@@ -16,6 +19,8 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/usage"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -31,36 +36,125 @@ var (
 	ErrUnitAlreadyBound          = errors.New("unit is already bound to this service instance")
 	ErrUnitNotBound              = errors.New("unit is not bound to this service instance")
 	ErrServiceInstanceBound      = errors.New("This service instance is bound to at least one app. Unbind them before removing it")
+	ErrServiceInstanceNotReady   = errors.New("service instance is not ready")
+	ErrInvalidPlan               = errors.New("invalid plan for this service")
+	ErrPoolNotAllowed            = errors.New("service instance cannot be bound to apps in this pool")
 	instanceNameRegexp           = regexp.MustCompile(`^[A-Za-z][-a-zA-Z0-9_]+$`)
 )
 
+// Lifecycle states of a service instance. Services may accept the creation
+// or removal of an instance and finish it asynchronously, in which case the
+// instance stays pending until a provisioning task starts polling the
+// service endpoint for its status.
+const (
+	StatePending        = "pending"
+	StateProvisioning   = "provisioning"
+	StateReady          = "ready"
+	StateFailed         = "failed"
+	StateDeprovisioning = "deprovisioning"
+)
+
 type ServiceInstance struct {
-	Name        string
-	Id          int
-	ServiceName string `bson:"service_name"`
-	PlanName    string `bson:"plan_name"`
-	Apps        []string
-	Units       []string
-	Teams       []string
-	TeamOwner   string
-	Description string
-}
-
-// DeleteInstance deletes the service instance from the database.
+	Name          string
+	Id            int
+	ServiceName   string `bson:"service_name"`
+	PlanName      string `bson:"plan_name"`
+	Apps          []string
+	Units         []string
+	Teams         []string
+	TeamOwner     string
+	Description   string
+	Tags          []string
+	Parameters    map[string]interface{}
+	Pools         []string
+	AppProcesses  map[string][]string `bson:",omitempty"`
+	State         string              `bson:",omitempty"`
+	StateMessage  string              `bson:",omitempty"`
+	BackupPolicy  *BackupPolicy       `bson:",omitempty"`
+	PendingUpdate *InstanceUpdate     `bson:",omitempty"`
+}
+
+// InstanceUpdate is a change of plan or parameters being applied
+// asynchronously by the service. The instance keeps its current plan and
+// parameters until the service finishes the change.
+type InstanceUpdate struct {
+	PlanName   string
+	Parameters map[string]interface{}
+}
+
+// IsReady returns whether the instance can be bound to apps. Instances
+// created before the lifecycle states were introduced have no state and are
+// considered ready.
+func (si *ServiceInstance) IsReady() bool {
+	return si.State == "" || si.State == StateReady
+}
+
+// AllowsPool returns whether apps in the given pool can be bound to the
+// instance. Instances without pools can be bound to apps in any pool.
+func (si *ServiceInstance) AllowsPool(pool string) bool {
+	if len(si.Pools) == 0 {
+		return true
+	}
+	for _, p := range si.Pools {
+		if p == pool {
+			return true
+		}
+	}
+	return false
+}
+
+func (si *ServiceInstance) setState(state, message string) error {
+	si.State = state
+	si.StateMessage = message
+	return si.update(bson.M{"$set": bson.M{"state": state, "statemessage": message}})
+}
+
+// DeleteInstance deletes the service instance from the database. When the
+// service removes the instance asynchronously, the instance is kept in the
+// deprovisioning state until the removal finishes.
 func DeleteInstance(si *ServiceInstance, requestID string) error {
 	if len(si.Apps) > 0 {
 		return ErrServiceInstanceBound
 	}
+	if !si.IsReady() && si.State != StateFailed {
+		return ErrServiceInstanceNotReady
+	}
 	endpoint, err := si.Service().getClient("production")
 	if err == nil {
-		endpoint.Destroy(si, requestID)
+		err = endpoint.Destroy(si, requestID)
+		if err == nil && si.State == StateDeprovisioning {
+			err = si.setState(StateDeprovisioning, "")
+			if err != nil {
+				return err
+			}
+			return enqueueInstanceOperation(si, requestID)
+		}
 	}
+	return removeInstance(si)
+}
+
+func removeInstance(si *ServiceInstance) error {
 	conn, err := db.Conn()
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	return conn.ServiceInstances().Remove(bson.M{"name": si.Name, "service_name": si.ServiceName})
+	err = conn.ServiceInstances().Remove(bson.M{"name": si.Name, "service_name": si.ServiceName})
+	if err != nil {
+		return err
+	}
+	si.recordUsage(true)
+	return nil
+}
+
+// recordUsage records the usage of the instance, and of its binds, with the
+// current plan, or the end of it when stop is true.
+func (si *ServiceInstance) recordUsage(stop bool) {
+	resource := si.ServiceName + "/" + si.Name
+	usage.Record(usage.KindServiceInstance, resource, si.TeamOwner, si.PlanName, 0, stop)
+	for _, app := range si.Apps {
+		usage.Record(usage.KindServiceBind, resource+"/"+app, si.TeamOwner, si.PlanName, 0, stop)
+	}
 }
 
 func (si *ServiceInstance) GetIdentifier() string {
@@ -85,6 +179,7 @@ func (si *ServiceInstance) MarshalJSON() ([]byte, error) {
 		"ServiceName": si.ServiceName,
 		"Info":        info,
 		"TeamOwner":   si.TeamOwner,
+		"State":       si.State,
 	}
 	return json.Marshal(&data)
 }
@@ -146,13 +241,250 @@ func (si *ServiceInstance) update(update bson.M) error {
 	return conn.ServiceInstances().Update(bson.M{"name": si.Name, "service_name": si.ServiceName}, update)
 }
 
+// Update changes the description, tags, pools, plan and parameters of the
+// instance.
+// Empty values in updateData are ignored. Changes to the plan or to the
+// parameters are sent to the service endpoint, and the new plan must be one
+// of the plans provided by the service.
+func (si *ServiceInstance) Update(updateData ServiceInstance, requestID string) error {
+	if !si.IsReady() {
+		return ErrServiceInstanceNotReady
+	}
+	set := bson.M{}
+	if updateData.Description != "" {
+		set["description"] = updateData.Description
+	}
+	if updateData.Tags != nil {
+		set["tags"] = normalizeTags(updateData.Tags)
+	}
+	if updateData.Pools != nil {
+		pools := normalizeTags(updateData.Pools)
+		err := validatePools(pools)
+		if err != nil {
+			return err
+		}
+		err = checkBoundAppsPools(si.Apps, pools)
+		if err != nil {
+			return err
+		}
+		set["pools"] = pools
+	}
+	planName := si.PlanName
+	if updateData.PlanName != "" {
+		planName = updateData.PlanName
+	}
+	parameters := si.Parameters
+	if updateData.Parameters != nil {
+		parameters = updateData.Parameters
+	}
+	if planName != si.PlanName || !reflect.DeepEqual(parameters, si.Parameters) {
+		endpoint, err := si.Service().getClient("production")
+		if err != nil {
+			return err
+		}
+		if planName != si.PlanName {
+			err = validatePlan(endpoint, planName, requestID)
+			if err != nil {
+				return err
+			}
+		}
+		err = endpoint.Update(si, planName, parameters, requestID)
+		if err != nil {
+			return err
+		}
+		if si.State == StatePending {
+			set["state"] = StatePending
+			set["pendingupdate"] = InstanceUpdate{PlanName: planName, Parameters: parameters}
+		} else {
+			set["plan_name"] = planName
+			set["parameters"] = parameters
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	err := si.update(bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if updateData.Description != "" {
+		si.Description = updateData.Description
+	}
+	if tags, ok := set["tags"].([]string); ok {
+		si.Tags = tags
+	}
+	if pools, ok := set["pools"].([]string); ok {
+		si.Pools = pools
+	}
+	if si.State == StatePending {
+		if _, ok := set["pendingupdate"]; ok {
+			si.PendingUpdate = &InstanceUpdate{PlanName: planName, Parameters: parameters}
+		}
+		return enqueueInstanceOperation(si, requestID)
+	}
+	planChanged := si.PlanName != planName
+	si.PlanName = planName
+	si.Parameters = parameters
+	if planChanged {
+		si.recordUsage(false)
+	}
+	return nil
+}
+
+// finishUpdate applies the pending change of plan and parameters of the
+// instance, once the service has finished it.
+func (si *ServiceInstance) finishUpdate() error {
+	pending := si.PendingUpdate
+	err := si.update(bson.M{
+		"$set": bson.M{
+			"state":        StateReady,
+			"statemessage": "",
+			"plan_name":    pending.PlanName,
+			"parameters":   pending.Parameters,
+		},
+		"$unset": bson.M{"pendingupdate": ""},
+	})
+	if err != nil {
+		return err
+	}
+	planChanged := si.PlanName != pending.PlanName
+	si.State = StateReady
+	si.StateMessage = ""
+	si.PlanName = pending.PlanName
+	si.Parameters = pending.Parameters
+	si.PendingUpdate = nil
+	if planChanged {
+		si.recordUsage(false)
+	}
+	return nil
+}
+
+// discardUpdate drops the pending change of plan and parameters of the
+// instance when the service fails to apply it. The instance is still ready,
+// with its previous plan and parameters.
+func (si *ServiceInstance) discardUpdate(message string) error {
+	si.State = StateReady
+	si.StateMessage = message
+	si.PendingUpdate = nil
+	return si.update(bson.M{
+		"$set":   bson.M{"state": StateReady, "statemessage": message},
+		"$unset": bson.M{"pendingupdate": ""},
+	})
+}
+
+func validatePools(pools []string) error {
+	for _, pool := range pools {
+		_, err := provision.GetPoolByName(pool)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBoundAppsPools ensures the apps already bound to an instance are in
+// one of the given pools.
+func checkBoundAppsPools(apps, pools []string) error {
+	if len(apps) == 0 || len(pools) == 0 {
+		return nil
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	n, err := conn.Apps().Find(bson.M{"name": bson.M{"$in": apps}, "pool": bson.M{"$nin": pools}}).Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrPoolNotAllowed
+	}
+	return nil
+}
+
+func validatePlan(endpoint ServiceClient, planName, requestID string) error {
+	plans, err := endpoint.Plans(requestID)
+	if err != nil {
+		return err
+	}
+	for _, p := range plans {
+		if p.Name == planName {
+			return nil
+		}
+	}
+	return ErrInvalidPlan
+}
+
+func normalizeTags(tags []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// ParseParameterValue parses a parameter value received as a string. Values
+// that are valid JSON, like numbers, booleans, objects and lists, keep their
+// types, any other value is used as a string.
+func ParseParameterValue(value string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		return v
+	}
+	return value
+}
+
+// formatParameterValue is the inverse of ParseParameterValue, used when
+// parameters are sent to services as form values.
+func formatParameterValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
 // BindApp makes the bind between the service instance and an app.
 func (si *ServiceInstance) BindApp(app bind.App, shouldRestart bool, writer io.Writer) error {
+	return si.BindAppProcesses(app, nil, shouldRestart, writer)
+}
+
+// BindAppProcesses makes the bind between the service instance and an app,
+// setting the instance environment variables only in units of the given
+// processes. An empty list of processes binds the instance to all processes
+// of the app.
+func (si *ServiceInstance) BindAppProcesses(app bind.App, processes []string, shouldRestart bool, writer io.Writer) error {
+	if !si.IsReady() {
+		return ErrServiceInstanceNotReady
+	}
+	if !si.AllowsPool(app.GetPool()) {
+		return ErrPoolNotAllowed
+	}
+	pool, err := provision.GetPoolByName(app.GetPool())
+	if err == nil {
+		err = pool.CheckConstraint(provision.PoolConstraintService, si.ServiceName)
+	} else if err == provision.ErrPoolNotFound {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
 	args := bindPipelineArgs{
 		serviceInstance: si,
 		app:             app,
 		writer:          writer,
 		shouldRestart:   shouldRestart,
+		processes:       processes,
 	}
 	actions := []*action.Action{
 		bindAppDBAction,
@@ -161,11 +493,19 @@ func (si *ServiceInstance) BindApp(app bind.App, shouldRestart bool, writer io.W
 		bindUnitsAction,
 	}
 	pipeline := action.NewPipeline(actions...)
-	return pipeline.Execute(&args)
+	err = pipeline.Execute(&args)
+	if err != nil {
+		return err
+	}
+	usage.Record(usage.KindServiceBind, si.ServiceName+"/"+si.Name+"/"+app.GetName(), si.TeamOwner, si.PlanName, 0, false)
+	return nil
 }
 
 // BindUnit makes the bind between the binder and an unit.
 func (si *ServiceInstance) BindUnit(app bind.App, unit bind.Unit) error {
+	if !si.IsReady() {
+		return ErrServiceInstanceNotReady
+	}
 	endpoint, err := si.Service().getClient("production")
 	if err != nil {
 		return err
@@ -212,7 +552,12 @@ func (si *ServiceInstance) UnbindApp(app bind.App, shouldRestart bool, writer io
 		&removeBoundEnvs,
 	}
 	pipeline := action.NewPipeline(actions...)
-	return pipeline.Execute(&args)
+	err := pipeline.Execute(&args)
+	if err != nil {
+		return err
+	}
+	usage.Record(usage.KindServiceBind, si.ServiceName+"/"+si.Name+"/"+app.GetName(), "", "", 0, true)
+	return nil
 }
 
 // UnbindUnit makes the unbind between the service instance and an unit.
@@ -314,10 +659,39 @@ func CreateServiceInstance(instance ServiceInstance, service *Service, user *aut
 	if instance.TeamOwner == "" {
 		return ErrTeamMandatory
 	}
+	reservation, err := auth.ReserveTeamQuota(instance.TeamOwner, auth.TeamResources{ServiceInstances: 1})
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
 	instance.Teams = []string{instance.TeamOwner}
+	if instance.Tags != nil {
+		instance.Tags = normalizeTags(instance.Tags)
+	}
+	if instance.Pools != nil {
+		instance.Pools = normalizeTags(instance.Pools)
+		err = validatePools(instance.Pools)
+		if err != nil {
+			return err
+		}
+	}
+	instance.State = ""
+	instance.StateMessage = ""
 	actions := []*action.Action{&createServiceInstance, &insertServiceInstance}
 	pipeline := action.NewPipeline(actions...)
-	return pipeline.Execute(*service, instance, user.Email, requestID)
+	err = pipeline.Execute(*service, instance, user.Email, requestID)
+	if err != nil {
+		return err
+	}
+	created, ok := pipeline.Result().(ServiceInstance)
+	if !ok {
+		return nil
+	}
+	created.recordUsage(false)
+	if created.State == StatePending {
+		return enqueueInstanceOperation(&created, requestID)
+	}
+	return nil
 }
 
 func UpdateService(si *ServiceInstance) error {
@@ -342,7 +716,7 @@ func GetServiceInstancesByServices(services []Service) ([]ServiceInstance, error
 	return instances, err
 }
 
-func GetServicesInstancesByTeamsAndNames(teams []string, names []string, appName, serviceName string) ([]ServiceInstance, error) {
+func GetServicesInstancesByTeamsAndNames(teams []string, names []string, appName, serviceName string, tags []string) ([]ServiceInstance, error) {
 	filter := bson.M{}
 	if teams != nil || names != nil {
 		filter = bson.M{
@@ -358,6 +732,9 @@ func GetServicesInstancesByTeamsAndNames(teams []string, names []string, appName
 	if serviceName != "" {
 		filter["service_name"] = serviceName
 	}
+	if len(tags) > 0 {
+		filter["tags"] = bson.M{"$all": tags}
+	}
 	conn, err := db.Conn()
 	if err != nil {
 		return nil, err
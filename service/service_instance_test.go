@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"runtime"
 	"sort"
 	"strconv"
@@ -23,6 +24,7 @@ import (
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/dbtest"
 	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/usage"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -265,6 +267,7 @@ func (s *InstanceSuite) TestMarshalJSON(c *check.C) {
 		"ServiceName": "mysql",
 		"Info":        map[string]interface{}{"key": "value"},
 		"TeamOwner":   "",
+		"State":       "",
 	}
 	c.Assert(result, check.DeepEquals, expected)
 }
@@ -289,6 +292,7 @@ func (s *InstanceSuite) TestMarshalJSONWithoutInfo(c *check.C) {
 		"ServiceName": "mysql",
 		"Info":        nil,
 		"TeamOwner":   "",
+		"State":       "",
 	}
 	c.Assert(result, check.DeepEquals, expected)
 }
@@ -313,6 +317,7 @@ func (s *InstanceSuite) TestMarshalJSONWithoutEndpoint(c *check.C) {
 		"ServiceName": "mysql",
 		"Info":        nil,
 		"TeamOwner":   "",
+		"State":       "",
 	}
 	c.Assert(result, check.DeepEquals, expected)
 }
@@ -371,6 +376,54 @@ func (s *InstanceSuite) TestCreateServiceInstance(c *check.C) {
 	c.Assert(si.Teams, check.DeepEquals, []string{s.team.Name})
 }
 
+func (s *InstanceSuite) TestServiceInstanceRecordsUsage(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/plans" {
+			w.Write([]byte(`[{"name": "small"}, {"name": "large"}]`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	instance := ServiceInstance{Name: "instance", PlanName: "small", TeamOwner: s.team.Name}
+	err = CreateServiceInstance(instance, &srv, s.user, "")
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	err = si.Update(ServiceInstance{PlanName: "large"}, "")
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	err = si.BindApp(a, true, &bytes.Buffer{})
+	c.Assert(err, check.IsNil)
+	si, err = GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	err = si.UnbindApp(a, true, &bytes.Buffer{})
+	c.Assert(err, check.IsNil)
+	si, err = GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	err = DeleteInstance(si, "")
+	c.Assert(err, check.IsNil)
+	var intervals []usage.Interval
+	err = s.conn.Usage().Find(bson.M{"resource": "mongodb/instance"}).Sort("start").All(&intervals)
+	c.Assert(err, check.IsNil)
+	c.Assert(intervals, check.HasLen, 2)
+	c.Assert(intervals[0].Kind, check.Equals, usage.KindServiceInstance)
+	c.Assert(intervals[0].Team, check.Equals, s.team.Name)
+	c.Assert(intervals[0].Plan, check.Equals, "small")
+	c.Assert(intervals[0].End.IsZero(), check.Equals, false)
+	c.Assert(intervals[1].Plan, check.Equals, "large")
+	c.Assert(intervals[1].End.IsZero(), check.Equals, false)
+	err = s.conn.Usage().Find(bson.M{"resource": "mongodb/instance/myapp"}).All(&intervals)
+	c.Assert(err, check.IsNil)
+	c.Assert(intervals, check.HasLen, 1)
+	c.Assert(intervals[0].Kind, check.Equals, usage.KindServiceBind)
+	c.Assert(intervals[0].Plan, check.Equals, "large")
+	c.Assert(intervals[0].End.IsZero(), check.Equals, false)
+}
+
 func (s *InstanceSuite) TestCreateServiceInstanceWithSameInstanceName(c *check.C) {
 	var requests int32
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -865,6 +918,63 @@ func (s *InstanceSuite) TestBindAppFullPipeline(c *check.C) {
 	})
 }
 
+func (s *InstanceSuite) TestBindAppProcesses(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.URL.Path == "/resources/my-mysql/bind-app" && r.Method == "POST" {
+			w.Write([]byte(`{"ENV1": "VAL1"}`))
+		}
+	}))
+	defer ts.Close()
+	serv := Service{Name: "mysql", Endpoint: map[string]string{"production": ts.URL}}
+	err := serv.Create()
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Teams:       []string{s.team.Name},
+	}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	err = si.BindAppProcesses(a, []string{"web"}, true, &bytes.Buffer{})
+	c.Assert(err, check.IsNil)
+	siDB, err := GetServiceInstance(si.ServiceName, si.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(siDB.Apps, check.DeepEquals, []string{"myapp"})
+	c.Assert(siDB.AppProcesses, check.DeepEquals, map[string][]string{"myapp": {"web"}})
+	c.Assert(a.GetInstances("mysql"), check.DeepEquals, []bind.ServiceInstance{
+		{Name: "my-mysql", Envs: map[string]string{"ENV1": "VAL1"}, Processes: []string{"web"}},
+	})
+	err = siDB.UnbindApp(a, true, &bytes.Buffer{})
+	c.Assert(err, check.IsNil)
+	siDB, err = GetServiceInstance(si.ServiceName, si.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(siDB.Apps, check.HasLen, 0)
+	c.Assert(siDB.AppProcesses, check.HasLen, 0)
+}
+
+func (s *InstanceSuite) TestBindAppPoolNotAllowed(c *check.C) {
+	si := ServiceInstance{
+		Name:        "my-mysql",
+		ServiceName: "mysql",
+		Pools:       []string{"pool1"},
+	}
+	a := provisiontest.NewFakeApp("myapp", "static", 1)
+	a.Pool = "pool2"
+	err := si.BindApp(a, true, nil)
+	c.Assert(err, check.Equals, ErrPoolNotAllowed)
+}
+
+func (s *InstanceSuite) TestAllowsPool(c *check.C) {
+	si := ServiceInstance{Name: "my-mysql"}
+	c.Assert(si.AllowsPool("pool1"), check.Equals, true)
+	si.Pools = []string{"pool1", "pool2"}
+	c.Assert(si.AllowsPool("pool1"), check.Equals, true)
+	c.Assert(si.AllowsPool("pool2"), check.Equals, true)
+	c.Assert(si.AllowsPool("pool3"), check.Equals, false)
+}
+
 func (s *InstanceSuite) TestBindAppMultipleApps(c *check.C) {
 	goMaxProcs := runtime.GOMAXPROCS(4)
 	defer runtime.GOMAXPROCS(goMaxProcs)
@@ -967,3 +1077,109 @@ func (s *InstanceSuite) TestUnbindAppMultipleApps(c *check.C) {
 	sort.Strings(siDB.Apps)
 	c.Assert(siDB.Apps, check.DeepEquals, []string{})
 }
+
+func (s *InstanceSuite) plansServer(updates *[]url.Values) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/resources/plans" {
+			w.Write([]byte(`[{"name": "small"}, {"name": "large"}]`))
+			return
+		}
+		if r.Method == "PUT" {
+			r.ParseForm()
+			*updates = append(*updates, r.Form)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func (s *InstanceSuite) TestUpdatePlanAndParameters(c *check.C) {
+	var updates []url.Values
+	ts := s.plansServer(&updates)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", PlanName: "small"}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = si.Update(ServiceInstance{PlanName: "large", Parameters: map[string]interface{}{"replicas": float64(3)}}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(updates, check.HasLen, 1)
+	c.Assert(updates[0].Get("plan"), check.Equals, "large")
+	c.Assert(updates[0].Get("parameters.replicas"), check.Equals, "3")
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.PlanName, check.Equals, "large")
+	c.Assert(dbInstance.Parameters, check.DeepEquals, map[string]interface{}{"replicas": float64(3)})
+}
+
+func (s *InstanceSuite) TestUpdateInvalidPlan(c *check.C) {
+	var updates []url.Values
+	ts := s.plansServer(&updates)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", PlanName: "small"}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = si.Update(ServiceInstance{PlanName: "huge"}, "")
+	c.Assert(err, check.Equals, ErrInvalidPlan)
+	c.Assert(updates, check.HasLen, 0)
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.PlanName, check.Equals, "small")
+}
+
+func (s *InstanceSuite) TestUpdateDescriptionAndTags(c *check.C) {
+	var updates []url.Values
+	ts := s.plansServer(&updates)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", PlanName: "small", Tags: []string{"old"}}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = si.Update(ServiceInstance{Description: "my instance", Tags: []string{" tag1", "tag2", "tag1", ""}}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(updates, check.HasLen, 0)
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.Description, check.Equals, "my instance")
+	c.Assert(dbInstance.Tags, check.DeepEquals, []string{"tag1", "tag2"})
+	c.Assert(dbInstance.PlanName, check.Equals, "small")
+}
+
+func (s *InstanceSuite) TestUpdateNotReady(c *check.C) {
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", State: StateProvisioning}
+	err := si.Update(ServiceInstance{Description: "desc"}, "")
+	c.Assert(err, check.Equals, ErrServiceInstanceNotReady)
+}
+
+func (s *InstanceSuite) TestGetServicesInstancesByTags(c *check.C) {
+	instances := []ServiceInstance{
+		{Name: "i1", ServiceName: "mongodb", Tags: []string{"prod", "cache"}},
+		{Name: "i2", ServiceName: "mongodb", Tags: []string{"prod"}},
+		{Name: "i3", ServiceName: "mongodb"},
+	}
+	for i := range instances {
+		err := instances[i].Create()
+		c.Assert(err, check.IsNil)
+	}
+	result, err := GetServicesInstancesByTeamsAndNames(nil, nil, "", "", []string{"prod"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 2)
+	result, err = GetServicesInstancesByTeamsAndNames(nil, nil, "", "", []string{"prod", "cache"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.HasLen, 1)
+	c.Assert(result[0].Name, check.Equals, "i1")
+}
+
+func (s *InstanceSuite) TestParseParameterValue(c *check.C) {
+	c.Assert(ParseParameterValue("3"), check.Equals, float64(3))
+	c.Assert(ParseParameterValue("true"), check.Equals, true)
+	c.Assert(ParseParameterValue(`"3"`), check.Equals, "3")
+	c.Assert(ParseParameterValue("small"), check.Equals, "small")
+	c.Assert(ParseParameterValue(`{"a": 1}`), check.DeepEquals, map[string]interface{}{"a": float64(1)})
+}
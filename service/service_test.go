@@ -103,7 +103,7 @@ func (s *S) TestGetClientWithouHTTP(c *check.C) {
 	service := Service{Name: "redis", Endpoint: endpoints}
 	cli, err := service.getClient("production")
 	c.Assert(err, check.IsNil)
-	c.Assert(cli.endpoint, check.Equals, "http://mysql.api.com")
+	c.Assert(cli.(*Client).endpoint, check.Equals, "http://mysql.api.com")
 }
 
 func (s *S) TestGetClientWithHTTPS(c *check.C) {
@@ -113,7 +113,7 @@ func (s *S) TestGetClientWithHTTPS(c *check.C) {
 	service := Service{Name: "redis", Endpoint: endpoints}
 	cli, err := service.getClient("production")
 	c.Assert(err, check.IsNil)
-	c.Assert(cli.endpoint, check.Equals, "https://mysql.api.com")
+	c.Assert(cli.(*Client).endpoint, check.Equals, "https://mysql.api.com")
 }
 
 func (s *S) TestGetClientWithUnknownEndpoint(c *check.C) {
@@ -285,3 +285,20 @@ func (s *S) TestProxy(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(recorder.Code, check.Equals, http.StatusNoContent)
 }
+
+func (s *S) TestGetClientWithBroker(c *check.C) {
+	endpoints := map[string]string{
+		"production": "http://broker.api.com",
+	}
+	service := Service{Name: "mysql-tsuru", Password: "abcde", Endpoint: endpoints, Broker: "mysql"}
+	cli, err := service.getClient("production")
+	expected := &brokerClient{
+		serviceName:   "mysql-tsuru",
+		brokerService: "mysql",
+		endpoint:      endpoints["production"],
+		username:      "mysql-tsuru",
+		password:      "abcde",
+	}
+	c.Assert(err, check.IsNil)
+	c.Assert(cli, check.DeepEquals, expected)
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/monsterqueue"
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/permission"
+	"github.com/tsuru/tsuru/queue"
+)
+
+const instanceOperationTaskName = "service-instance-operation"
+
+var (
+	instancePollInterval     = 5 * time.Second
+	instanceOperationTimeout = 30 * time.Minute
+)
+
+// instanceOperationTask polls the service endpoint until an asynchronous
+// provision or deprovision of a service instance finishes, updating the
+// instance state and logging the progress to an internal event.
+type instanceOperationTask struct{}
+
+func (t *instanceOperationTask) Name() string {
+	return instanceOperationTaskName
+}
+
+func (t *instanceOperationTask) Run(job monsterqueue.Job) {
+	params := job.Parameters()
+	serviceName, _ := params["service"].(string)
+	instanceName, _ := params["instance"].(string)
+	requestID, _ := params["requestID"].(string)
+	if serviceName == "" || instanceName == "" {
+		job.Error(errors.New("invalid parameters, expected service and instance"))
+		return
+	}
+	err := waitInstanceOperation(serviceName, instanceName, requestID)
+	if err != nil {
+		log.Errorf("[service-instance-operation] error waiting for instance %s/%s: %s", serviceName, instanceName, err)
+		job.Error(err)
+		return
+	}
+	job.Success(nil)
+}
+
+// RegisterTasks registers the queue tasks used by asynchronous service
+// instance operations and periodic backups.
+func RegisterTasks() error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	err = q.RegisterTask(&instanceOperationTask{})
+	if err != nil {
+		return err
+	}
+	return q.RegisterTask(&backupTask{})
+}
+
+func enqueueInstanceOperation(si *ServiceInstance, requestID string) error {
+	q, err := queue.Queue()
+	if err != nil {
+		return err
+	}
+	_, err = q.Enqueue(instanceOperationTaskName, monsterqueue.JobParams{
+		"service":   si.ServiceName,
+		"instance":  si.Name,
+		"requestID": requestID,
+	})
+	return err
+}
+
+func waitInstanceOperation(serviceName, instanceName, requestID string) (err error) {
+	si, err := GetServiceInstance(serviceName, instanceName)
+	if err != nil {
+		return err
+	}
+	deprovision := si.State == StateDeprovisioning
+	if !deprovision && si.State != StatePending && si.State != StateProvisioning {
+		return nil
+	}
+	kind := "service-instance-provision"
+	if deprovision {
+		kind = "service-instance-deprovision"
+	} else if si.PendingUpdate != nil {
+		kind = "service-instance-update"
+	}
+	permValue := fmt.Sprintf("%s/%s", serviceName, instanceName)
+	evt, err := event.NewInternal(&event.Opts{
+		Target:       event.Target{Type: event.TargetTypeServiceInstance, Value: permValue},
+		InternalKind: kind,
+		Allowed: event.Allowed(permission.PermServiceInstanceReadEvents,
+			append(permission.Contexts(permission.CtxTeam, si.Teams),
+				permission.Context(permission.CtxServiceInstance, permValue))...),
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { evt.Done(err) }()
+	if !deprovision {
+		err = si.setState(StateProvisioning, "")
+		if err != nil {
+			return err
+		}
+	}
+	operation := si.State
+	if si.PendingUpdate != nil {
+		operation = "update"
+	}
+	evt.Logf("waiting for service %q to finish the %s of instance %q", serviceName, operation, instanceName)
+	endpoint, err := si.Service().getClient("production")
+	if err != nil {
+		return err
+	}
+	timeout := time.After(instanceOperationTimeout)
+	var status string
+	for {
+		status, err = endpoint.Status(si, requestID)
+		if err != nil {
+			evt.Logf("unable to get instance status: %s", err)
+		} else if status != "pending" {
+			break
+		} else {
+			evt.Logf("instance %q is still %s", instanceName, si.State)
+		}
+		select {
+		case <-timeout:
+			err = errors.Errorf("timeout waiting for service %q to finish the %s of instance %q", serviceName, operation, instanceName)
+			failInstanceOperation(si, err)
+			return err
+		case <-time.After(instancePollInterval):
+		}
+	}
+	if status == "down" {
+		err = errors.Errorf("service %q failed the %s of instance %q", serviceName, operation, instanceName)
+		failInstanceOperation(si, err)
+		return err
+	}
+	if deprovision {
+		evt.Logf("instance %q removed", instanceName)
+		return removeInstance(si)
+	}
+	evt.Logf("instance %q is ready", instanceName)
+	if si.PendingUpdate != nil {
+		return si.finishUpdate()
+	}
+	return si.setState(StateReady, "")
+}
+
+// failInstanceOperation marks the instance as failed, unless the failed
+// operation is an update, which leaves the instance with its previous plan
+// and parameters.
+func failInstanceOperation(si *ServiceInstance, err error) {
+	var updateErr error
+	if si.PendingUpdate != nil {
+		updateErr = si.discardUpdate(err.Error())
+	} else {
+		updateErr = si.setState(StateFailed, err.Error())
+	}
+	if updateErr != nil {
+		log.Errorf("[service-instance-operation] unable to update state of instance %s/%s: %s", si.ServiceName, si.Name, updateErr)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/tsuru/event"
+	"github.com/tsuru/tsuru/event/eventtest"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) asyncServiceServer(pendingPolls int32, finalStatus int) (*httptest.Server, *int32) {
+	var polls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if atomic.AddInt32(&polls, 1) <= pendingPolls {
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			w.WriteHeader(finalStatus)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return ts, &polls
+}
+
+func (s *S) TestCreateServiceInstanceAsync(c *check.C) {
+	defer func(d time.Duration) { instancePollInterval = d }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+	ts, polls := s.asyncServiceServer(2, http.StatusNoContent)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	instance := ServiceInstance{Name: "instance", TeamOwner: s.team.Name}
+	err = CreateServiceInstance(instance, &srv, s.user, "")
+	c.Assert(err, check.IsNil)
+	timeout := time.After(5 * time.Second)
+	for {
+		n, err := s.conn.Events().Find(bson.M{"kind.name": "service-instance-provision", "running": false}).Count()
+		c.Assert(err, check.IsNil)
+		if n == 1 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for instance provisioning")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	si, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, StateReady)
+	c.Assert(atomic.LoadInt32(polls), check.Equals, int32(3))
+	c.Assert(eventtest.EventDesc{
+		Target:     event.Target{Type: event.TargetTypeServiceInstance, Value: "mongodb/instance"},
+		Kind:       "service-instance-provision",
+		LogMatches: `(?s).*instance "instance" is still provisioning.*instance "instance" is ready.*`,
+	}, eventtest.HasEvent)
+}
+
+func (s *S) TestCreateServiceInstanceSyncIsReady(c *check.C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	instance := ServiceInstance{Name: "instance", TeamOwner: s.team.Name}
+	err = CreateServiceInstance(instance, &srv, s.user, "")
+	c.Assert(err, check.IsNil)
+	si, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.State, check.Equals, StateReady)
+}
+
+func (s *S) TestWaitInstanceOperationFailed(c *check.C) {
+	defer func(d time.Duration) { instancePollInterval = d }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+	ts, _ := s.asyncServiceServer(1, http.StatusInternalServerError)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", State: StatePending}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = waitInstanceOperation("mongodb", "instance", "")
+	c.Assert(err, check.ErrorMatches, `service "mongodb" failed the provisioning of instance "instance"`)
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.State, check.Equals, StateFailed)
+	c.Assert(dbInstance.StateMessage, check.Equals, err.Error())
+}
+
+func (s *S) TestWaitInstanceOperationTimeout(c *check.C) {
+	defer func(d time.Duration) { instancePollInterval = d }(instancePollInterval)
+	defer func(d time.Duration) { instanceOperationTimeout = d }(instanceOperationTimeout)
+	instancePollInterval = time.Millisecond
+	instanceOperationTimeout = 50 * time.Millisecond
+	ts, _ := s.asyncServiceServer(1000000, http.StatusNoContent)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", State: StatePending}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = waitInstanceOperation("mongodb", "instance", "")
+	c.Assert(err, check.ErrorMatches, `timeout waiting for service "mongodb" to finish the provisioning of instance "instance"`)
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.State, check.Equals, StateFailed)
+}
+
+func (s *S) TestDeleteInstanceAsync(c *check.C) {
+	defer func(d time.Duration) { instancePollInterval = d }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+	ts, _ := s.asyncServiceServer(1, http.StatusNotFound)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", State: StateReady}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = DeleteInstance(&si, "")
+	c.Assert(err, check.IsNil)
+	timeout := time.After(5 * time.Second)
+	for {
+		n, err := s.conn.ServiceInstances().Find(bson.M{"name": "instance"}).Count()
+		c.Assert(err, check.IsNil)
+		if n == 0 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for instance to be removed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestDeleteInstanceNotReady(c *check.C) {
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", State: StateProvisioning}
+	err := DeleteInstance(&si, "")
+	c.Assert(err, check.Equals, ErrServiceInstanceNotReady)
+}
+
+func (s *S) TestBindAppNotReady(c *check.C) {
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", State: StatePending}
+	a := provisiontest.NewFakeApp("myapp", "python", 1)
+	err := si.BindApp(a, false, nil)
+	c.Assert(err, check.Equals, ErrServiceInstanceNotReady)
+	units, err := a.GetUnits()
+	c.Assert(err, check.IsNil)
+	err = si.BindUnit(a, units[0])
+	c.Assert(err, check.Equals, ErrServiceInstanceNotReady)
+}
+
+func (s *S) TestServiceInstanceIsReady(c *check.C) {
+	c.Assert((&ServiceInstance{}).IsReady(), check.Equals, true)
+	c.Assert((&ServiceInstance{State: StateReady}).IsReady(), check.Equals, true)
+	for _, state := range []string{StatePending, StateProvisioning, StateFailed, StateDeprovisioning} {
+		c.Assert((&ServiceInstance{State: state}).IsReady(), check.Equals, false)
+	}
+}
+
+func (s *S) asyncUpdateServer(finalStatus int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/resources/plans":
+			w.Write([]byte(`[{"name": "small"}, {"name": "large"}]`))
+		case r.Method == "GET":
+			w.WriteHeader(finalStatus)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+}
+
+func (s *S) TestUpdateServiceInstanceAsync(c *check.C) {
+	defer func(d time.Duration) { instancePollInterval = d }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+	ts := s.asyncUpdateServer(http.StatusNoContent)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{Name: "instance", ServiceName: "mongodb", PlanName: "small"}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = si.Update(ServiceInstance{PlanName: "large", Parameters: map[string]interface{}{"replicas": float64(3)}}, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(si.PlanName, check.Equals, "small")
+	c.Assert(si.PendingUpdate, check.DeepEquals, &InstanceUpdate{PlanName: "large", Parameters: map[string]interface{}{"replicas": float64(3)}})
+	timeout := time.After(5 * time.Second)
+	for {
+		n, err := s.conn.Events().Find(bson.M{"kind.name": "service-instance-update", "running": false}).Count()
+		c.Assert(err, check.IsNil)
+		if n == 1 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for instance update")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.State, check.Equals, StateReady)
+	c.Assert(dbInstance.PlanName, check.Equals, "large")
+	c.Assert(dbInstance.Parameters, check.DeepEquals, map[string]interface{}{"replicas": float64(3)})
+	c.Assert(dbInstance.PendingUpdate, check.IsNil)
+}
+
+func (s *S) TestWaitInstanceOperationUpdateFailed(c *check.C) {
+	defer func(d time.Duration) { instancePollInterval = d }(instancePollInterval)
+	instancePollInterval = time.Millisecond
+	ts := s.asyncUpdateServer(http.StatusInternalServerError)
+	defer ts.Close()
+	srv := Service{Name: "mongodb", Endpoint: map[string]string{"production": ts.URL}}
+	err := s.conn.Services().Insert(&srv)
+	c.Assert(err, check.IsNil)
+	si := ServiceInstance{
+		Name:          "instance",
+		ServiceName:   "mongodb",
+		PlanName:      "small",
+		State:         StatePending,
+		PendingUpdate: &InstanceUpdate{PlanName: "large"},
+	}
+	err = si.Create()
+	c.Assert(err, check.IsNil)
+	err = waitInstanceOperation("mongodb", "instance", "")
+	c.Assert(err, check.ErrorMatches, `service "mongodb" failed the update of instance "instance"`)
+	dbInstance, err := GetServiceInstance("mongodb", "instance")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbInstance.State, check.Equals, StateReady)
+	c.Assert(dbInstance.StateMessage, check.Equals, err.Error())
+	c.Assert(dbInstance.PlanName, check.Equals, "small")
+	c.Assert(dbInstance.PendingUpdate, check.IsNil)
+}
@@ -0,0 +1,245 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package usage records how long apps and service instances existed with
+// each plan, so that teams can be charged back for the resources they use.
+package usage
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Kinds of resources tracked in usage records.
+const (
+	KindApp             = "app"
+	KindServiceInstance = "service-instance"
+	KindServiceBind     = "service-bind"
+)
+
+// Interval is a period in which a resource existed with the same team, plan
+// and number of units. Open intervals have a zero End.
+type Interval struct {
+	ID       bson.ObjectId `bson:"_id"`
+	Kind     string
+	Resource string
+	Team     string
+	Plan     string
+	Units    int `bson:",omitempty"`
+	Start    time.Time
+	End      time.Time `bson:",omitempty"`
+}
+
+func (i *Interval) sameUsage(team, plan string, units int) bool {
+	return i.Team == team && i.Plan == plan && i.Units == units
+}
+
+var now = func() time.Time {
+	return time.Now().UTC()
+}
+
+// Start records that the given resource is in use by the team with the given
+// plan and number of units from now on, closing the interval previously open
+// for the resource, if any. Calling Start without any change in the usage of
+// the resource keeps the current interval open.
+func Start(kind, resource, team, plan string, units int) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	coll := conn.Usage()
+	var current Interval
+	err = coll.Find(openQuery(kind, resource)).One(&current)
+	if err == nil && current.sameUsage(team, plan, units) {
+		return nil
+	}
+	t := now()
+	_, err = coll.UpdateAll(openQuery(kind, resource), bson.M{"$set": bson.M{"end": t}})
+	if err != nil {
+		return err
+	}
+	return coll.Insert(Interval{
+		ID:       bson.NewObjectId(),
+		Kind:     kind,
+		Resource: resource,
+		Team:     team,
+		Plan:     plan,
+		Units:    units,
+		Start:    t,
+	})
+}
+
+// Stop records that the given resource is not in use anymore.
+func Stop(kind, resource string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Usage().UpdateAll(openQuery(kind, resource), bson.M{"$set": bson.M{"end": now()}})
+	return err
+}
+
+// Record calls Start, or Stop when stop is true, logging any error instead of
+// returning it, as failures recording usage must not break the operations
+// being recorded.
+func Record(kind, resource, team, plan string, units int, stop bool) {
+	var err error
+	if stop {
+		err = Stop(kind, resource)
+	} else {
+		err = Start(kind, resource, team, plan, units)
+	}
+	if err != nil {
+		log.Errorf("[usage] unable to record usage of %s %q: %s", kind, resource, err)
+	}
+}
+
+func openQuery(kind, resource string) bson.M {
+	return bson.M{"kind": kind, "resource": resource, "end": bson.M{"$exists": false}}
+}
+
+// ReportItem is the usage of a resource with a given plan in a month. Hours is
+// the time the resource existed with the plan, and UnitHours is the sum of the
+// hours of each unit, for resources with units.
+type ReportItem struct {
+	Kind      string  `json:"kind"`
+	Resource  string  `json:"resource"`
+	Plan      string  `json:"plan"`
+	Hours     float64 `json:"hours"`
+	UnitHours float64 `json:"unit_hours"`
+}
+
+// Report is the usage of a team in a month.
+type Report struct {
+	Team  string       `json:"team"`
+	Month string       `json:"month"`
+	Items []ReportItem `json:"items"`
+}
+
+// MonthFormat is the format of months in usage reports.
+const MonthFormat = "2006-01"
+
+// TeamReport aggregates the usage of the team in the month of the given time.
+// Intervals still open are accounted up to now.
+func TeamReport(team string, month time.Time) (*Report, error) {
+	begin := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := begin.AddDate(0, 1, 0)
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	query := bson.M{
+		"team":  team,
+		"start": bson.M{"$lt": end},
+		"$or": []bson.M{
+			{"end": bson.M{"$exists": false}},
+			{"end": bson.M{"$gt": begin}},
+		},
+	}
+	var intervals []Interval
+	err = conn.Usage().Find(query).All(&intervals)
+	if err != nil {
+		return nil, err
+	}
+	report := Report{Team: team, Month: begin.Format(MonthFormat), Items: []ReportItem{}}
+	items := map[[3]string]*ReportItem{}
+	var keys [][3]string
+	current := now()
+	for _, interval := range intervals {
+		start, stop := interval.Start, interval.End
+		if stop.IsZero() || stop.After(current) {
+			stop = current
+		}
+		if start.Before(begin) {
+			start = begin
+		}
+		if stop.After(end) {
+			stop = end
+		}
+		if !stop.After(start) {
+			continue
+		}
+		key := [3]string{interval.Kind, interval.Resource, interval.Plan}
+		item, ok := items[key]
+		if !ok {
+			item = &ReportItem{Kind: interval.Kind, Resource: interval.Resource, Plan: interval.Plan}
+			items[key] = item
+			keys = append(keys, key)
+		}
+		hours := stop.Sub(start).Hours()
+		item.Hours += hours
+		item.UnitHours += hours * float64(interval.Units)
+	}
+	sort.Sort(keysByName(keys))
+	for _, key := range keys {
+		report.Items = append(report.Items, *items[key])
+	}
+	return &report, nil
+}
+
+// WriteCSV writes the report items in CSV format, with a header line.
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"team", "month", "kind", "resource", "plan", "hours", "unit_hours"})
+	if err != nil {
+		return err
+	}
+	for _, item := range r.Items {
+		err = writer.Write([]string{
+			r.Team,
+			r.Month,
+			item.Kind,
+			item.Resource,
+			item.Plan,
+			formatHours(item.Hours),
+			formatHours(item.UnitHours),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatHours(h float64) string {
+	return strconv.FormatFloat(h, 'f', 2, 64)
+}
+
+// ParseMonth parses a month in the MonthFormat. An empty month means the
+// current one.
+func ParseMonth(month string) (time.Time, error) {
+	if month == "" {
+		return now(), nil
+	}
+	t, err := time.Parse(MonthFormat, month)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid month %q, expected format YYYY-MM", month)
+	}
+	return t, nil
+}
+
+type keysByName [][3]string
+
+func (k keysByName) Len() int      { return len(k) }
+func (k keysByName) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k keysByName) Less(i, j int) bool {
+	for n := range k[i] {
+		if k[i][n] != k[j][n] {
+			return k[i][n] < k[j][n]
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usage
+
+import (
+	"bytes"
+	"time"
+
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) setNow(t time.Time) {
+	now = func() time.Time {
+		return t
+	}
+}
+
+func (s *S) TestStartAndStop(c *check.C) {
+	t0 := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.setNow(t0)
+	err := Start(KindApp, "myapp", "myteam", "small", 1)
+	c.Assert(err, check.IsNil)
+	s.setNow(t0.Add(time.Hour))
+	err = Start(KindApp, "myapp", "myteam", "small", 1)
+	c.Assert(err, check.IsNil)
+	var intervals []Interval
+	err = s.storage.Usage().Find(nil).Sort("start").All(&intervals)
+	c.Assert(err, check.IsNil)
+	c.Assert(intervals, check.HasLen, 1)
+	s.setNow(t0.Add(2 * time.Hour))
+	err = Start(KindApp, "myapp", "myteam", "small", 3)
+	c.Assert(err, check.IsNil)
+	s.setNow(t0.Add(3 * time.Hour))
+	err = Stop(KindApp, "myapp")
+	c.Assert(err, check.IsNil)
+	err = s.storage.Usage().Find(bson.M{"kind": KindApp, "resource": "myapp"}).Sort("start").All(&intervals)
+	c.Assert(err, check.IsNil)
+	c.Assert(intervals, check.HasLen, 2)
+	c.Assert(intervals[0].Start.Equal(t0), check.Equals, true)
+	c.Assert(intervals[0].End.Equal(t0.Add(2*time.Hour)), check.Equals, true)
+	c.Assert(intervals[0].Units, check.Equals, 1)
+	c.Assert(intervals[1].Start.Equal(t0.Add(2*time.Hour)), check.Equals, true)
+	c.Assert(intervals[1].End.Equal(t0.Add(3*time.Hour)), check.Equals, true)
+	c.Assert(intervals[1].Units, check.Equals, 3)
+}
+
+func (s *S) TestTeamReport(c *check.C) {
+	s.setNow(time.Date(2017, 2, 28, 23, 0, 0, 0, time.UTC))
+	err := Start(KindServiceInstance, "mysql/db", "myteam", "small", 0)
+	c.Assert(err, check.IsNil)
+	err = Start(KindApp, "myapp", "myteam", "small", 2)
+	c.Assert(err, check.IsNil)
+	err = Start(KindApp, "otherapp", "otherteam", "small", 2)
+	c.Assert(err, check.IsNil)
+	s.setNow(time.Date(2017, 3, 1, 2, 0, 0, 0, time.UTC))
+	err = Start(KindServiceInstance, "mysql/db", "myteam", "large", 0)
+	c.Assert(err, check.IsNil)
+	s.setNow(time.Date(2017, 3, 1, 4, 0, 0, 0, time.UTC))
+	err = Stop(KindApp, "myapp")
+	c.Assert(err, check.IsNil)
+	s.setNow(time.Date(2017, 3, 2, 2, 0, 0, 0, time.UTC))
+	report, err := TeamReport("myteam", time.Date(2017, 3, 15, 0, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(report, check.DeepEquals, &Report{
+		Team:  "myteam",
+		Month: "2017-03",
+		Items: []ReportItem{
+			{Kind: KindApp, Resource: "myapp", Plan: "small", Hours: 4, UnitHours: 8},
+			{Kind: KindServiceInstance, Resource: "mysql/db", Plan: "large", Hours: 24},
+			{Kind: KindServiceInstance, Resource: "mysql/db", Plan: "small", Hours: 2},
+		},
+	})
+	report, err = TeamReport("myteam", time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Items, check.DeepEquals, []ReportItem{
+		{Kind: KindApp, Resource: "myapp", Plan: "small", Hours: 1, UnitHours: 2},
+		{Kind: KindServiceInstance, Resource: "mysql/db", Plan: "small", Hours: 1},
+	})
+	report, err = TeamReport("myteam", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Items, check.DeepEquals, []ReportItem{})
+}
+
+func (s *S) TestReportWriteCSV(c *check.C) {
+	report := Report{
+		Team:  "myteam",
+		Month: "2017-03",
+		Items: []ReportItem{
+			{Kind: KindApp, Resource: "myapp", Plan: "small", Hours: 4, UnitHours: 8},
+			{Kind: KindServiceInstance, Resource: "mysql/db", Plan: "large", Hours: 1.5},
+		},
+	}
+	var buf bytes.Buffer
+	err := report.WriteCSV(&buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `team,month,kind,resource,plan,hours,unit_hours
+myteam,2017-03,app,myapp,small,4.00,8.00
+myteam,2017-03,service-instance,mysql/db,large,1.50,0.00
+`)
+}
+
+func (s *S) TestParseMonth(c *check.C) {
+	t, err := ParseMonth("2017-03")
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Equal(time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)), check.Equals, true)
+	current := time.Date(2017, 5, 10, 0, 0, 0, 0, time.UTC)
+	s.setNow(current)
+	t, err = ParseMonth("")
+	c.Assert(err, check.IsNil)
+	c.Assert(t.Equal(current), check.Equals, true)
+	_, err = ParseMonth("03/2017")
+	c.Assert(err, check.ErrorMatches, `invalid month "03/2017", expected format YYYY-MM`)
+}